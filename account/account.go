@@ -0,0 +1,251 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package account implements the two flows almost every user of nonces
+// needs: verifying a user's email address and resetting their password.
+// The Issue methods return a token to put in the link mailed to the user and
+// the Complete methods consume it when the link is followed, reporting what
+// happened as an Outcome rather than an error.
+package account
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Actions the flows' nonces are created for. A verification nonce carries
+// the address being verified as its payload.
+const (
+	VerifyAction = "verify-email"
+	ResetAction  = "reset-password"
+)
+
+// Outcome is how completing a flow went
+type Outcome int
+
+const (
+	// Completed means the token was good and has now been used up
+	Completed Outcome = iota
+	// Invalid means the token is unknown, malformed, for another flow or was
+	// replaced by a newer one
+	Invalid
+	// Used means the token was already used
+	Used
+	// Expired means the token was good but is too old
+	Expired
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Completed:
+		return "completed"
+	case Invalid:
+		return "invalid"
+	case Used:
+		return "used"
+	case Expired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+var (
+	// ErrInvalidEmail is returned for an address that can't be stored in a nonce's payload
+	ErrInvalidEmail = errors.New("account: invalid email address")
+
+	// ErrNotInspector is returned by New for a Service that isn't a nonce.Inspector
+	ErrNotInspector = errors.New("account: Service must implement nonce.Inspector")
+
+	// ErrNotPayloader is returned by New for a Service that isn't a nonce.Payloader
+	ErrNotPayloader = errors.New("account: Service must implement nonce.Payloader")
+)
+
+// maxEmail is the longest address SMTP can deliver to (RFC 5321)
+const maxEmail = 254
+
+// Config configures Flows
+type Config struct {
+	// Service stores the nonces. It must implement nonce.Inspector, since
+	// a token alone says which user and address it is for, and
+	// nonce.Payloader to store the address.
+	Service nonce.Service
+
+	// VerificationTTL is how long a verification token lasts. Zero uses 24 hours.
+	VerificationTTL time.Duration
+
+	// ResetTTL is how long a password reset token lasts. Zero uses 1 hour.
+	ResetTTL time.Duration
+}
+
+// Flows issues and completes email verifications and password resets
+type Flows struct {
+	cfg     Config
+	inspect nonce.Inspector
+	payload nonce.Payloader
+}
+
+// New returns Flows for cfg, or ErrNotInspector or ErrNotPayloader if
+// cfg.Service isn't a nonce.Inspector or a nonce.Payloader
+func New(cfg Config) (*Flows, error) {
+	if cfg.VerificationTTL <= 0 {
+		cfg.VerificationTTL = 24 * time.Hour
+	}
+	if cfg.ResetTTL <= 0 {
+		cfg.ResetTTL = time.Hour
+	}
+	in, ok := cfg.Service.(nonce.Inspector)
+	if !ok {
+		return nil, ErrNotInspector
+	}
+	p, ok := cfg.Service.(nonce.Payloader)
+	if !ok {
+		return nil, ErrNotPayloader
+	}
+	return &Flows{cfg: cfg, inspect: in, payload: p}, nil
+}
+
+// Verification is the result of CompleteVerification
+type Verification struct {
+	Outcome Outcome
+
+	// UserID and Email are who and what was verified. They are only set when
+	// Outcome is Completed.
+	UserID uuid.UUID
+	Email  string
+}
+
+// IssueVerification returns a token that verifies email belongs to uid.
+// It replaces the last verification token issued to uid, whatever address
+// it was for, so only the address the user asked for last can be verified.
+func (f *Flows) IssueVerification(uid uuid.UUID, email string) (string, error) {
+	if email == "" || len(email) > maxEmail || strings.ContainsAny(email, "\r\n") {
+		return "", ErrInvalidEmail
+	}
+	n, err := f.payload.NewWithPayload(VerifyAction, uid, f.cfg.VerificationTTL, email)
+	if err == nonce.ErrPayloadTooLarge {
+		return "", ErrInvalidEmail
+	}
+	if err != nil {
+		return "", err
+	}
+	return n.Token, nil
+}
+
+// CompleteVerification consumes a token from IssueVerification. The error is
+// only set when the Service fails; a bad token is reported by the Outcome.
+func (f *Flows) CompleteVerification(token string) (Verification, error) {
+	n, o, err := f.complete(token, isVerification)
+	if err != nil || o != Completed {
+		return Verification{Outcome: o}, err
+	}
+	return Verification{
+		Outcome: Completed,
+		UserID:  n.UserID,
+		Email:   n.Payload,
+	}, nil
+}
+
+// Reset is the result of CheckReset and CompleteReset
+type Reset struct {
+	Outcome Outcome
+
+	// UserID is whose password may be reset. It is only set when Outcome is Completed.
+	UserID uuid.UUID
+}
+
+// IssueReset returns a token that lets uid's password be reset, replacing
+// the last one issued to them
+func (f *Flows) IssueReset(uid uuid.UUID) (string, error) {
+	n, err := f.cfg.Service.New(ResetAction, uid, f.cfg.ResetTTL)
+	if err != nil {
+		return "", err
+	}
+	return n.Token, nil
+}
+
+// CheckReset reports whether a token from IssueReset is still good without
+// using it, e.g. before showing the new password form
+func (f *Flows) CheckReset(token string) (Reset, error) {
+	n, err := f.lookup(token, isReset)
+	if err != nil {
+		o, err := outcome(err)
+		return Reset{Outcome: o}, err
+	}
+	err = f.cfg.Service.Check(token, ResetAction, n.UserID)
+	if err != nil {
+		o, err := outcome(err)
+		return Reset{Outcome: o}, err
+	}
+	return Reset{Outcome: Completed, UserID: n.UserID}, nil
+}
+
+// CompleteReset consumes a token from IssueReset once the new password has
+// been submitted. The error is only set when the Service fails.
+func (f *Flows) CompleteReset(token string) (Reset, error) {
+	n, o, err := f.complete(token, isReset)
+	if err != nil || o != Completed {
+		return Reset{Outcome: o}, err
+	}
+	return Reset{Outcome: Completed, UserID: n.UserID}, nil
+}
+
+// complete consumes token if its nonce's action is one match accepts
+func (f *Flows) complete(token string, match func(action string) bool) (nonce.Nonce, Outcome, error) {
+	n, err := f.lookup(token, match)
+	if err == nil {
+		// consuming checks the nonce again, so a racing Complete can't use it too
+		n, err = f.cfg.Service.CheckThenConsume(token, n.Action, n.UserID)
+	}
+	if err != nil {
+		o, err := outcome(err)
+		return nonce.Nonce{}, o, err
+	}
+	return n, Completed, nil
+}
+
+// lookup returns the nonce for token if match accepts its action
+func (f *Flows) lookup(token string, match func(action string) bool) (nonce.Nonce, error) {
+	n, err := f.inspect.GetByToken(token)
+	if err == nil && !match(n.Action) {
+		err = nonce.ErrTokenNotFound
+	}
+	return n, err
+}
+
+func isVerification(action string) bool {
+	return action == VerifyAction
+}
+
+func isReset(action string) bool {
+	return action == ResetAction
+}
+
+// outcome turns an error from the Service into an Outcome, keeping the
+// error only if it isn't about the token
+func outcome(err error) (Outcome, error) {
+	switch {
+	case errors.Is(err, nonce.ErrTokenUsed):
+		return Used, nil
+	case errors.Is(err, nonce.ErrTokenExpired):
+		return Expired, nil
+	case errors.Is(err, nonce.ErrNoToken), errors.Is(err, nonce.ErrInvalidToken),
+		errors.Is(err, nonce.ErrTokenNotFound), errors.Is(err, nonce.ErrBindingMismatch):
+		return Invalid, nil
+	}
+	return Invalid, err
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package account
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/noncetest"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestVerification(t *testing.T) {
+	clock := noncetest.NewClock(time.Now())
+	s := nonce.NewInMemoryService(nonce.WithClock(clock))
+	defer s.Shutdown()
+	f, err := New(Config{Service: s})
+	if err != nil {
+		t.Fatalf("Expected to create Flows. Instead got the error: %v", err)
+	}
+	uid := uuid.NewV4()
+
+	token, err := f.IssueVerification(uid, "ann@example.com")
+	if err != nil {
+		t.Fatalf("Expected to issue a verification. Instead got the error: %v", err)
+	}
+	v, err := f.CompleteVerification(token)
+	if err != nil || v.Outcome != Completed || v.UserID != uid || v.Email != "ann@example.com" {
+		t.Fatalf("Expected the verification to complete for ann@example.com. Instead got: %+v, %v", v, err)
+	}
+	v, err = f.CompleteVerification(token)
+	if err != nil || v.Outcome != Used {
+		t.Errorf("Expected a second completion to be %v. Instead got: %+v, %v", Used, v, err)
+	}
+
+	first, _ := f.IssueVerification(uid, "ann@example.net")
+	token, _ = f.IssueVerification(uid, "ann@example.org")
+	v, err = f.CompleteVerification(first)
+	if err != nil || v.Outcome != Invalid {
+		t.Errorf("Expected a verification for a replaced address to be %v. Instead got: %+v, %v", Invalid, v, err)
+	}
+	n, err := s.(nonce.Inspector).GetByToken(token)
+	if err != nil || n.Action != VerifyAction || n.Payload != "ann@example.org" {
+		t.Errorf("Expected the address to be the payload of a %s nonce. Instead got: %+v, %v", VerifyAction, n, err)
+	}
+	clock.Add(25 * time.Hour)
+	v, err = f.CompleteVerification(token)
+	if err != nil || v.Outcome != Expired || v.Email != "" {
+		t.Errorf("Expected an old verification to be %v. Instead got: %+v, %v", Expired, v, err)
+	}
+
+	v, err = f.CompleteVerification("not-a-token")
+	if err != nil || v.Outcome != Invalid {
+		t.Errorf("Expected an unknown token to be %v. Instead got: %+v, %v", Invalid, v, err)
+	}
+	_, err = f.IssueVerification(uid, strings.Repeat("a", 300)+"@example.com")
+	if err != ErrInvalidEmail {
+		t.Errorf("Expected a long address to return %v. Instead got: %v", ErrInvalidEmail, err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	f, err := New(Config{Service: s})
+	if err != nil {
+		t.Fatalf("Expected to create Flows. Instead got the error: %v", err)
+	}
+	uid := uuid.NewV4()
+
+	first, err := f.IssueReset(uid)
+	if err != nil {
+		t.Fatalf("Expected to issue a reset. Instead got the error: %v", err)
+	}
+	token, _ := f.IssueReset(uid)
+	r, err := f.CheckReset(first)
+	if err != nil || r.Outcome != Invalid {
+		t.Errorf("Expected a replaced reset to be %v. Instead got: %+v, %v", Invalid, r, err)
+	}
+
+	r, err = f.CheckReset(token)
+	if err != nil || r.Outcome != Completed || r.UserID != uid {
+		t.Fatalf("Expected the reset to check out. Instead got: %+v, %v", r, err)
+	}
+	verify, _ := f.IssueVerification(uid, "ann@example.com")
+	r, err = f.CompleteReset(verify)
+	if err != nil || r.Outcome != Invalid {
+		t.Errorf("Expected a verification token to be %v for a reset. Instead got: %+v, %v", Invalid, r, err)
+	}
+	r, err = f.CompleteReset(token)
+	if err != nil || r.Outcome != Completed || r.UserID != uid {
+		t.Fatalf("Expected the reset to complete. Instead got: %+v, %v", r, err)
+	}
+	r, err = f.CheckReset(token)
+	if err != nil || r.Outcome != Used {
+		t.Errorf("Expected a completed reset to be %v. Instead got: %+v, %v", Used, r, err)
+	}
+}
+
+func TestNewWrappedService(t *testing.T) {
+	wrapped := map[string]nonce.Service{
+		"cached":     mustService(nonce.NewCachedService(nonce.NewInMemoryService(), nonce.NewInMemoryService())),
+		"failover":   mustService(nonce.NewFailoverService(nonce.NewInMemoryService(), nonce.NewInMemoryService())),
+		"dual write": mustService(nonce.NewDualWriteService(nonce.NewInMemoryService(), nonce.NewInMemoryService(), nonce.DualWriteMode{})),
+	}
+	for name, s := range wrapped {
+		f, err := New(Config{Service: s})
+		if err != nil {
+			t.Fatalf("Expected a %s Service to be accepted. Instead got the error: %v", name, err)
+		}
+		token, err := f.IssueReset(uuid.NewV4())
+		if err != nil {
+			t.Fatalf("Expected to issue a reset through a %s Service. Instead got the error: %v", name, err)
+		}
+		r, err := f.CompleteReset(token)
+		if err != nil || r.Outcome != Completed {
+			t.Errorf("Expected the reset to complete through a %s Service. Instead got: %+v, %v", name, r, err)
+		}
+		s.Shutdown()
+	}
+
+	_, err := New(Config{Service: &noncetest.Mock{}})
+	if err != ErrNotInspector {
+		t.Errorf("Expected %v for a Service without GetByToken. Instead got: %v", ErrNotInspector, err)
+	}
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	_, err = New(Config{Service: inspectorOnly{s, s.(nonce.Inspector)}})
+	if err != ErrNotPayloader {
+		t.Errorf("Expected %v for a Service without NewWithPayload. Instead got: %v", ErrNotPayloader, err)
+	}
+}
+
+// mustService returns s, panicking on err, for Services a test builds in an expression
+func mustService(s nonce.Service, err error) nonce.Service {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// inspectorOnly hides every optional interface of a Service but Inspector
+type inspectorOnly struct {
+	nonce.Service
+	nonce.Inspector
+}
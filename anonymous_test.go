@@ -0,0 +1,113 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestAnonymousNonces(t *testing.T) {
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newService()
+			defer s.Shutdown()
+
+			first, err := s.New("signup", uuid.Nil, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add an anonymous nonce. Instead got the error: %v", err)
+			}
+			second, err := s.New("signup", uuid.Nil, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add an anonymous nonce. Instead got the error: %v", err)
+			}
+			batch, err := s.(Batcher).NewBatch(context.Background(), []NewRequest{
+				{Action: "signup", UserID: uuid.Nil, ExpiresIn: time.Minute},
+				{Action: "signup", UserID: uuid.Nil, ExpiresIn: time.Minute},
+			})
+			if err != nil {
+				t.Fatalf("Expected to add a batch of anonymous nonces. Instead got the error: %v", err)
+			}
+			for _, n := range append([]Nonce{first, second}, batch...) {
+				err = s.Check(n.Token, "signup", uuid.Nil)
+				if err != nil {
+					t.Errorf("Expected anonymous nonce %s to stay valid. Instead got: %v", n.Token, err)
+				}
+			}
+
+			_, err = s.Get("signup", uuid.Nil)
+			if err != ErrUserRequired {
+				t.Errorf("Expected Get for uuid.Nil to return %v. Instead got: %v", ErrUserRequired, err)
+			}
+
+			uid := uuid.NewV4()
+			n, err := s.New("signup", uid, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+			}
+			err = s.Check(n.Token, "signup", uuid.Nil)
+			if !errors.Is(err, ErrUserRequired) {
+				t.Errorf("Expected checking a user's nonce anonymously to fail with %v. Instead got: %v", ErrUserRequired, err)
+			}
+			err = s.Check(first.Token, "signup", uid)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected checking an anonymous nonce as a user to fail with %v. Instead got: %v", ErrInvalidToken, err)
+			}
+
+			_, err = s.CheckThenConsume(first.Token, "signup", uuid.Nil)
+			if err != nil {
+				t.Errorf("Expected to consume an anonymous nonce. Instead got: %v", err)
+			}
+		})
+	}
+}
+
+func TestWithUserRequired(t *testing.T) {
+	s := NewInMemoryService(WithUserRequired())
+	defer s.Shutdown()
+
+	_, err := s.New("signup", uuid.Nil, time.Minute)
+	if err != ErrUserRequired {
+		t.Errorf("Expected New for uuid.Nil to return %v. Instead got: %v", ErrUserRequired, err)
+	}
+	_, err = s.(Batcher).NewBatch(context.Background(), []NewRequest{
+		{Action: "signup", UserID: uuid.NewV4(), ExpiresIn: time.Minute},
+		{Action: "signup", UserID: uuid.Nil, ExpiresIn: time.Minute},
+	})
+	if err != ErrUserRequired {
+		t.Errorf("Expected NewBatch with an anonymous request to return %v. Instead got: %v", ErrUserRequired, err)
+	}
+
+	uid := uuid.NewV4()
+	n, err := s.New("signup", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(n.Token, "signup", uuid.Nil)
+	if err != ErrUserRequired {
+		t.Errorf("Expected Check for uuid.Nil to return %v. Instead got: %v", ErrUserRequired, err)
+	}
+	_, err = s.CheckThenConsume(n.Token, "signup", uid)
+	if err != nil {
+		t.Errorf("Expected to consume nonce. Instead got: %v", err)
+	}
+}
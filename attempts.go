@@ -0,0 +1,168 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// WithAttemptLimit locks out a token, and the user it was checked for, once
+// max Check or consume calls have failed with ErrInvalidToken,
+// ErrBindingMismatch or ErrTokenNotFound within window. The next attempts get ErrTooManyAttempts
+// until window has passed, and the nonce is burned by consuming it, so short
+// codes can't be brute forced. Attempts are counted by this Service instance only.
+func WithAttemptLimit(max int, window time.Duration) Option {
+	return func(cfg *config) {
+		if max > 0 && window > 0 {
+			cfg.attempts = &attemptLimit{
+				max:     max,
+				window:  window,
+				byToken: make(map[string]attemptCount),
+				byUser:  make(map[uuid.UUID]attemptCount),
+			}
+		}
+	}
+}
+
+// attemptLimit counts failed attempts per token and per user
+type attemptLimit struct {
+	sync.Mutex
+	max     int
+	window  time.Duration
+	byToken map[string]attemptCount
+	byUser  map[uuid.UUID]attemptCount
+	// swept is when counts whose window had passed were last dropped
+	swept time.Time
+}
+
+// attemptCount is the number of failures since first
+type attemptCount struct {
+	n     int
+	first time.Time
+}
+
+// interceptor counts failures for s. checkToken gives the key a token is
+// counted under, so the same token mangled in different ways shares one count.
+func (a *attemptLimit) interceptor(s Service, clock Clock, checkToken func(string) (string, error)) Interceptor {
+	return func(c Call, next func() error) error {
+		var token, action string
+		var uid uuid.UUID
+		switch c.Method {
+		case "Check", "CheckBound", "CheckThenConsume", "CheckThenConsumeWithMeta", "ConsumeAndChain":
+			token, action, uid = c.Args[0].(string), c.Args[1].(string), c.Args[2].(uuid.UUID)
+		case "Consume", "ConsumeWithMeta":
+			token = c.Args[0].(string)
+		default:
+			return next()
+		}
+		key := token
+		if k, err := checkToken(token); err == nil {
+			key = k
+		}
+
+		if a.locked(key, uid, clock.Now()) {
+			return ErrTooManyAttempts
+		}
+
+		err := next()
+		switch {
+		case err == nil:
+			a.reset(key, uid)
+			return nil
+		case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrBindingMismatch), err == ErrTokenNotFound:
+		default:
+			return err
+		}
+
+		tokenLocked, userLocked := a.fail(key, uid, clock.Now())
+		if tokenLocked {
+			s.Consume(token)
+		}
+		if userLocked {
+			n, err := s.Get(action, uid)
+			if err == nil {
+				s.ConsumeByID(n.ID, action, uid)
+			}
+		}
+		if tokenLocked || userLocked {
+			return ErrTooManyAttempts
+		}
+		return err
+	}
+}
+
+// locked reports whether token or uid has reached the limit at t
+func (a *attemptLimit) locked(token string, uid uuid.UUID, t time.Time) bool {
+	a.Lock()
+	defer a.Unlock()
+	return a.reached(a.byToken[token], t) || (uid != uuid.Nil && a.reached(a.byUser[uid], t))
+}
+
+// fail records a failed attempt at t and reports which counts reached the limit
+func (a *attemptLimit) fail(token string, uid uuid.UUID, t time.Time) (tokenLocked, userLocked bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	// drop counts whose window has passed so the maps don't grow forever.
+	// Doing so once a window keeps a failure from costing a pass over every
+	// count; until then count and reached ignore the stale ones.
+	if t.Sub(a.swept) > a.window {
+		for k, v := range a.byToken {
+			if t.Sub(v.first) > a.window {
+				delete(a.byToken, k)
+			}
+		}
+		for k, v := range a.byUser {
+			if t.Sub(v.first) > a.window {
+				delete(a.byUser, k)
+			}
+		}
+		a.swept = t
+	}
+
+	if token != "" {
+		a.byToken[token] = a.count(a.byToken[token], t)
+		tokenLocked = a.reached(a.byToken[token], t)
+	}
+	if uid != uuid.Nil {
+		a.byUser[uid] = a.count(a.byUser[uid], t)
+		userLocked = a.reached(a.byUser[uid], t)
+	}
+	return tokenLocked, userLocked
+}
+
+// reset forgets the failures for token and uid after a successful attempt
+func (a *attemptLimit) reset(token string, uid uuid.UUID) {
+	a.Lock()
+	delete(a.byToken, token)
+	delete(a.byUser, uid)
+	a.Unlock()
+}
+
+func (a *attemptLimit) count(c attemptCount, t time.Time) attemptCount {
+	if c.n == 0 || t.Sub(c.first) > a.window {
+		return attemptCount{n: 1, first: t}
+	}
+	c.n++
+	return c
+}
+
+func (a *attemptLimit) reached(c attemptCount, t time.Time) bool {
+	return c.n >= a.max && t.Sub(c.first) <= a.window
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestAttemptLimit(t *testing.T) {
+	clock := &testClock{}
+	s := NewInMemoryService(WithClock(clock), WithAttemptLimit(3, time.Minute))
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	// the wrong user guessing at the token locks it out on the third try
+	for i := 0; i < 2; i++ {
+		err = s.Check(n.Token, tNonce.Action, uuid.NewV4())
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Expected ErrInvalidToken. Instead got: %v", err)
+		}
+	}
+	_, err = s.CheckThenConsume(n.Token, tNonce.Action, uuid.NewV4())
+	if err != ErrTooManyAttempts {
+		t.Fatalf("Expected ErrTooManyAttempts. Instead got: %v", err)
+	}
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != ErrTooManyAttempts {
+		t.Fatalf("Expected the token to stay locked out. Instead got: %v", err)
+	}
+
+	// once the window passes the lockout lifts, but the nonce was burned
+	clock.Add(2 * time.Minute)
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("Expected ErrTokenUsed. Instead got: %v", err)
+	}
+
+	// guessing tokens for a user locks the user out and burns their nonce
+	uid := uuid.NewV4()
+	n, err = s.New(tNonce.Action, uid, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		guess, _ := DefaultHasher.Sum(nil)
+		err = s.Check(base64.URLEncoding.EncodeToString(guess), tNonce.Action, uid)
+	}
+	if err != ErrTooManyAttempts {
+		t.Fatalf("Expected ErrTooManyAttempts. Instead got: %v", err)
+	}
+	clock.Add(2 * time.Minute)
+	_, err = s.CheckThenConsume(n.Token, tNonce.Action, uid)
+	if !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("Expected ErrTokenUsed. Instead got: %v", err)
+	}
+}
+
+func TestAttemptLimitNormalizesTokens(t *testing.T) {
+	s := NewInMemoryService(WithAttemptLimit(3, time.Minute))
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	// a quoted or wrapped copy of the token is still the same token
+	for _, token := range []string{n.Token, "> " + n.Token, n.Token[:40] + "\r\n" + n.Token[40:]} {
+		err = s.Check(token, tNonce.Action, uuid.NewV4())
+	}
+	if err != ErrTooManyAttempts {
+		t.Fatalf("Expected the variants to share one count. Instead got: %v", err)
+	}
+}
+
+func TestAttemptLimitSweep(t *testing.T) {
+	a := &attemptLimit{
+		max:     3,
+		window:  time.Minute,
+		byToken: make(map[string]attemptCount),
+		byUser:  make(map[uuid.UUID]attemptCount),
+	}
+	start := time.Now()
+	a.fail("a", uuid.NewV4(), start)
+	a.fail("b", uuid.NewV4(), start.Add(30*time.Second))
+
+	// "a" is stale but isn't dropped until a window has passed since the last sweep
+	a.fail("c", uuid.NewV4(), start.Add(61*time.Second))
+	if len(a.byToken) != 2 || len(a.byUser) != 2 {
+		t.Fatalf("Expected the expired count to be dropped. Instead got %d tokens and %d users", len(a.byToken), len(a.byUser))
+	}
+	a.fail("d", uuid.NewV4(), start.Add(100*time.Second))
+	if len(a.byToken) != 3 {
+		t.Fatalf("Expected no sweep within a window of the last one. Instead got %d tokens", len(a.byToken))
+	}
+	if a.locked("b", uuid.Nil, start.Add(100*time.Second)) {
+		t.Fatalf("Expected a stale count not to lock")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import "time"
+
+// AuditEvent records an operation that changed stored nonces in bulk
+type AuditEvent struct {
+	Time time.Time
+
+	// Method is the Service method that made the change
+	Method string
+
+	// Filter selected the nonces the change applied to
+	Filter Filter
+
+	// Detail describes the change, e.g. "extended by 2h0m0s"
+	Detail string
+
+	// Affected is how many nonces were changed before Err, if any, stopped the operation
+	Affected int
+	Err      error
+}
+
+// Auditor receives AuditEvents. It is called synchronously, so it should
+// hand slow work off rather than block the operation it is auditing.
+type Auditor interface {
+	Audit(e AuditEvent)
+}
+
+// WithAuditor sets the Auditor bulk changes are reported to.
+// By default they are not recorded.
+func WithAuditor(a Auditor) Option {
+	return func(cfg *config) {
+		cfg.auditor = a
+	}
+}
+
+// audit reports e to the configured Auditor, if any
+func (c config) audit(e AuditEvent) {
+	if c.auditor == nil || !c.sampling.keep(e.Method, e.Err) {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = c.clock.Now()
+	}
+	c.auditor.Audit(e)
+}
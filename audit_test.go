@@ -0,0 +1,51 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"testing"
+	"time"
+)
+
+type testAuditor []AuditEvent
+
+func (a *testAuditor) Audit(e AuditEvent) {
+	*a = append(*a, e)
+}
+
+func TestAuditExtendExpiry(t *testing.T) {
+	auditor := &testAuditor{}
+	s := NewInMemoryService(WithAuditor(auditor))
+	defer s.Shutdown()
+
+	_, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	f := Filter{Action: tNonce.Action, Outstanding: true}
+	_, err = s.(ExpiryExtender).ExtendExpiry(f, 2*time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to extend nonces. Instead got the error: %v", err)
+	}
+
+	events := *auditor
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 audit event. Instead got: %d", len(events))
+	}
+	e := events[0]
+	if e.Method != "ExtendExpiry" || e.Filter != f || e.Affected != 1 || e.Detail != "extended by 2h0m0s" || e.Time.IsZero() {
+		t.Fatalf("Expected the audit event to describe the change. Instead got: %+v", e)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// AwaitPollInterval is how often AwaitConsumption re-reads a nonce to notice
+// consumes made by other instances sharing the same store.
+// Consumes made through the same Service wake it straight away.
+// It is read when a Service is created.
+var AwaitPollInterval = time.Second
+
+// Awaiter is implemented by Services that can wait for a nonce to be consumed
+type Awaiter interface {
+	// AwaitConsumption blocks until the nonce with id is consumed and returns it.
+	// It returns ErrInvalidToken or ErrTokenExpired once the nonce can no longer be
+	// consumed, ErrTokenNotFound if there is no such nonce, or ctx.Err() when ctx is done.
+	AwaitConsumption(ctx context.Context, id uuid.UUID) (Nonce, error)
+}
+
+// WaitForConsume blocks until the nonce for token is consumed and returns it,
+// as AwaitConsumption does for an ID, e.g. to sign in a device once its user
+// approves on their phone. s must implement Inspector and Awaiter; other
+// Services get ErrNotSupported.
+func WaitForConsume(ctx context.Context, s Service, token string) (Nonce, error) {
+	in, ok := s.(Inspector)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	a, ok := s.(Awaiter)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	n, err := in.GetByToken(token)
+	if err != nil {
+		return Nonce{}, err
+	}
+	return a.AwaitConsumption(ctx, n.ID)
+}
+
+// ConsumeResult is what WaitForConsume returned
+type ConsumeResult struct {
+	Nonce Nonce
+	Err   error
+}
+
+// SubscribeConsume is WaitForConsume returning straight away with a channel
+// that receives its result, then is closed. Cancel ctx to stop waiting.
+func SubscribeConsume(ctx context.Context, s Service, token string) <-chan ConsumeResult {
+	ch := make(chan ConsumeResult, 1)
+	go func() {
+		n, err := WaitForConsume(ctx, s, token)
+		ch <- ConsumeResult{Nonce: n, Err: err}
+		close(ch)
+	}()
+	return ch
+}
+
+// consumeWaiters wakes AwaitConsumption callers when their nonce is consumed
+type consumeWaiters struct {
+	sync.Mutex
+	waiting map[uuid.UUID][]chan Nonce
+}
+
+func newConsumeWaiters() *consumeWaiters {
+	return &consumeWaiters{
+		waiting: make(map[uuid.UUID][]chan Nonce),
+	}
+}
+
+// consumed hands n to everyone waiting on its ID
+func (w *consumeWaiters) consumed(n Nonce) {
+	w.Lock()
+	for _, ch := range w.waiting[n.ID] {
+		ch <- n
+	}
+	delete(w.waiting, n.ID)
+	w.Unlock()
+}
+
+// wait registers a waiter for id and returns a func that unregisters it
+func (w *consumeWaiters) wait(id uuid.UUID) (<-chan Nonce, func()) {
+	ch := make(chan Nonce, 1)
+	w.Lock()
+	w.waiting[id] = append(w.waiting[id], ch)
+	w.Unlock()
+
+	return ch, func() {
+		w.Lock()
+		chans := w.waiting[id]
+		for i, c := range chans {
+			if c == ch {
+				w.waiting[id] = append(chans[:i], chans[i+1:]...)
+				break
+			}
+		}
+		if len(w.waiting[id]) == 0 {
+			delete(w.waiting, id)
+		}
+		w.Unlock()
+	}
+}
+
+// await implements AwaitConsumption for a backend that reads nonces by ID with get
+func (w *consumeWaiters) await(ctx context.Context, id uuid.UUID, cfg config, get func(id uuid.UUID) (Nonce, error)) (Nonce, error) {
+	ch, stop := w.wait(id)
+	defer stop()
+	ticker := time.NewTicker(cfg.awaitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := get(id)
+		if err != nil {
+			return Nonce{}, err
+		}
+		if n.IsUsed {
+			return n, nil
+		}
+		if n.IsValid == false {
+			return Nonce{}, ErrInvalidToken
+		}
+		// a nonce within the expiry leeway can still be consumed
+		if n.ExpiresAt.After(cfg.expiryCutoff(cfg.clock.Now())) == false {
+			return Nonce{}, ErrTokenExpired
+		}
+
+		select {
+		case n = <-ch:
+			return n, nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return Nonce{}, ctx.Err()
+		}
+	}
+}
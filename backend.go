@@ -0,0 +1,332 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Backend is what a store package, such as nonce/sqlx or nonce/mongo, builds
+// its Service on. It holds the options the Service was created with and the
+// state its handles share: the writes remembered for WithReadYourWrites, the
+// AwaitConsumption waiters and the sweeps removing expired nonces. Its methods
+// apply the options the same way for every store, so a store only has to read
+// and write nonces. Applications don't need it.
+type Backend struct {
+	cfg     config
+	recent  *recentWrites
+	waiters *consumeWaiters
+	sweeps  *sweepTracker
+}
+
+// NewBackend returns the Backend for a Service created with opts
+func NewBackend(opts ...Option) *Backend {
+	cfg := newConfig(opts)
+	return &Backend{
+		cfg:     cfg,
+		recent:  newRecentWrites(cfg.readYourWrites),
+		waiters: newConsumeWaiters(),
+		sweeps:  newSweepTracker(cfg),
+	}
+}
+
+// BackendOption returns an Option only a store package understands, such as
+// sqlx.WithTableName. It records value under key for the package to read back
+// with Backend.Value. Like a context key, key should be of an unexported type
+// the package defines, so options from different packages can't collide.
+func BackendOption(key, value interface{}) Option {
+	return func(cfg *config) {
+		if cfg.values == nil {
+			cfg.values = make(map[interface{}]interface{})
+		}
+		cfg.values[key] = value
+	}
+}
+
+// Value returns what the last BackendOption for key recorded, or nil
+func (b *Backend) Value(key interface{}) interface{} {
+	return b.cfg.values[key]
+}
+
+// Tenant returns the Backend for namespace ns, sharing b's state, for ForTenant
+func (b *Backend) Tenant(ns string) *Backend {
+	t := *b
+	t.cfg = b.cfg.tenant(ns)
+	return &t
+}
+
+// Wrap returns s decorated with the counters, journal and limits that are
+// configured. Constructors return what it returns.
+func (b *Backend) Wrap(s Service) Service {
+	return b.cfg.wrap(s)
+}
+
+// Clock is the WithClock Clock
+func (b *Backend) Clock() Clock {
+	return b.cfg.clock
+}
+
+// Logger is the WithLogger Logger
+func (b *Backend) Logger() Logger {
+	return b.cfg.logger
+}
+
+// Hooks are the WithHooks Hooks, so a store can skip work for a hook that isn't set
+func (b *Backend) Hooks() Hooks {
+	return b.cfg.hooks
+}
+
+// Namespace is the WithNamespace namespace
+func (b *Backend) Namespace() string {
+	return b.cfg.namespace
+}
+
+// KeyPrefix is the WithKeyPrefix prefix with the namespace's keys under it
+func (b *Backend) KeyPrefix() string {
+	return b.cfg.namespacePrefix()
+}
+
+// ListChunkSize and PurgeBatchSize are the package tuning variables as
+// they were when the Service was created
+func (b *Backend) ListChunkSize() int {
+	return b.cfg.listChunkSize
+}
+
+func (b *Backend) PurgeBatchSize() int {
+	return b.cfg.purgeBatchSize
+}
+
+// CheckToken does a basic check of token and returns it normalized for the
+// lookup, hashed under WithTokenHashing
+func (b *Backend) CheckToken(token string) (string, error) {
+	return b.cfg.checkToken(token)
+}
+
+// NewNonce returns a new nonce created at t, ready to store
+func (b *Backend) NewNonce(action string, uid uuid.UUID, expiresIn time.Duration, t time.Time) (Nonce, error) {
+	return b.cfg.newNonce(action, uid, expiresIn, t)
+}
+
+// NewBoundNonce is NewNonce for NewBound and NewWithPayload, created now
+func (b *Backend) NewBoundNonce(action string, uid uuid.UUID, expiresIn time.Duration, binding Binding, payload string) (Nonce, error) {
+	return b.cfg.newBoundNonce(action, uid, expiresIn, binding, payload)
+}
+
+// NewBatch generates the nonces for requests. Like a series of New calls, only
+// the last nonce for each user and action stays valid; newest holds those.
+func (b *Backend) NewBatch(requests []NewRequest) (nonces []Nonce, newest map[string]Nonce, err error) {
+	return b.cfg.newBatch(requests)
+}
+
+// NewID returns a new nonce ID
+func (b *Backend) NewID() uuid.UUID {
+	return b.cfg.newID()
+}
+
+// FillNonce generates whatever identifying fields PutNonce was given n
+// without at t, and moves it into the Service's namespace
+func (b *Backend) FillNonce(n Nonce, t time.Time) (Nonce, error) {
+	return b.cfg.fillNonce(n, t)
+}
+
+// CheckNonce returns why the stored n can't be accepted for action and uid at t, or nil
+func (b *Backend) CheckNonce(n Nonce, action string, uid uuid.UUID, t time.Time) error {
+	return b.cfg.checkNonce(n, action, uid, t)
+}
+
+// CheckBound is CheckNonce for the request meta describes
+func (b *Backend) CheckBound(n Nonce, action string, uid uuid.UUID, meta ConsumeMeta, t time.Time) error {
+	return b.cfg.checkBound(n, action, uid, meta, t)
+}
+
+// CheckMeta returns ErrPayloadTooLarge if a field of meta is over the Meta limit
+func (b *Backend) CheckMeta(meta ConsumeMeta) error {
+	return b.cfg.checkMeta(meta)
+}
+
+// Usable reports whether n is valid, unused and unexpired at t
+func (b *Backend) Usable(n Nonce, t time.Time) bool {
+	return b.cfg.usable(n, t)
+}
+
+// RenewNonce returns n renewed by extendBy at t, or why it can't be
+func (b *Backend) RenewNonce(n Nonce, extendBy time.Duration, t time.Time) (Nonce, error) {
+	return b.cfg.renewNonce(n, extendBy, t)
+}
+
+// ExpiryCutoff is the ExpiresAt a nonce must be after to be usable at t
+func (b *Backend) ExpiryCutoff(t time.Time) time.Time {
+	return b.cfg.expiryCutoff(t)
+}
+
+// RemoveAt is when n can be removed from the store, for TTLs and leases
+func (b *Backend) RemoveAt(n Nonce) time.Time {
+	return b.cfg.removeAt(n)
+}
+
+// Retained reports whether WithRetention keeps the expired n at t
+func (b *Backend) Retained(n Nonce, t time.Time) bool {
+	return b.cfg.retained(n, t)
+}
+
+// Issue returns n as the caller creating it gets it, with the token to present
+func (b *Backend) Issue(n Nonce) Nonce {
+	return n.issue()
+}
+
+// IssueAll is Issue for every nonce of a batch
+func (b *Backend) IssueAll(nonces []Nonce) []Nonce {
+	return issueAll(nonces)
+}
+
+// Seal encrypts n's WithFieldEncryption fields before it is stored
+func (b *Backend) Seal(n Nonce) (Nonce, error) {
+	return b.cfg.seal(n)
+}
+
+// SealMeta is Seal for the ConsumeMeta stored when token is consumed
+func (b *Backend) SealMeta(meta ConsumeMeta, token string) (ConsumeMeta, error) {
+	return b.cfg.sealMeta(meta, token)
+}
+
+// Open decrypts what Seal encrypted
+func (b *Backend) Open(n Nonce) Nonce {
+	return b.cfg.open(n)
+}
+
+// MarshalNonce is MarshalBinary for the key-value stores, sealing n first
+func (b *Backend) MarshalNonce(n Nonce) ([]byte, error) {
+	return b.cfg.marshalNonce(n)
+}
+
+// InNamespace reports whether n belongs to the Service's namespace
+func (b *Backend) InNamespace(n Nonce) bool {
+	return b.cfg.inNamespace(n)
+}
+
+// Scope restricts f to the Service's namespace
+func (b *Backend) Scope(f Filter) Filter {
+	return b.cfg.scope(f)
+}
+
+// Unscoped returns f matching every namespace, for sweeps of the whole store
+func (b *Backend) Unscoped(f Filter) Filter {
+	f.anyNamespace = true
+	return f
+}
+
+// Created, Invalidated and ExpiredRemoved call the Hooks for nonces the store
+// wrote. Consumed also wakes the AwaitConsumption callers waiting on n.
+func (b *Backend) Created(nonces ...Nonce) {
+	b.cfg.created(nonces...)
+}
+
+func (b *Backend) Consumed(n Nonce) {
+	b.waiters.consumed(n)
+	b.cfg.consumed(n)
+}
+
+func (b *Backend) Invalidated(nonces ...Nonce) {
+	b.cfg.invalidated(nonces...)
+}
+
+func (b *Backend) ExpiredRemoved(nonces ...Nonce) {
+	b.cfg.expiredRemoved(nonces...)
+}
+
+// Audit reports e to the WithAuditor Auditor, if any
+func (b *Backend) Audit(e AuditEvent) {
+	b.cfg.audit(e)
+}
+
+// Remember records n as written at t for WithReadYourWrites.
+// Remember and the methods below do nothing without it.
+func (b *Backend) Remember(n Nonce, t time.Time) {
+	b.recent.put(n, t)
+}
+
+// RememberInvalidated mirrors New creating n by marking the older nonces
+// remembered for the same user, action and namespace invalid
+func (b *Backend) RememberInvalidated(n Nonce) {
+	b.recent.invalidateOthers(n)
+}
+
+// Remembered returns the nonce written for token if it is still remembered at t
+func (b *Backend) Remembered(token string, t time.Time) (Nonce, bool) {
+	return b.recent.get(token, t)
+}
+
+// NewestRemembered returns the newest usable nonce remembered for action and uid
+func (b *Backend) NewestRemembered(action string, uid uuid.UUID, t time.Time) (Nonce, bool) {
+	return b.recent.newest(action, uid, b.cfg.namespace, t, b.cfg.usable)
+}
+
+// Merge combines what the store returned with what was remembered for it
+func (b *Backend) Merge(stored Nonce, t time.Time) Nonce {
+	return b.recent.merge(stored, t)
+}
+
+// Await implements AwaitConsumption for a store that reads nonces by ID with get
+func (b *Backend) Await(ctx context.Context, id uuid.UUID, get func(id uuid.UUID) (Nonce, error)) (Nonce, error) {
+	return b.waiters.await(ctx, id, b.cfg, get)
+}
+
+// RemoveExpired sweeps expired nonces away with purge straight away and then
+// every RemoveExpiredInterval until quit is closed, for stores that can't
+// expire nonces themselves. Run it in its own goroutine.
+func (b *Backend) RemoveExpired(quit <-chan struct{}, purge func(ctx context.Context, limit int) (int64, error)) {
+	b.cfg.removeExpired(quit, b.sweeps, purge)
+}
+
+// CheckSweeps returns ErrSweepStalled if RemoveExpired last succeeded too long ago
+func (b *Backend) CheckSweeps() error {
+	return b.sweeps.check()
+}
+
+// LastSweep is when RemoveExpired last succeeded
+func (b *Backend) LastSweep() time.Time {
+	return b.sweeps.lastSweep()
+}
+
+// ReportSweeps adds RemoveExpired's passes to st
+func (b *Backend) ReportSweeps(st *Stats) {
+	b.sweeps.report(st)
+}
+
+// DeleteAllForUser implements UserDeleter: it checks uid, runs del and audits it
+func (b *Backend) DeleteAllForUser(uid uuid.UUID, del func() (int64, error)) (int64, error) {
+	return b.cfg.deleteAllForUser(uid, del)
+}
+
+// ExtendExpiry implements ExpiryExtender by listing the nonces matching f in l
+// and calling extend with each one's new expiry. extend reports whether it
+// changed the nonce.
+func (b *Backend) ExtendExpiry(l Lister, f Filter, by time.Duration, extend func(n Nonce, expiresAt time.Time) (bool, error)) (int, error) {
+	return b.cfg.extendExpiry(l, f, by, extend)
+}
+
+// PurgeExpired implements Purger by listing the expired nonces in l and
+// handing them to remove in batches of PurgeBatchSize
+func (b *Backend) PurgeExpired(ctx context.Context, l Lister, limit int, remove func(batch []Nonce, t time.Time) (int64, error)) (int64, error) {
+	return b.cfg.purgeExpired(ctx, l, limit, remove)
+}
+
+// NewIdempotencyRecord returns the record reserving key for uid at t
+func (b *Backend) NewIdempotencyRecord(key string, uid uuid.UUID, ttl time.Duration, t time.Time) IdempotencyRecord {
+	return newIdempotencyRecord(b.cfg.namespace, key, uid, ttl, t)
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+	"github.com/bryanjeal/go-nonce"
+	noncebadger "github.com/bryanjeal/go-nonce/badger"
+	noncecassandra "github.com/bryanjeal/go-nonce/cassandra"
+	nonceetcd "github.com/bryanjeal/go-nonce/etcd"
+	noncemongo "github.com/bryanjeal/go-nonce/mongo"
+	noncesqlx "github.com/bryanjeal/go-nonce/sqlx"
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/jmoiron/sqlx"
+	// the sqlx tests run against sqlite3
+	_ "github.com/mattn/go-sqlite3"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func init() {
+	nonce.TestStores = append(nonce.TestStores,
+		nonce.TestStore{Name: "sqlx", Open: openSQLStore},
+		nonce.TestStore{Name: "badger", Open: openBadgerStore, Skip: map[string]string{
+			"RemoveExpired": "Badger TTLs run out in real time, not on the test clock",
+		}},
+		nonce.TestStore{Name: "mongo", Open: openMongoStore, Skip: map[string]string{
+			"PurgeExpired":  "MongoDB's TTL monitor removes expired nonces on its own schedule",
+			"RemoveExpired": "MongoDB's TTL monitor removes expired nonces on its own schedule",
+		}},
+		nonce.TestStore{Name: "etcd", Open: openEtcdStore, Skip: map[string]string{
+			"RemoveExpired": "etcd leases run out in real time, not on the test clock",
+		}},
+		nonce.TestStore{Name: "cassandra", Open: openCassandraStore, Skip: map[string]string{
+			"RemoveExpired": "Cassandra TTLs run out in real time, not on the test clock",
+		}},
+	)
+}
+
+// openSQLStore opens a migrated in-memory sqlite database
+func openSQLStore(tb testing.TB) (func(opts ...nonce.Option) nonce.Service, func()) {
+	db := sqlx.MustConnect("sqlite3", ":memory:")
+	tb.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	err := noncesqlx.Migrate(context.Background(), db)
+	if err != nil {
+		tb.Fatalf("Expected Migrate to succeed. Instead got: %v", err)
+	}
+	newService := func(opts ...nonce.Option) nonce.Service {
+		return noncesqlx.NewService(db, opts...)
+	}
+	return newService, func() { db.MustExec("DELETE FROM nonce;") }
+}
+
+// openBadgerStore opens an in-memory BadgerDB
+func openBadgerStore(tb testing.TB) (func(opts ...nonce.Option) nonce.Service, func()) {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		tb.Fatalf("Expected to open BadgerDB. Instead got the error: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	newService := func(opts ...nonce.Option) nonce.Service {
+		return noncebadger.NewService(db, opts...)
+	}
+	return newService, func() { db.DropAll() }
+}
+
+// MongoDB needs a running server so only test against it when one is configured
+func openMongoStore(tb testing.TB) (func(opts ...nonce.Option) nonce.Service, func()) {
+	uri := os.Getenv("NONCE_TEST_MONGO_URI")
+	if uri == "" {
+		return nil, nil
+	}
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		tb.Fatalf("Expected to connect to MongoDB. Instead got the error: %v", err)
+	}
+	tb.Cleanup(func() { client.Disconnect(context.Background()) })
+	coll := client.Database("nonce_test").Collection("nonce")
+	newService := func(opts ...nonce.Option) nonce.Service {
+		return noncemongo.NewService(coll, opts...)
+	}
+	return newService, func() { coll.DeleteMany(context.Background(), bson.M{}) }
+}
+
+// likewise etcd, e.g. NONCE_TEST_ETCD_ENDPOINTS=localhost:2379
+func openEtcdStore(tb testing.TB) (func(opts ...nonce.Option) nonce.Service, func()) {
+	endpoints := os.Getenv("NONCE_TEST_ETCD_ENDPOINTS")
+	if endpoints == "" {
+		return nil, nil
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		tb.Fatalf("Expected to connect to etcd. Instead got the error: %v", err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	const prefix = "nonce_test/"
+	newService := func(opts ...nonce.Option) nonce.Service {
+		return nonceetcd.NewService(client, append([]nonce.Option{nonce.WithKeyPrefix(prefix)}, opts...)...)
+	}
+	return newService, func() { client.Delete(context.Background(), prefix, clientv3.WithPrefix()) }
+}
+
+// and Cassandra, e.g. NONCE_TEST_CASSANDRA_HOSTS=localhost
+func openCassandraStore(tb testing.TB) (func(opts ...nonce.Option) nonce.Service, func()) {
+	hosts := os.Getenv("NONCE_TEST_CASSANDRA_HOSTS")
+	if hosts == "" {
+		return nil, nil
+	}
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	session, err := cluster.CreateSession()
+	if err != nil {
+		tb.Fatalf("Expected to connect to Cassandra. Instead got the error: %v", err)
+	}
+	err = session.Query(`CREATE KEYSPACE IF NOT EXISTS nonce_test
+		WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`).Exec()
+	session.Close()
+	if err != nil {
+		tb.Fatalf("Expected to create the Cassandra keyspace. Instead got the error: %v", err)
+	}
+	cluster.Keyspace = "nonce_test"
+	session, err = cluster.CreateSession()
+	if err != nil {
+		tb.Fatalf("Expected to connect to Cassandra. Instead got the error: %v", err)
+	}
+	tb.Cleanup(session.Close)
+	newService := func(opts ...nonce.Option) nonce.Service {
+		return noncecassandra.NewService(session, opts...)
+	}
+	return newService, func() {
+		for _, table := range []string{"nonce", "nonce_by_id", "nonce_by_user"} {
+			session.Query("TRUNCATE " + table).Exec()
+		}
+	}
+}
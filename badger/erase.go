@@ -0,0 +1,55 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package badger
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/internal/kvstore"
+	badger "github.com/dgraph-io/badger/v4"
+	uuid "github.com/satori/go.uuid"
+)
+
+func (s *service) DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error) {
+	return s.cfg.DeleteAllForUser(uid, func() (int64, error) {
+		nonces, err := kvstore.UserNonces(ctx, s, uid)
+		if err != nil {
+			return 0, err
+		}
+		var deleted int64
+		err = s.update(func(txn *badger.Txn) error {
+			deleted = 0
+			for _, n := range nonces {
+				cur, _, err := s.get(txn, n.Token)
+				if err == nonce.ErrTokenNotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
+				err = s.remove(txn, cur)
+				if err != nil {
+					return err
+				}
+				deleted++
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		return deleted, nil
+	})
+}
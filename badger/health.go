@@ -0,0 +1,32 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package badger
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func (s *service) Health(ctx context.Context) error {
+	if s.db.IsClosed() {
+		return errors.New("nonce: badger database is closed")
+	}
+	return nil
+}
+
+func (s *service) LastSweep() time.Time {
+	return time.Time{}
+}
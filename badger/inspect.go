@@ -0,0 +1,32 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package badger
+
+import (
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func (s *service) GetByID(id uuid.UUID) (nonce.Nonce, error) {
+	return s.getNonceByID(id)
+}
+
+func (s *service) GetByToken(token string) (nonce.Nonce, error) {
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	return s.getNonce(token)
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package badger
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func (s *service) List(ctx context.Context, f nonce.Filter, fn func(nonce.Nonce) error) error {
+	t := s.cfg.Clock().Now()
+	f = s.cfg.Scope(f)
+
+	// read a chunk per transaction so a long scan doesn't pin old versions,
+	// resuming after the last key of the previous chunk
+	prefix := s.tokenKey("")
+	seek := prefix
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		var chunk []nonce.Nonce
+		err = s.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Seek(seek); it.Valid() && len(chunk) < s.cfg.ListChunkSize(); it.Next() {
+				n, err := s.decodeNonce(it.Item())
+				if err != nil {
+					return err
+				}
+				chunk = append(chunk, n)
+				seek = append(it.Item().KeyCopy(nil), 0)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, n := range chunk {
+			if !f.Matches(n, t) {
+				continue
+			}
+			err = fn(n)
+			if err != nil {
+				return err
+			}
+		}
+		if len(chunk) < s.cfg.ListChunkSize() {
+			return nil
+		}
+	}
+}
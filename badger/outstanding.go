@@ -0,0 +1,31 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package badger
+
+import (
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/internal/kvstore"
+)
+
+func (s *service) Evict(n nonce.Nonce) (bool, error) {
+	n, err := s.updateNonce(n.Token, kvstore.Evict)
+	if err == kvstore.ErrNotEvicted || err == nonce.ErrTokenNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	s.cfg.Invalidated(n)
+	return true, nil
+}
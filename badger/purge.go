@@ -0,0 +1,55 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package badger
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func (s *service) PurgeExpired(ctx context.Context, limit int) (int64, error) {
+	return s.cfg.PurgeExpired(ctx, s, limit, func(batch []nonce.Nonce, t time.Time) (int64, error) {
+		var gone []nonce.Nonce
+		err := s.update(func(txn *badger.Txn) error {
+			gone = gone[:0]
+			for _, n := range batch {
+				// expires_at is checked again in case the nonce was renewed after it was listed
+				cur, _, err := s.get(txn, n.Token)
+				if err == nonce.ErrTokenNotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if !cur.ExpiresAt.Before(t) || s.cfg.Retained(cur, t) {
+					continue
+				}
+				err = s.remove(txn, cur)
+				if err != nil {
+					return err
+				}
+				gone = append(gone, cur)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		s.cfg.ExpiredRemoved(gone...)
+		return int64(len(gone)), nil
+	})
+}
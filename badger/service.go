@@ -0,0 +1,541 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package badger stores nonces in an embedded BadgerDB, with key TTLs
+// expiring them.
+package badger
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	badger "github.com/dgraph-io/badger/v4"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Badger uses the same key layout as etcd (see the etcd package), with every
+// key of a nonce written with the same TTL so they expire together.
+
+type service struct {
+	db     *badger.DB
+	cfg    *nonce.Backend
+	prefix string
+}
+
+// NewService creates an Nonce Service that stores nonces in an embedded
+// BadgerDB under the WithKeyPrefix prefix, for single-node services that need
+// more write throughput than SQLite gives. Keys carry TTLs that run out at
+// ExpiresAt, so there is no cleanup goroutine; run db.RunValueLogGC
+// periodically to reclaim their space.
+func NewService(db *badger.DB, opts ...nonce.Option) nonce.Service {
+	cfg := nonce.NewBackend(opts...)
+	s := &service{
+		db:     db,
+		cfg:    cfg,
+		prefix: cfg.KeyPrefix(),
+	}
+	return s.cfg.Wrap(s)
+}
+
+func (s *service) tokenKey(token string) []byte {
+	return []byte(s.prefix + "token/" + token)
+}
+
+func (s *service) idKey(id uuid.UUID) []byte {
+	return []byte(s.prefix + "id/" + id.String())
+}
+
+// userKey is the prefix of the index keys for uid's nonces for action
+func (s *service) userKey(action string, uid uuid.UUID) []byte {
+	return []byte(s.prefix + "user/" + uid.String() + "/" + url.PathEscape(action) + "/")
+}
+
+func (s *service) New(action string, uid uuid.UUID, expiresIn time.Duration) (nonce.Nonce, error) {
+	return s.NewBound(action, uid, expiresIn, nonce.Binding{})
+}
+
+func (s *service) NewBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, b, "")
+}
+
+func (s *service) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, nonce.Binding{}, payload)
+}
+
+func (s *service) newBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding, payload string) (nonce.Nonce, error) {
+	n, err := s.cfg.NewBoundNonce(action, uid, expiresIn, b, payload)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n.ID = s.cfg.NewID()
+
+	// save the nonce and invalidate existing tokens for same user & action together
+	var others []nonce.Nonce
+	err = s.update(func(txn *badger.Txn) error {
+		err := s.set(txn, n, s.expiresAt(n))
+		if err != nil {
+			return err
+		}
+		others, err = s.invalidateOthers(txn, n)
+		return err
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Created(n)
+	s.cfg.Invalidated(others...)
+
+	// return new nonce
+	return s.cfg.Issue(n), nil
+}
+
+func (s *service) Check(token, action string, uid uuid.UUID) error {
+	return s.CheckBound(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckBound(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) error {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return err
+	}
+
+	// get Nonce data from Badger
+	n, err := s.getNonce(token)
+	if err != nil {
+		return err
+	}
+
+	err = s.cfg.CheckBound(n, action, uid, meta, s.cfg.Clock().Now())
+	return err
+}
+
+func (s *service) Consume(token string) (nonce.Nonce, error) {
+	return s.ConsumeWithMeta(token, nonce.ConsumeMeta{})
+}
+
+func (s *service) ConsumeWithMeta(token string, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.updateNonce(token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		// make sure token hasn't been used
+		if n.IsUsed == true {
+			return nonce.Nonce{}, nonce.ErrTokenUsed
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = s.cfg.Clock().Now().Unix()
+		n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+		return n, nil
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) CheckThenConsume(token, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	return s.CheckThenConsumeWithMeta(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.updateNonce(token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		t := s.cfg.Clock().Now()
+		err := s.cfg.CheckBound(n, action, uid, meta, t)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = t.Unix()
+		n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+		return n, nil
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	var n nonce.Nonce
+	err := s.update(func(txn *badger.Txn) error {
+		token, err := s.tokenFor(txn, id)
+		if err != nil {
+			return err
+		}
+		n, err = s.updateIn(txn, token, func(n nonce.Nonce) (nonce.Nonce, error) {
+			t := s.cfg.Clock().Now()
+			err := s.cfg.CheckNonce(n, action, uid, t)
+			if err != nil {
+				return nonce.Nonce{}, err
+			}
+
+			// set token as used
+			n.IsUsed = true
+			n.ConsumedAt = t.Unix()
+			return n, nil
+		})
+		return err
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) Get(action string, uid uuid.UUID) (nonce.Nonce, error) {
+	if uid == uuid.Nil {
+		return nonce.Nonce{}, nonce.ErrUserRequired
+	}
+	var nonces []nonce.Nonce
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		nonces, err = s.forUser(txn, action, uid)
+		return err
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	t := s.cfg.Clock().Now()
+	var newestN nonce.Nonce
+	found := false
+	for _, n := range nonces {
+		if !s.cfg.Usable(n, t) {
+			continue
+		}
+		if !found || newestN.CreatedAt < n.CreatedAt {
+			newestN = n
+			found = true
+		}
+	}
+
+	if !found {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+
+	return newestN, nil
+}
+
+func (s *service) Renew(token string, extendBy time.Duration) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// the transaction conflicts if a Consume slips in between
+	return s.updateNonce(token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		return s.cfg.RenewNonce(n, extendBy, s.cfg.Clock().Now())
+	})
+}
+
+func (s *service) AwaitConsumption(ctx context.Context, id uuid.UUID) (nonce.Nonce, error) {
+	return s.cfg.Await(ctx, id, s.getNonceByID)
+}
+
+func (s *service) PutNonce(n nonce.Nonce) (nonce.Nonce, error) {
+	n, err := s.cfg.FillNonce(n, s.cfg.Clock().Now())
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if n.ID == uuid.Nil {
+		n.ID = s.cfg.NewID()
+	}
+
+	// replace any existing nonce with the same token
+	err = s.update(func(txn *badger.Txn) error {
+		old, _, err := s.get(txn, n.Token)
+		if err == nil {
+			err = s.remove(txn, old)
+		}
+		if err != nil && err != nonce.ErrTokenNotFound {
+			return err
+		}
+		return s.set(txn, n, s.expiresAt(n))
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	return s.cfg.Issue(n), nil
+}
+
+// Shutdown does nothing; Badger drops nonces when their TTLs run out.
+// The caller owns the DB, so closing it and running its value log GC is up to them.
+func (s *service) Shutdown() {}
+
+// commands lists the operations each method issues, for the debug journal
+func (s *service) Commands(method string) []string {
+	switch method {
+	case "New", "NewBound", "NewWithPayload":
+		return []string{"set token, id, user", "iterate user prefix", "get token", "set token"}
+	case "Check", "CheckBound", "GetByToken":
+		return []string{"get token"}
+	case "Consume", "ConsumeWithMeta", "CheckThenConsume", "CheckThenConsumeWithMeta", "Renew":
+		return []string{"get token", "set token"}
+	case "ConsumeByID":
+		return []string{"get id", "get token", "set token"}
+	case "AwaitConsumption", "GetByID":
+		return []string{"get id", "get token"}
+	case "Get":
+		return []string{"iterate user prefix", "get token"}
+	case "PutNonce":
+		return []string{"get token", "delete token, id, user", "set token, id, user"}
+	}
+	return nil
+}
+
+// expiresAt is when Badger should drop n, as a Unix time on the system clock,
+// since the Clock the Service uses may not match it. It is at least a second
+// from now so a nonce that has already expired can still be read back.
+func (s *service) expiresAt(n nonce.Nonce) uint64 {
+	ttl := math.Ceil(s.cfg.RemoveAt(n).Sub(s.cfg.Clock().Now()).Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+	return uint64(time.Now().Unix() + int64(ttl))
+}
+
+// update runs fn in a read-write transaction, retrying when it conflicts with
+// another one
+func (s *service) update(fn func(txn *badger.Txn) error) error {
+	for {
+		err := s.db.Update(fn)
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+}
+
+// updateNonce replaces the nonce stored for token with fn's result
+func (s *service) updateNonce(token string, fn func(nonce.Nonce) (nonce.Nonce, error)) (nonce.Nonce, error) {
+	var n nonce.Nonce
+	err := s.update(func(txn *badger.Txn) error {
+		var err error
+		n, err = s.updateIn(txn, token, fn)
+		return err
+	})
+	return n, err
+}
+
+// updateIn replaces the nonce stored for token with fn's result in txn. An error
+// from fn is returned as is. Badger's conflict detection fails the transaction
+// if another one changes the nonce after it was read.
+func (s *service) updateIn(txn *badger.Txn, token string, fn func(nonce.Nonce) (nonce.Nonce, error)) (nonce.Nonce, error) {
+	cur, expiresAt, err := s.get(txn, token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n, err := fn(cur)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// keep the current TTL unless when n should be removed changed
+	if !s.cfg.RemoveAt(n).Equal(s.cfg.RemoveAt(cur)) {
+		expiresAt = s.expiresAt(n)
+	}
+	return n, s.set(txn, n, expiresAt)
+}
+
+// set writes every key for n to expire at expiresAt
+func (s *service) set(txn *badger.Txn, n nonce.Nonce, expiresAt uint64) error {
+	b, err := s.cfg.MarshalNonce(n)
+	if err != nil {
+		return err
+	}
+	entries := []*badger.Entry{
+		{Key: s.tokenKey(n.Token), Value: b, ExpiresAt: expiresAt},
+		{Key: s.idKey(n.ID), Value: []byte(n.Token), ExpiresAt: expiresAt},
+	}
+	// anonymous nonces are never found by user
+	if n.UserID != uuid.Nil {
+		entries = append(entries, &badger.Entry{Key: append(s.userKey(n.Action, n.UserID), n.Token...), ExpiresAt: expiresAt})
+	}
+	for _, e := range entries {
+		err = txn.SetEntry(e)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes every key for n
+func (s *service) remove(txn *badger.Txn, n nonce.Nonce) error {
+	for _, key := range [][]byte{s.tokenKey(n.Token), s.idKey(n.ID), append(s.userKey(n.Action, n.UserID), n.Token...)} {
+		err := txn.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeNonce reads the nonce stored in item
+func (s *service) decodeNonce(item *badger.Item) (nonce.Nonce, error) {
+	n := nonce.Nonce{}
+	err := item.Value(func(b []byte) error {
+		return n.UnmarshalBinary(b)
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n.ExpiresAt = n.ExpiresAt.In(time.Local)
+	return s.cfg.Open(n), nil
+}
+
+// get returns the nonce stored for token and when Badger will drop it
+func (s *service) get(txn *badger.Txn, token string) (nonce.Nonce, uint64, error) {
+	item, err := txn.Get(s.tokenKey(token))
+	if err == badger.ErrKeyNotFound {
+		return nonce.Nonce{}, 0, nonce.ErrTokenNotFound
+	} else if err != nil {
+		return nonce.Nonce{}, 0, err
+	}
+
+	n, err := s.decodeNonce(item)
+	if err != nil {
+		return nonce.Nonce{}, 0, err
+	}
+	return n, item.ExpiresAt(), nil
+}
+
+// getNonce gets a Nonce from Badger
+func (s *service) getNonce(token string) (nonce.Nonce, error) {
+	var n nonce.Nonce
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		n, _, err = s.get(txn, token)
+		return err
+	})
+	return n, err
+}
+
+// tokenFor returns the token of the nonce with id
+func (s *service) tokenFor(txn *badger.Txn, id uuid.UUID) (string, error) {
+	item, err := txn.Get(s.idKey(id))
+	if err == badger.ErrKeyNotFound {
+		return "", nonce.ErrTokenNotFound
+	} else if err != nil {
+		return "", err
+	}
+	b, err := item.ValueCopy(nil)
+	return string(b), err
+}
+
+// getNonceByID gets the Nonce with id from Badger
+func (s *service) getNonceByID(id uuid.UUID) (nonce.Nonce, error) {
+	var n nonce.Nonce
+	err := s.db.View(func(txn *badger.Txn) error {
+		token, err := s.tokenFor(txn, id)
+		if err != nil {
+			return err
+		}
+		n, _, err = s.get(txn, token)
+		return err
+	})
+	return n, err
+}
+
+// forUser returns every nonce stored for action and uid
+func (s *service) forUser(txn *badger.Txn, action string, uid uuid.UUID) ([]nonce.Nonce, error) {
+	prefix := s.userKey(action, uid)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	var tokens []string
+	for it.Rewind(); it.Valid(); it.Next() {
+		tokens = append(tokens, string(bytes.TrimPrefix(it.Item().Key(), prefix)))
+	}
+	it.Close()
+
+	nonces := make([]nonce.Nonce, 0, len(tokens))
+	for _, token := range tokens {
+		n, _, err := s.get(txn, token)
+		if err == nonce.ErrTokenNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		nonces = append(nonces, n)
+	}
+	return nonces, nil
+}
+
+// invalidateOthers marks every other valid nonce for n's user and action invalid
+// in txn. It returns the ones that were unused, as they are now.
+func (s *service) invalidateOthers(txn *badger.Txn, n nonce.Nonce) ([]nonce.Nonce, error) {
+	if n.UserID == uuid.Nil {
+		return nil, nil
+	}
+	nonces, err := s.forUser(txn, n.Action, n.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var others []nonce.Nonce
+	for _, o := range nonces {
+		if o.Token == n.Token || o.IsValid == false {
+			continue
+		}
+		o, err = s.updateIn(txn, o.Token, func(o nonce.Nonce) (nonce.Nonce, error) {
+			o.IsValid = false
+			return o, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !o.IsUsed {
+			others = append(others, o)
+		}
+	}
+	return others, nil
+}
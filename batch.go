@@ -0,0 +1,94 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// NewRequest holds the arguments of one New call in a batch
+type NewRequest struct {
+	Action    string
+	UserID    uuid.UUID
+	ExpiresIn time.Duration
+
+	// Payload is stored as Nonce.Payload, as NewWithPayload would
+	Payload string
+}
+
+// Batcher is implemented by Services that can create many nonces at once
+type Batcher interface {
+	// NewBatch creates a nonce for each request as New would, in the same order.
+	// The SQL backend writes them all in one transaction, so either every nonce
+	// is created or none are. It stops early with ctx.Err() once ctx is done.
+	NewBatch(ctx context.Context, requests []NewRequest) ([]Nonce, error)
+}
+
+// newBatch generates the nonces for requests, giving each an ID.
+// Like a series of New calls, only the last nonce for each user & action
+// stays valid; newest maps batchKey to it.
+func (c config) newBatch(requests []NewRequest) (nonces []Nonce, newest map[string]Nonce, err error) {
+	t := c.clock.Now()
+	nonces = make([]Nonce, len(requests))
+	newest = make(map[string]Nonce, len(requests))
+	for i, r := range requests {
+		err = c.checkPayload(r.Payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		n, err := c.newNonce(r.Action, r.UserID, r.ExpiresIn, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		n.ID, n.Payload = c.newID(), r.Payload
+		nonces[i] = n
+		if n.UserID != uuid.Nil {
+			newest[batchKey(n)] = n
+		}
+	}
+	for i, n := range nonces {
+		nonces[i].IsValid = n.UserID == uuid.Nil || newest[batchKey(n)].ID == n.ID
+	}
+	return nonces, newest, nil
+}
+
+func batchKey(n Nonce) string {
+	return n.Action + "::" + n.UserID.String()
+}
+
+func (s *nonceInMemoryService) NewBatch(ctx context.Context, requests []NewRequest) ([]Nonce, error) {
+	nonces, newest, err := s.cfg.newBatch(requests)
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, n := range nonces {
+		s.store.put(n)
+	}
+	s.cfg.created(nonces...)
+	for _, n := range newest {
+		s.cfg.invalidated(s.store.invalidateOthers(n)...)
+		s.broadcast(broadcastInvalidated, n)
+	}
+
+	return issueAll(nonces), nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Binding ties a nonce to the request it was created for, so a leaked link
+// can't be redeemed from another session or network. Each field that is set
+// must match the ConsumeMeta of the request checking or consuming the nonce.
+// SessionID and UserAgent are stored hashed.
+type Binding struct {
+	// SessionID must equal ConsumeMeta.SessionID
+	SessionID string
+
+	// Network is an IP address or CIDR block, e.g. "203.0.113.0/24",
+	// that ConsumeMeta.IP must be in
+	Network string
+
+	// UserAgent must equal ConsumeMeta.UserAgent
+	UserAgent string
+}
+
+// Binder is implemented by Services that can bind nonces to a Binding.
+//
+// CheckThenConsumeWithMeta verifies the Binding against its ConsumeMeta.
+// Check, CheckThenConsume and ConsumeByID have no request to verify, so they
+// reject bound nonces with ErrBindingMismatch. Consume and ConsumeWithMeta
+// don't check bindings, just as they don't check the action or user.
+type Binder interface {
+	// NewBound is New for a nonce bound to b. It returns ErrInvalidBinding
+	// if b.Network isn't an IP address or CIDR block.
+	NewBound(action string, uid uuid.UUID, expiresIn time.Duration, b Binding) (Nonce, error)
+
+	// CheckBound is Check for the request meta describes
+	CheckBound(token, action string, uid uuid.UUID, meta ConsumeMeta) error
+}
+
+// encodeBinding returns what is stored as Nonce.Binding for b
+func (c config) encodeBinding(b Binding) (string, error) {
+	if c.limits.Meta > 0 && len(b.Network) > c.limits.Meta {
+		return "", ErrPayloadTooLarge
+	}
+	v := url.Values{}
+	if b.SessionID != "" {
+		v.Set("s", bindingHash(b.SessionID))
+	}
+	if b.Network != "" {
+		network, err := parseNetwork(b.Network)
+		if err != nil {
+			return "", ErrInvalidBinding
+		}
+		v.Set("n", network.String())
+	}
+	if b.UserAgent != "" {
+		v.Set("u", bindingHash(b.UserAgent))
+	}
+	return v.Encode(), nil
+}
+
+// bindingMatches reports whether the request meta describes satisfies the
+// encoded Binding
+func bindingMatches(encoded string, meta ConsumeMeta) bool {
+	if encoded == "" {
+		return true
+	}
+	v, err := url.ParseQuery(encoded)
+	if err != nil {
+		return false
+	}
+	if h := v.Get("s"); h != "" && !constantTimeEqual(h, bindingHash(meta.SessionID)) {
+		return false
+	}
+	if h := v.Get("u"); h != "" && !constantTimeEqual(h, bindingHash(meta.UserAgent)) {
+		return false
+	}
+	if n := v.Get("n"); n != "" {
+		_, network, err := net.ParseCIDR(n)
+		ip := net.ParseIP(meta.IP)
+		if err != nil || ip == nil || !network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseNetwork parses an IP address as the network holding only it, or a CIDR block
+func parseNetwork(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	return network, err
+}
+
+func bindingHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
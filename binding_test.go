@@ -0,0 +1,100 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestBinding(t *testing.T) {
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newService()
+			defer s.Shutdown()
+			b := s.(Binder)
+
+			uid := uuid.NewV4()
+			_, err := b.NewBound("reset", uid, time.Minute, Binding{Network: "not an address"})
+			if err != ErrInvalidBinding {
+				t.Errorf("Expected NewBound to reject a bad network with %v. Instead got: %v", ErrInvalidBinding, err)
+			}
+
+			n, err := b.NewBound("reset", uid, time.Minute, Binding{SessionID: "session", Network: "203.0.113.0/24", UserAgent: "browser"})
+			if err != nil {
+				t.Fatalf("Expected to add bound nonce. Instead got the error: %v", err)
+			}
+			meta := ConsumeMeta{SessionID: "session", IP: "203.0.113.7", UserAgent: "browser"}
+			err = b.CheckBound(n.Token, "reset", uid, meta)
+			if err != nil {
+				t.Errorf("Expected CheckBound to accept the request the nonce is bound to. Instead got: %v", err)
+			}
+
+			for _, m := range []ConsumeMeta{
+				{SessionID: "other", IP: "203.0.113.7", UserAgent: "browser"},
+				{SessionID: "session", IP: "198.51.100.7", UserAgent: "browser"},
+				{SessionID: "session", IP: "203.0.113.7", UserAgent: "curl"},
+			} {
+				err = b.CheckBound(n.Token, "reset", uid, m)
+				if !errors.Is(err, ErrBindingMismatch) {
+					t.Errorf("Expected CheckBound for %+v to fail with %v. Instead got: %v", m, ErrBindingMismatch, err)
+				}
+			}
+			err = s.Check(n.Token, "reset", uid)
+			if !errors.Is(err, ErrBindingMismatch) {
+				t.Errorf("Expected Check to reject a bound nonce with %v. Instead got: %v", ErrBindingMismatch, err)
+			}
+			_, err = s.CheckThenConsume(n.Token, "reset", uid)
+			if !errors.Is(err, ErrBindingMismatch) {
+				t.Errorf("Expected CheckThenConsume to reject a bound nonce with %v. Instead got: %v", ErrBindingMismatch, err)
+			}
+			_, err = s.ConsumeByID(n.ID, "reset", uid)
+			if !errors.Is(err, ErrBindingMismatch) {
+				t.Errorf("Expected ConsumeByID to reject a bound nonce with %v. Instead got: %v", ErrBindingMismatch, err)
+			}
+
+			consumed, err := s.(MetaConsumer).CheckThenConsumeWithMeta(n.Token, "reset", uid, meta)
+			if err != nil {
+				t.Fatalf("Expected to consume the bound nonce. Instead got: %v", err)
+			}
+			if !consumed.IsUsed || consumed.ConsumedIP != meta.IP {
+				t.Errorf("Expected the consumed nonce to be used from %s. Instead got: %+v", meta.IP, consumed)
+			}
+			_, err = s.(MetaConsumer).CheckThenConsumeWithMeta(n.Token, "reset", uid, meta)
+			if !errors.Is(err, ErrTokenUsed) {
+				t.Errorf("Expected consuming again to fail with %v. Instead got: %v", ErrTokenUsed, err)
+			}
+		})
+	}
+}
+
+func TestBindingSingleAddress(t *testing.T) {
+	encoded, err := config{}.encodeBinding(Binding{Network: "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("Expected to encode an address binding. Instead got: %v", err)
+	}
+	if !bindingMatches(encoded, ConsumeMeta{IP: "2001:db8::1"}) {
+		t.Errorf("Expected the bound address to match")
+	}
+	if bindingMatches(encoded, ConsumeMeta{IP: "2001:db8::2"}) {
+		t.Errorf("Expected another address not to match")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// NewCircuitBreakerService creates a Service that stops calling s for cooldown
+// once threshold calls in a row have failed with errors that aren't the
+// package's own, returning ErrBackendUnavailable straight away instead of
+// waiting on a backend that is down. After cooldown one call is let through
+// to test s; the breaker closes if it succeeds and opens again if it fails.
+// Calls that end with context.Canceled or context.DeadlineExceeded say
+// nothing about the backend and leave the count as it was.
+// Shutdown is always passed through.
+func NewCircuitBreakerService(s Service, threshold int, cooldown time.Duration) Service {
+	if threshold < 1 {
+		threshold = 1
+	}
+	b := &breaker{threshold: threshold, cooldown: cooldown, clock: SystemClock}
+	return Decorate(s, b.interceptor())
+}
+
+// breaker counts consecutive backend failures
+type breaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+
+	failures int
+	// openUntil is when a call may next test the backend, while the breaker is open
+	openUntil time.Time
+	// probing is set while that call is running
+	probing bool
+}
+
+func (b *breaker) interceptor() Interceptor {
+	return func(c Call, next func() error) error {
+		if c.Method == "Shutdown" {
+			return next()
+		}
+		ok, probe := b.allow()
+		if !ok {
+			return ErrBackendUnavailable
+		}
+		err := next()
+		b.done(err, probe)
+		return err
+	}
+}
+
+// allow reports whether a call may go to the backend and whether it is the
+// call testing it while the breaker is open
+func (b *breaker) allow() (ok, probe bool) {
+	b.Lock()
+	defer b.Unlock()
+	if b.failures < b.threshold {
+		return true, false
+	}
+	if b.probing || b.clock.Now().Before(b.openUntil) {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+// done records how a call that was allowed ended
+func (b *breaker) done(err error, probe bool) {
+	b.Lock()
+	defer b.Unlock()
+	if probe {
+		b.probing = false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	if err == nil || isServiceError(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.clock.Now().Add(b.cooldown)
+	}
+}
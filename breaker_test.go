@@ -0,0 +1,115 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := &testClock{}
+	backend := &failingService{Service: NewInMemoryService(), err: syscall.ECONNREFUSED}
+	b := &breaker{threshold: 2, cooldown: time.Minute, clock: clock}
+	s := Decorate(backend, b.interceptor())
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	// the nonce's own errors aren't backend failures
+	for i := 0; i < 3; i++ {
+		err = s.Check(n.Token, tNonce.Action, uuid.NewV4())
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("Expected ErrInvalidToken. Instead got: %v", err)
+		}
+	}
+
+	backend.fails = 10
+	for i := 0; i < 2; i++ {
+		err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+		if err != syscall.ECONNREFUSED {
+			t.Fatalf("Expected the backend error. Instead got: %v", err)
+		}
+	}
+	backend.calls = 0
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != ErrBackendUnavailable || backend.calls != 0 {
+		t.Fatalf("Expected the open breaker to fail fast. Instead got %d calls and: %v", backend.calls, err)
+	}
+
+	// after cooldown one call tests the backend and opens the breaker again
+	clock.Add(time.Minute)
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != syscall.ECONNREFUSED || backend.calls != 1 {
+		t.Fatalf("Expected a test call to reach the backend. Instead got %d calls and: %v", backend.calls, err)
+	}
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != ErrBackendUnavailable {
+		t.Fatalf("Expected the breaker to open again. Instead got: %v", err)
+	}
+
+	// and closes it once the backend answers
+	backend.fails = 0
+	clock.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+		if err != nil {
+			t.Fatalf("Expected the breaker to close. Instead got: %v", err)
+		}
+	}
+}
+
+func TestCircuitBreakerIgnoresContextErrors(t *testing.T) {
+	backend := &failingService{Service: NewInMemoryService(), err: syscall.ECONNREFUSED}
+	b := &breaker{threshold: 2, cooldown: time.Minute, clock: &testClock{}}
+	s := Decorate(backend, b.interceptor())
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	backend.fails = 1
+	s.Check(n.Token, tNonce.Action, tNonce.UserID)
+
+	// a caller giving up neither counts as a failure nor resets the count
+	for _, ctxErr := range []error{context.Canceled, fmt.Errorf("query: %w", context.DeadlineExceeded)} {
+		backend.err, backend.fails = ctxErr, 1
+		err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+		if !errors.Is(err, ctxErr) {
+			t.Fatalf("Expected the context error. Instead got: %v", err)
+		}
+		if b.failures != 1 {
+			t.Fatalf("Expected the failure count to stay at 1. Instead got: %d", b.failures)
+		}
+	}
+
+	backend.err, backend.fails = syscall.ECONNREFUSED, 1
+	s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != ErrBackendUnavailable {
+		t.Fatalf("Expected the second backend failure to open the breaker. Instead got: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/json"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Broadcaster carries messages between the in-memory Services of every
+// instance of an application, e.g. over a Redis channel (see broadcast/redis).
+type Broadcaster interface {
+	// Publish sends msg to every subscriber, including this instance's
+	Publish(msg []byte) error
+
+	// Subscribe calls receive with every message published until stop is called
+	Subscribe(receive func(msg []byte)) (stop func(), err error)
+}
+
+// WithBroadcaster makes the in-memory Service tell the other instances sharing
+// b when it consumes a nonce, New invalidates older ones or PutNonce stores a
+// used or invalid nonce, and apply what they tell it, so a nonce copied into several instances, such as the cache of a
+// NewCachedService, can't be used again or stay valid on another one.
+// Messages are best effort: one lost in transit leaves that instance stale.
+// Other backends ignore it since their store is already shared.
+func WithBroadcaster(b Broadcaster) Option {
+	return func(cfg *config) {
+		cfg.broadcaster = b
+	}
+}
+
+// Kinds of broadcast
+const (
+	// the nonce with Token was consumed
+	broadcastConsumed = "consumed"
+	// the nonce with Token was marked invalid
+	broadcastInvalid = "invalid"
+	// New created ID, invalidating the other nonces for UserID and Action
+	broadcastInvalidated = "invalidated"
+)
+
+// broadcastMsg is what instances send each other
+type broadcastMsg struct {
+	Origin uuid.UUID `json:"origin"`
+	Kind   string    `json:"kind"`
+	Token  string    `json:"token"`
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Action string    `json:"action"`
+
+	Namespace string `json:"namespace,omitempty"`
+
+	ConsumedAt        int64  `json:"consumed_at,omitempty"`
+	ConsumedIP        string `json:"consumed_ip,omitempty"`
+	ConsumedUserAgent string `json:"consumed_user_agent,omitempty"`
+}
+
+// broadcast tells the other instances about n. Failures are logged since the
+// local change has already been made.
+func (s *nonceInMemoryService) broadcast(kind string, n Nonce) {
+	if s.cfg.broadcaster == nil {
+		return
+	}
+	b, err := json.Marshal(broadcastMsg{
+		Origin:            s.origin,
+		Kind:              kind,
+		Token:             n.Token,
+		ID:                n.ID,
+		UserID:            n.UserID,
+		Action:            n.Action,
+		Namespace:         n.Namespace,
+		ConsumedAt:        n.ConsumedAt,
+		ConsumedIP:        n.ConsumedIP,
+		ConsumedUserAgent: n.ConsumedUserAgent,
+	})
+	if err == nil {
+		err = s.cfg.broadcaster.Publish(b)
+	}
+	if err != nil {
+		s.cfg.logger.Printf("nonce: error broadcasting %s nonce: %v", kind, err)
+	}
+}
+
+// subscribe applies other instances' broadcasts until the Service is shut down
+func (s *nonceInMemoryService) subscribe() {
+	if s.cfg.broadcaster == nil {
+		return
+	}
+	stop, err := s.cfg.broadcaster.Subscribe(s.receive)
+	if err != nil {
+		s.cfg.logger.Printf("nonce: error subscribing to broadcasts: %v", err)
+		return
+	}
+	go func() {
+		<-s.quit
+		stop()
+	}()
+}
+
+// receive applies one broadcast. Nonces this instance never stored are ignored.
+func (s *nonceInMemoryService) receive(b []byte) {
+	var m broadcastMsg
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		s.cfg.logger.Printf("nonce: error decoding broadcast: %v", err)
+		return
+	}
+	if m.Origin == s.origin {
+		return
+	}
+
+	switch m.Kind {
+	case broadcastConsumed:
+		n, err := s.store.update(m.Token, func(n Nonce) (Nonce, error) {
+			if !n.IsUsed {
+				n.IsUsed = true
+				n.ConsumedAt, n.ConsumedIP, n.ConsumedUserAgent = m.ConsumedAt, m.ConsumedIP, m.ConsumedUserAgent
+			}
+			return n, nil
+		})
+		if err == nil {
+			s.waiters.consumed(n)
+		}
+	case broadcastInvalid:
+		s.store.update(m.Token, func(n Nonce) (Nonce, error) {
+			n.IsValid = false
+			return n, nil
+		})
+	case broadcastInvalidated:
+		s.store.invalidateOthers(Nonce{ID: m.ID, UserID: m.UserID, Action: m.Action, Namespace: m.Namespace})
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redis is a nonce.Broadcaster over a Redis pub/sub channel, letting
+// in-memory Services on several instances keep each other up to date:
+//
+//	rdb := redis.NewClient(&redis.Options{Addr: "localhost:6379"}) // github.com/redis/go-redis/v9
+//	s := nonce.NewInMemoryService(nonce.WithBroadcaster(nonceredis.New(rdb, "nonce")))
+package redis
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broadcaster publishes to and subscribes to one channel
+type Broadcaster struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+// New returns a Broadcaster using channel on client
+func New(client redis.UniversalClient, channel string) *Broadcaster {
+	return &Broadcaster{
+		client:  client,
+		channel: channel,
+	}
+}
+
+func (b *Broadcaster) Publish(msg []byte) error {
+	return b.client.Publish(context.Background(), b.channel, msg).Err()
+}
+
+// Subscribe returns once Redis has confirmed the subscription, so nothing
+// published afterwards is missed
+func (b *Broadcaster) Subscribe(receive func(msg []byte)) (func(), error) {
+	ctx := context.Background()
+	sub := b.client.Subscribe(ctx, b.channel)
+	_, err := sub.Receive(ctx)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	ch := sub.Channel()
+	go func() {
+		for m := range ch {
+			receive([]byte(m.Payload))
+		}
+	}()
+	return func() { sub.Close() }, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ nonce.Broadcaster = (*Broadcaster)(nil)
+
+// Redis needs a running server so only test against it when one is configured
+func TestBroadcaster(t *testing.T) {
+	addr := os.Getenv("NONCE_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("NONCE_TEST_REDIS_ADDR not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb.Close()
+
+	b := New(rdb, "nonce_test")
+	got := make(chan string, 1)
+	stop, err := b.Subscribe(func(msg []byte) { got <- string(msg) })
+	if err != nil {
+		t.Fatalf("Expected to subscribe. Instead got: %v", err)
+	}
+	defer stop()
+
+	err = b.Publish([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Expected to publish. Instead got: %v", err)
+	}
+	select {
+	case msg := <-got:
+		if msg != "hello" {
+			t.Fatalf("Expected hello. Instead got: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the message to arrive")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// localBroadcaster delivers every message to its subscribers synchronously
+type localBroadcaster struct {
+	sync.Mutex
+	subs map[int]func([]byte)
+	next int
+}
+
+func (b *localBroadcaster) Publish(msg []byte) error {
+	b.Lock()
+	subs := make([]func([]byte), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.Unlock()
+	for _, fn := range subs {
+		fn(msg)
+	}
+	return nil
+}
+
+func (b *localBroadcaster) Subscribe(receive func([]byte)) (func(), error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[int]func([]byte))
+	}
+	id := b.next
+	b.next++
+	b.subs[id] = receive
+	return func() {
+		b.Lock()
+		delete(b.subs, id)
+		b.Unlock()
+	}, nil
+}
+
+func TestBroadcaster(t *testing.T) {
+	b := &localBroadcaster{}
+	a := NewInMemoryService(WithBroadcaster(b))
+	defer a.Shutdown()
+	other := NewInMemoryService(WithBroadcaster(b))
+	defer other.Shutdown()
+
+	// the same nonce copied into both instances, as a cache would hold it
+	uid := uuid.NewV4()
+	n, err := a.New("broadcast", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	other.(Putter).PutNonce(n)
+
+	_, err = a.Consume(n.Token)
+	if err != nil {
+		t.Fatalf("Expected Consume to succeed. Instead got: %v", err)
+	}
+	err = other.Check(n.Token, "broadcast", uid)
+	if !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("Expected the other instance to see the consume. Instead got: %v", err)
+	}
+
+	second, _ := a.New("broadcast", uid, time.Minute)
+	other.(Putter).PutNonce(second)
+	_, err = a.New("broadcast", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	err = other.Check(second.Token, "broadcast", uid)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected New on one instance to invalidate the other's copy. Instead got: %v", err)
+	}
+
+	// nothing is applied once the instance is shut down
+	other.Shutdown()
+	time.Sleep(10 * time.Millisecond)
+	b.Lock()
+	subs := len(b.subs)
+	b.Unlock()
+	if subs != 1 {
+		t.Fatalf("Expected Shutdown to unsubscribe. Instead got: %d subscribers", subs)
+	}
+}
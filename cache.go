@@ -0,0 +1,224 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// cachedService answers reads from cache and writes through to primary
+type cachedService struct {
+	primary Service
+	cache   Service
+	put     Putter
+	list    Lister
+
+	// mu serialises cache updates so invalidating a user's older nonces
+	// and storing the new one can't interleave with another New
+	mu sync.Mutex
+}
+
+// NewCachedService serves Check and Get from cache and sends every write to primary,
+// copying the result into cache. cache must be a Putter and a Lister, such as an
+// undecorated in-memory Service, or ErrNotPutter or ErrNotLister is returned.
+// It is shut down with the returned Service.
+//
+// A nonce can only become used, invalid or expired, so a cached rejection is final
+// and only a cached "valid" can be stale. That happens when another instance
+// consumes or replaces a nonce this instance has cached, so Check and Get must only
+// guard work that is then confirmed by Consume or CheckThenConsume, which always
+// ask primary.
+func NewCachedService(primary, cache Service) (Service, error) {
+	put, ok := cache.(Putter)
+	if !ok {
+		return nil, ErrNotPutter
+	}
+	list, ok := cache.(Lister)
+	if !ok {
+		return nil, ErrNotLister
+	}
+	return &cachedService{
+		primary: primary,
+		cache:   cache,
+		put:     put,
+		list:    list,
+	}, nil
+}
+
+func (s *cachedService) New(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error) {
+	n, err := s.primary.New(action, uid, expiresIn)
+	if err != nil {
+		return Nonce{}, err
+	}
+	s.store(n, true)
+	return n, nil
+}
+
+// NewWithPayload returns ErrNotSupported if primary isn't a Payloader
+func (s *cachedService) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (Nonce, error) {
+	p, ok := s.primary.(Payloader)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	n, err := p.NewWithPayload(action, uid, expiresIn, payload)
+	if err != nil {
+		return Nonce{}, err
+	}
+	s.store(n, true)
+	return n, nil
+}
+
+// Check asks primary only when cache has never seen token
+func (s *cachedService) Check(token, action string, uid uuid.UUID) error {
+	err := s.cache.Check(token, action, uid)
+	if err != ErrTokenNotFound {
+		return err
+	}
+
+	err = s.primary.Check(token, action, uid)
+	if err != nil {
+		return err
+	}
+	// remember it so the next Check is served from cache
+	n, err := s.primary.Get(action, uid)
+	if err == nil && constantTimeEqual(n.Token, NormalizeToken(token)) {
+		s.store(n, true)
+	}
+	return nil
+}
+
+func (s *cachedService) Consume(token string) (Nonce, error) {
+	return s.written(s.primary.Consume(token))
+}
+
+func (s *cachedService) CheckThenConsume(token, action string, uid uuid.UUID) (Nonce, error) {
+	return s.written(s.primary.CheckThenConsume(token, action, uid))
+}
+
+// ConsumeWithMeta returns ErrNotSupported if primary isn't a MetaConsumer
+func (s *cachedService) ConsumeWithMeta(token string, meta ConsumeMeta) (Nonce, error) {
+	m, ok := s.primary.(MetaConsumer)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	return s.written(m.ConsumeWithMeta(token, meta))
+}
+
+// CheckThenConsumeWithMeta returns ErrNotSupported if primary isn't a MetaConsumer
+func (s *cachedService) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta ConsumeMeta) (Nonce, error) {
+	m, ok := s.primary.(MetaConsumer)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	return s.written(m.CheckThenConsumeWithMeta(token, action, uid, meta))
+}
+
+func (s *cachedService) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (Nonce, error) {
+	return s.written(s.primary.ConsumeByID(id, action, uid))
+}
+
+// Get asks primary only when cache has no valid nonce for action and uid
+func (s *cachedService) Get(action string, uid uuid.UUID) (Nonce, error) {
+	n, err := s.cache.Get(action, uid)
+	if err == nil && n.ID != uuid.Nil {
+		return n, nil
+	}
+
+	n, err = s.primary.Get(action, uid)
+	if err != nil {
+		return Nonce{}, err
+	}
+	s.store(n, true)
+	return n, nil
+}
+
+func (s *cachedService) Renew(token string, extendBy time.Duration) (Nonce, error) {
+	return s.written(s.primary.Renew(token, extendBy))
+}
+
+// GetByID asks primary, which is never stale.
+// It returns ErrNotSupported if primary isn't an Inspector.
+func (s *cachedService) GetByID(id uuid.UUID) (Nonce, error) {
+	in, ok := s.primary.(Inspector)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	return in.GetByID(id)
+}
+
+// GetByToken asks primary like GetByID
+func (s *cachedService) GetByToken(token string) (Nonce, error) {
+	in, ok := s.primary.(Inspector)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	return in.GetByToken(token)
+}
+
+// AwaitConsumption waits on primary.
+// It returns ErrNotSupported if primary isn't an Awaiter.
+func (s *cachedService) AwaitConsumption(ctx context.Context, id uuid.UUID) (Nonce, error) {
+	a, ok := s.primary.(Awaiter)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	return a.AwaitConsumption(ctx, id)
+}
+
+// Shutdown shuts down primary and cache
+func (s *cachedService) Shutdown() {
+	s.primary.Shutdown()
+	s.cache.Shutdown()
+}
+
+// written copies the nonce a successful write returned into cache
+func (s *cachedService) written(n Nonce, err error) (Nonce, error) {
+	if err != nil {
+		return Nonce{}, err
+	}
+	s.store(n, false)
+	return n, nil
+}
+
+// store puts n into cache. newest marks the other cached nonces for the
+// same action and user invalid, mirroring what New did in primary. Copies of
+// n cached under another form of its token, such as the plain token when
+// primary stores a WithTokenHashing hash, are updated to match it.
+func (s *cachedService) store(n Nonce, newest bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stale []Nonce
+	s.list.List(context.Background(), Filter{Action: n.Action, UserID: n.UserID}, func(c Nonce) error {
+		switch {
+		case c.ID == n.ID && c.Token != n.Token:
+			copied := n
+			copied.Token = c.Token
+			stale = append(stale, copied)
+		case newest && c.ID != n.ID && c.IsValid:
+			c.IsValid = false
+			stale = append(stale, c)
+		}
+		return nil
+	})
+	for _, c := range stale {
+		s.put.PutNonce(c)
+	}
+	s.put.PutNonce(n)
+}
@@ -0,0 +1,165 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestCachedService(t *testing.T) {
+	backend := NewInMemoryService()
+	checks := 0
+	primary := Decorate(backend, func(c Call, next func() error) error {
+		if c.Method == "Check" {
+			checks++
+		}
+		return next()
+	})
+	s, err := NewCachedService(primary, NewInMemoryService())
+	if err != nil {
+		t.Fatalf("Expected to create the cached Service. Instead got the error: %v", err)
+	}
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != nil || checks != 0 {
+		t.Fatalf("Expected Check to be served from cache. Instead got: %v after %d primary checks", err, checks)
+	}
+
+	// New invalidates the older cached nonce
+	n2, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected ErrInvalidToken. Instead got: %v", err)
+	}
+	got, err := s.Get(tNonce.Action, tNonce.UserID)
+	if err != nil || got.ID != n2.ID {
+		t.Fatalf("Expected Get to return the newest nonce. Instead got: %v, %v", got, err)
+	}
+
+	// Consume invalidates the cached nonce
+	_, err = s.Consume(n2.Token)
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(n2.Token, tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, ErrTokenUsed) || checks != 0 {
+		t.Fatalf("Expected cached ErrTokenUsed. Instead got: %v after %d primary checks", err, checks)
+	}
+
+	// a nonce written by another instance is read through once
+	uid := uuid.NewV4()
+	other, err := backend.New(tNonce.Action, uid, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		err = s.Check(other.Token, tNonce.Action, uid)
+		if err != nil {
+			t.Fatalf("Expected to nonce check to be valid. Instead got the error: %v", err)
+		}
+	}
+	if checks != 1 {
+		t.Fatalf("Expected 1 primary check. Instead got: %d", checks)
+	}
+}
+
+func TestCachedServiceTokenHashing(t *testing.T) {
+	s, err := NewCachedService(NewInMemoryService(WithTokenHashing([]byte("key"))), NewInMemoryService())
+	if err != nil {
+		t.Fatalf("Expected to create the cached Service. Instead got the error: %v", err)
+	}
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Fatalf("Expected the nonce to check out. Instead got the error: %v", err)
+	}
+	_, err = s.Consume(n.Token)
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("Expected %v once consumed. Instead got: %v", ErrTokenUsed, err)
+	}
+}
+
+func TestWrappersForwardInspector(t *testing.T) {
+	wrapped := map[string]Service{
+		"cached":     mustService(NewCachedService(NewInMemoryService(), NewInMemoryService())),
+		"failover":   mustService(NewFailoverService(NewInMemoryService(), NewInMemoryService())),
+		"dual write": mustService(NewDualWriteService(NewInMemoryService(), NewInMemoryService(), DualWriteMode{})),
+	}
+	for name, s := range wrapped {
+		n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+		if err != nil {
+			t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+		}
+		got, err := s.(Inspector).GetByToken(n.Token)
+		if err != nil || got.ID != n.ID {
+			t.Errorf("Expected GetByToken through a %s Service to find the nonce. Instead got: %+v, %v", name, got, err)
+		}
+		_, err = s.Consume(n.Token)
+		if err != nil {
+			t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+		}
+		got, err = WaitForConsume(context.Background(), s, n.Token)
+		if err != nil || !got.IsUsed {
+			t.Errorf("Expected WaitForConsume through a %s Service to return the used nonce. Instead got: %+v, %v", name, got, err)
+		}
+		s.Shutdown()
+	}
+}
+
+func TestWrappersUnsupported(t *testing.T) {
+	s := NewInMemoryService()
+	defer s.Shutdown()
+	// hides every optional interface of s
+	bare := struct{ Service }{s}
+
+	_, err := NewCachedService(s, bare)
+	if err != ErrNotPutter {
+		t.Errorf("Expected %v for a cache without PutNonce. Instead got: %v", ErrNotPutter, err)
+	}
+	_, err = NewFailoverService(s, bare)
+	if err != ErrNotLister {
+		t.Errorf("Expected %v for a failover secondary without List. Instead got: %v", ErrNotLister, err)
+	}
+	_, err = NewDualWriteService(s, bare, DualWriteMode{})
+	if err != ErrNotPutter {
+		t.Errorf("Expected %v for a new store without PutNonce. Instead got: %v", ErrNotPutter, err)
+	}
+	_, err = NewIdempotencyService(bare, time.Minute)
+	if err != ErrNotIdempotencyStore {
+		t.Errorf("Expected %v for a Service without idempotency keys. Instead got: %v", ErrNotIdempotencyStore, err)
+	}
+}
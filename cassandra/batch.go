@@ -0,0 +1,49 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cassandra
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+func (s *service) NewBatch(ctx context.Context, requests []nonce.NewRequest) ([]nonce.Nonce, error) {
+	nonces, newest, err := s.cfg.NewBatch(requests)
+	if err != nil || len(nonces) == 0 {
+		return nonces, err
+	}
+
+	// rows for different tokens live in different partitions, so a batch
+	// statement would only add coordinator work
+	for _, n := range nonces {
+		err = s.insert(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var others []nonce.Nonce
+	for _, n := range newest {
+		o, err := s.invalidateOthers(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		others = append(others, o...)
+	}
+	s.cfg.Created(nonces...)
+	s.cfg.Invalidated(others...)
+	return s.cfg.IssueAll(nonces), nil
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cassandra
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+func (s *service) List(ctx context.Context, f nonce.Filter, fn func(nonce.Nonce) error) error {
+	t := s.cfg.Clock().Now()
+	f = s.cfg.Scope(f)
+
+	// page by hand so cancellation is checked between chunks
+	var state []byte
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		iter := s.session.Query(cqlSelectAll).PageSize(s.cfg.ListChunkSize()).PageState(state).IterContext(ctx)
+		state = iter.PageState()
+		scanner := iter.Scanner()
+		for scanner.Next() {
+			n, err := s.scanNonce(scanner.Scan)
+			if err == nil && f.Matches(n, t) {
+				err = fn(n)
+			}
+			if err != nil {
+				iter.Close()
+				return err
+			}
+		}
+		err = scanner.Err()
+		if err != nil || len(state) == 0 {
+			return err
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cassandra
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+func (s *service) PurgeExpired(ctx context.Context, limit int) (int64, error) {
+	return s.cfg.PurgeExpired(ctx, s, limit, func(batch []nonce.Nonce, t time.Time) (int64, error) {
+		var purged int64
+		for _, n := range batch {
+			// only delete the nonce as it was listed, in case it was renewed or consumed since
+			applied, err := s.session.Query(cqlDeleteNonce, n.Token, n.IsUsed, n.IsValid, n.ExpiresAt).
+				MapScanCASContext(ctx, map[string]interface{}{})
+			if err != nil {
+				return purged, err
+			}
+			if !applied {
+				continue
+			}
+			err = s.deleteIndex(ctx, n)
+			if err != nil {
+				return purged, err
+			}
+			purged++
+			s.cfg.ExpiredRemoved(n)
+		}
+		return purged, nil
+	})
+}
@@ -0,0 +1,613 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cassandra stores nonces in Cassandra or ScyllaDB, with row TTLs
+// expiring them.
+package cassandra
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	gocql "github.com/apache/cassandra-gocql-driver/v2"
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/internal/kvstore"
+	uuid "github.com/satori/go.uuid"
+)
+
+type service struct {
+	session *gocql.Session
+	cfg     *nonce.Backend
+}
+
+// NewService creates an Nonce Service that stores nonces in Cassandra or
+// ScyllaDB, creating its tables in session's keyspace if they don't exist. Rows
+// are written with TTLs that run out at ExpiresAt, so there is no cleanup
+// goroutine, and consumes are lightweight transactions, so only one caller can win.
+func NewService(session *gocql.Session, opts ...nonce.Option) nonce.Service {
+	s := &service{
+		session: session,
+		cfg:     nonce.NewBackend(opts...),
+	}
+	err := s.ensureTables()
+	if err != nil {
+		s.cfg.Logger().Printf("nonce: error creating Cassandra tables: %v", err)
+	}
+	return s.cfg.Wrap(s)
+}
+
+// cqlSchema creates the tables NewService uses in the session's keyspace.
+// Every row is written with a TTL that runs out when the nonce expires.
+var cqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS nonce (
+		token text PRIMARY KEY,
+		id uuid,
+		user_id uuid,
+		action text,
+		salt text,
+		is_used boolean,
+		is_valid boolean,
+		created_at bigint,
+		expires_at timestamp,
+		consumed_at bigint,
+		consumed_ip text,
+		consumed_user_agent text,
+		binding text,
+		parent_id uuid,
+		namespace text,
+		payload text
+	)`,
+	`CREATE TABLE IF NOT EXISTS nonce_by_id (
+		id uuid PRIMARY KEY,
+		token text
+	)`,
+	`CREATE TABLE IF NOT EXISTS nonce_by_user (
+		user_id uuid,
+		action text,
+		token text,
+		PRIMARY KEY ((user_id, action), token)
+	)`,
+}
+
+// cqlAddColumns adds the columns tables created by earlier versions are missing
+var cqlAddColumns = []string{
+	`ALTER TABLE nonce ADD binding text`,
+	`ALTER TABLE nonce ADD parent_id uuid`,
+	`ALTER TABLE nonce ADD namespace text`,
+	`ALTER TABLE nonce ADD payload text`,
+}
+
+const cqlNonceColumns = `token, id, user_id, action, salt, is_used, is_valid, created_at, expires_at, consumed_at, consumed_ip, consumed_user_agent, binding, parent_id, namespace, payload`
+
+const cqlInsertNonce = `INSERT INTO nonce (` + cqlNonceColumns + `)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+
+const cqlInsertByID = `INSERT INTO nonce_by_id (id, token) VALUES (?, ?) USING TTL ?`
+
+const cqlInsertByUser = `INSERT INTO nonce_by_user (user_id, action, token) VALUES (?, ?, ?) USING TTL ?`
+
+const cqlSelectNonce = `SELECT ` + cqlNonceColumns + ` FROM nonce WHERE token = ?`
+
+const cqlSelectNoncesIn = `SELECT ` + cqlNonceColumns + ` FROM nonce WHERE token IN ?`
+
+const cqlSelectAll = `SELECT ` + cqlNonceColumns + ` FROM nonce`
+
+const cqlSelectTokenByID = `SELECT token FROM nonce_by_id WHERE id = ?`
+
+const cqlSelectTokensByUser = `SELECT token FROM nonce_by_user WHERE user_id = ? AND action = ?`
+
+// cqlUpdateNonce rewrites every column so they all share the new TTL. The
+// lightweight transaction only applies if nothing changed the nonce since it was read.
+const cqlUpdateNonce = `UPDATE nonce USING TTL ?
+	SET id = ?, user_id = ?, action = ?, salt = ?, is_used = ?, is_valid = ?, created_at = ?,
+		expires_at = ?, consumed_at = ?, consumed_ip = ?, consumed_user_agent = ?, binding = ?,
+		parent_id = ?, namespace = ?, payload = ?
+	WHERE token = ?
+	IF is_used = ? AND is_valid = ? AND expires_at = ?`
+
+// cqlDeleteNonce only deletes if nothing changed the nonce since it was read
+const cqlDeleteNonce = `DELETE FROM nonce WHERE token = ? IF is_used = ? AND is_valid = ? AND expires_at = ?`
+
+const cqlDeleteByID = `DELETE FROM nonce_by_id WHERE id = ?`
+
+const cqlDeleteByUser = `DELETE FROM nonce_by_user WHERE user_id = ? AND action = ? AND token = ?`
+
+// ensureTables creates the tables the Service needs if they don't exist
+func (s *service) ensureTables() error {
+	for _, stmt := range cqlSchema {
+		err := s.session.Query(stmt).Exec()
+		if err != nil {
+			return err
+		}
+	}
+	for _, stmt := range cqlAddColumns {
+		err := s.session.Query(stmt).Exec()
+		if err != nil && !strings.Contains(err.Error(), "conflicts with an existing column") {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) New(action string, uid uuid.UUID, expiresIn time.Duration) (nonce.Nonce, error) {
+	return s.NewBound(action, uid, expiresIn, nonce.Binding{})
+}
+
+func (s *service) NewBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, b, "")
+}
+
+func (s *service) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, nonce.Binding{}, payload)
+}
+
+func (s *service) newBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding, payload string) (nonce.Nonce, error) {
+	n, err := s.cfg.NewBoundNonce(action, uid, expiresIn, b, payload)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n.ID = s.cfg.NewID()
+
+	// Save nonce
+	ctx := context.Background()
+	err = s.insert(ctx, n)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// Invalidate existing tokens for same user & action
+	others, err := s.invalidateOthers(ctx, n)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Created(n)
+	s.cfg.Invalidated(others...)
+
+	// return new nonce
+	return s.cfg.Issue(n), nil
+}
+
+func (s *service) Check(token, action string, uid uuid.UUID) error {
+	return s.CheckBound(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckBound(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) error {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return err
+	}
+
+	// get Nonce data from Cassandra
+	n, err := s.getNonce(context.Background(), token)
+	if err != nil {
+		return err
+	}
+
+	err = s.cfg.CheckBound(n, action, uid, meta, s.cfg.Clock().Now())
+	return err
+}
+
+func (s *service) Consume(token string) (nonce.Nonce, error) {
+	return s.ConsumeWithMeta(token, nonce.ConsumeMeta{})
+}
+
+func (s *service) ConsumeWithMeta(token string, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.update(context.Background(), token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		// make sure token hasn't been used
+		if n.IsUsed == true {
+			return nonce.Nonce{}, nonce.ErrTokenUsed
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = s.cfg.Clock().Now().Unix()
+		n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+		return n, nil
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) CheckThenConsume(token, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	return s.CheckThenConsumeWithMeta(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.update(context.Background(), token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		t := s.cfg.Clock().Now()
+		err := s.cfg.CheckBound(n, action, uid, meta, t)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = t.Unix()
+		n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+		return n, nil
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	ctx := context.Background()
+	token, err := s.tokenFor(ctx, id)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.update(ctx, token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		if n.ID != id {
+			return nonce.Nonce{}, nonce.ErrTokenNotFound
+		}
+		t := s.cfg.Clock().Now()
+		err := s.cfg.CheckNonce(n, action, uid, t)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = t.Unix()
+		return n, nil
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) Get(action string, uid uuid.UUID) (nonce.Nonce, error) {
+	if uid == uuid.Nil {
+		return nonce.Nonce{}, nonce.ErrUserRequired
+	}
+	nonces, err := s.forUser(context.Background(), action, uid)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	t := s.cfg.Clock().Now()
+	var newestN nonce.Nonce
+	found := false
+	for _, n := range nonces {
+		if !s.cfg.Usable(n, t) {
+			continue
+		}
+		if !found || newestN.CreatedAt < n.CreatedAt {
+			newestN = n
+			found = true
+		}
+	}
+
+	if !found {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+
+	return newestN, nil
+}
+
+func (s *service) Renew(token string, extendBy time.Duration) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// the lightweight transaction in update fails if a Consume slips in between
+	return s.update(context.Background(), token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		return s.cfg.RenewNonce(n, extendBy, s.cfg.Clock().Now())
+	})
+}
+
+func (s *service) AwaitConsumption(ctx context.Context, id uuid.UUID) (nonce.Nonce, error) {
+	return s.cfg.Await(ctx, id, s.getNonceByID)
+}
+
+func (s *service) PutNonce(n nonce.Nonce) (nonce.Nonce, error) {
+	n, err := s.cfg.FillNonce(n, s.cfg.Clock().Now())
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if n.ID == uuid.Nil {
+		n.ID = s.cfg.NewID()
+	}
+
+	// replace any existing nonce with the same token
+	ctx := context.Background()
+	old, err := s.getNonce(ctx, n.Token)
+	if err == nil {
+		err = s.deleteIndex(ctx, old)
+	}
+	if err != nil && err != nonce.ErrTokenNotFound {
+		return nonce.Nonce{}, err
+	}
+	err = s.insert(ctx, n)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	return s.cfg.Issue(n), nil
+}
+
+// Shutdown does nothing; Cassandra removes nonces when their TTLs run out
+func (s *service) Shutdown() {}
+
+// commands lists the statements each method runs, for the debug journal
+func (s *service) Commands(method string) []string {
+	switch method {
+	case "New", "NewBound", "NewWithPayload":
+		return []string{cqlInsertNonce, cqlInsertByID, cqlInsertByUser, cqlSelectTokensByUser, cqlSelectNonce, cqlUpdateNonce}
+	case "Check", "CheckBound", "GetByToken":
+		return []string{cqlSelectNonce}
+	case "Consume", "ConsumeWithMeta", "CheckThenConsume", "CheckThenConsumeWithMeta", "Renew":
+		return []string{cqlSelectNonce, cqlUpdateNonce}
+	case "ConsumeByID":
+		return []string{cqlSelectTokenByID, cqlSelectNonce, cqlUpdateNonce}
+	case "AwaitConsumption", "GetByID":
+		return []string{cqlSelectTokenByID, cqlSelectNonce}
+	case "Get":
+		return []string{cqlSelectTokensByUser, cqlSelectNoncesIn}
+	case "PutNonce":
+		return []string{cqlSelectNonce, cqlDeleteByID, cqlDeleteByUser, cqlInsertNonce, cqlInsertByID, cqlInsertByUser}
+	}
+	return nil
+}
+
+// ttl is how many seconds Cassandra should keep n. A TTL of 0 would keep it
+// forever, so it is at least 1.
+func (s *service) ttl(n nonce.Nonce) int {
+	ttl := int(math.Ceil(s.cfg.RemoveAt(n).Sub(s.cfg.Clock().Now()).Seconds()))
+	if ttl < 1 {
+		ttl = 1
+	}
+	return ttl
+}
+
+// insert writes n and its index rows
+func (s *service) insert(ctx context.Context, n nonce.Nonce) error {
+	sealed, err := s.cfg.Seal(n)
+	if err != nil {
+		return err
+	}
+	ttl := s.ttl(n)
+	err = s.session.Query(cqlInsertNonce,
+		n.Token, gocql.UUID(n.ID), gocql.UUID(n.UserID), n.Action, sealed.Salt, n.IsUsed, n.IsValid,
+		n.CreatedAt, n.ExpiresAt, n.ConsumedAt, sealed.ConsumedIP, sealed.ConsumedUserAgent, n.Binding, gocql.UUID(n.ParentID), n.Namespace,
+		sealed.Payload, ttl,
+	).ExecContext(ctx)
+	if err != nil {
+		return err
+	}
+	return s.index(ctx, n, ttl)
+}
+
+// index writes the rows that find n by ID and by user and action
+func (s *service) index(ctx context.Context, n nonce.Nonce, ttl int) error {
+	err := s.session.Query(cqlInsertByID, gocql.UUID(n.ID), n.Token, ttl).ExecContext(ctx)
+	if err != nil || n.UserID == uuid.Nil {
+		// anonymous nonces would all share one nonce_by_user partition
+		return err
+	}
+	return s.session.Query(cqlInsertByUser, gocql.UUID(n.UserID), n.Action, n.Token, ttl).ExecContext(ctx)
+}
+
+// deleteIndex removes n's index rows
+func (s *service) deleteIndex(ctx context.Context, n nonce.Nonce) error {
+	err := s.session.Query(cqlDeleteByID, gocql.UUID(n.ID)).ExecContext(ctx)
+	if err != nil || n.UserID == uuid.Nil {
+		return err
+	}
+	return s.session.Query(cqlDeleteByUser, gocql.UUID(n.UserID), n.Action, n.Token).ExecContext(ctx)
+}
+
+// scanNonce reads a row selected with cqlNonceColumns
+func (s *service) scanNonce(scan func(dest ...interface{}) error) (nonce.Nonce, error) {
+	n := nonce.Nonce{}
+	var id, uid, parent gocql.UUID
+	err := scan(&n.Token, &id, &uid, &n.Action, &n.Salt, &n.IsUsed, &n.IsValid,
+		&n.CreatedAt, &n.ExpiresAt, &n.ConsumedAt, &n.ConsumedIP, &n.ConsumedUserAgent, &n.Binding, &parent, &n.Namespace, &n.Payload)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n.ID, n.UserID, n.ParentID = uuid.UUID(id), uuid.UUID(uid), uuid.UUID(parent)
+	n.ExpiresAt = n.ExpiresAt.In(time.Local)
+	return s.cfg.Open(n), nil
+}
+
+// getNonce gets a Nonce in the Service's namespace from Cassandra
+func (s *service) getNonce(ctx context.Context, token string) (nonce.Nonce, error) {
+	q := s.session.Query(cqlSelectNonce, token)
+	n, err := s.scanNonce(func(dest ...interface{}) error {
+		return q.ScanContext(ctx, dest...)
+	})
+	if err == gocql.ErrNotFound || (err == nil && !s.cfg.InNamespace(n)) {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+	return n, err
+}
+
+// tokenFor returns the token of the nonce with id
+func (s *service) tokenFor(ctx context.Context, id uuid.UUID) (string, error) {
+	var token string
+	err := s.session.Query(cqlSelectTokenByID, gocql.UUID(id)).ScanContext(ctx, &token)
+	if err == gocql.ErrNotFound {
+		return "", nonce.ErrTokenNotFound
+	}
+	return token, err
+}
+
+// getNonceByID gets the Nonce with id from Cassandra
+func (s *service) getNonceByID(id uuid.UUID) (nonce.Nonce, error) {
+	ctx := context.Background()
+	token, err := s.tokenFor(ctx, id)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n, err := s.getNonce(ctx, token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if n.ID != id {
+		// the token was replaced by PutNonce after we looked up the id
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+	return n, nil
+}
+
+// forUser returns every nonce stored for action and uid in the Service's namespace.
+// nonce_by_user isn't partitioned by namespace, so the others are skipped here.
+func (s *service) forUser(ctx context.Context, action string, uid uuid.UUID) ([]nonce.Nonce, error) {
+	var tokens []string
+	iter := s.session.Query(cqlSelectTokensByUser, gocql.UUID(uid), action).IterContext(ctx)
+	var token string
+	for iter.Scan(&token) {
+		tokens = append(tokens, token)
+	}
+	err := iter.Close()
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+
+	// read all the nonces in one query
+	var nonces []nonce.Nonce
+	iter = s.session.Query(cqlSelectNoncesIn, tokens).IterContext(ctx)
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		n, err := s.scanNonce(scanner.Scan)
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+		if s.cfg.InNamespace(n) {
+			nonces = append(nonces, n)
+		}
+	}
+	return nonces, scanner.Err()
+}
+
+// update replaces the nonce stored for token with fn's result in a lightweight
+// transaction, retrying when another writer changes it in between. An error
+// from fn is returned as is.
+func (s *service) update(ctx context.Context, token string, fn func(nonce.Nonce) (nonce.Nonce, error)) (nonce.Nonce, error) {
+	for {
+		cur, err := s.getNonce(ctx, token)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		n, err := fn(cur)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+
+		sealed, err := s.cfg.Seal(n)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		ttl := s.ttl(n)
+		applied, err := s.session.Query(cqlUpdateNonce, ttl,
+			gocql.UUID(n.ID), gocql.UUID(n.UserID), n.Action, sealed.Salt, n.IsUsed, n.IsValid, n.CreatedAt,
+			n.ExpiresAt, n.ConsumedAt, sealed.ConsumedIP, sealed.ConsumedUserAgent, n.Binding,
+			gocql.UUID(n.ParentID), n.Namespace, sealed.Payload, token,
+			cur.IsUsed, cur.IsValid, cur.ExpiresAt,
+		).MapScanCASContext(ctx, map[string]interface{}{})
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		if !applied {
+			// lost a race; re-read and try again
+			continue
+		}
+
+		// keep the index rows as long as the nonce
+		if !s.cfg.RemoveAt(n).Equal(s.cfg.RemoveAt(cur)) {
+			err = s.index(ctx, n, ttl)
+			if err != nil {
+				return nonce.Nonce{}, err
+			}
+		}
+		return n, nil
+	}
+}
+
+// invalidateOthers marks every other valid nonce for n's user and action invalid.
+// It returns the ones that were unused, as they are now.
+func (s *service) invalidateOthers(ctx context.Context, n nonce.Nonce) ([]nonce.Nonce, error) {
+	if n.UserID == uuid.Nil {
+		return nil, nil
+	}
+	nonces, err := s.forUser(ctx, n.Action, n.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var others []nonce.Nonce
+	for _, o := range nonces {
+		if o.Token == n.Token || o.IsValid == false {
+			continue
+		}
+		o, err = s.update(ctx, o.Token, func(o nonce.Nonce) (nonce.Nonce, error) {
+			if o.IsValid == false || o.ID == n.ID {
+				return nonce.Nonce{}, kvstore.ErrUnchanged
+			}
+			o.IsValid = false
+			return o, nil
+		})
+		if err == kvstore.ErrUnchanged || err == nonce.ErrTokenNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !o.IsUsed {
+			others = append(others, o)
+		}
+	}
+	return others, nil
+}
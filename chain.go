@@ -0,0 +1,89 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Chainer is implemented by Services that can hand a multi-step flow, such as
+// verify email, then set password, then confirm, from one nonce to the next
+type Chainer interface {
+	// ConsumeAndChain checks and consumes token as CheckThenConsume does and
+	// creates a nonce for nextAction and the same user lasting expiresIn, as
+	// New does, with the consumed nonce's ID as its ParentID. It returns the
+	// new nonce. Either both happen or, if anything fails, neither does.
+	ConsumeAndChain(token, action string, uid uuid.UUID, nextAction string, expiresIn time.Duration) (Nonce, error)
+}
+
+// Lineage returns the nonces n was chained from, its parent first, by
+// following ParentID until a nonce without one. It stops at the first
+// ancestor in can't find, which may have been removed once it expired.
+func Lineage(in Inspector, n Nonce) ([]Nonce, error) {
+	var chain []Nonce
+	for n.ParentID != uuid.Nil {
+		parent, err := in.GetByID(n.ParentID)
+		if err == ErrTokenNotFound {
+			break
+		} else if err != nil {
+			return chain, err
+		}
+		chain = append(chain, parent)
+		n = parent
+	}
+	return chain, nil
+}
+
+func (s *nonceInMemoryService) ConsumeAndChain(token, action string, uid uuid.UUID, nextAction string, expiresIn time.Duration) (Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.checkToken(token)
+	if err != nil {
+		return Nonce{}, err
+	}
+	next, err := s.cfg.newNonce(nextAction, uid, expiresIn, s.cfg.clock.Now())
+	if err != nil {
+		return Nonce{}, err
+	}
+
+	// nothing after the consume can fail, so the successor is only saved once it succeeds
+	parent, err := s.update(token, func(n Nonce) (Nonce, error) {
+		t := s.cfg.clock.Now()
+		err := s.cfg.checkNonce(n, action, uid, t)
+		if err != nil {
+			return Nonce{}, err
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = t.Unix()
+		return n, nil
+	})
+	if err != nil {
+		return Nonce{}, err
+	}
+	s.waiters.consumed(parent)
+	s.cfg.consumed(parent)
+	s.broadcast(broadcastConsumed, parent)
+
+	next.ParentID = parent.ID
+	next = s.saveNonce(next)
+	others := s.store.invalidateOthers(next)
+	s.cfg.created(next)
+	s.cfg.invalidated(others...)
+	s.broadcast(broadcastInvalidated, next)
+	return next.issue(), nil
+}
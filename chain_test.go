@@ -0,0 +1,85 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestConsumeAndChain(t *testing.T) {
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newService(WithCounters(&Counters{}))
+			defer s.Shutdown()
+			c := s.(Chainer)
+			uid := uuid.NewV4()
+
+			first, err := s.New("verify-email", uid, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+			}
+			second, err := c.ConsumeAndChain(first.Token, "verify-email", uid, "set-password", time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to chain the nonce. Instead got the error: %v", err)
+			}
+			if second.ParentID != first.ID || second.Action != "set-password" || second.UserID != uid {
+				t.Fatalf("Expected a set-password successor of %s. Instead got: %+v", first.ID, second)
+			}
+			_, err = c.ConsumeAndChain(first.Token, "verify-email", uid, "set-password", time.Minute)
+			if !errors.Is(err, ErrTokenUsed) {
+				t.Errorf("Expected chaining a used nonce to fail with %v. Instead got: %v", ErrTokenUsed, err)
+			}
+
+			third, err := c.ConsumeAndChain(second.Token, "set-password", uid, "confirm", time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to chain the nonce. Instead got the error: %v", err)
+			}
+			err = s.Check(third.Token, "confirm", uid)
+			if err != nil {
+				t.Errorf("Expected the successor to be valid. Instead got: %v", err)
+			}
+			lineage, err := Lineage(s.(Inspector), third)
+			if err != nil || len(lineage) != 2 || lineage[0].ID != second.ID || lineage[1].ID != first.ID {
+				t.Errorf("Expected the lineage [%s %s]. Instead got: %+v, %v", second.ID, first.ID, lineage, err)
+			}
+			if !lineage[0].IsUsed {
+				t.Errorf("Expected the parent to be consumed")
+			}
+
+			// a failed check leaves no successor behind
+			other, _ := s.New("verify-email", uid, time.Minute)
+			stranger := uuid.NewV4()
+			_, err = c.ConsumeAndChain(other.Token, "verify-email", stranger, "set-password", time.Minute)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected chaining another user's nonce to fail with %v. Instead got: %v", ErrInvalidToken, err)
+			}
+			_, err = s.Get("set-password", stranger)
+			if err != ErrTokenNotFound {
+				t.Errorf("Expected a failed chain not to create a successor. Instead got: %v", err)
+			}
+			err = s.Check(other.Token, "verify-email", uid)
+			if err != nil {
+				t.Errorf("Expected a failed chain not to consume the nonce. Instead got: %v", err)
+			}
+		})
+	}
+}
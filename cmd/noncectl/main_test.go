@@ -0,0 +1,106 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bryanjeal/go-nonce"
+	noncesqlx "github.com/bryanjeal/go-nonce/sqlx"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestInvalidateLeakedToken(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nonce.db")
+	db := sqlx.MustConnect("sqlite3", file)
+	err := noncesqlx.Migrate(context.Background(), db)
+	db.Close()
+	if err != nil {
+		t.Fatalf("Expected to migrate the test database. Instead got: %v", err)
+	}
+	store := "sqlite3://" + file
+	uid := uuid.NewV4().String()
+
+	var out bytes.Buffer
+	err = run([]string{"-store", store, "create", "-action", "reset", "-user", uid}, &out)
+	if err != nil {
+		t.Fatalf("Expected create to succeed. Instead got: %v", err)
+	}
+	var n nonce.Nonce
+	err = json.Unmarshal(out.Bytes(), &n)
+	if err != nil || n.Token == "" {
+		t.Fatalf("Expected create to print the nonce. Instead got: %q, %v", out.String(), err)
+	}
+
+	out.Reset()
+	err = run([]string{"-store", store, "invalidate", n.Token}, &out)
+	if err != nil {
+		t.Fatalf("Expected invalidate to succeed. Instead got: %v", err)
+	}
+
+	out.Reset()
+	err = run([]string{"-store", store, "list", "-user", uid, "-outstanding"}, &out)
+	if err != nil || out.Len() != 0 {
+		t.Fatalf("Expected no outstanding nonces after invalidate. Instead got: %q, %v", out.String(), err)
+	}
+
+	out.Reset()
+	err = run([]string{"-store", store, "inspect", n.Token}, &out)
+	if err != nil || !strings.Contains(out.String(), `"is_valid":false`) {
+		t.Fatalf("Expected inspect to show the nonce is invalid. Instead got: %q, %v", out.String(), err)
+	}
+
+	err = run([]string{"-store", store, "inspect", "-id", uuid.NewV4().String()}, &out)
+	if err != nonce.ErrTokenNotFound {
+		t.Fatalf("Expected ErrTokenNotFound for an unknown ID. Instead got: %v", err)
+	}
+}
+
+func TestUnsupportedStore(t *testing.T) {
+	err := run([]string{"-store", "redis://localhost:6379", "list"}, &bytes.Buffer{})
+	if err == nil || errors.Is(err, nonce.ErrNotSupported) {
+		t.Fatalf("Expected an unsupported store error. Instead got: %v", err)
+	}
+}
+
+func TestStoreSchemes(t *testing.T) {
+	tests := []struct {
+		store       string
+		unsupported bool
+	}{
+		// nothing listens on port 1, so these get as far as the driver
+		{"postgres://nonce@127.0.0.1:1/nonce?sslmode=disable&connect_timeout=1", false},
+		{"postgresql://nonce@127.0.0.1:1/nonce?sslmode=disable&connect_timeout=1", false},
+		{"mysql://nonce@tcp(127.0.0.1:1)/nonce?parseTime=true&timeout=1s", false},
+		{"oracle://nonce@127.0.0.1:1/nonce", true},
+	}
+	for _, tt := range tests {
+		_, _, err := open(tt.store)
+		if err == nil {
+			t.Fatalf("Expected %s to fail to connect. Instead got: nil", tt.store)
+		}
+		unsupported := strings.HasPrefix(err.Error(), "unsupported store")
+		if unsupported != tt.unsupported {
+			t.Fatalf("Expected %s unsupported to be %v. Instead got: %v", tt.store, tt.unsupported, err)
+		}
+	}
+}
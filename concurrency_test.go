@@ -0,0 +1,97 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// TestConcurrentMisuse changes everything a caller can still reach after
+// creating a Service while goroutines use it. Run with -race.
+func TestConcurrentMisuse(t *testing.T) {
+	rates := SampleRates{"Check": 1}
+	hashers := []Hasher{SHA512}
+	s := NewInMemoryService(WithSampling(rates), WithLegacyHashers(hashers...), WithDebugJournal(discard{}))
+	defer s.Shutdown()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uid := uuid.NewV4()
+			for j := 0; j < 20; j++ {
+				n, err := s.New(tNonce.Action, uid, time.Minute)
+				if err != nil {
+					t.Errorf("Expected to add nonce. Instead got the error: %v", err)
+					return
+				}
+				s.Check(n.Token, tNonce.Action, uid)
+				s.(Purger).PurgeExpired(context.Background(), 0)
+				_, err = s.CheckThenConsume(n.Token, tNonce.Action, uid)
+				if err != nil {
+					t.Errorf("Expected to consume nonce. Instead got the error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for j := 0; j < 20; j++ {
+		rates["Check"] = 0
+		hashers[0] = nil
+		ListChunkSize++
+		PurgeBatchSize++
+	}
+	wg.Wait()
+	ListChunkSize -= 20
+	PurgeBatchSize -= 20
+
+	// the Service kept its own copy of the legacy hashers
+	legacy := strings.Repeat("A", 86) + "=="
+	if err := s.Check(legacy, tNonce.Action, tNonce.UserID); err != ErrTokenNotFound {
+		t.Fatalf("Expected ErrTokenNotFound. Instead got: %v", err)
+	}
+}
+
+func TestShutdownTwice(t *testing.T) {
+	s := NewInMemoryService()
+	s.Shutdown()
+	s.Shutdown()
+}
+
+func TestInvalidTuningPanics(t *testing.T) {
+	chunkSize := ListChunkSize
+	ListChunkSize = 0
+	defer func() {
+		ListChunkSize = chunkSize
+		if recover() == nil {
+			t.Fatalf("Expected NewInMemoryService to panic when ListChunkSize is 0.")
+		}
+	}()
+	NewInMemoryService()
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
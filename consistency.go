@@ -0,0 +1,154 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// WithReadYourWrites makes a Service remember the nonces it writes for window.
+// Reads made through the same Service during that window see those writes
+// even when the backing store serves reads from a lagging replica or cache.
+// The in-memory service is always read-your-writes consistent and ignores it.
+func WithReadYourWrites(window time.Duration) Option {
+	return func(cfg *config) {
+		cfg.readYourWrites = window
+	}
+}
+
+// recentWrites holds nonces written by this instance in the last window
+type recentWrites struct {
+	sync.Mutex
+	window time.Duration
+	nonces map[string]recentWrite
+	// order is every put, oldest first, so put only looks at the entries
+	// that have aged out
+	order []recentPut
+}
+
+type recentWrite struct {
+	n  Nonce
+	at time.Time
+}
+
+type recentPut struct {
+	token string
+	at    time.Time
+}
+
+// newRecentWrites returns nil when window is not positive so callers can
+// treat a nil *recentWrites as "disabled"
+func newRecentWrites(window time.Duration) *recentWrites {
+	if window <= 0 {
+		return nil
+	}
+	return &recentWrites{
+		window: window,
+		nonces: make(map[string]recentWrite),
+	}
+}
+
+// put records n as written at time t and drops entries older than window
+func (r *recentWrites) put(n Nonce, t time.Time) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	i := 0
+	for ; i < len(r.order) && t.Sub(r.order[i].at) > r.window; i++ {
+		// a token put again since is kept for its later put
+		if w, ok := r.nonces[r.order[i].token]; ok && w.at.Equal(r.order[i].at) {
+			delete(r.nonces, r.order[i].token)
+		}
+	}
+	r.order = r.order[i:]
+	n.plainToken = ""
+	r.nonces[n.Token] = recentWrite{n: n, at: t}
+	r.order = append(r.order, recentPut{token: n.Token, at: t})
+	r.Unlock()
+}
+
+// invalidateOthers mirrors New by marking older nonces for the same user, action and namespace invalid
+func (r *recentWrites) invalidateOthers(n Nonce) {
+	if r == nil || n.UserID == uuid.Nil {
+		return
+	}
+	r.Lock()
+	for k, v := range r.nonces {
+		if v.n.UserID == n.UserID && v.n.Action == n.Action && v.n.Namespace == n.Namespace && v.n.ID != n.ID {
+			v.n.IsValid = false
+			r.nonces[k] = v
+		}
+	}
+	r.Unlock()
+}
+
+// get returns the nonce written for token if it is still within window
+func (r *recentWrites) get(token string, t time.Time) (Nonce, bool) {
+	if r == nil {
+		return Nonce{}, false
+	}
+	r.Lock()
+	defer r.Unlock()
+	w, ok := r.nonces[token]
+	if !ok || t.Sub(w.at) > r.window {
+		return Nonce{}, false
+	}
+	return w.n, true
+}
+
+// newest returns the most recently created nonce written for action and uid
+// in namespace that is usable at t
+func (r *recentWrites) newest(action string, uid uuid.UUID, namespace string, t time.Time, usable func(Nonce, time.Time) bool) (Nonce, bool) {
+	if r == nil {
+		return Nonce{}, false
+	}
+	r.Lock()
+	defer r.Unlock()
+	var newestN Nonce
+	found := false
+	for _, w := range r.nonces {
+		if t.Sub(w.at) > r.window || w.n.Action != action || w.n.UserID != uid || w.n.Namespace != namespace || !usable(w.n, t) {
+			continue
+		}
+		if !found || newestN.CreatedAt < w.n.CreatedAt {
+			newestN = w.n
+			found = true
+		}
+	}
+	return newestN, found
+}
+
+// merge combines what the store returned with what this instance wrote.
+// A nonce can only move towards used and invalid and Renew only pushes its
+// expiry out, so the most advanced state and the later ExpiresAt win.
+func (r *recentWrites) merge(stored Nonce, t time.Time) Nonce {
+	w, ok := r.get(stored.Token, t)
+	if !ok {
+		return stored
+	}
+	stored.IsUsed = stored.IsUsed || w.IsUsed
+	stored.IsValid = stored.IsValid && w.IsValid
+	if w.ExpiresAt.After(stored.ExpiresAt) {
+		stored.ExpiresAt = w.ExpiresAt
+	}
+	if stored.ConsumedAt == 0 {
+		stored.ConsumedAt, stored.ConsumedIP, stored.ConsumedUserAgent = w.ConsumedAt, w.ConsumedIP, w.ConsumedUserAgent
+	}
+	return stored
+}
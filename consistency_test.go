@@ -0,0 +1,58 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecentWritesPut(t *testing.T) {
+	r := newRecentWrites(time.Minute)
+	start := time.Now()
+	r.put(Nonce{Token: "old"}, start)
+	r.put(Nonce{Token: "again"}, start)
+	r.put(Nonce{Token: "again"}, start.Add(30*time.Second))
+	r.put(Nonce{Token: "new"}, start.Add(90*time.Second))
+
+	if _, ok := r.nonces["old"]; ok {
+		t.Errorf("Expected a write older than the window to be dropped")
+	}
+	if _, ok := r.nonces["again"]; !ok {
+		t.Errorf("Expected a write made again within the window to be kept")
+	}
+	if len(r.order) != 2 {
+		t.Errorf("Expected only the puts within the window to be left. Instead got: %+v", r.order)
+	}
+}
+
+func TestRecentWritesMerge(t *testing.T) {
+	r := newRecentWrites(time.Minute)
+	now := time.Now()
+	stored := Nonce{Token: "token", IsValid: true, ExpiresAt: now.Add(time.Minute)}
+	renewed := stored
+	renewed.ExpiresAt = now.Add(time.Hour)
+	r.put(renewed, now)
+
+	n := r.merge(stored, now)
+	if !n.ExpiresAt.Equal(renewed.ExpiresAt) {
+		t.Errorf("Expected a renewal the store hasn't seen to win. Instead got: %v", n.ExpiresAt)
+	}
+	stored.ExpiresAt = now.Add(2 * time.Hour)
+	n = r.merge(stored, now)
+	if !n.ExpiresAt.Equal(stored.ExpiresAt) {
+		t.Errorf("Expected a later renewal from the store to win. Instead got: %v", n.ExpiresAt)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import uuid "github.com/satori/go.uuid"
+
+// ConsumeMeta describes the request that consumed a nonce.
+// It is stored on the Nonce as ConsumedIP and ConsumedUserAgent
+// so a suspicious consume can be traced after the fact.
+// It is also what a nonce's Binding is verified against.
+type ConsumeMeta struct {
+	IP        string
+	UserAgent string
+
+	// SessionID is only compared with the Binding; it isn't stored
+	SessionID string
+}
+
+// MetaConsumer is implemented by Services that can record ConsumeMeta
+type MetaConsumer interface {
+	// ConsumeWithMeta is Consume but records meta on the consumed nonce
+	ConsumeWithMeta(token string, meta ConsumeMeta) (Nonce, error)
+	// CheckThenConsumeWithMeta is CheckThenConsume but records meta on the consumed nonce
+	CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta ConsumeMeta) (Nonce, error)
+}
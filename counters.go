@@ -0,0 +1,149 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"expvar"
+	"net/http"
+	"time"
+)
+
+// Counters counts what the Services created with WithCounters do. It is an
+// expvar.Var, so it can be published with expvar.Publish("nonce", c) and shows
+// up on /debug/vars, and an http.Handler serving the same JSON:
+//
+//	creates                nonces created
+//	consumes               nonces consumed
+//	check_failures         failed checks and consumes by reason, e.g. "token_used"
+//	cleanups               background sweeps of expired nonces
+//	cleanup_seconds        time spent in those sweeps
+//	last_cleanup_seconds   how long the last sweep took
+//	purged                 expired nonces those sweeps removed
+type Counters struct {
+	vars               expvar.Map
+	creates            expvar.Int
+	consumes           expvar.Int
+	checkFailures      expvar.Map
+	cleanups           expvar.Int
+	cleanupSeconds     expvar.Float
+	lastCleanupSeconds expvar.Float
+	purged             expvar.Int
+}
+
+// NewCounters returns Counters starting from zero
+func NewCounters() *Counters {
+	c := &Counters{}
+	c.vars.Init()
+	c.checkFailures.Init()
+	c.vars.Set("creates", &c.creates)
+	c.vars.Set("consumes", &c.consumes)
+	c.vars.Set("check_failures", &c.checkFailures)
+	c.vars.Set("cleanups", &c.cleanups)
+	c.vars.Set("cleanup_seconds", &c.cleanupSeconds)
+	c.vars.Set("last_cleanup_seconds", &c.lastCleanupSeconds)
+	c.vars.Set("purged", &c.purged)
+	return c
+}
+
+// WithCounters makes a Service add what it does to c. One Counters can be
+// shared by several Services to count them together.
+func WithCounters(c *Counters) Option {
+	return func(cfg *config) {
+		cfg.counters = c
+	}
+}
+
+// String returns the counters as a JSON object
+func (c *Counters) String() string {
+	return c.vars.String()
+}
+
+func (c *Counters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write([]byte(c.String()))
+}
+
+// checkFailureReasons names the errors check_failures is keyed by.
+// Errors not listed are counted as "other".
+var checkFailureReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrNoToken, "no_token"},
+	{ErrInvalidToken, "invalid_token"},
+	{ErrTokenUsed, "token_used"},
+	{ErrTokenExpired, "token_expired"},
+	{ErrTokenNotFound, "token_not_found"},
+	{ErrTooManyAttempts, "too_many_attempts"},
+	{ErrUserRequired, "user_required"},
+	{ErrBindingMismatch, "binding_mismatch"},
+}
+
+func (c *Counters) interceptor() Interceptor {
+	return func(call Call, next func() error) error {
+		err := next()
+		switch call.Method {
+		case "New", "NewBound", "NewWithPayload":
+			if err == nil {
+				c.creates.Add(1)
+			}
+		case "NewBatch":
+			if err == nil {
+				c.creates.Add(int64(len(call.Args[1].([]NewRequest))))
+			}
+		case "Check", "CheckBound":
+			c.checked(err, false)
+		case "Consume", "ConsumeWithMeta", "CheckThenConsume", "CheckThenConsumeWithMeta", "ConsumeByID":
+			c.checked(err, true)
+		case "ConsumeAndChain":
+			c.checked(err, true)
+			if err == nil {
+				c.creates.Add(1)
+			}
+		}
+		return err
+	}
+}
+
+// checked counts a check, or a consume when consumed is set, that ended with err
+func (c *Counters) checked(err error, consumed bool) {
+	if err == nil {
+		if consumed {
+			c.consumes.Add(1)
+		}
+		return
+	}
+	reason := "other"
+	for _, r := range checkFailureReasons {
+		if errors.Is(err, r.err) {
+			reason = r.reason
+			break
+		}
+	}
+	c.checkFailures.Add(reason, 1)
+}
+
+// swept counts a background sweep that removed purged nonces in d.
+// It does nothing to nil Counters.
+func (c *Counters) swept(purged int64, d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.cleanups.Add(1)
+	c.cleanupSeconds.Add(d.Seconds())
+	c.lastCleanupSeconds.Set(d.Seconds())
+	c.purged.Add(purged)
+}
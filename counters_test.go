@@ -0,0 +1,68 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestCounters(t *testing.T) {
+	c := NewCounters()
+	s := NewInMemoryService(WithCounters(c))
+	defer s.Shutdown()
+
+	uid := uuid.NewV4()
+	n, err := s.New("login", uid, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	s.Check(n.Token, "other", uid)
+	s.Consume(n.Token)
+	s.Consume(n.Token)
+	s.Consume("")
+	c.swept(2, 1500*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/debug/nonce", nil))
+	var got struct {
+		Creates            int64            `json:"creates"`
+		Consumes           int64            `json:"consumes"`
+		CheckFailures      map[string]int64 `json:"check_failures"`
+		Cleanups           int64            `json:"cleanups"`
+		LastCleanupSeconds float64          `json:"last_cleanup_seconds"`
+		Purged             int64            `json:"purged"`
+	}
+	err = json.NewDecoder(w.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("Expected the counters as JSON. Instead got the error: %v", err)
+	}
+	if got.Creates != 1 || got.Consumes != 1 || got.Cleanups != 1 || got.LastCleanupSeconds != 1.5 || got.Purged != 2 {
+		t.Fatalf("Expected 1 create, 1 consume and 1 sweep of 2 nonces. Instead got: %+v", got)
+	}
+	want := map[string]int64{"invalid_token": 1, "token_used": 1, "no_token": 1}
+	if len(got.CheckFailures) != len(want) {
+		t.Fatalf("Expected check failures %v. Instead got: %v", want, got.CheckFailures)
+	}
+	for reason, count := range want {
+		if got.CheckFailures[reason] != count {
+			t.Fatalf("Expected check failures %v. Instead got: %v", want, got.CheckFailures)
+		}
+	}
+}
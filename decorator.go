@@ -0,0 +1,521 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+//go:generate go run internal/gendecorator/main.go -in service.go -out decorator_gen.go -type Service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Call describes a Service method invocation seen by an Interceptor
+type Call struct {
+	// Method is the name of the Service method being called
+	Method string
+
+	// Params and Args hold the method's parameter names and values in order
+	Params []string
+	Args   []interface{}
+}
+
+// Interceptor wraps a Service method call. It must call next to invoke the
+// method and should return the error next returns unless it is replacing it.
+type Interceptor func(c Call, next func() error) error
+
+// decorated routes every Service method through an Interceptor.
+// Its methods are generated from the Service interface; see decorator_gen.go.
+type decorated struct {
+	next      Service
+	intercept func(c Call, call func() error) error
+
+	// options is set when config.wrap applied the interceptors for the
+	// Service's options, which ForTenant rebuilds for the tenant's backend
+	options bool
+}
+
+// Decorate returns a Service that runs each method of s through interceptors.
+// The first interceptor is the outermost, so it sees the call first and its
+// result last. This keeps logging, metrics, tracing and retries out of the backends.
+func Decorate(s Service, interceptors ...Interceptor) Service {
+	intercept := func(c Call, call func() error) error {
+		return call()
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		outer, inner := interceptors[i], intercept
+		intercept = func(c Call, call func() error) error {
+			return outer(c, func() error {
+				return inner(c, call)
+			})
+		}
+	}
+
+	return &decorated{
+		next:      s,
+		intercept: intercept,
+	}
+}
+
+// PutNonce is forwarded so decorated Services can still be used with fixtures.
+// It returns ErrNotSupported if the wrapped Service isn't a Putter.
+func (d *decorated) PutNonce(n Nonce) (Nonce, error) {
+	p, ok := d.next.(Putter)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "PutNonce", Params: []string{"n"}, Args: []interface{}{n}}, func() error {
+		var err error
+		r0, err = p.PutNonce(n)
+		return err
+	})
+	return r0, err
+}
+
+// AwaitConsumption is forwarded so decorated Services can still be awaited.
+// It returns ErrNotSupported if the wrapped Service isn't an Awaiter.
+func (d *decorated) AwaitConsumption(ctx context.Context, id uuid.UUID) (Nonce, error) {
+	a, ok := d.next.(Awaiter)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "AwaitConsumption", Params: []string{"ctx", "id"}, Args: []interface{}{ctx, id}}, func() error {
+		var err error
+		r0, err = a.AwaitConsumption(ctx, id)
+		return err
+	})
+	return r0, err
+}
+
+// NewBatch is forwarded so decorated Services can still create batches.
+// It returns ErrNotSupported if the wrapped Service isn't a Batcher.
+func (d *decorated) NewBatch(ctx context.Context, requests []NewRequest) ([]Nonce, error) {
+	b, ok := d.next.(Batcher)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	var r0 []Nonce
+	err := d.intercept(Call{Method: "NewBatch", Params: []string{"ctx", "requests"}, Args: []interface{}{ctx, requests}}, func() error {
+		var err error
+		r0, err = b.NewBatch(ctx, requests)
+		return err
+	})
+	return r0, err
+}
+
+// GetByID is forwarded so decorated Services can still be inspected.
+// It returns ErrNotSupported if the wrapped Service isn't an Inspector.
+func (d *decorated) GetByID(id uuid.UUID) (Nonce, error) {
+	in, ok := d.next.(Inspector)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "GetByID", Params: []string{"id"}, Args: []interface{}{id}}, func() error {
+		var err error
+		r0, err = in.GetByID(id)
+		return err
+	})
+	return r0, err
+}
+
+// GetByToken is forwarded like GetByID.
+// It returns ErrNotSupported if the wrapped Service isn't an Inspector.
+func (d *decorated) GetByToken(token string) (Nonce, error) {
+	in, ok := d.next.(Inspector)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "GetByToken", Params: []string{"token"}, Args: []interface{}{token}}, func() error {
+		var err error
+		r0, err = in.GetByToken(token)
+		return err
+	})
+	return r0, err
+}
+
+// ConsumeWithMeta is forwarded so decorated Services can still record ConsumeMeta.
+// It returns ErrNotSupported if the wrapped Service isn't a MetaConsumer.
+func (d *decorated) ConsumeWithMeta(token string, meta ConsumeMeta) (Nonce, error) {
+	m, ok := d.next.(MetaConsumer)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "ConsumeWithMeta", Params: []string{"token", "meta"}, Args: []interface{}{token, meta}}, func() error {
+		var err error
+		r0, err = m.ConsumeWithMeta(token, meta)
+		return err
+	})
+	return r0, err
+}
+
+// CheckThenConsumeWithMeta is forwarded like ConsumeWithMeta.
+// It returns ErrNotSupported if the wrapped Service isn't a MetaConsumer.
+func (d *decorated) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta ConsumeMeta) (Nonce, error) {
+	m, ok := d.next.(MetaConsumer)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "CheckThenConsumeWithMeta", Params: []string{"token", "action", "uid", "meta"}, Args: []interface{}{token, action, uid, meta}}, func() error {
+		var err error
+		r0, err = m.CheckThenConsumeWithMeta(token, action, uid, meta)
+		return err
+	})
+	return r0, err
+}
+
+// NewBound is forwarded so decorated Services can still bind nonces.
+// It returns ErrNotSupported if the wrapped Service isn't a Binder.
+func (d *decorated) NewBound(action string, uid uuid.UUID, expiresIn time.Duration, b Binding) (Nonce, error) {
+	bd, ok := d.next.(Binder)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "NewBound", Params: []string{"action", "uid", "expiresIn", "b"}, Args: []interface{}{action, uid, expiresIn, b}}, func() error {
+		var err error
+		r0, err = bd.NewBound(action, uid, expiresIn, b)
+		return err
+	})
+	return r0, err
+}
+
+// NewWithPayload is forwarded so decorated Services can still store payloads.
+// It returns ErrNotSupported if the wrapped Service isn't a Payloader.
+func (d *decorated) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (Nonce, error) {
+	p, ok := d.next.(Payloader)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "NewWithPayload", Params: []string{"action", "uid", "expiresIn", "payload"}, Args: []interface{}{action, uid, expiresIn, payload}}, func() error {
+		var err error
+		r0, err = p.NewWithPayload(action, uid, expiresIn, payload)
+		return err
+	})
+	return r0, err
+}
+
+// CheckBound is forwarded like NewBound.
+// It returns ErrNotSupported if the wrapped Service isn't a Binder.
+func (d *decorated) CheckBound(token, action string, uid uuid.UUID, meta ConsumeMeta) error {
+	bd, ok := d.next.(Binder)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return d.intercept(Call{Method: "CheckBound", Params: []string{"token", "action", "uid", "meta"}, Args: []interface{}{token, action, uid, meta}}, func() error {
+		return bd.CheckBound(token, action, uid, meta)
+	})
+}
+
+// ConsumeAndChain is forwarded so decorated Services can still chain nonces.
+// It returns ErrNotSupported if the wrapped Service isn't a Chainer.
+func (d *decorated) ConsumeAndChain(token, action string, uid uuid.UUID, nextAction string, expiresIn time.Duration) (Nonce, error) {
+	c, ok := d.next.(Chainer)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+
+	var r0 Nonce
+	err := d.intercept(Call{Method: "ConsumeAndChain", Params: []string{"token", "action", "uid", "nextAction", "expiresIn"}, Args: []interface{}{token, action, uid, nextAction, expiresIn}}, func() error {
+		var err error
+		r0, err = c.ConsumeAndChain(token, action, uid, nextAction, expiresIn)
+		return err
+	})
+	return r0, err
+}
+
+// ExtendExpiry is forwarded so decorated Services can still extend nonces in bulk.
+// It returns ErrNotSupported if the wrapped Service isn't an ExpiryExtender.
+func (d *decorated) ExtendExpiry(filter Filter, by time.Duration) (int, error) {
+	e, ok := d.next.(ExpiryExtender)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	var r0 int
+	err := d.intercept(Call{Method: "ExtendExpiry", Params: []string{"filter", "by"}, Args: []interface{}{filter, by}}, func() error {
+		var err error
+		r0, err = e.ExtendExpiry(filter, by)
+		return err
+	})
+	return r0, err
+}
+
+// List is forwarded so decorated Services can still be scanned.
+// It returns ErrNotSupported if the wrapped Service isn't a Lister.
+func (d *decorated) List(ctx context.Context, f Filter, fn func(Nonce) error) error {
+	l, ok := d.next.(Lister)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return d.intercept(Call{Method: "List", Params: []string{"ctx", "f"}, Args: []interface{}{ctx, f}}, func() error {
+		return l.List(ctx, f, fn)
+	})
+}
+
+// PurgeExpired is forwarded so decorated Services can still be purged on demand.
+// It returns ErrNotSupported if the wrapped Service isn't a Purger.
+func (d *decorated) PurgeExpired(ctx context.Context, limit int) (int64, error) {
+	p, ok := d.next.(Purger)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	var r0 int64
+	err := d.intercept(Call{Method: "PurgeExpired", Params: []string{"ctx", "limit"}, Args: []interface{}{ctx, limit}}, func() error {
+		var err error
+		r0, err = p.PurgeExpired(ctx, limit)
+		return err
+	})
+	return r0, err
+}
+
+// PurgeDeleted is forwarded so decorated Services can still purge tombstones.
+// It returns ErrNotSupported if the wrapped Service isn't a Tombstoner.
+func (d *decorated) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ts, ok := d.next.(Tombstoner)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	var r0 int64
+	err := d.intercept(Call{Method: "PurgeDeleted", Params: []string{"ctx", "before"}, Args: []interface{}{ctx, before}}, func() error {
+		var err error
+		r0, err = ts.PurgeDeleted(ctx, before)
+		return err
+	})
+	return r0, err
+}
+
+// Undelete is forwarded like PurgeDeleted.
+// It returns ErrNotSupported if the wrapped Service isn't a Tombstoner.
+func (d *decorated) Undelete(ctx context.Context, since time.Time) (int64, error) {
+	ts, ok := d.next.(Tombstoner)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	var r0 int64
+	err := d.intercept(Call{Method: "Undelete", Params: []string{"ctx", "since"}, Args: []interface{}{ctx, since}}, func() error {
+		var err error
+		r0, err = ts.Undelete(ctx, since)
+		return err
+	})
+	return r0, err
+}
+
+// DeleteAllForUser is forwarded so decorated Services can still erase a user.
+// It returns ErrNotSupported if the wrapped Service isn't a UserDeleter.
+func (d *decorated) DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error) {
+	ud, ok := d.next.(UserDeleter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	var r0 int64
+	err := d.intercept(Call{Method: "DeleteAllForUser", Params: []string{"ctx", "uid"}, Args: []interface{}{ctx, uid}}, func() error {
+		var err error
+		r0, err = ud.DeleteAllForUser(ctx, uid)
+		return err
+	})
+	return r0, err
+}
+
+// Snapshot is forwarded so decorated Services can still be saved.
+// It returns ErrNotSupported if the wrapped Service isn't a Snapshotter.
+func (d *decorated) Snapshot(w io.Writer) error {
+	sn, ok := d.next.(Snapshotter)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return d.intercept(Call{Method: "Snapshot", Params: []string{"w"}, Args: []interface{}{w}}, func() error {
+		return sn.Snapshot(w)
+	})
+}
+
+// Restore is forwarded so decorated Services can still be loaded.
+// It returns ErrNotSupported if the wrapped Service isn't a Snapshotter.
+func (d *decorated) Restore(r io.Reader) error {
+	sn, ok := d.next.(Snapshotter)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return d.intercept(Call{Method: "Restore", Params: []string{"r"}, Args: []interface{}{r}}, func() error {
+		return sn.Restore(r)
+	})
+}
+
+// Stats is forwarded so decorated Services can still report what they hold.
+// It returns ErrNotSupported if the wrapped Service isn't a StatsReporter.
+func (d *decorated) Stats(ctx context.Context) (Stats, error) {
+	r, ok := d.next.(StatsReporter)
+	if !ok {
+		return Stats{}, ErrNotSupported
+	}
+	return r.Stats(ctx)
+}
+
+// Health is forwarded so decorated Services can still be probed. It doesn't
+// go through the interceptors, so frequent probes aren't journaled.
+// It returns ErrNotSupported if the wrapped Service isn't a HealthChecker.
+func (d *decorated) Health(ctx context.Context) error {
+	h, ok := d.next.(HealthChecker)
+	if !ok {
+		return ErrNotSupported
+	}
+	return h.Health(ctx)
+}
+
+// LastSweep is forwarded along with Health.
+// It returns the zero Time if the wrapped Service isn't a HealthChecker.
+func (d *decorated) LastSweep() time.Time {
+	h, ok := d.next.(HealthChecker)
+	if !ok {
+		return time.Time{}
+	}
+	return h.LastSweep()
+}
+
+// ReserveKey is forwarded so decorated Services can still keep idempotency
+// keys. It doesn't go through the interceptors, nor do the other
+// IdempotencyStore methods. It returns ErrNotSupported if the wrapped Service
+// isn't an IdempotencyStore.
+func (d *decorated) ReserveKey(key string, uid uuid.UUID, ttl time.Duration) (IdempotencyRecord, bool, error) {
+	st, ok := d.next.(IdempotencyStore)
+	if !ok {
+		return IdempotencyRecord{}, false, ErrNotSupported
+	}
+	return st.ReserveKey(key, uid, ttl)
+}
+
+// CompleteKey is forwarded along with ReserveKey
+func (d *decorated) CompleteKey(key string, uid uuid.UUID, result []byte) error {
+	st, ok := d.next.(IdempotencyStore)
+	if !ok {
+		return ErrNotSupported
+	}
+	return st.CompleteKey(key, uid, result)
+}
+
+// ReleaseKey is forwarded along with ReserveKey
+func (d *decorated) ReleaseKey(key string, uid uuid.UUID) error {
+	st, ok := d.next.(IdempotencyStore)
+	if !ok {
+		return ErrNotSupported
+	}
+	return st.ReleaseKey(key, uid)
+}
+
+// PurgeExpiredKeys is forwarded along with ReserveKey
+func (d *decorated) PurgeExpiredKeys(ctx context.Context) (int64, error) {
+	st, ok := d.next.(IdempotencyStore)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return st.PurgeExpiredKeys(ctx)
+}
+
+// LoggingInterceptor logs every call that returns an error to l
+func LoggingInterceptor(l Logger) Interceptor {
+	return func(c Call, next func() error) error {
+		err := next()
+		if err != nil {
+			l.Printf("nonce: %s failed: %v", c.Method, err)
+		}
+		return err
+	}
+}
+
+// Metrics receives the duration and outcome of every Service call
+type Metrics interface {
+	ObserveCall(method string, d time.Duration, err error)
+}
+
+// MetricsInterceptor reports every call to m
+func MetricsInterceptor(m Metrics) Interceptor {
+	return func(c Call, next func() error) error {
+		start := time.Now()
+		err := next()
+		m.ObserveCall(c.Method, time.Since(start), err)
+		return err
+	}
+}
+
+// Tracer starts a Span for each Service call.
+// Adapt it to the tracing library your application already uses.
+type Tracer interface {
+	StartSpan(method string) Span
+}
+
+// Span is finished with the error the call returned
+type Span interface {
+	Finish(err error)
+}
+
+// TracingInterceptor wraps every call in a Span started by t
+func TracingInterceptor(t Tracer) Interceptor {
+	return func(c Call, next func() error) error {
+		span := t.StartSpan(c.Method)
+		err := next()
+		span.Finish(err)
+		return err
+	}
+}
+
+// RetryInterceptor calls methods up to attempts times, sleeping backoff between
+// tries, while retryable reports true for the error. A nil retryable uses
+// IsTransient. It is a RetryPolicy with a fixed backoff and no budget, so it
+// retries exactly as NewRetryingService does. Shutdown is never retried.
+func RetryInterceptor(attempts int, backoff time.Duration, retryable func(error) bool) Interceptor {
+	return RetryPolicy{Attempts: attempts, Backoff: backoff, MaxBackoff: backoff, Retryable: retryable}.interceptor()
+}
+
+// isServiceError reports whether err is one of the package's own errors,
+// which describe the nonce rather than a failure of the backend
+func isServiceError(err error) bool {
+	for _, e := range []error{
+		ErrNoToken, ErrInvalidToken, ErrTokenUsed, ErrTokenExpired, ErrTokenNotFound, ErrNotSupported,
+		ErrTooManyAttempts, ErrPayloadTooLarge, ErrRateLimited, ErrTooManyNonces, ErrUserRequired,
+		ErrBindingMismatch, ErrInvalidBinding, ErrKeyInProgress, ErrInvalidKey,
+		ErrInvalidScope,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+// Code generated by gendecorator; DO NOT EDIT.
+
+package nonce
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func (d *decorated) New(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error) {
+	var r0 Nonce
+	err := d.intercept(Call{Method: "New", Params: []string{"action", "uid", "expiresIn"}, Args: []interface{}{action, uid, expiresIn}}, func() error {
+		var err error
+		r0, err = d.next.New(action, uid, expiresIn)
+		return err
+	})
+	return r0, err
+}
+
+func (d *decorated) Check(token string, action string, uid uuid.UUID) error {
+	err := d.intercept(Call{Method: "Check", Params: []string{"token", "action", "uid"}, Args: []interface{}{token, action, uid}}, func() error {
+		var err error
+		err = d.next.Check(token, action, uid)
+		return err
+	})
+	return err
+}
+
+func (d *decorated) Consume(token string) (Nonce, error) {
+	var r0 Nonce
+	err := d.intercept(Call{Method: "Consume", Params: []string{"token"}, Args: []interface{}{token}}, func() error {
+		var err error
+		r0, err = d.next.Consume(token)
+		return err
+	})
+	return r0, err
+}
+
+func (d *decorated) CheckThenConsume(token string, action string, uid uuid.UUID) (Nonce, error) {
+	var r0 Nonce
+	err := d.intercept(Call{Method: "CheckThenConsume", Params: []string{"token", "action", "uid"}, Args: []interface{}{token, action, uid}}, func() error {
+		var err error
+		r0, err = d.next.CheckThenConsume(token, action, uid)
+		return err
+	})
+	return r0, err
+}
+
+func (d *decorated) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (Nonce, error) {
+	var r0 Nonce
+	err := d.intercept(Call{Method: "ConsumeByID", Params: []string{"id", "action", "uid"}, Args: []interface{}{id, action, uid}}, func() error {
+		var err error
+		r0, err = d.next.ConsumeByID(id, action, uid)
+		return err
+	})
+	return r0, err
+}
+
+func (d *decorated) Get(action string, uid uuid.UUID) (Nonce, error) {
+	var r0 Nonce
+	err := d.intercept(Call{Method: "Get", Params: []string{"action", "uid"}, Args: []interface{}{action, uid}}, func() error {
+		var err error
+		r0, err = d.next.Get(action, uid)
+		return err
+	})
+	return r0, err
+}
+
+func (d *decorated) Renew(token string, extendBy time.Duration) (Nonce, error) {
+	var r0 Nonce
+	err := d.intercept(Call{Method: "Renew", Params: []string{"token", "extendBy"}, Args: []interface{}{token, extendBy}}, func() error {
+		var err error
+		r0, err = d.next.Renew(token, extendBy)
+		return err
+	})
+	return r0, err
+}
+
+func (d *decorated) Shutdown() {
+	d.intercept(Call{Method: "Shutdown", Params: []string{}, Args: []interface{}{}}, func() error {
+		d.next.Shutdown()
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecorate(t *testing.T) {
+	s := NewInMemoryService()
+	defer s.Shutdown()
+
+	var calls []string
+	record := func(name string) Interceptor {
+		return func(c Call, next func() error) error {
+			calls = append(calls, name+":"+c.Method)
+			return next()
+		}
+	}
+
+	d := Decorate(s, record("outer"), record("inner"))
+	n, err := d.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce through decorator. Instead got the error: %v", err)
+	}
+	err = d.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Fatalf("Expected to nonce check to be valid. Instead got the error: %v", err)
+	}
+
+	want := []string{"outer:New", "inner:New", "outer:Check", "inner:Check"}
+	if len(calls) != len(want) {
+		t.Fatalf("Expected calls: %v. Instead got: %v", want, calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("Expected calls: %v. Instead got: %v", want, calls)
+		}
+	}
+}
+
+func TestRetryInterceptor(t *testing.T) {
+	retry := RetryInterceptor(3, time.Millisecond, nil)
+
+	tries := 0
+	errBackend := errors.New("connection reset")
+	err := retry(Call{Method: "Consume"}, func() error {
+		tries++
+		return errBackend
+	})
+	if err != errBackend || tries != 3 {
+		t.Fatalf("Expected 3 tries ending in the backend error. Instead got %d tries and: %v", tries, err)
+	}
+
+	tries = 0
+	err = retry(Call{Method: "Consume"}, func() error {
+		tries++
+		return ErrTokenUsed
+	})
+	if !errors.Is(err, ErrTokenUsed) || tries != 1 {
+		t.Fatalf("Expected ErrTokenUsed not to be retried. Instead got %d tries and: %v", tries, err)
+	}
+
+	// it classifies errors the same way NewRetryingService does
+	tries = 0
+	errQuery := errors.New("syntax error at or near \"FROM\"")
+	err = retry(Call{Method: "Consume"}, func() error {
+		tries++
+		return errQuery
+	})
+	if err != errQuery || tries != 1 {
+		t.Fatalf("Expected an error IsTransient rejects not to be retried. Instead got %d tries and: %v", tries, err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package download mints expiring, single-use URLs for file downloads and
+// guards the handler that serves the files with them. A URL carries an
+// anonymous nonce for its path and an HMAC-SHA256 of both:
+//
+//	https://example.com/files/report.pdf?token=...&sig=...
+//
+// The first GET consumes the nonce; the URL then gets 410 Gone.
+package download
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Query parameters a URL carries
+const (
+	TokenParam     = "token"
+	SignatureParam = "sig"
+)
+
+// ErrInvalidPath is returned for a path that doesn't start with "/"
+var ErrInvalidPath = errors.New("download: invalid path")
+
+// Config configures URLs
+type Config struct {
+	// Service stores the download nonces
+	Service nonce.Service
+
+	// BaseURL is put in front of the paths URLs are made for, e.g.
+	// "https://example.com". It may be empty for relative URLs.
+	BaseURL string
+
+	// Secret keys the URL signatures
+	Secret []byte
+
+	// TTL is how long a URL works for. Zero uses an hour.
+	TTL time.Duration
+
+	// ActionPrefix goes in front of a path to make its nonces' action.
+	// Empty uses "download:".
+	ActionPrefix string
+}
+
+// URLs mints download URLs and checks them
+type URLs struct {
+	cfg Config
+}
+
+// New returns URLs for cfg
+func New(cfg Config) *URLs {
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Hour
+	}
+	if cfg.ActionPrefix == "" {
+		cfg.ActionPrefix = "download:"
+	}
+	return &URLs{cfg: cfg}
+}
+
+// URL returns a URL that downloads path once. path is the request path Guard
+// will see, e.g. "/files/report.pdf". Each URL stands alone, so minting
+// another for the same path doesn't stop this one working.
+func (u *URLs) URL(path string) (string, error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", ErrInvalidPath
+	}
+	n, err := u.cfg.Service.New(u.cfg.ActionPrefix+path, uuid.Nil, u.cfg.TTL)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set(TokenParam, n.Token)
+	q.Set(SignatureParam, u.sign(path, n.Token))
+	return u.cfg.BaseURL + (&url.URL{Path: path}).EscapedPath() + "?" + q.Encode(), nil
+}
+
+// Guard serves the requests that carry a URL's token and signature with
+// next, taking the parameters off first. A GET uses the URL up; a HEAD only
+// checks it. Used and expired URLs get 410 Gone, other bad or missing ones 403
+// Forbidden and every method but GET and HEAD 405 Method Not Allowed.
+func (u *URLs) Guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		token := q.Get(TokenParam)
+		err := u.verify(r.Method, r.URL.Path, token, q.Get(SignatureParam))
+		if err != nil {
+			status := status(err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		// the handler downstream shouldn't see or pass on the token
+		q.Del(TokenParam)
+		q.Del(SignatureParam)
+		r2 := r.Clone(r.Context())
+		r2.URL.RawQuery = q.Encode()
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// verify checks the signature and nonce of a request for path
+func (u *URLs) verify(method, path, token, sig string) error {
+	if token == "" {
+		return nonce.ErrNoToken
+	}
+	if !hmac.Equal([]byte(sig), []byte(u.sign(path, token))) {
+		return nonce.ErrInvalidToken
+	}
+	action := u.cfg.ActionPrefix + path
+	if method == http.MethodHead {
+		return u.cfg.Service.Check(token, action, uuid.Nil)
+	}
+	_, err := u.cfg.Service.CheckThenConsume(token, action, uuid.Nil)
+	return err
+}
+
+// sign returns the URL-safe HMAC-SHA256 of path and token
+func (u *URLs) sign(path, token string) string {
+	mac := hmac.New(sha256.New, u.cfg.Secret)
+	mac.Write([]byte(path + "\n" + token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// rejected are the errors that mean the URL, rather than the Service, is at fault
+var rejected = []error{
+	nonce.ErrNoToken, nonce.ErrInvalidToken, nonce.ErrTokenNotFound,
+	nonce.ErrUserRequired, nonce.ErrBindingMismatch, nonce.ErrTooManyAttempts,
+}
+
+func status(err error) int {
+	if errors.Is(err, nonce.ErrTokenUsed) || errors.Is(err, nonce.ErrTokenExpired) {
+		return http.StatusGone
+	}
+	for _, e := range rejected {
+		if errors.Is(err, e) {
+			return http.StatusForbidden
+		}
+	}
+	return http.StatusInternalServerError
+}
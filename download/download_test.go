@@ -0,0 +1,72 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package download
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+func TestGuard(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	u := New(Config{Service: s, Secret: []byte("shh")})
+	var query string
+	h := u.Guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte("report"))
+	}))
+
+	link, err := u.URL("/files/report.pdf")
+	if err != nil {
+		t.Fatalf("Expected to mint a URL. Instead got the error: %v", err)
+	}
+	other, _ := u.URL("/files/report.pdf")
+
+	if w := get(h, http.MethodHead, link); w.Code != http.StatusOK {
+		t.Errorf("Expected HEAD to leave the URL usable. Instead got: %d", w.Code)
+	}
+	w := get(h, http.MethodGet, link)
+	if w.Code != http.StatusOK || w.Body.String() != "report" || query != "" {
+		t.Fatalf("Expected the first GET to download the file without the token. Instead got: %d %q %q", w.Code, w.Body.String(), query)
+	}
+	if w = get(h, http.MethodGet, link); w.Code != http.StatusGone {
+		t.Errorf("Expected a used URL to get %d. Instead got: %d", http.StatusGone, w.Code)
+	}
+	if w = get(h, http.MethodGet, other); w.Code != http.StatusOK {
+		t.Errorf("Expected a second URL for the path to still work. Instead got: %d", w.Code)
+	}
+
+	link, _ = u.URL("/files/report.pdf")
+	if w = get(h, http.MethodGet, strings.Replace(link, "report.pdf", "secret.pdf", 1)); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a URL moved to another path to get %d. Instead got: %d", http.StatusForbidden, w.Code)
+	}
+	if w = get(h, http.MethodGet, "/files/report.pdf"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a request without a token to get %d. Instead got: %d", http.StatusForbidden, w.Code)
+	}
+	if w = get(h, http.MethodPost, link); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected a POST to get %d. Instead got: %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func get(h http.Handler, method, link string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, link, nil))
+	return w
+}
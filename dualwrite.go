@@ -0,0 +1,139 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// DualWriteMode says how a NewDualWriteService reads
+type DualWriteMode struct {
+	// ReadNew answers Check and Get from the new store, falling back to the
+	// old one for nonces it doesn't have yet. By default the old store answers.
+	ReadNew bool
+
+	// Verify also asks the store that isn't answering and calls OnMismatch
+	// when they disagree, to shadow-test the new store before relying on it
+	Verify     bool
+	OnMismatch func(m DualWriteMismatch)
+}
+
+// DualWriteMismatch is a read the old and new stores answered differently
+type DualWriteMismatch struct {
+	// Method is "Check" or "Get"
+	Method string
+	Token  string
+	Action string
+	UserID uuid.UUID
+
+	OldErr, NewErr error
+	// OldID and NewID are the nonces Get returned
+	OldID, NewID uuid.UUID
+}
+
+// dualWriteService is a cachedService, copying what primary writes into
+// cache, that reads as mode says
+type dualWriteService struct {
+	*cachedService
+	mode DualWriteMode
+}
+
+// NewDualWriteService creates a Service for the cutover from oldSvc to newSvc.
+// Every write is made on oldSvc, then the nonce it created or changed is
+// copied into newSvc, token and all, so links keep working on either store.
+// Check and Get are answered as mode says; GetByID, GetByToken and
+// AwaitConsumption always ask oldSvc. Failed copies are dropped, so run
+// MigrateStore after starting it to copy the rest. newSvc must be a Putter
+// and a Lister, or ErrNotPutter or ErrNotLister is returned. Both are shut
+// down with the returned Service.
+func NewDualWriteService(oldSvc, newSvc Service, mode DualWriteMode) (Service, error) {
+	put, ok := newSvc.(Putter)
+	if !ok {
+		return nil, ErrNotPutter
+	}
+	list, ok := newSvc.(Lister)
+	if !ok {
+		return nil, ErrNotLister
+	}
+	return &dualWriteService{
+		cachedService: &cachedService{
+			primary: oldSvc,
+			cache:   newSvc,
+			put:     put,
+			list:    list,
+		},
+		mode: mode,
+	}, nil
+}
+
+func (s *dualWriteService) Check(token, action string, uid uuid.UUID) error {
+	m := DualWriteMismatch{Method: "Check", Token: token, Action: action, UserID: uid}
+	_, err := s.read(m, func(store Service) (Nonce, error) {
+		return Nonce{}, store.Check(token, action, uid)
+	})
+	return err
+}
+
+func (s *dualWriteService) Get(action string, uid uuid.UUID) (Nonce, error) {
+	m := DualWriteMismatch{Method: "Get", Action: action, UserID: uid}
+	return s.read(m, func(store Service) (Nonce, error) {
+		return store.Get(action, uid)
+	})
+}
+
+// read runs fn on the store mode prefers, checking it against the other
+func (s *dualWriteService) read(m DualWriteMismatch, fn func(store Service) (Nonce, error)) (Nonce, error) {
+	answer, shadow := s.primary, s.cache
+	if s.mode.ReadNew {
+		answer, shadow = s.cache, s.primary
+	}
+	n, err := fn(answer)
+	if s.mode.ReadNew && err == ErrTokenNotFound {
+		// not copied yet
+		return fn(s.primary)
+	}
+	if !s.mode.Verify || s.mode.OnMismatch == nil {
+		return n, err
+	}
+
+	sn, serr := fn(shadow)
+	if sn.ID == n.ID && sameError(err, serr) {
+		return n, err
+	}
+	m.OldErr, m.OldID, m.NewErr, m.NewID = err, n.ID, serr, sn.ID
+	if s.mode.ReadNew {
+		m.OldErr, m.OldID, m.NewErr, m.NewID = serr, sn.ID, err, n.ID
+	}
+	s.mode.OnMismatch(m)
+	return n, err
+}
+
+// sameError reports whether a and b wrap the same error, ignoring details
+// such as a TokenError's CheckedAt
+func sameError(a, b error) bool {
+	return rootError(a) == rootError(b)
+}
+
+func rootError(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
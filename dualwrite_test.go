@@ -0,0 +1,81 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDualWriteService(t *testing.T) {
+	oldSvc, newSvc := NewInMemoryService(), NewInMemoryService()
+	var mismatches []DualWriteMismatch
+	s, err := NewDualWriteService(oldSvc, newSvc, DualWriteMode{
+		ReadNew:    true,
+		Verify:     true,
+		OnMismatch: func(m DualWriteMismatch) { mismatches = append(mismatches, m) },
+	})
+	if err != nil {
+		t.Fatalf("Expected to create the dual write Service. Instead got the error: %v", err)
+	}
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	err = newSvc.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Fatalf("Expected the nonce to be copied into the new store. Instead got the error: %v", err)
+	}
+	_, err = s.Consume(n.Token)
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("Expected %v. Instead got: %v", ErrTokenUsed, err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("Expected the stores to agree. Instead got: %+v", mismatches)
+	}
+
+	// a nonce from before the cutover is only in the old store
+	before, _ := oldSvc.New("before", tNonce.UserID, time.Hour)
+	err = s.Check(before.Token, "before", tNonce.UserID)
+	if err != nil {
+		t.Errorf("Expected to fall back to the old store. Instead got the error: %v", err)
+	}
+
+	s, err = NewDualWriteService(oldSvc, newSvc, DualWriteMode{
+		Verify:     true,
+		OnMismatch: func(m DualWriteMismatch) { mismatches = append(mismatches, m) },
+	})
+	if err != nil {
+		t.Fatalf("Expected to create the dual write Service. Instead got the error: %v", err)
+	}
+	_, err = s.Get("before", tNonce.UserID)
+	if err != nil {
+		t.Fatalf("Expected the old store to answer. Instead got the error: %v", err)
+	}
+	if len(mismatches) != 1 {
+		t.Fatalf("Expected 1 mismatch. Instead got: %+v", mismatches)
+	}
+	m := mismatches[0]
+	if m.Method != "Get" || m.OldID != before.ID || m.NewErr != ErrTokenNotFound {
+		t.Errorf("Expected the mismatch to describe both answers. Instead got: %+v", m)
+	}
+}
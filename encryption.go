@@ -0,0 +1,197 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// sealedPrefix starts every encrypted field, followed by the key ID, a colon
+// and the base64 GCM nonce and ciphertext
+const sealedPrefix = "enc1:"
+
+var errSealedField = errors.New("nonce: can't decrypt field")
+
+// WithEncryption makes the Service encrypt each nonce's Salt, Payload,
+// ConsumedIP and ConsumedUserAgent with AES-256-GCM under key before storing
+// them and decrypt them when reading them back, so a dump of the database or
+// key space doesn't show how its tokens were made or who redeemed them. The
+// in-memory Service only encrypts them in its Snapshots and persisted copy.
+//
+// Each encrypted field names the key it was sealed with. To rotate keys pass
+// the new key and the old ones as previous, which are only used to decrypt,
+// until the nonces sealed with them have been purged. Fields stored before
+// encryption was enabled are read as they are. A field that can't be decrypted
+// is left encrypted and reported to the Logger; checking and consuming never
+// need them. Action and Binding are stored in the clear since the backends
+// match them in their queries.
+//
+// The sqlx backend needs migration 0007 to widen the salt column and 0011 to
+// widen the consumed_ip and consumed_user_agent columns.
+func WithEncryption(key [32]byte, previous ...[32]byte) Option {
+	return func(cfg *config) {
+		cfg.cipher = newFieldCipher(key, previous)
+	}
+}
+
+// fieldCipher seals fields with the current key and opens them with any key
+type fieldCipher struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+func newFieldCipher(key [32]byte, previous [][32]byte) *fieldCipher {
+	c := &fieldCipher{current: keyID(key), keys: make(map[string]cipher.AEAD)}
+	for _, k := range append([][32]byte{key}, previous...) {
+		// NewCipher only fails for key sizes other than 16, 24 and 32 bytes
+		block, _ := aes.NewCipher(k[:])
+		aead, _ := cipher.NewGCM(block)
+		c.keys[keyID(k)] = aead
+	}
+	return c
+}
+
+// keyID names key without revealing it
+func keyID(key [32]byte) string {
+	sum := sha256.Sum256(key[:])
+	return hex.EncodeToString(sum[:4])
+}
+
+// seal encrypts value, binding it to ad so it can't be moved to another nonce
+// or field
+func (c *fieldCipher) seal(value, ad string) (string, error) {
+	aead := c.keys[c.current]
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(ad))
+	return sealedPrefix + c.current + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts what seal produced
+func (c *fieldCipher) open(sealed, ad string) (string, error) {
+	id, data, ok := strings.Cut(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	aead := c.keys[id]
+	if !ok || aead == nil {
+		return "", errSealedField
+	}
+	b, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil || len(b) < aead.NonceSize() {
+		return "", errSealedField
+	}
+	value, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(ad))
+	if err != nil {
+		return "", errSealedField
+	}
+	return string(value), nil
+}
+
+// sealedField is a field of a Nonce WithEncryption encrypts
+type sealedField struct {
+	name  string
+	value *string
+}
+
+// sealedFields returns the fields of n WithEncryption encrypts
+func sealedFields(n *Nonce) []sealedField {
+	return []sealedField{
+		{"salt", &n.Salt},
+		{"payload", &n.Payload},
+		{"consumed_ip", &n.ConsumedIP},
+		{"consumed_user_agent", &n.ConsumedUserAgent},
+	}
+}
+
+// sealedAD is what the field name of the nonce with token is sealed with.
+// Salts are sealed with the token alone, as they were before other fields were.
+func sealedAD(name, token string) string {
+	if name == "salt" {
+		return token
+	}
+	return name + ":" + token
+}
+
+// sealField returns value sealed as the field name of the nonce with token,
+// or value itself if it is empty, already sealed, or WithEncryption isn't set
+func (c config) sealField(name, value, token string) (string, error) {
+	if c.cipher == nil || value == "" || strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	return c.cipher.seal(value, sealedAD(name, token))
+}
+
+// seal returns n as it should be stored, with its fields encrypted under WithEncryption
+func (c config) seal(n Nonce) (Nonce, error) {
+	for _, f := range sealedFields(&n) {
+		v, err := c.sealField(f.name, *f.value, n.Token)
+		if err != nil {
+			return Nonce{}, err
+		}
+		*f.value = v
+	}
+	return n, nil
+}
+
+// sealMeta returns meta as it should be stored on the nonce with token when
+// a backend writes ConsumedIP and ConsumedUserAgent itself
+func (c config) sealMeta(meta ConsumeMeta, token string) (ConsumeMeta, error) {
+	ip, err := c.sealField("consumed_ip", meta.IP, token)
+	if err != nil {
+		return ConsumeMeta{}, err
+	}
+	ua, err := c.sealField("consumed_user_agent", meta.UserAgent, token)
+	if err != nil {
+		return ConsumeMeta{}, err
+	}
+	meta.IP, meta.UserAgent = ip, ua
+	return meta, nil
+}
+
+// open returns n as read from the store with its fields decrypted
+func (c config) open(n Nonce) Nonce {
+	if c.cipher == nil {
+		return n
+	}
+	for _, f := range sealedFields(&n) {
+		if !strings.HasPrefix(*f.value, sealedPrefix) {
+			continue
+		}
+		v, err := c.cipher.open(*f.value, sealedAD(f.name, n.Token))
+		if err != nil {
+			c.logger.Printf("nonce: error decrypting %s of nonce %s: %v", f.name, n.ID, err)
+			continue
+		}
+		*f.value = v
+	}
+	return n
+}
+
+// marshalNonce is MarshalBinary for the key-value stores, sealing n first
+func (c config) marshalNonce(n Nonce) ([]byte, error) {
+	n, err := c.seal(n)
+	if err != nil {
+		return nil, err
+	}
+	return n.MarshalBinary()
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestEncryptionSnapshot(t *testing.T) {
+	key := [32]byte{3}
+	s := NewInMemoryService(WithEncryption(key))
+	defer s.Shutdown()
+
+	n, err := s.(Payloader).NewWithPayload("login", uuid.NewV4(), time.Minute, "new@example.com")
+	if err != nil {
+		t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+	}
+	var buf bytes.Buffer
+	err = s.(Snapshotter).Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("Expected Snapshot to succeed. Instead got: %v", err)
+	}
+	if strings.Contains(buf.String(), n.Salt) || strings.Contains(buf.String(), n.Payload) {
+		t.Fatalf("Expected the snapshot to hold the salt and payload encrypted. Instead got: %s", buf.String())
+	}
+
+	restored := NewInMemoryService(WithEncryption(key))
+	defer restored.Shutdown()
+	err = restored.(Snapshotter).Restore(&buf)
+	if err != nil {
+		t.Fatalf("Expected Restore to succeed. Instead got: %v", err)
+	}
+	got, err := restored.(Inspector).GetByID(n.ID)
+	if err != nil || got.Salt != n.Salt || got.Payload != n.Payload {
+		t.Errorf("Expected Restore to decrypt the salt and payload. Instead got: %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// UserDeleter is implemented by Services that can erase everything stored
+// about a user
+type UserDeleter interface {
+	// DeleteAllForUser deletes every nonce uid has in the Service's namespace,
+	// outstanding, used, kept by WithRetention or tombstoned by sqlx.WithSoftDelete,
+	// and returns how many, e.g. as evidence for a data subject's erasure
+	// request. It is audited. It returns ErrUserRequired for uuid.Nil.
+	DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error)
+}
+
+// deleteAllForUser checks uid, runs del and audits it
+func (c config) deleteAllForUser(uid uuid.UUID, del func() (int64, error)) (int64, error) {
+	if uid == uuid.Nil {
+		return 0, ErrUserRequired
+	}
+	deleted, err := del()
+	c.audit(AuditEvent{
+		Method:   "DeleteAllForUser",
+		Filter:   Filter{UserID: uid},
+		Affected: int(deleted),
+		Err:      err,
+	})
+	return deleted, err
+}
+
+// userNonces lists every nonce uid has in l
+func userNonces(ctx context.Context, l Lister, uid uuid.UUID) ([]Nonce, error) {
+	var nonces []Nonce
+	err := l.List(ctx, Filter{UserID: uid}, func(n Nonce) error {
+		nonces = append(nonces, n)
+		return nil
+	})
+	return nonces, err
+}
+
+func (s *nonceInMemoryService) DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error) {
+	return s.cfg.deleteAllForUser(uid, func() (int64, error) {
+		nonces, err := userNonces(ctx, s, uid)
+		if err != nil {
+			return 0, err
+		}
+		var deleted int64
+		for _, n := range nonces {
+			removed := s.store.remove(n.Token, func(cur Nonce) bool {
+				return cur.UserID == uid
+			})
+			if removed {
+				deleted++
+			}
+		}
+		return deleted, nil
+	})
+}
+
+// DeleteAllForUser deletes uid's nonces from every store
+func (s *routingService) DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error) {
+	var deleted int64
+	for _, store := range s.stores {
+		d, ok := store.(UserDeleter)
+		if !ok {
+			return deleted, ErrNotSupported
+		}
+		n, err := d.DeleteAllForUser(ctx, uid)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// DeleteAllForUser deletes uid's nonces from primary, then drops the copies
+// in cache, if it is a UserDeleter. It returns how many primary deleted.
+func (s *cachedService) DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error) {
+	d, ok := s.primary.(UserDeleter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	deleted, err := d.DeleteAllForUser(ctx, uid)
+	if err != nil {
+		return deleted, err
+	}
+	if c, ok := s.cache.(UserDeleter); ok {
+		_, err = c.DeleteAllForUser(ctx, uid)
+	}
+	return deleted, err
+}
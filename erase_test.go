@@ -0,0 +1,91 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestDeleteAllForUser(t *testing.T) {
+	clock := &testClock{}
+	auditor := &testAuditor{}
+	services := map[string]Service{
+		"sqlx":  openTestStore(t, "sqlx")(WithClock(clock), WithAuditor(auditor)),
+		"inmem": NewInMemoryService(WithClock(clock), WithAuditor(auditor)),
+	}
+	ctx := context.Background()
+
+	for name, s := range services {
+		t.Run(name, func(t *testing.T) {
+			defer s.Shutdown()
+			*auditor = nil
+			uid, other := uuid.NewV4(), uuid.NewV4()
+
+			// one expired, one used, one outstanding
+			_, err := s.New("expired", uid, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+			}
+			clock.Add(2 * time.Minute)
+			s.(Purger).PurgeExpired(ctx, 0)
+			used, _ := s.New("used", uid, time.Hour)
+			_, err = s.Consume(used.Token)
+			if err != nil {
+				t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+			}
+			s.New(tNonce.Action, uid, time.Hour)
+			kept, _ := s.New(tNonce.Action, other, time.Hour)
+
+			// the expired nonce was purged
+			want := int64(2)
+			deleted, err := s.(UserDeleter).DeleteAllForUser(ctx, uid)
+			if err != nil || deleted != want {
+				t.Fatalf("Expected %d nonces to be deleted. Instead got: %d, %v", want, deleted, err)
+			}
+			if got := countListed(t, s.(Lister), Filter{UserID: uid}); got != 0 {
+				t.Errorf("Expected no nonces left for the user. Instead got: %d", got)
+			}
+			err = s.Check(kept.Token, tNonce.Action, other)
+			if err != nil {
+				t.Errorf("Expected other users' nonces to be kept. Instead got the error: %v", err)
+			}
+
+			events := *auditor
+			if len(events) == 0 || events[len(events)-1].Method != "DeleteAllForUser" || events[len(events)-1].Affected != int(want) {
+				t.Errorf("Expected the deletion to be audited. Instead got: %+v", events)
+			}
+			_, err = s.(UserDeleter).DeleteAllForUser(ctx, uuid.Nil)
+			if err != ErrUserRequired {
+				t.Errorf("Expected %v for uuid.Nil. Instead got: %v", ErrUserRequired, err)
+			}
+		})
+	}
+}
+
+func countListed(t *testing.T, l Lister, f Filter) int {
+	n := 0
+	err := l.List(context.Background(), f, func(Nonce) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected to list nonces. Instead got the error: %v", err)
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// TokenError is returned when a stored nonce is rejected. It carries what the
+// nonce was issued for so callers can log why without parsing strings.
+// It matches its Reason with errors.Is, so compare with
+// errors.Is(err, ErrTokenExpired) rather than err == ErrTokenExpired.
+type TokenError struct {
+	// Reason is ErrInvalidToken, ErrTokenUsed, ErrTokenExpired, ErrUserRequired
+	// when a nonce bound to a user was checked with uuid.Nil, or
+	// ErrBindingMismatch when the checking request doesn't match its Binding
+	Reason error
+
+	// Action, UserID and ExpiresAt are the rejected nonce's
+	Action    string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+
+	// CheckedAt is when the nonce was rejected
+	CheckedAt time.Time
+}
+
+func (e *TokenError) Error() string {
+	if e.Reason == ErrTokenExpired {
+		return fmt.Sprintf("%v %v ago", e.Reason, e.ExpiredFor())
+	}
+	return e.Reason.Error()
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Reason
+}
+
+// ExpiredFor is how long before CheckedAt the nonce expired, or 0 if it hadn't
+func (e *TokenError) ExpiredFor() time.Duration {
+	if !e.ExpiresAt.Before(e.CheckedAt) {
+		return 0
+	}
+	return e.CheckedAt.Sub(e.ExpiresAt).Truncate(time.Second)
+}
+
+// NewTokenError returns a *TokenError rejecting n at t for reason
+func NewTokenError(reason error, n Nonce, t time.Time) error {
+	return &TokenError{
+		Reason:    reason,
+		Action:    n.Action,
+		UserID:    n.UserID,
+		ExpiresAt: n.ExpiresAt,
+		CheckedAt: t,
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenError(t *testing.T) {
+	clock := &testClock{}
+	s := NewInMemoryService(WithClock(clock))
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	clock.Add(time.Hour)
+
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("Expected ErrTokenExpired. Instead got: %v", err)
+	}
+	var te *TokenError
+	if !errors.As(err, &te) {
+		t.Fatalf("Expected a *TokenError. Instead got: %T", err)
+	}
+	if te.Action != tNonce.Action || te.UserID != tNonce.UserID || !te.ExpiresAt.Equal(n.ExpiresAt) {
+		t.Fatalf("Expected the error to describe the nonce. Instead got: %+v", te)
+	}
+	if d := te.ExpiredFor(); d < 58*time.Minute || d > time.Hour {
+		t.Fatalf("Expected the nonce to have expired about 59m ago. Instead got: %v", d)
+	}
+}
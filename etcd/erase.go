@@ -0,0 +1,47 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce/internal/kvstore"
+	uuid "github.com/satori/go.uuid"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func (s *service) DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error) {
+	return s.cfg.DeleteAllForUser(uid, func() (int64, error) {
+		nonces, err := kvstore.UserNonces(ctx, s, uid)
+		if err != nil {
+			return 0, err
+		}
+		var deleted int64
+		for _, n := range nonces {
+			// only count the nonces that weren't deleted since they were listed
+			resp, err := s.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.CreateRevision(s.tokenKey(n.Token)), ">", 0)).
+				Then(s.deleteOps(n)...).
+				Commit()
+			if err != nil {
+				return deleted, err
+			}
+			if resp.Succeeded {
+				deleted++
+			}
+		}
+		return deleted, nil
+	})
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/internal/kvstore"
+)
+
+func (s *service) ExtendExpiry(filter nonce.Filter, by time.Duration) (int, error) {
+	return s.cfg.ExtendExpiry(s, filter, by, func(n nonce.Nonce, expiresAt time.Time) (bool, error) {
+		_, err := s.update(context.Background(), n.Token, func(cur nonce.Nonce) (nonce.Nonce, error) {
+			if cur.IsValid == false || cur.IsUsed == true || !cur.ExpiresAt.Equal(n.ExpiresAt) {
+				return nonce.Nonce{}, kvstore.ErrNotExtended
+			}
+			cur.ExpiresAt = expiresAt
+			return cur, nil
+		})
+		if err == kvstore.ErrNotExtended || err == nonce.ErrTokenNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
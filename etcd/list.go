@@ -0,0 +1,59 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func (s *service) List(ctx context.Context, f nonce.Filter, fn func(nonce.Nonce) error) error {
+	t := s.cfg.Clock().Now()
+	f = s.cfg.Scope(f)
+
+	// page through the token keys so every chunk resumes after the last one
+	key := s.tokenKey("")
+	end := clientv3.GetPrefixRangeEnd(key)
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		resp, err := s.client.Get(ctx, key, clientv3.WithRange(end), clientv3.WithLimit(int64(s.cfg.ListChunkSize())))
+		if err != nil {
+			return err
+		}
+		for _, kv := range resp.Kvs {
+			n, err := s.decodeNonce(kv)
+			if err != nil {
+				return err
+			}
+			if !f.Matches(n, t) {
+				continue
+			}
+			err = fn(n)
+			if err != nil {
+				return err
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return nil
+		}
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+}
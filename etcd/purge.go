@@ -0,0 +1,54 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func (s *service) PurgeExpired(ctx context.Context, limit int) (int64, error) {
+	return s.cfg.PurgeExpired(ctx, s, limit, func(batch []nonce.Nonce, t time.Time) (int64, error) {
+		var purged int64
+		for _, n := range batch {
+			// expires_at is checked again in case the nonce was renewed after it was listed
+			cur, kv, err := s.get(ctx, n.Token)
+			if err == nonce.ErrTokenNotFound {
+				continue
+			} else if err != nil {
+				return purged, err
+			}
+			if !cur.ExpiresAt.Before(t) || s.cfg.Retained(cur, t) {
+				continue
+			}
+
+			resp, err := s.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.ModRevision(s.tokenKey(n.Token)), "=", kv.ModRevision)).
+				Then(s.deleteOps(cur)...).
+				Commit()
+			if err != nil {
+				return purged, err
+			}
+			if resp.Succeeded {
+				purged++
+				s.cfg.ExpiredRemoved(cur)
+			}
+		}
+		return purged, nil
+	})
+}
@@ -0,0 +1,600 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package etcd stores nonces in etcd, with leases expiring them.
+package etcd
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/internal/kvstore"
+	uuid "github.com/satori/go.uuid"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Each nonce is stored under three keys sharing one lease, so etcd removes all
+// of them together once the nonce expires:
+//
+//	<prefix>token/<token>                  the nonce, as MarshalBinary
+//	<prefix>id/<id>                        its token
+//	<prefix>user/<user id>/<action>/<token> empty; finds a user's nonces for an action
+//
+// The action is path escaped so it can't contain a slash. A WithNamespace
+// namespace adds ns/<namespace>/ to the prefix, so each namespace has its own
+// keys.
+
+type service struct {
+	client *clientv3.Client
+	cfg    *nonce.Backend
+	prefix string
+}
+
+// NewService creates an Nonce Service that stores nonces in etcd under the
+// WithKeyPrefix prefix. Each nonce's keys share a lease that runs out at ExpiresAt,
+// so etcd removes expired nonces and there is no cleanup goroutine. Consumes
+// are compare-and-swaps on the nonce's key, so only one caller can win.
+func NewService(client *clientv3.Client, opts ...nonce.Option) nonce.Service {
+	cfg := nonce.NewBackend(opts...)
+	s := &service{
+		client: client,
+		cfg:    cfg,
+		prefix: cfg.KeyPrefix(),
+	}
+	return s.cfg.Wrap(s)
+}
+
+func (s *service) tokenKey(token string) string {
+	return s.prefix + "token/" + token
+}
+
+func (s *service) idKey(id uuid.UUID) string {
+	return s.prefix + "id/" + id.String()
+}
+
+// userKey is the prefix of the index keys for uid's nonces for action
+func (s *service) userKey(action string, uid uuid.UUID) string {
+	return s.prefix + "user/" + uid.String() + "/" + url.PathEscape(action) + "/"
+}
+
+func (s *service) New(action string, uid uuid.UUID, expiresIn time.Duration) (nonce.Nonce, error) {
+	return s.NewBound(action, uid, expiresIn, nonce.Binding{})
+}
+
+func (s *service) NewBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, b, "")
+}
+
+func (s *service) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, nonce.Binding{}, payload)
+}
+
+func (s *service) newBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding, payload string) (nonce.Nonce, error) {
+	n, err := s.cfg.NewBoundNonce(action, uid, expiresIn, b, payload)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n.ID = s.cfg.NewID()
+
+	// Save nonce
+	ctx := context.Background()
+	err = s.create(ctx, n)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// Invalidate existing tokens for same user & action
+	others, err := s.invalidateOthers(ctx, n)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Created(n)
+	s.cfg.Invalidated(others...)
+
+	// return new nonce
+	return s.cfg.Issue(n), nil
+}
+
+func (s *service) Check(token, action string, uid uuid.UUID) error {
+	return s.CheckBound(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckBound(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) error {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return err
+	}
+
+	// get Nonce data from etcd
+	n, _, err := s.get(context.Background(), token)
+	if err != nil {
+		return err
+	}
+
+	err = s.cfg.CheckBound(n, action, uid, meta, s.cfg.Clock().Now())
+	return err
+}
+
+func (s *service) Consume(token string) (nonce.Nonce, error) {
+	return s.ConsumeWithMeta(token, nonce.ConsumeMeta{})
+}
+
+func (s *service) ConsumeWithMeta(token string, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.update(context.Background(), token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		// make sure token hasn't been used
+		if n.IsUsed == true {
+			return nonce.Nonce{}, nonce.ErrTokenUsed
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = s.cfg.Clock().Now().Unix()
+		n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+		return n, nil
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) CheckThenConsume(token, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	return s.CheckThenConsumeWithMeta(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.update(context.Background(), token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		t := s.cfg.Clock().Now()
+		err := s.cfg.CheckBound(n, action, uid, meta, t)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = t.Unix()
+		n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+		return n, nil
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	ctx := context.Background()
+	token, err := s.tokenFor(ctx, id)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.update(ctx, token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		if n.ID != id {
+			return nonce.Nonce{}, nonce.ErrTokenNotFound
+		}
+		t := s.cfg.Clock().Now()
+		err := s.cfg.CheckNonce(n, action, uid, t)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = t.Unix()
+		return n, nil
+	})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Consumed(n)
+
+	return n, nil
+}
+
+func (s *service) Get(action string, uid uuid.UUID) (nonce.Nonce, error) {
+	if uid == uuid.Nil {
+		return nonce.Nonce{}, nonce.ErrUserRequired
+	}
+	nonces, err := s.forUser(context.Background(), action, uid)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	t := s.cfg.Clock().Now()
+	var newestN nonce.Nonce
+	found := false
+	for _, n := range nonces {
+		if !s.cfg.Usable(n, t) {
+			continue
+		}
+		if !found || newestN.CreatedAt < n.CreatedAt {
+			newestN = n
+			found = true
+		}
+	}
+
+	if !found {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+
+	return newestN, nil
+}
+
+func (s *service) Renew(token string, extendBy time.Duration) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// the compare-and-swap in update fails if a Consume slips in between
+	return s.update(context.Background(), token, func(n nonce.Nonce) (nonce.Nonce, error) {
+		return s.cfg.RenewNonce(n, extendBy, s.cfg.Clock().Now())
+	})
+}
+
+func (s *service) AwaitConsumption(ctx context.Context, id uuid.UUID) (nonce.Nonce, error) {
+	return s.cfg.Await(ctx, id, s.getNonceByID)
+}
+
+func (s *service) PutNonce(n nonce.Nonce) (nonce.Nonce, error) {
+	n, err := s.cfg.FillNonce(n, s.cfg.Clock().Now())
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if n.ID == uuid.Nil {
+		n.ID = s.cfg.NewID()
+	}
+
+	// replace any existing nonce with the same token
+	ctx := context.Background()
+	for {
+		old, kv, err := s.get(ctx, n.Token)
+		if err != nil && err != nonce.ErrTokenNotFound {
+			return nonce.Nonce{}, err
+		}
+		lease, err := s.grant(ctx, n)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		ops, err := s.putOps(n, lease)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+
+		cmp := clientv3.Compare(clientv3.CreateRevision(s.tokenKey(n.Token)), "=", 0)
+		if kv != nil {
+			cmp = clientv3.Compare(clientv3.ModRevision(s.tokenKey(n.Token)), "=", kv.ModRevision)
+			ops = append(s.staleOps(old, n), ops...)
+		}
+		resp, err := s.client.Txn(ctx).If(cmp).Then(ops...).Commit()
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		if resp.Succeeded {
+			if kv != nil && kv.Lease != 0 {
+				s.client.Revoke(ctx, clientv3.LeaseID(kv.Lease))
+			}
+			return s.cfg.Issue(n), nil
+		}
+		// someone else wrote the token since we read it; try again
+		s.client.Revoke(ctx, lease)
+	}
+}
+
+// Shutdown does nothing; etcd removes nonces when their leases expire
+func (s *service) Shutdown() {}
+
+// commands lists the operations each method issues, for the debug journal
+func (s *service) Commands(method string) []string {
+	switch method {
+	case "New", "NewBound", "NewWithPayload":
+		return []string{"lease grant", "txn put token, id, user", "get user prefix", "get token", "txn if mod_revision put token"}
+	case "Check", "CheckBound", "GetByToken":
+		return []string{"get token"}
+	case "Consume", "ConsumeWithMeta", "CheckThenConsume", "CheckThenConsumeWithMeta", "Renew":
+		return []string{"get token", "txn if mod_revision put token"}
+	case "ConsumeByID":
+		return []string{"get id", "get token", "txn if mod_revision put token"}
+	case "AwaitConsumption", "GetByID":
+		return []string{"get id", "get token"}
+	case "Get":
+		return []string{"get user prefix", "txn get token"}
+	case "PutNonce":
+		return []string{"get token", "lease grant", "txn if mod_revision delete old, put token, id, user"}
+	}
+	return nil
+}
+
+// grant creates the lease n's keys are attached to
+func (s *service) grant(ctx context.Context, n nonce.Nonce) (clientv3.LeaseID, error) {
+	ttl := int64(math.Ceil(s.cfg.RemoveAt(n).Sub(s.cfg.Clock().Now()).Seconds()))
+	if ttl < 1 {
+		ttl = 1
+	}
+	resp, err := s.client.Grant(ctx, ttl)
+	if err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+// putOps writes every key for n on lease
+func (s *service) putOps(n nonce.Nonce, lease clientv3.LeaseID) ([]clientv3.Op, error) {
+	b, err := s.cfg.MarshalNonce(n)
+	if err != nil {
+		return nil, err
+	}
+	ops := []clientv3.Op{
+		clientv3.OpPut(s.tokenKey(n.Token), string(b), clientv3.WithLease(lease)),
+		clientv3.OpPut(s.idKey(n.ID), n.Token, clientv3.WithLease(lease)),
+	}
+	// anonymous nonces are never found by user
+	if n.UserID != uuid.Nil {
+		ops = append(ops, clientv3.OpPut(s.userKey(n.Action, n.UserID)+n.Token, "", clientv3.WithLease(lease)))
+	}
+	return ops, nil
+}
+
+// deleteOps removes every key for n
+func (s *service) deleteOps(n nonce.Nonce) []clientv3.Op {
+	return []clientv3.Op{
+		clientv3.OpDelete(s.tokenKey(n.Token)),
+		clientv3.OpDelete(s.idKey(n.ID)),
+		clientv3.OpDelete(s.userKey(n.Action, n.UserID) + n.Token),
+	}
+}
+
+// staleOps removes the keys of old that n, stored under the same token, doesn't
+// overwrite. etcd rejects a txn that writes the same key twice.
+func (s *service) staleOps(old, n nonce.Nonce) []clientv3.Op {
+	var ops []clientv3.Op
+	if old.ID != n.ID {
+		ops = append(ops, clientv3.OpDelete(s.idKey(old.ID)))
+	}
+	if old.Action != n.Action || old.UserID != n.UserID {
+		ops = append(ops, clientv3.OpDelete(s.userKey(old.Action, old.UserID)+old.Token))
+	}
+	return ops
+}
+
+// create stores a new nonce on a lease of its own
+func (s *service) create(ctx context.Context, n nonce.Nonce) error {
+	lease, err := s.grant(ctx, n)
+	if err != nil {
+		return err
+	}
+	ops, err := s.putOps(n, lease)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+// decodeNonce reads the nonce stored in kv
+func (s *service) decodeNonce(kv *mvccpb.KeyValue) (nonce.Nonce, error) {
+	n := nonce.Nonce{}
+	err := n.UnmarshalBinary(kv.Value)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n.ExpiresAt = n.ExpiresAt.In(time.Local)
+	return s.cfg.Open(n), nil
+}
+
+// get returns the nonce stored for token and the key holding it
+func (s *service) get(ctx context.Context, token string) (nonce.Nonce, *mvccpb.KeyValue, error) {
+	resp, err := s.client.Get(ctx, s.tokenKey(token))
+	if err != nil {
+		return nonce.Nonce{}, nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nonce.Nonce{}, nil, nonce.ErrTokenNotFound
+	}
+
+	kv := resp.Kvs[0]
+	n, err := s.decodeNonce(kv)
+	if err != nil {
+		return nonce.Nonce{}, nil, err
+	}
+	return n, kv, nil
+}
+
+// tokenFor returns the token of the nonce with id
+func (s *service) tokenFor(ctx context.Context, id uuid.UUID) (string, error) {
+	resp, err := s.client.Get(ctx, s.idKey(id))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", nonce.ErrTokenNotFound
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+// getNonceByID gets the Nonce with id from etcd
+func (s *service) getNonceByID(id uuid.UUID) (nonce.Nonce, error) {
+	ctx := context.Background()
+	token, err := s.tokenFor(ctx, id)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n, _, err := s.get(ctx, token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if n.ID != id {
+		// the token was replaced by PutNonce after we looked up the id
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+	return n, nil
+}
+
+// forUser returns every nonce stored for action and uid
+func (s *service) forUser(ctx context.Context, action string, uid uuid.UUID) ([]nonce.Nonce, error) {
+	prefix := s.userKey(action, uid)
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil || len(resp.Kvs) == 0 {
+		return nil, err
+	}
+
+	// read all the nonces in one round trip
+	ops := make([]clientv3.Op, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		ops[i] = clientv3.OpGet(s.tokenKey(strings.TrimPrefix(string(kv.Key), prefix)))
+	}
+	txn, err := s.client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	nonces := make([]nonce.Nonce, 0, len(ops))
+	for _, r := range txn.Responses {
+		for _, kv := range r.GetResponseRange().Kvs {
+			n, err := s.decodeNonce(kv)
+			if err != nil {
+				return nil, err
+			}
+			nonces = append(nonces, n)
+		}
+	}
+	return nonces, nil
+}
+
+// update replaces the nonce stored for token with fn's result, retrying when
+// another writer changes it in between. An error from fn is returned as is.
+// Changing when the nonce should be removed moves its keys onto a new lease.
+func (s *service) update(ctx context.Context, token string, fn func(nonce.Nonce) (nonce.Nonce, error)) (nonce.Nonce, error) {
+	for {
+		cur, kv, err := s.get(ctx, token)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		n, err := fn(cur)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+
+		var lease clientv3.LeaseID
+		var ops []clientv3.Op
+		if s.cfg.RemoveAt(n).Equal(s.cfg.RemoveAt(cur)) {
+			b, err := s.cfg.MarshalNonce(n)
+			if err != nil {
+				return nonce.Nonce{}, err
+			}
+			ops = []clientv3.Op{clientv3.OpPut(s.tokenKey(token), string(b), clientv3.WithIgnoreLease())}
+		} else {
+			lease, err = s.grant(ctx, n)
+			if err != nil {
+				return nonce.Nonce{}, err
+			}
+			ops, err = s.putOps(n, lease)
+			if err != nil {
+				return nonce.Nonce{}, err
+			}
+		}
+
+		resp, err := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(s.tokenKey(token)), "=", kv.ModRevision)).
+			Then(ops...).
+			Commit()
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		if resp.Succeeded {
+			if lease != 0 && kv.Lease != 0 {
+				// nothing is attached to the old lease any more
+				s.client.Revoke(ctx, clientv3.LeaseID(kv.Lease))
+			}
+			return n, nil
+		}
+		// lost a race; re-read and try again
+		if lease != 0 {
+			s.client.Revoke(ctx, lease)
+		}
+	}
+}
+
+// invalidateOthers marks every other valid nonce for n's user and action invalid.
+// It returns the ones that were unused, as they are now.
+func (s *service) invalidateOthers(ctx context.Context, n nonce.Nonce) ([]nonce.Nonce, error) {
+	if n.UserID == uuid.Nil {
+		return nil, nil
+	}
+	prefix := s.userKey(n.Action, n.UserID)
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+
+	var others []nonce.Nonce
+	for _, kv := range resp.Kvs {
+		token := strings.TrimPrefix(string(kv.Key), prefix)
+		if token == n.Token {
+			continue
+		}
+		o, err := s.update(ctx, token, func(o nonce.Nonce) (nonce.Nonce, error) {
+			if o.IsValid == false || o.ID == n.ID {
+				return nonce.Nonce{}, kvstore.ErrUnchanged
+			}
+			o.IsValid = false
+			return o, nil
+		})
+		if err == kvstore.ErrUnchanged || err == nonce.ErrTokenNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !o.IsUsed {
+			others = append(others, o)
+		}
+	}
+	return others, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package events defines the JSON schema nonce lifecycle events are published
+// with, so every publisher (webhook, events/nats, events/kafka) emits the same
+// messages and consumers can rely on them.
+//
+// Fields are only ever added. A change that would break consumers bumps Version.
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Version is the schema version every Event carries
+const Version = 1
+
+// Event types
+const (
+	Created        = "nonce.created"
+	Consumed       = "nonce.consumed"
+	Invalidated    = "nonce.invalidated"
+	ExpiredRemoved = "nonce.expired_removed"
+)
+
+// Event describes one change to a nonce. Tokens and salts are never included,
+// so a leaked event can't be used to redeem the nonce.
+type Event struct {
+	Version           int        `json:"version"`
+	Type              string     `json:"type"`
+	Time              time.Time  `json:"time"`
+	ID                uuid.UUID  `json:"id"`
+	UserID            uuid.UUID  `json:"user_id"`
+	Action            string     `json:"action"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	ConsumedAt        *time.Time `json:"consumed_at,omitempty"`
+	ConsumedIP        string     `json:"consumed_ip,omitempty"`
+	ConsumedUserAgent string     `json:"consumed_user_agent,omitempty"`
+}
+
+// New returns the Event of type typ for n, happening at t
+func New(typ string, n nonce.Nonce, t time.Time) Event {
+	e := Event{
+		Version:           Version,
+		Type:              typ,
+		Time:              t.UTC(),
+		ID:                n.ID,
+		UserID:            n.UserID,
+		Action:            n.Action,
+		ExpiresAt:         n.ExpiresAt.UTC(),
+		ConsumedIP:        n.ConsumedIP,
+		ConsumedUserAgent: n.ConsumedUserAgent,
+	}
+	if n.ConsumedAt != 0 {
+		c := time.Unix(n.ConsumedAt, 0).UTC()
+		e.ConsumedAt = &c
+	}
+	return e
+}
+
+// Encode returns e as JSON
+func (e Event) Encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Hooks returns nonce.Hooks that pass an Event for every lifecycle change to
+// publish. A nil clock uses nonce.SystemClock.
+func Hooks(clock nonce.Clock, publish func(Event)) nonce.Hooks {
+	if clock == nil {
+		clock = nonce.SystemClock
+	}
+	hook := func(typ string) func(nonce.Nonce) {
+		return func(n nonce.Nonce) {
+			publish(New(typ, n, clock.Now()))
+		}
+	}
+	return nonce.Hooks{
+		OnCreated:        hook(Created),
+		OnConsumed:       hook(Consumed),
+		OnInvalidated:    hook(Invalidated),
+		OnExpiredRemoved: hook(ExpiredRemoved),
+	}
+}
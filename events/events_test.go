@@ -0,0 +1,71 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestEventSchema(t *testing.T) {
+	id := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	uid := uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	at := time.Date(2017, 2, 6, 16, 0, 0, 0, time.UTC)
+	n := nonce.Nonce{
+		ID:         id,
+		UserID:     uid,
+		Token:      "secret-token",
+		Salt:       "secret-salt",
+		Action:     "reset",
+		ExpiresAt:  at.Add(time.Hour),
+		ConsumedAt: at.Unix(),
+		ConsumedIP: "10.0.0.1",
+	}
+
+	b, err := New(Consumed, n, at).Encode()
+	if err != nil {
+		t.Fatalf("Expected Encode to succeed. Instead got: %v", err)
+	}
+	want := `{"version":1,"type":"nonce.consumed","time":"2017-02-06T16:00:00Z",` +
+		`"id":"6ba7b810-9dad-11d1-80b4-00c04fd430c8","user_id":"6ba7b811-9dad-11d1-80b4-00c04fd430c8",` +
+		`"action":"reset","expires_at":"2017-02-06T17:00:00Z","consumed_at":"2017-02-06T16:00:00Z",` +
+		`"consumed_ip":"10.0.0.1"}`
+	if string(b) != want {
+		t.Fatalf("Expected the stable schema:\n%s\nInstead got:\n%s", want, b)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Fatalf("Expected the token and salt to be left out. Instead got: %s", b)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	got := make(chan Event, 4)
+	s := nonce.NewInMemoryService(nonce.WithHooks(Hooks(nil, func(e Event) { got <- e })))
+	defer s.Shutdown()
+
+	n, _ := s.New("events", uuid.NewV4(), time.Minute)
+	select {
+	case e := <-got:
+		if e.Type != Created || e.ID != n.ID || e.Version != Version {
+			t.Fatalf("Expected a created event. Instead got: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a created event to be published")
+	}
+}
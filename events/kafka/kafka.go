@@ -0,0 +1,76 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kafka publishes nonce lifecycle events to a Kafka topic as
+// events.Event JSON, keyed by user ID so each user's events stay in order
+// on one partition. Adapt your client's producer to Producer, e.g. with
+// github.com/segmentio/kafka-go:
+//
+//	w := &kafka.Writer{Addr: kafka.TCP("localhost:9092")}
+//	p := noncekafka.New(func(ctx context.Context, topic string, key, value []byte) error {
+//		return w.WriteMessages(ctx, kafka.Message{Topic: topic, Key: key, Value: value})
+//	}, "nonce-events", logger)
+package kafka
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/events"
+)
+
+// Timeout bounds how long publishing one event may take
+var Timeout = 10 * time.Second
+
+// Producer writes one message to topic
+type Producer func(ctx context.Context, topic string, key, value []byte) error
+
+// Publisher sends events to one topic
+type Publisher struct {
+	produce Producer
+	topic   string
+	logger  nonce.Logger
+}
+
+// New returns a Publisher writing to topic with produce. Events that can't be
+// published are reported to logger; a nil logger discards them.
+func New(produce Producer, topic string, logger nonce.Logger) *Publisher {
+	return &Publisher{
+		produce: produce,
+		topic:   topic,
+		logger:  logger,
+	}
+}
+
+// Hooks returns nonce.Hooks that publish every lifecycle event
+func (p *Publisher) Hooks() nonce.Hooks {
+	return events.Hooks(nil, func(e events.Event) {
+		ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+		defer cancel()
+		err := p.Publish(ctx, e)
+		if err != nil && p.logger != nil {
+			p.logger.Printf("kafka: publishing %s event %v: %v", e.Type, e.ID, err)
+		}
+	})
+}
+
+// Publish writes e to the topic keyed by its user ID
+func (p *Publisher) Publish(ctx context.Context, e events.Event) error {
+	b, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	return p.produce(ctx, p.topic, []byte(e.UserID.String()), b)
+}
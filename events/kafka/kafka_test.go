@@ -0,0 +1,40 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bryanjeal/go-nonce/events"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPublishKeysByUser(t *testing.T) {
+	var topic, key string
+	p := New(func(ctx context.Context, t string, k, v []byte) error {
+		topic, key = t, string(k)
+		return nil
+	}, "nonce-events", nil)
+
+	uid := uuid.NewV4()
+	err := p.Publish(context.Background(), events.Event{Type: events.Created, UserID: uid})
+	if err != nil {
+		t.Fatalf("Expected Publish to succeed. Instead got: %v", err)
+	}
+	if topic != "nonce-events" || key != uid.String() {
+		t.Fatalf("Expected the event on nonce-events keyed by user. Instead got: %s, %s", topic, key)
+	}
+}
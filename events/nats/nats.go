@@ -0,0 +1,68 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nats publishes nonce lifecycle events to a NATS subject as
+// events.Event JSON:
+//
+//	nc, _ := nats.Connect(nats.DefaultURL) // github.com/nats-io/nats.go
+//	p := noncenats.New(nc, "nonce.events", logger)
+//	s := noncesqlx.NewService(db, nonce.WithHooks(p.Hooks()))
+package nats
+
+import (
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/events"
+)
+
+// Conn is the part of a NATS connection the Publisher uses. *nats.Conn
+// implements it, so this package doesn't tie you to a client version.
+type Conn interface {
+	Publish(subject string, data []byte) error
+}
+
+// Publisher sends events to one subject
+type Publisher struct {
+	conn    Conn
+	subject string
+	logger  nonce.Logger
+}
+
+// New returns a Publisher sending to subject on conn. Events that can't be
+// published are reported to logger; a nil logger discards them.
+func New(conn Conn, subject string, logger nonce.Logger) *Publisher {
+	return &Publisher{
+		conn:    conn,
+		subject: subject,
+		logger:  logger,
+	}
+}
+
+// Hooks returns nonce.Hooks that publish every lifecycle event
+func (p *Publisher) Hooks() nonce.Hooks {
+	return events.Hooks(nil, func(e events.Event) {
+		err := p.Publish(e)
+		if err != nil && p.logger != nil {
+			p.logger.Printf("nats: publishing %s event %v: %v", e.Type, e.ID, err)
+		}
+	})
+}
+
+// Publish sends e to the subject
+func (p *Publisher) Publish(e events.Event) error {
+	b, err := e.Encode()
+	if err != nil {
+		return err
+	}
+	return p.conn.Publish(p.subject, b)
+}
@@ -0,0 +1,49 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nats
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bryanjeal/go-nonce/events"
+	uuid "github.com/satori/go.uuid"
+)
+
+type fakeConn struct {
+	subject string
+	data    []byte
+}
+
+func (c *fakeConn) Publish(subject string, data []byte) error {
+	c.subject, c.data = subject, data
+	return nil
+}
+
+func TestPublish(t *testing.T) {
+	c := &fakeConn{}
+	p := New(c, "nonce.events", nil)
+	id := uuid.NewV4()
+	err := p.Publish(events.Event{Version: events.Version, Type: events.Consumed, ID: id})
+	if err != nil {
+		t.Fatalf("Expected Publish to succeed. Instead got: %v", err)
+	}
+
+	var e events.Event
+	json.Unmarshal(c.data, &e)
+	if c.subject != "nonce.events" || e.ID != id || e.Type != events.Consumed {
+		t.Fatalf("Expected the event on nonce.events. Instead got: %s %s", c.subject, c.data)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Export writes every nonce l lists to w, one JSON object per line, so they
+// can be copied into another store with Import, e.g. when moving from SQLite
+// to Postgres without invalidating outstanding links. Each line is what
+// JSONWithSalt produces:
+//
+//	{"id":"…","user_id":"…","token":"…","action":"reset","salt":"…",
+//	 "is_used":false,"is_valid":true,"created_at":"2016-01-02T15:04:05Z",
+//	 "expires_at":"2016-01-03T15:04:05Z","consumed_at":"…",...}
+//
+// with consumed_at, consumed_ip, consumed_user_agent, binding, parent_id and
+// namespace left out when empty. Salts are written decrypted, so treat the
+// output like a copy of the store. Tombstones aren't exported. It returns
+// how many nonces were written.
+func Export(ctx context.Context, l Lister, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	count := 0
+	err := l.List(ctx, Filter{}, func(n Nonce) error {
+		b, err := n.JSONWithSalt()
+		if err != nil {
+			return err
+		}
+		bw.Write(b)
+		err = bw.WriteByte('\n')
+		if err == nil {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// Import stores each nonce Export wrote to r with PutNonce, as it was
+// exported, in p's namespace. Token hashing and encryption are applied as p is
+// configured. It stops at the first line that can't be decoded or stored,
+// returning how many nonces were imported before it.
+func Import(ctx context.Context, p Putter, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	count := 0
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return count, err
+		}
+
+		var n Nonce
+		err = dec.Decode(&n)
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, fmt.Errorf("nonce: import line %d: %w", count+1, err)
+		}
+		if n.Token == "" || n.ID == uuid.Nil || n.Salt == "" {
+			return count, fmt.Errorf("nonce: import line %d has no token, id or salt", count+1)
+		}
+		_, err = p.PutNonce(n)
+		if err != nil {
+			return count, fmt.Errorf("nonce: import line %d: %w", count+1, err)
+		}
+		count++
+	}
+}
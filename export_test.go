@@ -0,0 +1,69 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	newService := openTestStore(t, "sqlx")
+	from := newService(WithEncryption([32]byte{1, 2, 3}))
+	defer from.Shutdown()
+	ctx := context.Background()
+
+	live, err := from.New(tNonce.Action, tNonce.UserID, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	used, _ := from.New("used", tNonce.UserID, time.Hour)
+	_, err = from.Consume(used.Token)
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	exported, err := Export(ctx, from.(Lister), &buf)
+	if err != nil || exported != 2 {
+		t.Fatalf("Expected 2 nonces to be exported. Instead got: %d, %v", exported, err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Errorf("Expected one line per nonce. Instead got: %d", lines)
+	}
+
+	to := NewInMemoryService()
+	defer to.Shutdown()
+	imported, err := Import(ctx, to.(Putter), &buf)
+	if err != nil || imported != 2 {
+		t.Fatalf("Expected 2 nonces to be imported. Instead got: %d, %v", imported, err)
+	}
+	err = to.Check(live.Token, tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Errorf("Expected the outstanding nonce to check out after importing. Instead got the error: %v", err)
+	}
+	_, err = to.Consume(used.Token)
+	if err != ErrTokenUsed {
+		t.Errorf("Expected the used nonce to stay used. Instead got: %v", err)
+	}
+
+	imported, err = Import(ctx, to.(Putter), strings.NewReader(`{"token":"abc"}`+"\n"))
+	if err == nil || imported != 0 {
+		t.Errorf("Expected an incomplete line to fail the import. Instead got: %d, %v", imported, err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errNotExtended stops the in-memory store updating a nonce that changed since it was listed
+var errNotExtended = errors.New("nonce: not extended")
+
+// ExpiryExtender is implemented by Services that can extend many nonces at once
+type ExpiryExtender interface {
+	// ExtendExpiry pushes ExpiresAt forward by by for every valid, unused nonce
+	// matching filter, e.g. to give users more time after an outage stopped them
+	// finishing a flow. Expired nonces are revived unless filter.Outstanding is set.
+	// It returns how many nonces were extended and reports the change to the Auditor.
+	ExtendExpiry(filter Filter, by time.Duration) (int, error)
+}
+
+// extendExpiry lists the nonces matching f and calls extend with each one's new
+// expiry. extend reports whether it changed the nonce.
+func (c config) extendExpiry(l Lister, f Filter, by time.Duration, extend func(n Nonce, expiresAt time.Time) (bool, error)) (int, error) {
+	count := 0
+	err := l.List(context.Background(), f, func(n Nonce) error {
+		if n.IsValid == false || n.IsUsed == true {
+			return nil
+		}
+		ok, err := extend(n, n.ExpiresAt.Add(by).Truncate(time.Second))
+		if ok {
+			count++
+		}
+		return err
+	})
+
+	c.audit(AuditEvent{
+		Method:   "ExtendExpiry",
+		Filter:   f,
+		Detail:   fmt.Sprintf("extended by %s", by),
+		Affected: count,
+		Err:      err,
+	})
+	return count, err
+}
+
+func (s *nonceInMemoryService) ExtendExpiry(filter Filter, by time.Duration) (int, error) {
+	return s.cfg.extendExpiry(s, filter, by, func(n Nonce, expiresAt time.Time) (bool, error) {
+		_, err := s.store.update(n.Token, func(cur Nonce) (Nonce, error) {
+			if cur.IsValid == false || cur.IsUsed == true || !cur.ExpiresAt.Equal(n.ExpiresAt) {
+				return Nonce{}, errNotExtended
+			}
+			cur.ExpiresAt = expiresAt
+			return cur, nil
+		})
+		return err == nil, nil
+	})
+}
@@ -0,0 +1,251 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// FailoverRetryInterval is how long a failover Service waits after primary
+// fails before trying it again. It is read when the Service is created.
+var FailoverRetryInterval = 5 * time.Second
+
+// failoverService uses primary, falling back to secondary while primary is down
+type failoverService struct {
+	primary   Service
+	secondary Service
+	put       Putter
+	list      Lister
+	interval  time.Duration
+
+	mu sync.Mutex
+	// down is set from when a call to primary fails until one succeeds again
+	down bool
+	// since is when primary went down, retry when to try it again
+	since, retry time.Time
+	// err is what primary last failed with
+	err error
+}
+
+// NewFailoverService creates a Service that uses primary until a call fails
+// with an error that isn't one of the package's own, such as a lost database
+// connection, then makes that call and those after it on secondary, e.g. an
+// in-memory Service. Every FailoverRetryInterval it copies the nonces secondary
+// created since primary went down into primary, as they are now, and tries
+// primary again, going back to it once a call succeeds.
+//
+// While primary is down, nonces it stores can't be checked or consumed; a call
+// secondary answers with ErrTokenNotFound gets the error primary failed with
+// instead. The older nonces a New on secondary replaces stay valid in primary.
+// primary must be a Putter and secondary a Lister, or ErrNotPutter or
+// ErrNotLister is returned. Both are shut down with the returned Service.
+func NewFailoverService(primary, secondary Service) (Service, error) {
+	put, ok := primary.(Putter)
+	if !ok {
+		return nil, ErrNotPutter
+	}
+	list, ok := secondary.(Lister)
+	if !ok {
+		return nil, ErrNotLister
+	}
+	return &failoverService{
+		primary:   primary,
+		secondary: secondary,
+		put:       put,
+		list:      list,
+		interval:  FailoverRetryInterval,
+	}, nil
+}
+
+func (s *failoverService) New(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		return store.New(action, uid, expiresIn)
+	})
+}
+
+// NewWithPayload returns ErrNotSupported from a store that isn't a Payloader
+func (s *failoverService) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		p, ok := store.(Payloader)
+		if !ok {
+			return Nonce{}, ErrNotSupported
+		}
+		return p.NewWithPayload(action, uid, expiresIn, payload)
+	})
+}
+
+func (s *failoverService) Check(token, action string, uid uuid.UUID) error {
+	_, err := s.call(func(store Service) (Nonce, error) {
+		return Nonce{}, store.Check(token, action, uid)
+	})
+	return err
+}
+
+func (s *failoverService) Consume(token string) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		return store.Consume(token)
+	})
+}
+
+func (s *failoverService) CheckThenConsume(token, action string, uid uuid.UUID) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		return store.CheckThenConsume(token, action, uid)
+	})
+}
+
+func (s *failoverService) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		return store.ConsumeByID(id, action, uid)
+	})
+}
+
+func (s *failoverService) Get(action string, uid uuid.UUID) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		return store.Get(action, uid)
+	})
+}
+
+func (s *failoverService) Renew(token string, extendBy time.Duration) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		return store.Renew(token, extendBy)
+	})
+}
+
+// GetByID returns ErrNotSupported from a store that isn't an Inspector
+func (s *failoverService) GetByID(id uuid.UUID) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		in, ok := store.(Inspector)
+		if !ok {
+			return Nonce{}, ErrNotSupported
+		}
+		return in.GetByID(id)
+	})
+}
+
+// GetByToken returns ErrNotSupported from a store that isn't an Inspector
+func (s *failoverService) GetByToken(token string) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		in, ok := store.(Inspector)
+		if !ok {
+			return Nonce{}, ErrNotSupported
+		}
+		return in.GetByToken(token)
+	})
+}
+
+// AwaitConsumption returns ErrNotSupported from a store that isn't an Awaiter
+func (s *failoverService) AwaitConsumption(ctx context.Context, id uuid.UUID) (Nonce, error) {
+	return s.call(func(store Service) (Nonce, error) {
+		a, ok := store.(Awaiter)
+		if !ok {
+			return Nonce{}, ErrNotSupported
+		}
+		return a.AwaitConsumption(ctx, id)
+	})
+}
+
+// Shutdown shuts down primary and secondary
+func (s *failoverService) Shutdown() {
+	s.primary.Shutdown()
+	s.secondary.Shutdown()
+}
+
+// call runs fn on primary, or on secondary while primary is down
+func (s *failoverService) call(fn func(store Service) (Nonce, error)) (Nonce, error) {
+	if s.usePrimary() {
+		n, err := fn(s.primary)
+		if err == nil || isServiceError(err) {
+			s.recovered()
+			return n, err
+		}
+		s.failed(err)
+	}
+
+	n, err := fn(s.secondary)
+	if err == ErrTokenNotFound {
+		s.mu.Lock()
+		if s.err != nil {
+			err = s.err
+		}
+		s.mu.Unlock()
+	}
+	return n, err
+}
+
+// usePrimary reports whether the next call should go to primary. Once it is
+// time to try primary again, it first copies what secondary did into primary.
+func (s *failoverService) usePrimary() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.down {
+		return true
+	}
+	t := time.Now()
+	if t.Before(s.retry) {
+		return false
+	}
+
+	err := s.reconcile()
+	if err != nil {
+		s.err, s.retry = err, t.Add(s.interval)
+		return false
+	}
+	return true
+}
+
+// reconcile puts the nonces secondary created since primary went down into
+// primary. The caller must hold mu.
+func (s *failoverService) reconcile() error {
+	since := s.since.Unix()
+	var written []Nonce
+	err := s.list.List(context.Background(), Filter{}, func(n Nonce) error {
+		if n.CreatedAt >= since {
+			written = append(written, n)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, n := range written {
+		_, err = s.put.PutNonce(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failed marks primary down after a call to it failed with err
+func (s *failoverService) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := time.Now()
+	if !s.down {
+		s.down, s.since = true, t
+	}
+	s.err, s.retry = err, t.Add(s.interval)
+}
+
+// recovered marks primary up after a call to it succeeded
+func (s *failoverService) recovered() {
+	s.mu.Lock()
+	s.down, s.err = false, nil
+	s.mu.Unlock()
+}
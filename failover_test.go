@@ -0,0 +1,134 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var errUnreachable = errors.New("dial tcp: connection refused")
+
+// unreachableService fails every call with errUnreachable while down is set
+type unreachableService struct {
+	Service
+	down int32
+}
+
+func (s *unreachableService) err() error {
+	if atomic.LoadInt32(&s.down) == 1 {
+		return errUnreachable
+	}
+	return nil
+}
+
+func (s *unreachableService) New(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error) {
+	if err := s.err(); err != nil {
+		return Nonce{}, err
+	}
+	return s.Service.New(action, uid, expiresIn)
+}
+
+func (s *unreachableService) Check(token, action string, uid uuid.UUID) error {
+	if err := s.err(); err != nil {
+		return err
+	}
+	return s.Service.Check(token, action, uid)
+}
+
+func (s *unreachableService) CheckThenConsume(token, action string, uid uuid.UUID) (Nonce, error) {
+	if err := s.err(); err != nil {
+		return Nonce{}, err
+	}
+	return s.Service.CheckThenConsume(token, action, uid)
+}
+
+func (s *unreachableService) PutNonce(n Nonce) (Nonce, error) {
+	if err := s.err(); err != nil {
+		return Nonce{}, err
+	}
+	return s.Service.(Putter).PutNonce(n)
+}
+
+func TestFailover(t *testing.T) {
+	defer func(d time.Duration) { FailoverRetryInterval = d }(FailoverRetryInterval)
+	FailoverRetryInterval = 20 * time.Millisecond
+
+	primary := &unreachableService{Service: NewInMemoryService()}
+	s, err := NewFailoverService(primary, NewInMemoryService())
+	if err != nil {
+		t.Fatalf("Expected to create the failover Service. Instead got the error: %v", err)
+	}
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+
+	before, err := s.New("before", uid, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+
+	// while primary is down nonces are made and used on secondary
+	atomic.StoreInt32(&primary.down, 1)
+	during, err := s.New("during", uid, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected New to fail over. Instead got: %v", err)
+	}
+	err = s.Check(during.Token, "during", uid)
+	if err != nil {
+		t.Fatalf("Expected Check to use secondary. Instead got: %v", err)
+	}
+	consumed, err := s.New("consumed", uid, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected New to fail over. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume(consumed.Token, "consumed", uid)
+	if err != nil {
+		t.Fatalf("Expected CheckThenConsume to use secondary. Instead got: %v", err)
+	}
+
+	// nonces only primary has get primary's error, not ErrTokenNotFound
+	err = s.Check(before.Token, "before", uid)
+	if err != errUnreachable {
+		t.Fatalf("Expected primary's error. Instead got: %v", err)
+	}
+	_, err = s.Get("before", uid)
+	if err != errUnreachable {
+		t.Fatalf("Expected primary's error. Instead got: %v", err)
+	}
+
+	// once primary is back what secondary did is copied into it
+	atomic.StoreInt32(&primary.down, 0)
+	time.Sleep(2 * FailoverRetryInterval)
+	err = s.Check(before.Token, "before", uid)
+	if err != nil {
+		t.Fatalf("Expected primary to be used again. Instead got: %v", err)
+	}
+	err = primary.Check(during.Token, "during", uid)
+	if err != nil {
+		t.Fatalf("Expected the nonce made during the outage to be copied. Instead got: %v", err)
+	}
+	err = primary.Check(consumed.Token, "consumed", uid)
+	if !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("Expected the nonce consumed during the outage to be copied as used. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume(during.Token, "during", uid)
+	if err != nil {
+		t.Fatalf("Expected the copied nonce to be usable. Instead got: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+
+	"github.com/bryanjeal/go-helpers"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hasher produces the raw bytes of a token.
+// Tokens are the TokenEncoding of those bytes, so every token a Hasher
+// produces has the same length and checkToken can reject anything else.
+type Hasher interface {
+	// Sum returns the token bytes for input, which describes the nonce being created
+	Sum(input []byte) ([]byte, error)
+
+	// Size is the number of bytes Sum returns
+	Size() int
+}
+
+type hashHasher struct {
+	new  func() hash.Hash
+	size int
+}
+
+func (h hashHasher) Sum(input []byte) ([]byte, error) {
+	hasher := h.new()
+	hasher.Write(input)
+	return hasher.Sum(nil), nil
+}
+
+func (h hashHasher) Size() int {
+	return h.size
+}
+
+// Built in Hashers. They hash the action, user, creation time and salt of the
+// nonce, so only the salt is unpredictable; prefer DefaultHasher for new tokens.
+var (
+	SHA512     Hasher = hashHasher{sha512.New, sha512.Size}
+	SHA256     Hasher = hashHasher{sha256.New, sha256.Size}
+	BLAKE2b512 Hasher = hashHasher{newBLAKE2b512, blake2b.Size}
+	BLAKE2b256 Hasher = hashHasher{newBLAKE2b256, blake2b.Size256}
+)
+
+func newBLAKE2b512() hash.Hash {
+	// New512 only fails for keys longer than 64 bytes
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+func newBLAKE2b256() hash.Hash {
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+// DefaultHasher draws every token straight from crypto/rand.
+// 64 bytes keeps tokens at the 88 characters SHA512 produced, so tokens issued
+// before the switch still validate and the SQL schema is unchanged.
+var DefaultHasher = RandomBytes(64)
+
+type randomHasher struct {
+	size int
+}
+
+// RandomBytes returns a Hasher that ignores its input and returns n bytes from crypto/rand
+func RandomBytes(n int) Hasher {
+	return randomHasher{size: n}
+}
+
+func (h randomHasher) Sum(input []byte) ([]byte, error) {
+	return helpers.Crypto.GenerateRandomKey(h.size)
+}
+
+func (h randomHasher) Size() int {
+	return h.size
+}
+
+// WithHasher sets the Hasher used to create tokens.
+// NOTE: the SQL schema stores tokens as VARCHAR(128); widen it and Limits.Token for Hashers bigger than 93 bytes
+func WithHasher(h Hasher) Option {
+	return func(cfg *config) {
+		if h != nil {
+			cfg.hasher = h
+		}
+	}
+}
+
+// WithLegacyHashers sets the Hashers that produced tokens which may still be stored.
+// Their tokens keep passing validation after WithHasher switches to a Hasher of a
+// different size. The default is SHA512, the Hasher used before DefaultHasher.
+func WithLegacyHashers(hs ...Hasher) Option {
+	return func(cfg *config) {
+		cfg.legacyHashers = append([]Hasher(nil), hs...)
+	}
+}
+
+// validTokenLen reports whether n is the length of token bodies from the
+// configured Hasher or from one of the legacy Hashers, in the configured encoding
+func (c config) validTokenLen(n int) bool {
+	if n == c.encoding.EncodedLen(c.hasher.Size()) {
+		return true
+	}
+	for _, h := range c.legacyHashers {
+		if n == c.encoding.EncodedLen(h.Size()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashers(t *testing.T) {
+	hashers := map[string]Hasher{
+		"SHA512":      SHA512,
+		"SHA256":      SHA256,
+		"BLAKE2b512":  BLAKE2b512,
+		"BLAKE2b256":  BLAKE2b256,
+		"RandomBytes": RandomBytes(24),
+	}
+
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			s := NewInMemoryService(WithHasher(h), WithLegacyHashers())
+			defer s.Shutdown()
+
+			n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+			if err != nil {
+				t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+			}
+			want := (h.Size() + 2) / 3 * 4
+			if len(n.Token) != want {
+				t.Fatalf("Expected Token to be %d characters long. Instead length is: %d", want, len(n.Token))
+			}
+			err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+			if err != nil {
+				t.Fatalf("Expected to nonce check to be valid. Instead got the error: %v", err)
+			}
+			err = s.Check(n.Token+"A", tNonce.Action, tNonce.UserID)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Expected ErrInvalidToken. Instead got: %v", err)
+			}
+		})
+	}
+}
+
+func TestLegacyHashers(t *testing.T) {
+	legacy := NewInMemoryService(WithHasher(SHA512))
+	defer legacy.Shutdown()
+	old, err := legacy.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	// a service issuing shorter random tokens still accepts stored SHA512 tokens
+	s := NewInMemoryService(WithHasher(RandomBytes(32)))
+	defer s.Shutdown()
+	_, err = s.(Putter).PutNonce(old)
+	if err != nil {
+		t.Fatalf("Expected to put nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(old.Token, tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Fatalf("Expected legacy token check to be valid. Instead got the error: %v", err)
+	}
+
+	strict := NewInMemoryService(WithHasher(RandomBytes(32)), WithLegacyHashers())
+	defer strict.Shutdown()
+	err = strict.Check(old.Token, tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected ErrInvalidToken without legacy hashers. Instead got: %v", err)
+	}
+}
+
+func TestPepper(t *testing.T) {
+	forge := func(n Nonce) string {
+		raw := fmt.Sprintf("%s::%s::%d::%s", n.Action, n.UserID, n.CreatedAt, n.Salt)
+		sum, _ := SHA512.Sum([]byte(raw))
+		return base64.URLEncoding.EncodeToString(sum)
+	}
+
+	plain := NewInMemoryService(WithHasher(SHA512))
+	defer plain.Shutdown()
+	n, err := plain.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	if forge(n) != n.Token {
+		t.Fatalf("Expected an unpeppered SHA512 token to be derivable from the nonce")
+	}
+
+	for name, h := range map[string]Hasher{"SHA512": SHA512, "DefaultHasher": DefaultHasher} {
+		peppered := NewInMemoryService(WithHasher(h), WithPepper(1, []byte("secret")))
+		defer peppered.Shutdown()
+		n, err = peppered.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+		if err != nil {
+			t.Fatalf("Expected to add nonce with %s. Instead got the error: %v", name, err)
+		}
+		info, err := ParseToken(n.Token)
+		if err != nil || info.Pepper != 1 || !strings.HasPrefix(n.Token, "p1.") {
+			t.Errorf("Expected a %s token tagged with pepper 1. Instead got: %q, %+v, %v", name, n.Token, info, err)
+		}
+		got, _ := peppered.Get(tNonce.Action, tNonce.UserID)
+		if forge(got) == info.Body || !strings.HasPrefix(got.Token, hashedTokenPrefix) {
+			t.Errorf("Expected the stored %s nonce to reveal nothing of its token. Instead got: %q", name, got.Token)
+		}
+		err = peppered.Check(n.Token, tNonce.Action, tNonce.UserID)
+		if err != nil {
+			t.Errorf("Expected the peppered %s token to check out. Instead got the error: %v", name, err)
+		}
+	}
+
+	for name, opts := range map[string][]Option{
+		"id 0":         {WithPepper(0, []byte("secret"))},
+		"empty secret": {WithPepper(1, nil)},
+		"id twice":     {WithPepper(1, []byte("secret")), WithVerifyPepper(1, []byte("other"))},
+	} {
+		s := NewInMemoryService(opts...)
+		_, err = s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+		if err != ErrInvalidPepper {
+			t.Errorf("Expected New with %s to return %v. Instead got: %v", name, ErrInvalidPepper, err)
+		}
+		s.Shutdown()
+	}
+}
+
+func TestPepperRotation(t *testing.T) {
+	newService := openTestStore(t, "sqlx")
+	oldPepper, newPepper := []byte("old secret"), []byte("new secret")
+
+	plain := newService()
+	defer plain.Shutdown()
+	unpeppered, err := plain.New("plain", tNonce.UserID, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	before := newService(WithPepper(1, oldPepper))
+	defer before.Shutdown()
+	old, err := before.New("old", tNonce.UserID, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	rotated := newService(WithPepper(2, newPepper), WithVerifyPepper(1, oldPepper))
+	defer rotated.Shutdown()
+	n, err := rotated.New("new", tNonce.UserID, time.Minute)
+	if err != nil || !strings.HasPrefix(n.Token, "p2.") {
+		t.Fatalf("Expected a token under pepper 2. Instead got: %q, %v", n.Token, err)
+	}
+	for action, token := range map[string]string{"plain": unpeppered.Token, "old": old.Token, "new": n.Token} {
+		err = rotated.Check(token, action, tNonce.UserID)
+		if err != nil {
+			t.Errorf("Expected the %s token to check out after rotating. Instead got the error: %v", action, err)
+		}
+	}
+
+	retired := newService(WithPepper(2, newPepper))
+	defer retired.Shutdown()
+	err = retired.Check(old.Token, "old", tNonce.UserID)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected a token under a dropped pepper to return %v. Instead got: %v", ErrInvalidToken, err)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"time"
+)
+
+// SweepStallIntervals is how many RemoveExpiredIntervals may pass without a
+// successful sweep before Health reports ErrSweepStalled.
+// It is read when a Service is created.
+var SweepStallIntervals = 3
+
+// HealthChecker is implemented by Services that can tell whether they are
+// able to serve requests
+type HealthChecker interface {
+	// Health pings the store, returning the error it failed with, or
+	// ErrSweepStalled if the store is reachable but expired nonces aren't
+	// being removed.
+	Health(ctx context.Context) error
+
+	// LastSweep is when expired nonces were last removed, or when the Service
+	// was created if that hasn't finished yet. It is the zero Time for stores
+	// that remove expired nonces themselves.
+	LastSweep() time.Time
+}
+
+func (s *nonceInMemoryService) Health(ctx context.Context) error {
+	return s.sweeps.check()
+}
+
+func (s *nonceInMemoryService) LastSweep() time.Time {
+	return s.sweeps.lastSweep()
+}
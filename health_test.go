@@ -0,0 +1,35 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHealthDecorated(t *testing.T) {
+	s := NewInMemoryService(WithRateLimit("", 10, time.Minute))
+	defer s.Shutdown()
+
+	h, ok := s.(HealthChecker)
+	if !ok {
+		t.Fatal("Expected a decorated in-memory Service to be a HealthChecker")
+	}
+	err := h.Health(context.Background())
+	if err != nil || h.LastSweep().IsZero() {
+		t.Fatalf("Expected a healthy Service with a LastSweep. Instead got: %v, %v", err, h.LastSweep())
+	}
+}
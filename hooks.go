@@ -0,0 +1,96 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+// Hooks are called as nonces move through their lifecycle. Any of them may be nil.
+// Each call runs in its own goroutine after the change has been stored, so a
+// slow hook never delays the caller and hooks may run in any order.
+// Unlike the journal and audit events, hooks are never sampled.
+type Hooks struct {
+	// OnCreated is called for every nonce New and NewBatch create
+	OnCreated func(Nonce)
+
+	// OnConsumed is called with the nonce as it was marked used
+	OnConsumed func(Nonce)
+
+	// OnInvalidated is called for each older, unused nonce New or NewBatch marks
+	// invalid, including those evicted for WithMaxOutstanding
+	OnInvalidated func(Nonce)
+
+	// OnExpiredRemoved is called for each expired nonce removed from the store.
+	// The MongoDB backend's TTL index and etcd's leases remove nonces without
+	// telling the Service, so there it only fires for nonces PurgeExpired removes.
+	OnExpiredRemoved func(Nonce)
+
+	// OnSwept is called after each pass of the goroutine the sqlx and in-memory
+	// backends remove expired nonces with, so you can tell whether it keeps up
+	// with how fast nonces are created. It isn't called for a pass Shutdown cancels.
+	OnSwept func(SweepResult)
+}
+
+// WithHooks sets the Hooks a Service calls
+func WithHooks(h Hooks) Option {
+	return func(cfg *config) {
+		cfg.hooks = h
+	}
+}
+
+func (c config) created(nonces ...Nonce) {
+	c.hook(c.hooks.OnCreated, nonces)
+}
+
+func (c config) consumed(n Nonce) {
+	c.hook(c.hooks.OnConsumed, []Nonce{n})
+}
+
+func (c config) invalidated(nonces ...Nonce) {
+	c.hook(c.hooks.OnInvalidated, nonces)
+}
+
+func (c config) expiredRemoved(nonces ...Nonce) {
+	c.hook(c.hooks.OnExpiredRemoved, nonces)
+}
+
+func (c config) swept(res SweepResult) {
+	if c.hooks.OnSwept == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.logger.Printf("nonce: hook panicked: %v", r)
+			}
+		}()
+		c.hooks.OnSwept(res)
+	}()
+}
+
+// hook calls fn with each nonce in its own goroutine, logging a panic rather
+// than letting a broken hook crash the program
+func (c config) hook(fn func(Nonce), nonces []Nonce) {
+	if fn == nil {
+		return
+	}
+	for _, n := range nonces {
+		go func(n Nonce) {
+			defer func() {
+				if r := recover(); r != nil {
+					c.logger.Printf("nonce: hook panicked: %v", r)
+				}
+			}()
+			fn(n)
+		}(n)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// hookEvent is one hook call seen by recordHooks
+type hookEvent struct {
+	hook string
+	n    Nonce
+}
+
+// recordHooks returns Hooks that send every call to the returned channel
+func recordHooks() (Hooks, chan hookEvent) {
+	ch := make(chan hookEvent, 16)
+	send := func(hook string) func(Nonce) {
+		return func(n Nonce) { ch <- hookEvent{hook, n} }
+	}
+	return Hooks{
+		OnCreated:        send("created"),
+		OnConsumed:       send("consumed"),
+		OnInvalidated:    send("invalidated"),
+		OnExpiredRemoved: send("expired"),
+	}, ch
+}
+
+// expectHooks waits for one call of each hook in want, in any order
+func expectHooks(t *testing.T, ch chan hookEvent, want map[string]uuid.UUID) {
+	for len(want) > 0 {
+		select {
+		case e := <-ch:
+			id, ok := want[e.hook]
+			if !ok || id != e.n.ID {
+				t.Fatalf("Unexpected %s hook for %v. Still waiting for: %v", e.hook, e.n.ID, want)
+			}
+			delete(want, e.hook)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected hooks to be called. Still waiting for: %v", want)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	clock := &testClock{}
+	hooks, ch := recordHooks()
+	for _, s := range []Service{
+		openTestStore(t, "sqlx")(WithClock(clock), WithHooks(hooks)),
+		NewInMemoryService(WithClock(clock), WithHooks(hooks)),
+	} {
+		uid := uuid.NewV4()
+		first, err := s.New("hooks", uid, time.Minute)
+		if err != nil {
+			t.Fatalf("Expected New to succeed. Instead got: %v", err)
+		}
+		expectHooks(t, ch, map[string]uuid.UUID{"created": first.ID})
+
+		second, _ := s.New("hooks", uid, time.Minute)
+		expectHooks(t, ch, map[string]uuid.UUID{"created": second.ID, "invalidated": first.ID})
+
+		_, err = s.CheckThenConsume(second.Token, "hooks", uid)
+		if err != nil {
+			t.Fatalf("Expected CheckThenConsume to succeed. Instead got: %v", err)
+		}
+		expectHooks(t, ch, map[string]uuid.UUID{"consumed": second.ID})
+
+		third, _ := s.New("hooks", uid, time.Second)
+		expectHooks(t, ch, map[string]uuid.UUID{"created": third.ID})
+		clock.Add(time.Hour)
+		purged, err := s.(Purger).PurgeExpired(context.Background(), 0)
+		if err != nil || purged != 3 {
+			t.Fatalf("Expected 3 nonces purged. Instead got: %d, %v", purged, err)
+		}
+		got := map[uuid.UUID]bool{}
+		for i := 0; i < 3; i++ {
+			select {
+			case e := <-ch:
+				if e.hook != "expired" {
+					t.Fatalf("Expected only expired hooks. Instead got: %s", e.hook)
+				}
+				got[e.n.ID] = true
+			case <-time.After(time.Second):
+				t.Fatalf("Expected 3 expired hooks. Instead got: %d", len(got))
+			}
+		}
+		if !got[first.ID] || !got[second.ID] || !got[third.ID] {
+			t.Fatalf("Expected expired hooks for every nonce. Instead got: %v", got)
+		}
+		s.Shutdown()
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package htmlhelper
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// Where IssueCSRF and VerifyCSRF carry the double submitted token.
+// VerifyCSRF reads the token from the TokenField form value, or from the
+// CSRFHeader header when the form doesn't have one.
+var (
+	CSRFCookie = "nonce_csrf"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// IssueCSRF creates a nonce for action and the user r is from, sets its token
+// as the CSRFCookie cookie and returns it to be sent back as the TokenField
+// form value or the CSRFHeader header. The cookie is SameSite=Strict, but not
+// HttpOnly so scripts can copy it into the header.
+func (h *Helper) IssueCSRF(w http.ResponseWriter, r *http.Request, action string) (string, error) {
+	n, err := h.s.New(action, h.userID(r), h.expiresIn)
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    n.Token,
+		Path:     "/",
+		Expires:  n.ExpiresAt,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return n.Token, nil
+}
+
+// VerifyCSRF checks that the token submitted with r matches its CSRFCookie
+// cookie and that the Service accepts it for action and the user r is from.
+// It returns nonce.ErrNoToken if either is missing and nonce.ErrInvalidToken
+// if they differ. With rotate set the nonce is consumed and a new one issued
+// as IssueCSRF does, so each token is good for one request; the token to
+// send with the next request is returned either way.
+func (h *Helper) VerifyCSRF(w http.ResponseWriter, r *http.Request, action string, rotate bool) (string, error) {
+	c, err := r.Cookie(CSRFCookie)
+	if err != nil || c.Value == "" {
+		return "", nonce.ErrNoToken
+	}
+	token := r.PostFormValue(TokenField)
+	if token == "" {
+		token = r.Header.Get(CSRFHeader)
+	}
+	if token == "" {
+		return "", nonce.ErrNoToken
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(c.Value)) != 1 {
+		return "", nonce.ErrInvalidToken
+	}
+
+	if !rotate {
+		return token, h.s.Check(token, action, h.userID(r))
+	}
+	_, err = h.s.CheckThenConsume(token, action, h.userID(r))
+	if err != nil {
+		return "", err
+	}
+	return h.IssueCSRF(w, r, action)
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package htmlhelper
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestDoubleSubmitCSRF(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+
+	uid := uuid.NewV4()
+	h := New(s, time.Hour, func(r *http.Request) uuid.UUID { return uid })
+
+	w := httptest.NewRecorder()
+	token, err := h.IssueCSRF(w, httptest.NewRequest("GET", "/", nil), "settings")
+	if err != nil {
+		t.Fatalf("Expected to issue a CSRF token. Instead got the error: %v", err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRFCookie || cookies[0].Value != token || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("Expected a SameSite cookie carrying the token. Instead got: %v", cookies)
+	}
+
+	post := func(cookie, header string, rotate bool) (*httptest.ResponseRecorder, string, error) {
+		r := httptest.NewRequest("POST", "/", nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: cookie})
+		}
+		r.Header.Set(CSRFHeader, header)
+		w := httptest.NewRecorder()
+		next, err := h.VerifyCSRF(w, r, "settings", rotate)
+		return w, next, err
+	}
+
+	_, _, err = post("", token, false)
+	if err != nonce.ErrNoToken {
+		t.Errorf("Expected ErrNoToken without the cookie. Instead got: %v", err)
+	}
+	_, _, err = post(token, token[1:]+"A", false)
+	if err != nonce.ErrInvalidToken {
+		t.Errorf("Expected ErrInvalidToken when the values differ. Instead got: %v", err)
+	}
+	_, next, err := post(token, token, false)
+	if err != nil || next != token {
+		t.Fatalf("Expected the token to verify and be kept. Instead got: %q, %v", next, err)
+	}
+
+	w, next, err = post(token, token, true)
+	if err != nil || next == token || next == "" {
+		t.Fatalf("Expected the token to verify and rotate. Instead got: %q, %v", next, err)
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].Value != next {
+		t.Errorf("Expected the rotated token to be set as the cookie. Instead got: %v", c)
+	}
+	_, _, err = post(token, token, false)
+	if !errors.Is(err, nonce.ErrInvalidToken) {
+		t.Errorf("Expected the rotated out token to be rejected. Instead got: %v", err)
+	}
+	_, _, err = post(next, next, false)
+	if err != nil {
+		t.Errorf("Expected the rotated token to verify. Instead got: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package htmlhelper renders nonces into HTML forms and verifies them when
+// the form is posted back. IssueCSRF and VerifyCSRF do the same for the
+// double submit cookie pattern, where the token also travels in a cookie.
+package htmlhelper
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Form field names the hidden inputs are rendered with
+var (
+	TokenField  = "nonce_token"
+	ActionField = "nonce_action"
+)
+
+// Helper creates nonces for forms and verifies submitted forms
+type Helper struct {
+	s         nonce.Service
+	expiresIn time.Duration
+	userID    func(r *http.Request) uuid.UUID
+}
+
+// New returns a Helper that creates nonces in s lasting expiresIn.
+// userID tells VerifyForm which user submitted a request.
+func New(s nonce.Service, expiresIn time.Duration, userID func(r *http.Request) uuid.UUID) *Helper {
+	return &Helper{
+		s:         s,
+		expiresIn: expiresIn,
+		userID:    userID,
+	}
+}
+
+// FuncMap returns template functions for html/template:
+//
+//	nonceField creates a nonce for an action and user and renders it as hidden inputs,
+//	           e.g. {{nonceField "delete-account" .UserID}}
+func (h *Helper) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"nonceField": h.Field,
+	}
+}
+
+// Field creates a nonce for action and uid and returns the hidden inputs that carry it
+func (h *Helper) Field(action string, uid uuid.UUID) (template.HTML, error) {
+	n, err := h.s.New(action, uid, h.expiresIn)
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s"><input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(ActionField), template.HTMLEscapeString(n.Action),
+		template.HTMLEscapeString(TokenField), template.HTMLEscapeString(n.Token))), nil
+}
+
+// VerifyForm checks and consumes the nonce posted with r.
+// The action comes from the form, so handlers must make sure the returned
+// Nonce's Action is the one they expect before acting on it.
+// If the Service is a nonce.MetaConsumer the client's address and user agent are recorded.
+func (h *Helper) VerifyForm(r *http.Request) (nonce.Nonce, error) {
+	token := r.PostFormValue(TokenField)
+	action := r.PostFormValue(ActionField)
+
+	if m, ok := h.s.(nonce.MetaConsumer); ok {
+		return m.CheckThenConsumeWithMeta(token, action, h.userID(r), nonce.ConsumeMeta{
+			IP:        r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+		})
+	}
+	return h.s.CheckThenConsume(token, action, h.userID(r))
+}
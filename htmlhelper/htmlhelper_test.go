@@ -0,0 +1,67 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package htmlhelper
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestFormRoundTrip(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+
+	uid := uuid.NewV4()
+	h := New(s, time.Hour, func(r *http.Request) uuid.UUID { return uid })
+
+	tmpl := template.Must(template.New("form").Funcs(h.FuncMap()).Parse(`<form>{{nonceField "delete" .}}</form>`))
+	var b bytes.Buffer
+	err := tmpl.Execute(&b, uid)
+	if err != nil {
+		t.Fatalf("Expected to render the form. Instead got the error: %v", err)
+	}
+
+	m := regexp.MustCompile(`name="` + TokenField + `" value="([^"]+)"`).FindStringSubmatch(b.String())
+	if m == nil {
+		t.Fatalf("Expected a hidden token input. Instead got: %s", b.String())
+	}
+
+	form := url.Values{TokenField: {m[1]}, ActionField: {"delete"}}
+	post := func() (nonce.Nonce, error) {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return h.VerifyForm(r)
+	}
+
+	n, err := post()
+	if err != nil || n.Action != "delete" {
+		t.Fatalf("Expected the form to verify. Instead got: %v, %v", n, err)
+	}
+	_, err = post()
+	if !errors.Is(err, nonce.ErrTokenUsed) {
+		t.Fatalf("Expected ErrTokenUsed on resubmission. Instead got: %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Client is a nonce.Service that calls a Handler over HTTP.
+// The server's errors come back as the matching nonce errors, so errors.Is
+// works as it does against a local Service.
+type Client struct {
+	base string
+	hc   *http.Client
+}
+
+// NewClient returns a Client for the Handler served at base, e.g. "https://nonces.internal/api".
+// A nil hc uses http.DefaultClient.
+func NewClient(base string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{
+		base: strings.TrimSuffix(base, "/"),
+		hc:   hc,
+	}
+}
+
+func (c *Client) New(action string, uid uuid.UUID, expiresIn time.Duration) (nonce.Nonce, error) {
+	return c.post("/nonces", request{Action: action, UserID: uid, ExpiresIn: int64(expiresIn / time.Second)})
+}
+
+func (c *Client) Check(token, action string, uid uuid.UUID) error {
+	_, err := c.post("/nonces/verify", request{Token: token, Action: action, UserID: uid})
+	return err
+}
+
+func (c *Client) Consume(token string) (nonce.Nonce, error) {
+	return c.post("/nonces/consume", request{Token: token})
+}
+
+func (c *Client) CheckThenConsume(token, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	return c.post("/nonces/consume", request{Token: token, Action: action, UserID: uid})
+}
+
+func (c *Client) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	return c.post("/nonces/consume-by-id", request{ID: id, Action: action, UserID: uid})
+}
+
+func (c *Client) Get(action string, uid uuid.UUID) (nonce.Nonce, error) {
+	q := url.Values{"action": {action}, "user_id": {uid.String()}}
+	resp, err := c.hc.Get(c.base + "/nonces?" + q.Encode())
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	return decode(resp)
+}
+
+func (c *Client) Renew(token string, extendBy time.Duration) (nonce.Nonce, error) {
+	return c.post("/nonces/renew", request{Token: token, ExtendBy: int64(extendBy / time.Second)})
+}
+
+// Shutdown closes idle connections; the server keeps running
+func (c *Client) Shutdown() {
+	c.hc.CloseIdleConnections()
+}
+
+func (c *Client) post(path string, req request) (nonce.Nonce, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	resp, err := c.hc.Post(c.base+path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	return decode(resp)
+}
+
+// decode reads the nonce or error in resp and closes its body
+func decode(resp *http.Response) (nonce.Nonce, error) {
+	defer resp.Body.Close()
+
+	var n nonce.Nonce
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return n, nil
+	case resp.StatusCode < 300:
+		err := json.NewDecoder(resp.Body).Decode(&n)
+		return n, err
+	}
+
+	var e errorResponse
+	err := json.NewDecoder(resp.Body).Decode(&e)
+	if err != nil {
+		return n, fmt.Errorf("httpapi: unexpected response %s", resp.Status)
+	}
+	if e.Error == "bad_request" {
+		return n, fmt.Errorf("%w: %s", errBadRequest, e.Message)
+	}
+	for _, known := range Errors {
+		if known.Code == e.Error {
+			return n, known.Err
+		}
+	}
+	return n, fmt.Errorf("httpapi: server error %s: %s", e.Error, e.Message)
+}
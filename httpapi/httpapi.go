@@ -0,0 +1,250 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpapi serves a nonce.Service over HTTP and provides a Client that
+// implements nonce.Service against such a server, so services written in
+// other languages and browser apps can share one nonce store.
+//
+// Every endpoint takes and returns JSON. Nonces are encoded by Nonce.MarshalJSON,
+// so salts never leave the server.
+//
+//	POST /nonces                {"action", "user_id", "expires_in"}  201 and the nonce
+//	GET  /nonces?action=&user_id=                                    200 and the newest usable nonce
+//	POST /nonces/verify         {"token", "action", "user_id"}       204
+//	POST /nonces/consume        {"token"[, "action", "user_id"]}     200 and the consumed nonce
+//	POST /nonces/consume-by-id  {"id", "action", "user_id"}          200 and the consumed nonce
+//	POST /nonces/renew          {"token", "extend_by"}               200 and the renewed nonce
+//	GET  /health                                                     200 or 503 and a healthResponse
+//
+// expires_in, extend_by and sweep_age are in seconds. Errors are returned as
+// {"error": code, "message": text} with the status listed in Errors.
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// MaxBodySize caps how much of a request body the Handler reads
+var MaxBodySize int64 = 1 << 16
+
+// Errors lists how the Service's errors are sent over HTTP.
+// Errors not listed are sent as "internal" with status 500.
+var Errors = []struct {
+	Err    error
+	Code   string
+	Status int
+}{
+	{nonce.ErrNoToken, "no_token", http.StatusBadRequest},
+	{nonce.ErrInvalidToken, "invalid_token", http.StatusForbidden},
+	{nonce.ErrTokenUsed, "token_used", http.StatusConflict},
+	{nonce.ErrTokenExpired, "token_expired", http.StatusGone},
+	{nonce.ErrTokenNotFound, "token_not_found", http.StatusNotFound},
+	{nonce.ErrUserRequired, "user_required", http.StatusBadRequest},
+	{nonce.ErrBindingMismatch, "binding_mismatch", http.StatusForbidden},
+	{nonce.ErrInvalidBinding, "invalid_binding", http.StatusBadRequest},
+	{nonce.ErrTooManyAttempts, "too_many_attempts", http.StatusTooManyRequests},
+	{nonce.ErrPayloadTooLarge, "payload_too_large", http.StatusRequestEntityTooLarge},
+	{nonce.ErrRateLimited, "rate_limited", http.StatusTooManyRequests},
+	{nonce.ErrTooManyNonces, "too_many_nonces", http.StatusTooManyRequests},
+	{nonce.ErrNotSupported, "not_supported", http.StatusNotImplemented},
+	{nonce.ErrBackendUnavailable, "backend_unavailable", http.StatusServiceUnavailable},
+}
+
+// errBadRequest is returned by the Client when the server couldn't decode a request
+var errBadRequest = errors.New("httpapi: bad request")
+
+// request is the body of every POST endpoint; each reads the fields it needs
+type request struct {
+	ID        uuid.UUID `json:"id"`
+	Token     string    `json:"token"`
+	Action    string    `json:"action"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresIn int64     `json:"expires_in"`
+	ExtendBy  int64     `json:"extend_by"`
+}
+
+// healthResponse is the body of GET /health. LastSweep and SweepAge are left
+// out for stores that remove expired nonces themselves.
+type healthResponse struct {
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	LastSweep *time.Time `json:"last_sweep,omitempty"`
+	SweepAge  int64      `json:"sweep_age,omitempty"`
+}
+
+type errorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// Handler serves a nonce.Service. Mount it with http.StripPrefix to serve it below a path.
+type Handler struct {
+	s nonce.Service
+}
+
+// NewHandler returns a Handler serving s.
+// If s is a nonce.MetaConsumer consumes record the client's address and user agent.
+func NewHandler(s nonce.Service) *Handler {
+	return &Handler{s: s}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/nonces" && r.Method == http.MethodGet:
+		h.get(w, r)
+		return
+	case r.URL.Path == "/health" && r.Method == http.MethodGet:
+		HealthHandler(h.s).ServeHTTP(w, r)
+		return
+	case r.Method != http.MethodPost:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var handle func(w http.ResponseWriter, r *http.Request, req request)
+	switch r.URL.Path {
+	case "/nonces":
+		handle = h.create
+	case "/nonces/verify":
+		handle = h.verify
+	case "/nonces/consume":
+		handle = h.consume
+	case "/nonces/consume-by-id":
+		handle = h.consumeByID
+	case "/nonces/renew":
+		handle = h.renew
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	var req request
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodySize)).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "bad_request", Message: err.Error()})
+		return
+	}
+	handle(w, r, req)
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, req request) {
+	n, err := h.s.New(req.Action, req.UserID, time.Duration(req.ExpiresIn)*time.Second)
+	respond(w, http.StatusCreated, n, err)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.FromString(r.URL.Query().Get("user_id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "bad_request", Message: err.Error()})
+		return
+	}
+	n, err := h.s.Get(r.URL.Query().Get("action"), uid)
+	respond(w, http.StatusOK, n, err)
+}
+
+func (h *Handler) verify(w http.ResponseWriter, r *http.Request, req request) {
+	err := h.s.Check(req.Token, req.Action, req.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// consume checks action and user_id first when action is given
+func (h *Handler) consume(w http.ResponseWriter, r *http.Request, req request) {
+	var n nonce.Nonce
+	var err error
+	m, ok := h.s.(nonce.MetaConsumer)
+	meta := nonce.ConsumeMeta{IP: r.RemoteAddr, UserAgent: r.UserAgent()}
+	switch {
+	case req.Action != "" && ok:
+		n, err = m.CheckThenConsumeWithMeta(req.Token, req.Action, req.UserID, meta)
+	case req.Action != "":
+		n, err = h.s.CheckThenConsume(req.Token, req.Action, req.UserID)
+	case ok:
+		n, err = m.ConsumeWithMeta(req.Token, meta)
+	default:
+		n, err = h.s.Consume(req.Token)
+	}
+	respond(w, http.StatusOK, n, err)
+}
+
+func (h *Handler) consumeByID(w http.ResponseWriter, r *http.Request, req request) {
+	n, err := h.s.ConsumeByID(req.ID, req.Action, req.UserID)
+	respond(w, http.StatusOK, n, err)
+}
+
+func (h *Handler) renew(w http.ResponseWriter, r *http.Request, req request) {
+	n, err := h.s.Renew(req.Token, time.Duration(req.ExtendBy)*time.Second)
+	respond(w, http.StatusOK, n, err)
+}
+
+// HealthHandler returns a handler for liveness and readiness probes.
+// It responds 200 when s is healthy and 503, with the error, when its store
+// can't be reached or its sweep of expired nonces has stalled, and reports when
+// that sweep last finished. s must be a nonce.HealthChecker to be healthy.
+func HealthHandler(s nonce.Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hc, ok := s.(nonce.HealthChecker)
+		if !ok {
+			writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "unhealthy", Error: nonce.ErrNotSupported.Error()})
+			return
+		}
+
+		res := healthResponse{Status: "ok"}
+		status := http.StatusOK
+		err := hc.Health(r.Context())
+		if err != nil {
+			res.Status, res.Error = "unhealthy", err.Error()
+			status = http.StatusServiceUnavailable
+		}
+		if t := hc.LastSweep(); !t.IsZero() {
+			res.LastSweep = &t
+			res.SweepAge = int64(time.Since(t) / time.Second)
+		}
+		writeJSON(w, status, res)
+	})
+}
+
+// respond writes n with status, or err if there is one
+func respond(w http.ResponseWriter, status int, n nonce.Nonce, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, status, n)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	for _, e := range Errors {
+		if errors.Is(err, e.Err) {
+			writeJSON(w, e.Status, errorResponse{Error: e.Code, Message: err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal", Message: http.StatusText(http.StatusInternalServerError)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
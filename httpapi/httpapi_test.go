@@ -0,0 +1,129 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+var _ nonce.Service = (*Client)(nil)
+
+func TestClientRoundTrip(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	srv := httptest.NewServer(NewHandler(s))
+	defer srv.Close()
+	c := NewClient(srv.URL, nil)
+	defer c.Shutdown()
+
+	uid := uuid.NewV4()
+	n, err := c.New("reset", uid, time.Hour)
+	if err != nil || n.Token == "" || n.Salt != "" {
+		t.Fatalf("Expected New to return a nonce without its salt. Instead got: %+v, %v", n, err)
+	}
+
+	err = c.Check(n.Token, "reset", uid)
+	if err != nil {
+		t.Fatalf("Expected Check to succeed. Instead got: %v", err)
+	}
+	err = c.Check(n.Token, "other", uid)
+	if !errors.Is(err, nonce.ErrInvalidToken) {
+		t.Fatalf("Expected ErrInvalidToken for the wrong action. Instead got: %v", err)
+	}
+
+	got, err := c.Get("reset", uid)
+	if err != nil || got.ID != n.ID {
+		t.Fatalf("Expected Get to return the new nonce. Instead got: %+v, %v", got, err)
+	}
+
+	renewed, err := c.Renew(n.Token, time.Hour)
+	if err != nil || !renewed.ExpiresAt.After(n.ExpiresAt) {
+		t.Fatalf("Expected Renew to push ExpiresAt forward. Instead got: %+v, %v", renewed, err)
+	}
+
+	used, err := c.CheckThenConsume(n.Token, "reset", uid)
+	if err != nil || !used.IsUsed {
+		t.Fatalf("Expected CheckThenConsume to succeed. Instead got: %+v, %v", used, err)
+	}
+	_, err = c.Consume(n.Token)
+	if !errors.Is(err, nonce.ErrTokenUsed) {
+		t.Fatalf("Expected ErrTokenUsed. Instead got: %v", err)
+	}
+
+	n, _ = c.New("reset", uid, time.Hour)
+	_, err = c.ConsumeByID(n.ID, "reset", uid)
+	if err != nil {
+		t.Fatalf("Expected ConsumeByID to succeed. Instead got: %v", err)
+	}
+
+	_, err = c.Get("missing", uid)
+	if err != nonce.ErrTokenNotFound {
+		t.Fatalf("Expected ErrTokenNotFound. Instead got: %v", err)
+	}
+	err = c.Check("", "reset", uid)
+	if err != nonce.ErrNoToken {
+		t.Fatalf("Expected ErrNoToken. Instead got: %v", err)
+	}
+}
+
+func TestHandlerRejectsBadRequests(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	h := NewHandler(s)
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "/nonces/verify", "{", http.StatusBadRequest},
+		{"GET", "/nonces?user_id=nope", "", http.StatusBadRequest},
+		{"DELETE", "/nonces", "", http.StatusMethodNotAllowed},
+		{"POST", "/elsewhere", "{}", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != tc.status {
+			t.Fatalf("Expected %s %s to return %d. Instead got: %d", tc.method, tc.path, tc.status, w.Code)
+		}
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+
+	w := httptest.NewRecorder()
+	NewHandler(s).ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	var res healthResponse
+	err := json.NewDecoder(w.Body).Decode(&res)
+	if w.Code != http.StatusOK || err != nil || res.Status != "ok" || res.LastSweep == nil {
+		t.Fatalf("Expected a healthy response with the last sweep. Instead got: %d %+v, %v", w.Code, res, err)
+	}
+
+	w = httptest.NewRecorder()
+	HealthHandler(NewClient("http://127.0.0.1:0", nil)).ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 for a Service that can't be checked. Instead got: %d", w.Code)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"fmt"
+	"time"
+)
+
+// RemainingLife returns how long the nonce has left before it expires.
+// A nonce that has already expired at now has no remaining life.
+func (n Nonce) RemainingLife(now time.Time) time.Duration {
+	d := n.ExpiresAt.Sub(now)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Humanizer turns a remaining lifetime into text that can be shown to a user
+type Humanizer func(d time.Duration) string
+
+// Units holds the words a Humanizer built by Units.Humanize uses.
+// Translate the fields to display lifetimes in another language.
+type Units struct {
+	Day, Days       string
+	Hour, Hours     string
+	Minute, Minutes string
+	Second, Seconds string
+	Expired         string
+}
+
+// EnglishUnits is the default set of Units
+var EnglishUnits = Units{
+	Day:     "day",
+	Days:    "days",
+	Hour:    "hour",
+	Hours:   "hours",
+	Minute:  "minute",
+	Minutes: "minutes",
+	Second:  "second",
+	Seconds: "seconds",
+	Expired: "expired",
+}
+
+// Humanize formats d using the largest whole unit, e.g. "2 hours" or "1 minute".
+// Durations under a second are reported as Expired.
+func (u Units) Humanize(d time.Duration) string {
+	var amount int64
+	var one, many string
+	switch {
+	case d >= 24*time.Hour:
+		amount, one, many = int64(d/(24*time.Hour)), u.Day, u.Days
+	case d >= time.Hour:
+		amount, one, many = int64(d/time.Hour), u.Hour, u.Hours
+	case d >= time.Minute:
+		amount, one, many = int64(d/time.Minute), u.Minute, u.Minutes
+	case d >= time.Second:
+		amount, one, many = int64(d/time.Second), u.Second, u.Seconds
+	default:
+		return u.Expired
+	}
+
+	if amount == 1 {
+		return fmt.Sprintf("%d %s", amount, one)
+	}
+	return fmt.Sprintf("%d %s", amount, many)
+}
+
+// HumanizeDuration is the default Humanizer and uses EnglishUnits
+func HumanizeDuration(d time.Duration) string {
+	return EnglishUnits.Humanize(d)
+}
+
+// TemplateFuncs returns functions for text/template and html/template:
+//
+//	nonceRemaining     the time.Duration left on a Nonce
+//	nonceRemainingText the remaining life formatted by h
+//	nonceExpiresAt     the Nonce's ExpiresAt
+//
+// A nil h uses HumanizeDuration and a nil clock uses SystemClock.
+func TemplateFuncs(h Humanizer, clock Clock) map[string]interface{} {
+	if h == nil {
+		h = HumanizeDuration
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	return map[string]interface{}{
+		"nonceRemaining": func(n Nonce) time.Duration {
+			return n.RemainingLife(clock.Now())
+		},
+		"nonceRemainingText": func(n Nonce) string {
+			return h(n.RemainingLife(clock.Now()))
+		},
+		"nonceExpiresAt": func(n Nonce) time.Time {
+			return n.ExpiresAt
+		},
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestRemainingLife(t *testing.T) {
+	now := time.Now()
+	n := Nonce{ExpiresAt: now.Add(90 * time.Second)}
+
+	if d := n.RemainingLife(now); d != 90*time.Second {
+		t.Fatalf("Expected RemainingLife to be: %s. Instead got: %s", 90*time.Second, d)
+	}
+	if d := n.RemainingLife(now.Add(time.Hour)); d != 0 {
+		t.Fatalf("Expected RemainingLife of an expired nonce to be 0. Instead got: %s", d)
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "expired"},
+		{time.Second, "1 second"},
+		{59 * time.Second, "59 seconds"},
+		{90 * time.Second, "1 minute"},
+		{2 * time.Hour, "2 hours"},
+		{49 * time.Hour, "2 days"},
+	}
+
+	for _, tt := range tests {
+		if got := HumanizeDuration(tt.d); got != tt.want {
+			t.Fatalf("Expected %s to humanize as: %s. Instead got: %s", tt.d, tt.want, got)
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	clock := &testClock{}
+	n := Nonce{ExpiresAt: clock.Now().Add(2*time.Minute + time.Second)}
+
+	tmpl := template.Must(template.New("t").Funcs(TemplateFuncs(nil, clock)).Parse(`{{nonceRemainingText .}}`))
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, n); err != nil {
+		t.Fatalf("Expected template to execute. Instead got the error: %v", err)
+	}
+	if b.String() != "2 minutes" {
+		t.Fatalf("Expected template to render: 2 minutes. Instead got: %s", b.String())
+	}
+}
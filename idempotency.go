@@ -0,0 +1,240 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// maxKeyLen is the longest idempotency key the sqlx table can hold
+const maxKeyLen = 255
+
+// IdempotencyRecord is what is kept for one idempotency key
+type IdempotencyRecord struct {
+	// Namespace is the WithNamespace tenant the key belongs to, or empty.
+	// Each namespace has its own keys, so tenants sharing a store can reuse them.
+	Namespace string
+	UserID    uuid.UUID `db:"user_id"`
+	Key       string    `db:"idempotency_key"`
+
+	// Completed is set once Complete has stored Result
+	Completed bool `db:"is_completed"`
+	Result    []byte
+
+	CreatedAt int64     `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// IdempotencyStore is implemented by Services that can keep IdempotencyRecords.
+// The sqlx backend keeps them in the nonce_idempotency table, which sqlx.Migrate
+// creates, and the in-memory backend keeps them out of its Snapshots. Records
+// are kept per WithNamespace namespace, like nonces.
+type IdempotencyStore interface {
+	// ReserveKey stores a record for uid and key expiring after ttl unless an
+	// unexpired one is already there. It returns the stored record and whether
+	// it was created by this call.
+	ReserveKey(key string, uid uuid.UUID, ttl time.Duration) (IdempotencyRecord, bool, error)
+
+	// CompleteKey stores result on the reserved record for uid and key. It
+	// returns ErrTokenUsed if the record is already completed and
+	// ErrTokenNotFound if there isn't an unexpired one.
+	CompleteKey(key string, uid uuid.UUID, result []byte) error
+
+	// ReleaseKey deletes the record for uid and key unless it is completed
+	ReleaseKey(key string, uid uuid.UUID) error
+
+	// PurgeExpiredKeys deletes the namespace's expired records, returning how
+	// many there were
+	PurgeExpiredKeys(ctx context.Context) (int64, error)
+}
+
+// IdempotencyService makes a request carrying an idempotency key run once:
+// the first Begin for a key reserves it, Complete records what the request
+// returned, and every Begin after that gets the recorded result back until
+// the key expires.
+type IdempotencyService struct {
+	store IdempotencyStore
+	ttl   time.Duration
+}
+
+// NewIdempotencyService creates an IdempotencyService that keeps keys in s for
+// ttl after they are reserved, or returns ErrNotIdempotencyStore if s isn't
+// an IdempotencyStore. Expired keys are skipped but stay in the store until
+// PurgeExpired is called.
+func NewIdempotencyService(s Service, ttl time.Duration) (*IdempotencyService, error) {
+	store, ok := s.(IdempotencyStore)
+	if !ok {
+		return nil, ErrNotIdempotencyStore
+	}
+	return &IdempotencyService{store: store, ttl: ttl}, nil
+}
+
+// Begin reserves key for uid. If the returned record isn't Completed the
+// caller holds the key and must call Complete once the request has run, or
+// Abort if it failed and may be retried. If it is Completed the request has
+// already run and its Result should be returned again. While the key is
+// reserved and not completed, Begin returns ErrKeyInProgress.
+func (s *IdempotencyService) Begin(key string, uid uuid.UUID) (IdempotencyRecord, error) {
+	err := checkKey(key)
+	if err != nil {
+		return IdempotencyRecord{}, err
+	}
+	r, reserved, err := s.store.ReserveKey(key, uid, s.ttl)
+	if err != nil {
+		return IdempotencyRecord{}, err
+	}
+	if !reserved && !r.Completed {
+		return r, ErrKeyInProgress
+	}
+	return r, nil
+}
+
+// Complete records result, e.g. the response body or a hash of it, for the
+// key uid reserved with Begin
+func (s *IdempotencyService) Complete(key string, uid uuid.UUID, result []byte) error {
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+	return s.store.CompleteKey(key, uid, result)
+}
+
+// Abort gives up the key uid reserved with Begin so the request can be retried.
+// It does nothing once the key is completed.
+func (s *IdempotencyService) Abort(key string, uid uuid.UUID) error {
+	err := checkKey(key)
+	if err != nil {
+		return err
+	}
+	return s.store.ReleaseKey(key, uid)
+}
+
+// PurgeExpired deletes the expired keys in the Service's namespace, returning
+// how many there were
+func (s *IdempotencyService) PurgeExpired(ctx context.Context) (int64, error) {
+	return s.store.PurgeExpiredKeys(ctx)
+}
+
+func checkKey(key string) error {
+	if key == "" || len(key) > maxKeyLen {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+func newIdempotencyRecord(ns, key string, uid uuid.UUID, ttl time.Duration, t time.Time) IdempotencyRecord {
+	return IdempotencyRecord{
+		Namespace: ns,
+		UserID:    uid,
+		Key:       key,
+		CreatedAt: t.Unix(),
+		ExpiresAt: t.Add(ttl).Truncate(time.Second),
+	}
+}
+
+// Completable returns why r can't be completed at t, or nil if it can.
+// The zero IdempotencyRecord, for a key nobody reserved, is ErrTokenNotFound.
+func (r IdempotencyRecord) Completable(t time.Time) error {
+	if !r.ExpiresAt.After(t) {
+		return ErrTokenNotFound
+	}
+	if r.Completed {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+// idempotencyKeys holds the in-memory backend's IdempotencyRecords
+type idempotencyKeys struct {
+	sync.Mutex
+	records map[idempotencyKey]IdempotencyRecord
+}
+
+type idempotencyKey struct {
+	ns  string
+	uid uuid.UUID
+	key string
+}
+
+func newIdempotencyKeys() *idempotencyKeys {
+	return &idempotencyKeys{records: make(map[idempotencyKey]IdempotencyRecord)}
+}
+
+func (s *nonceInMemoryService) ReserveKey(key string, uid uuid.UUID, ttl time.Duration) (IdempotencyRecord, bool, error) {
+	t := s.cfg.clock.Now()
+	ik := idempotencyKey{s.cfg.namespace, uid, key}
+	k := s.keys
+	k.Lock()
+	defer k.Unlock()
+
+	stored, ok := k.records[ik]
+	if ok && stored.ExpiresAt.After(t) {
+		return stored, false, nil
+	}
+	r := newIdempotencyRecord(s.cfg.namespace, key, uid, ttl, t)
+	k.records[ik] = r
+	return r, true, nil
+}
+
+func (s *nonceInMemoryService) CompleteKey(key string, uid uuid.UUID, result []byte) error {
+	t := s.cfg.clock.Now()
+	ik := idempotencyKey{s.cfg.namespace, uid, key}
+	k := s.keys
+	k.Lock()
+	defer k.Unlock()
+
+	stored := k.records[ik]
+	err := stored.Completable(t)
+	if err != nil {
+		return err
+	}
+	stored.Completed = true
+	stored.Result = append([]byte(nil), result...)
+	k.records[ik] = stored
+	return nil
+}
+
+func (s *nonceInMemoryService) ReleaseKey(key string, uid uuid.UUID) error {
+	ik := idempotencyKey{s.cfg.namespace, uid, key}
+	k := s.keys
+	k.Lock()
+	defer k.Unlock()
+
+	stored, ok := k.records[ik]
+	if ok && !stored.Completed {
+		delete(k.records, ik)
+	}
+	return nil
+}
+
+func (s *nonceInMemoryService) PurgeExpiredKeys(ctx context.Context) (int64, error) {
+	t := s.cfg.clock.Now()
+	k := s.keys
+	k.Lock()
+	defer k.Unlock()
+
+	var purged int64
+	for uk, r := range k.records {
+		if uk.ns == s.cfg.namespace && !r.ExpiresAt.After(t) {
+			delete(k.records, uk)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestIdempotencyService(t *testing.T) {
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := &testClock{}
+			s := newService(WithClock(clock))
+			defer s.Shutdown()
+			is, err := NewIdempotencyService(s, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to create the IdempotencyService. Instead got the error: %v", err)
+			}
+			uid := uuid.NewV4()
+
+			r, err := is.Begin("charge-1", uid)
+			if err != nil || r.Completed {
+				t.Fatalf("Expected the first Begin to reserve the key. Instead got: %+v, %v", r, err)
+			}
+			_, err = is.Begin("charge-1", uid)
+			if err != ErrKeyInProgress {
+				t.Errorf("Expected a second Begin to return %v. Instead got: %v", ErrKeyInProgress, err)
+			}
+			r, err = is.Begin("charge-1", uuid.NewV4())
+			if err != nil {
+				t.Errorf("Expected keys to be kept per user. Instead got: %v", err)
+			}
+
+			err = is.Complete("charge-1", uid, []byte(`{"status":"paid"}`))
+			if err != nil {
+				t.Fatalf("Expected to complete the key. Instead got the error: %v", err)
+			}
+			err = is.Complete("charge-1", uid, []byte(`{}`))
+			if err != ErrTokenUsed {
+				t.Errorf("Expected completing twice to return %v. Instead got: %v", ErrTokenUsed, err)
+			}
+			r, err = is.Begin("charge-1", uid)
+			if err != nil || !r.Completed || string(r.Result) != `{"status":"paid"}` {
+				t.Errorf("Expected Begin to return the stored result. Instead got: %+v, %v", r, err)
+			}
+			err = is.Abort("charge-1", uid)
+			if err != nil {
+				t.Errorf("Expected Abort of a completed key to do nothing. Instead got: %v", err)
+			}
+			r, _ = is.Begin("charge-1", uid)
+			if !r.Completed {
+				t.Errorf("Expected Abort to keep a completed key")
+			}
+
+			_, err = is.Begin("charge-2", uid)
+			if err != nil {
+				t.Fatalf("Expected to reserve the key. Instead got the error: %v", err)
+			}
+			err = is.Abort("charge-2", uid)
+			if err != nil {
+				t.Fatalf("Expected to abort the key. Instead got the error: %v", err)
+			}
+			r, err = is.Begin("charge-2", uid)
+			if err != nil || r.Completed {
+				t.Errorf("Expected an aborted key to be reserved again. Instead got: %+v, %v", r, err)
+			}
+
+			err = is.Complete("unknown", uid, nil)
+			if err != ErrTokenNotFound {
+				t.Errorf("Expected completing an unknown key to return %v. Instead got: %v", ErrTokenNotFound, err)
+			}
+			_, err = is.Begin("", uid)
+			if err != ErrInvalidKey {
+				t.Errorf("Expected an empty key to return %v. Instead got: %v", ErrInvalidKey, err)
+			}
+
+			clock.Add(2 * time.Minute)
+			err = is.Complete("charge-2", uid, nil)
+			if err != ErrTokenNotFound {
+				t.Errorf("Expected completing an expired key to return %v. Instead got: %v", ErrTokenNotFound, err)
+			}
+			r, err = is.Begin("charge-1", uid)
+			if err != nil || r.Completed {
+				t.Errorf("Expected an expired key to be reserved again. Instead got: %+v, %v", r, err)
+			}
+			clock.Add(2 * time.Minute)
+			purged, err := is.PurgeExpired(context.Background())
+			if err != nil || purged != 3 {
+				t.Errorf("Expected 3 expired keys to be purged. Instead got: %d, %v", purged, err)
+			}
+		})
+	}
+}
+
+func TestIdempotencyNamespaces(t *testing.T) {
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := &testClock{}
+			s := newService(WithClock(clock))
+			defer s.Shutdown()
+			acme, err := NewIdempotencyService(mustService(ForTenant(s, "acme")), time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to create the IdempotencyService. Instead got the error: %v", err)
+			}
+			globex, err := NewIdempotencyService(mustService(ForTenant(s, "globex")), time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to create the IdempotencyService. Instead got the error: %v", err)
+			}
+			uid := uuid.NewV4()
+
+			r, err := acme.Begin("charge-1", uid)
+			if err != nil || r.Namespace != "acme" {
+				t.Fatalf("Expected acme to reserve the key. Instead got: %+v, %v", r, err)
+			}
+			err = acme.Complete("charge-1", uid, []byte("acme"))
+			if err != nil {
+				t.Fatalf("Expected acme to complete the key. Instead got the error: %v", err)
+			}
+			r, err = globex.Begin("charge-1", uid)
+			if err != nil || r.Completed {
+				t.Fatalf("Expected globex to reserve its own key. Instead got: %+v, %v", r, err)
+			}
+			err = globex.Complete("charge-1", uid, []byte("globex"))
+			if err != nil {
+				t.Fatalf("Expected globex to complete its key. Instead got the error: %v", err)
+			}
+			r, _ = acme.Begin("charge-1", uid)
+			if string(r.Result) != "acme" {
+				t.Errorf("Expected acme to get its own result back. Instead got: %q", r.Result)
+			}
+
+			_, err = globex.Begin("charge-2", uid)
+			if err != nil {
+				t.Fatalf("Expected globex to reserve the key. Instead got the error: %v", err)
+			}
+			err = acme.Abort("charge-2", uid)
+			if err != nil {
+				t.Fatalf("Expected acme's Abort to succeed. Instead got the error: %v", err)
+			}
+			_, err = globex.Begin("charge-2", uid)
+			if err != ErrKeyInProgress {
+				t.Errorf("Expected acme's Abort to leave globex's key alone. Instead got: %v", err)
+			}
+			err = acme.Complete("charge-2", uid, nil)
+			if err != ErrTokenNotFound {
+				t.Errorf("Expected acme not to complete globex's key. Instead got: %v", err)
+			}
+
+			clock.Add(2 * time.Minute)
+			purged, err := acme.PurgeExpired(context.Background())
+			if err != nil || purged != 1 {
+				t.Errorf("Expected acme to purge its 1 expired key. Instead got: %d, %v", purged, err)
+			}
+			purged, err = globex.PurgeExpired(context.Background())
+			if err != nil || purged != 2 {
+				t.Errorf("Expected globex to purge its 2 expired keys. Instead got: %d, %v", purged, err)
+			}
+		})
+	}
+}
+
+func TestIdempotencyConcurrentBegin(t *testing.T) {
+	s := NewInMemoryService()
+	defer s.Shutdown()
+	is, err := NewIdempotencyService(s, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to create the IdempotencyService. Instead got the error: %v", err)
+	}
+	uid := uuid.NewV4()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := is.Begin("transfer", uid)
+			if err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 1 {
+		t.Fatalf("Expected exactly one Begin to reserve the key. Instead got: %d", reserved)
+	}
+}
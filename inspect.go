@@ -0,0 +1,41 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	uuid "github.com/satori/go.uuid"
+)
+
+// Inspector is implemented by Services that can look up a single nonce
+// whatever its state, for admin tooling and debugging. Neither method
+// checks or changes the nonce; use Check before acting on one.
+type Inspector interface {
+	// GetByID returns the nonce with id or ErrTokenNotFound
+	GetByID(id uuid.UUID) (Nonce, error)
+	// GetByToken returns the nonce for token or ErrTokenNotFound
+	GetByToken(token string) (Nonce, error)
+}
+
+func (s *nonceInMemoryService) GetByID(id uuid.UUID) (Nonce, error) {
+	return s.getNonceByID(id)
+}
+
+func (s *nonceInMemoryService) GetByToken(token string) (Nonce, error) {
+	token, err := s.cfg.checkToken(token)
+	if err != nil {
+		return Nonce{}, err
+	}
+	return s.getNonce(token)
+}
@@ -0,0 +1,223 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command gendecorator writes a Service implementation that routes every
+// method through an Interceptor chain. It is run by go generate from the
+// nonce package so the decorator stays in step with the Service interface.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	in    = flag.String("in", "service.go", "file declaring the interface")
+	out   = flag.String("out", "decorator_gen.go", "file to write")
+	iface = flag.String("type", "Service", "interface to decorate")
+)
+
+func main() {
+	flag.Parse()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, *in, nil, parser.ParseComments)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	it := findInterface(f, *iface)
+	if it == nil {
+		log.Fatalf("interface %s not found in %s", *iface, *in)
+	}
+
+	used := make(map[string]bool)
+	var methods bytes.Buffer
+	for _, m := range it.Methods.List {
+		ft, ok := m.Type.(*ast.FuncType)
+		if !ok || len(m.Names) == 0 {
+			log.Fatalf("%s: embedded interfaces are not supported", *iface)
+		}
+		collectPackages(ft, used)
+		for _, name := range m.Names {
+			writeMethod(&methods, fset, name.Name, ft)
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by gendecorator; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\n", f.Name.Name)
+	// standard library imports go in the first group
+	var std, other bytes.Buffer
+	for _, imp := range f.Imports {
+		if !used[importName(imp)] {
+			continue
+		}
+		w := &other
+		if !strings.Contains(strings.SplitN(imp.Path.Value, "/", 2)[0], ".") {
+			w = &std
+		}
+		if imp.Name != nil {
+			fmt.Fprintf(w, "%s %s\n", imp.Name.Name, imp.Path.Value)
+		} else {
+			fmt.Fprintf(w, "%s\n", imp.Path.Value)
+		}
+	}
+	fmt.Fprintf(&src, "import (\n%s\n%s)\n\n", std.Bytes(), other.Bytes())
+	src.Write(methods.Bytes())
+
+	b, err := format.Source(src.Bytes())
+	if err != nil {
+		log.Fatalf("formatting generated code: %v\n%s", err, src.Bytes())
+	}
+	err = ioutil.WriteFile(*out, b, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func findInterface(f *ast.File, name string) *ast.InterfaceType {
+	for _, d := range f.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if it, ok := ts.Type.(*ast.InterfaceType); ok && ts.Name.Name == name {
+				return it
+			}
+		}
+	}
+	return nil
+}
+
+// collectPackages records the package names referenced by a method signature
+func collectPackages(ft *ast.FuncType, used map[string]bool) {
+	ast.Inspect(ft, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+func importName(imp *ast.ImportSpec) string {
+	if imp.Name != nil {
+		return imp.Name.Name
+	}
+	p, _ := strconv.Unquote(imp.Path.Value)
+	return path.Base(p)
+}
+
+type field struct {
+	name string
+	typ  string
+}
+
+func fields(fset *token.FileSet, fl *ast.FieldList, prefix string) []field {
+	var fs []field
+	if fl == nil {
+		return fs
+	}
+	for _, f := range fl.List {
+		var b bytes.Buffer
+		printer.Fprint(&b, fset, f.Type)
+		if len(f.Names) == 0 {
+			fs = append(fs, field{name: fmt.Sprintf("%s%d", prefix, len(fs)), typ: b.String()})
+			continue
+		}
+		for _, n := range f.Names {
+			fs = append(fs, field{name: n.Name, typ: b.String()})
+		}
+	}
+	return fs
+}
+
+func writeMethod(w *bytes.Buffer, fset *token.FileSet, name string, ft *ast.FuncType) {
+	params := fields(fset, ft.Params, "p")
+	results := fields(fset, ft.Results, "r")
+	for i := range results {
+		results[i].name = fmt.Sprintf("r%d", i)
+	}
+
+	// the last result is returned through the interceptor when it is an error
+	returnsErr := len(results) > 0 && results[len(results)-1].typ == "error"
+	if returnsErr {
+		results[len(results)-1].name = "err"
+	}
+
+	var sig, names, quoted, res, resNames []string
+	for _, p := range params {
+		sig = append(sig, p.name+" "+p.typ)
+		names = append(names, p.name)
+		quoted = append(quoted, strconv.Quote(p.name))
+	}
+	for _, r := range results {
+		res = append(res, r.typ)
+		resNames = append(resNames, r.name)
+	}
+
+	fmt.Fprintf(w, "func (d *decorated) %s(%s) ", name, strings.Join(sig, ", "))
+	if len(res) > 0 {
+		fmt.Fprintf(w, "(%s) ", strings.Join(res, ", "))
+	}
+	fmt.Fprintf(w, "{\n")
+	for _, r := range results {
+		if r.name != "err" {
+			fmt.Fprintf(w, "var %s %s\n", r.name, r.typ)
+		}
+	}
+
+	call := callLiteral(name, quoted, names)
+	assign := ""
+	if len(results) > 0 {
+		assign = strings.Join(resNames, ", ") + " = "
+	}
+	if returnsErr {
+		fmt.Fprintf(w, "err := d.intercept(%s, func() error {\n", call)
+		fmt.Fprintf(w, "var err error\n")
+		fmt.Fprintf(w, "%sd.next.%s(%s)\n", assign, name, strings.Join(names, ", "))
+		fmt.Fprintf(w, "return err\n")
+		fmt.Fprintf(w, "})\n")
+	} else {
+		fmt.Fprintf(w, "d.intercept(%s, func() error {\n", call)
+		fmt.Fprintf(w, "%sd.next.%s(%s)\n", assign, name, strings.Join(names, ", "))
+		fmt.Fprintf(w, "return nil\n")
+		fmt.Fprintf(w, "})\n")
+	}
+	if len(results) > 0 {
+		fmt.Fprintf(w, "return %s\n", strings.Join(resNames, ", "))
+	}
+	fmt.Fprintf(w, "}\n\n")
+}
+
+// callLiteral renders the Call literal handed to interceptors
+func callLiteral(method string, params, args []string) string {
+	return fmt.Sprintf("Call{Method: %q, Params: []string{%s}, Args: []interface{}{%s}}",
+		method, strings.Join(params, ", "), strings.Join(args, ", "))
+}
@@ -0,0 +1,53 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kvstore holds what the key-value backends, nonce/etcd,
+// nonce/cassandra and nonce/badger, share.
+package kvstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	// ErrUnchanged stops an update writing a nonce its fn left as it was
+	ErrUnchanged = errors.New("nonce: unchanged")
+	// ErrNotExtended stops an update extending a nonce that changed since it was listed
+	ErrNotExtended = errors.New("nonce: not extended")
+	// ErrNotEvicted stops an update invalidating a nonce that changed since it was listed
+	ErrNotEvicted = errors.New("nonce: not evicted")
+)
+
+// Evict is the update the key-value stores apply to evict a nonce
+func Evict(n nonce.Nonce) (nonce.Nonce, error) {
+	if n.IsValid == false || n.IsUsed == true {
+		return nonce.Nonce{}, ErrNotEvicted
+	}
+	n.IsValid = false
+	return n, nil
+}
+
+// UserNonces lists every nonce uid has in l
+func UserNonces(ctx context.Context, l nonce.Lister, uid uuid.UUID) ([]nonce.Nonce, error) {
+	var nonces []nonce.Nonce
+	err := l.List(ctx, nonce.Filter{UserID: uid}, func(n nonce.Nonce) error {
+		nonces = append(nonces, n)
+		return nil
+	})
+	return nonces, err
+}
@@ -0,0 +1,170 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// WithDebugJournal writes a line of JSON to w for every Service call, recording
+// the method, its sanitized arguments, the statements the backend issues for it,
+// how long it took and its outcome. Support engineers can use the journal to see
+// exactly what the service did while a problem was reported.
+// Tokens are shortened so the journal can't be used to redeem them.
+func WithDebugJournal(w io.Writer) Option {
+	return func(cfg *config) {
+		if w != nil {
+			cfg.journal = &journal{w: w}
+		}
+	}
+}
+
+// journal serialises entries onto its writer as NDJSON
+type journal struct {
+	sync.Mutex
+	w io.Writer
+}
+
+type journalEntry struct {
+	Time     time.Time              `json:"time"`
+	Method   string                 `json:"method"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+	Commands []string               `json:"commands,omitempty"`
+	Duration string                 `json:"duration"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+// Commander is implemented by backends that can say which statements a method
+// issues, for WithDebugJournal
+type Commander interface {
+	Commands(method string) []string
+}
+
+// wrap returns s decorated with the counters, journal and limits that are configured
+func (c config) wrap(s Service) Service {
+	var interceptors []Interceptor
+	if c.counters != nil {
+		interceptors = append(interceptors, c.counters.interceptor())
+	}
+	if c.userRequired {
+		interceptors = append(interceptors, requireUser)
+	}
+	if c.journal != nil {
+		interceptors = append(interceptors, c.journal.interceptor(s, c.clock, c.sampling))
+	}
+	if c.attempts != nil {
+		interceptors = append(interceptors, c.attempts.interceptor(s, c.clock, c.checkToken))
+	}
+	if c.rateLimit != nil {
+		interceptors = append(interceptors, c.rateLimit.interceptor(c.clock))
+	}
+	if c.outstanding.enabled() {
+		interceptors = append(interceptors, c.outstanding.interceptor(s))
+	}
+	if len(interceptors) == 0 {
+		return s
+	}
+	d := Decorate(s, interceptors...).(*decorated)
+	d.options = true
+	return d
+}
+
+func (j *journal) interceptor(s Service, clock Clock, sampling SampleRates) Interceptor {
+	cmd, _ := s.(Commander)
+	return func(c Call, next func() error) error {
+		start := clock.Now()
+		err := next()
+		if !sampling.keep(c.Method, err) {
+			return err
+		}
+
+		e := journalEntry{
+			Time:     start,
+			Method:   c.Method,
+			Args:     sanitizeArgs(c),
+			Duration: clock.Now().Sub(start).String(),
+		}
+		if cmd != nil {
+			e.Commands = cmd.Commands(c.Method)
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		j.write(e)
+
+		return err
+	}
+}
+
+func (j *journal) write(e journalEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	j.Lock()
+	j.w.Write(append(b, '\n'))
+	j.Unlock()
+}
+
+// sanitizeArgs turns call arguments into JSON friendly values, shortening tokens
+// and session IDs
+func sanitizeArgs(c Call) map[string]interface{} {
+	if len(c.Params) == 0 {
+		return nil
+	}
+	args := make(map[string]interface{}, len(c.Params))
+	for i, p := range c.Params {
+		var v interface{}
+		if i < len(c.Args) {
+			v = c.Args[i]
+		}
+		switch a := v.(type) {
+		case string:
+			if p == "token" {
+				v = redactToken(a)
+			}
+		case Nonce:
+			v = redactToken(a.Token)
+		case ConsumeMeta:
+			if a.SessionID != "" {
+				a.SessionID = redactToken(a.SessionID)
+			}
+			v = a
+		case Binding:
+			if a.SessionID != "" {
+				a.SessionID = redactToken(a.SessionID)
+			}
+			v = a
+		case time.Duration:
+			v = a.String()
+		case fmt.Stringer:
+			v = a.String()
+		}
+		args[p] = v
+	}
+	return args
+}
+
+// redactToken keeps just enough of a token to tell tokens apart in a journal
+func redactToken(token string) string {
+	if len(token) <= 8 {
+		return fmt.Sprintf("[%d chars]", len(token))
+	}
+	return fmt.Sprintf("%s...[%d chars]", token[:6], len(token))
+}
@@ -0,0 +1,64 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDebugJournal(t *testing.T) {
+	var b bytes.Buffer
+	s := NewInMemoryService(WithDebugJournal(&b))
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	_, err = s.Consume(n.Token)
+	if err != nil {
+		t.Fatalf("Expected token to be consumed. Instead got the error: %v", err)
+	}
+	_, err = s.Consume(n.Token)
+	if !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("Expected ErrTokenUsed. Instead got: %v", err)
+	}
+	s.Shutdown()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(&b)
+	for scanner.Scan() {
+		e := journalEntry{}
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			t.Fatalf("Expected journal line to be JSON. Instead got the error: %v", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 journal entries. Instead got: %d", len(entries))
+	}
+	if entries[1].Method != "Consume" || entries[2].Error != ErrTokenUsed.Error() {
+		t.Fatalf("Expected journal to record consumes and their outcome. Instead got: %+v", entries)
+	}
+	if token := entries[1].Args["token"].(string); strings.Contains(token, n.Token) {
+		t.Fatalf("Expected token to be redacted in the journal. Instead got: %s", token)
+	}
+}
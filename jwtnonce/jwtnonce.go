@@ -0,0 +1,122 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jwtnonce carries nonces in JWTs for services that already pass JWTs
+// around. The nonce's token is the jti claim, its action the "action" claim
+// and its user the sub claim, with exp set to when it expires:
+//
+//	{"action":"reset","sub":"...","exp":1700000900,"iat":1700000000,"jti":"..."}
+//
+// The JWT's signature only shows it was issued by a Signer holder; replay
+// protection still comes from consuming the nonce, so verify JWTs with
+// VerifyJWTAndConsume rather than FromJWT alone.
+package jwtnonce
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	jwt "github.com/golang-jwt/jwt/v5"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Signer signs and verifies JWTs
+type Signer struct {
+	// Method signs the JWTs, e.g. jwt.SigningMethodHS256. JWTs signed with
+	// any other method are rejected.
+	Method jwt.SigningMethod
+
+	// Key signs the JWTs. VerifyKey verifies them, e.g. the public key for an
+	// asymmetric Method; nil uses Key.
+	Key, VerifyKey interface{}
+
+	// Issuer is the iss claim. When it is set, JWTs from other issuers are rejected.
+	Issuer string
+}
+
+// Claims are a nonce's JWT claims
+type Claims struct {
+	Action string `json:"action"`
+	jwt.RegisteredClaims
+}
+
+// UserID is the sub claim, or uuid.Nil if it is empty
+func (c Claims) UserID() (uuid.UUID, error) {
+	if c.Subject == "" {
+		return uuid.Nil, nil
+	}
+	return uuid.FromString(c.Subject)
+}
+
+// ToJWT returns n, as returned by New, as a JWT signed by s
+func ToJWT(n nonce.Nonce, s Signer) (string, error) {
+	c := Claims{
+		Action: n.Action,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        n.Token,
+			Issuer:    s.Issuer,
+			ExpiresAt: jwt.NewNumericDate(n.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Unix(n.CreatedAt, 0)),
+		},
+	}
+	if !uuid.Equal(n.UserID, uuid.Nil) {
+		c.Subject = n.UserID.String()
+	}
+	return jwt.NewWithClaims(s.Method, c).SignedString(s.Key)
+}
+
+// FromJWT verifies token's signature and returns its claims. It doesn't check
+// the nonce, so the same JWT is accepted until it expires. An expired JWT gets
+// nonce.ErrTokenExpired, and one that doesn't parse, isn't signed by s or has
+// no jti nonce.ErrInvalidToken, so middleware.Status handles both.
+func FromJWT(token string, s Signer) (Claims, error) {
+	key := s.VerifyKey
+	if key == nil {
+		key = s.Key
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{s.Method.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if s.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(s.Issuer))
+	}
+
+	var c Claims
+	_, err := jwt.ParseWithClaims(token, &c, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}, opts...)
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return Claims{}, nonce.ErrTokenExpired
+	case err != nil, c.ID == "":
+		return Claims{}, nonce.ErrInvalidToken
+	}
+	return c, nil
+}
+
+// VerifyJWTAndConsume verifies token as FromJWT does, then consumes the nonce
+// it carries on svc with CheckThenConsume, so each JWT is accepted only once
+func VerifyJWTAndConsume(svc nonce.Service, token string, s Signer) (nonce.Nonce, error) {
+	c, err := FromJWT(token, s)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	uid, err := c.UserID()
+	if err != nil {
+		return nonce.Nonce{}, nonce.ErrInvalidToken
+	}
+	return svc.CheckThenConsume(c.ID, c.Action, uid)
+}
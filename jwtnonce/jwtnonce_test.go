@@ -0,0 +1,100 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jwtnonce
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	jwt "github.com/golang-jwt/jwt/v5"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestVerifyJWTAndConsume(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	signer := Signer{Method: jwt.SigningMethodHS256, Key: []byte("shh"), Issuer: "example.com"}
+	uid := uuid.NewV4()
+
+	n, err := s.New("reset", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to create a nonce. Instead got the error: %v", err)
+	}
+	token, err := ToJWT(n, signer)
+	if err != nil {
+		t.Fatalf("Expected to sign the nonce. Instead got the error: %v", err)
+	}
+
+	c, err := FromJWT(token, signer)
+	if err != nil || c.ID != n.Token || c.Action != "reset" || c.Subject != uid.String() || c.Issuer != "example.com" {
+		t.Fatalf("Expected the nonce's claims. Instead got: %+v %v", c, err)
+	}
+	if !c.ExpiresAt.Time.Equal(n.ExpiresAt.Truncate(time.Second)) {
+		t.Errorf("Expected exp to be when the nonce expires. Instead got: %v", c.ExpiresAt)
+	}
+
+	got, err := VerifyJWTAndConsume(s, token, signer)
+	if err != nil || !uuid.Equal(got.ID, n.ID) {
+		t.Fatalf("Expected to consume the nonce. Instead got: %+v %v", got, err)
+	}
+	_, err = VerifyJWTAndConsume(s, token, signer)
+	if !errors.Is(err, nonce.ErrTokenUsed) {
+		t.Errorf("Expected a replayed JWT to get %v. Instead got: %v", nonce.ErrTokenUsed, err)
+	}
+}
+
+func TestFromJWTRejects(t *testing.T) {
+	signer := Signer{Method: jwt.SigningMethodHS256, Key: []byte("shh"), Issuer: "example.com"}
+	n := nonce.Nonce{Token: "token", Action: "reset", ExpiresAt: time.Now().Add(time.Minute)}
+	token, _ := ToJWT(n, signer)
+
+	other := signer
+	other.Key = []byte("other")
+	forged, _ := ToJWT(n, other)
+	other = signer
+	other.Issuer = "other.com"
+	foreign, _ := ToJWT(n, other)
+	other = signer
+	other.Method = jwt.SigningMethodHS512
+	method, _ := ToJWT(n, other)
+	noID := n
+	noID.Token = ""
+	empty, _ := ToJWT(noID, signer)
+	expired := n
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	old, _ := ToJWT(expired, signer)
+
+	tests := map[string]struct {
+		token string
+		err   error
+	}{
+		"forged":    {forged, nonce.ErrInvalidToken},
+		"issuer":    {foreign, nonce.ErrInvalidToken},
+		"method":    {method, nonce.ErrInvalidToken},
+		"no jti":    {empty, nonce.ErrInvalidToken},
+		"tampered":  {strings.Replace(token, ".", ".x", 1), nonce.ErrInvalidToken},
+		"malformed": {"not.a.jwt", nonce.ErrInvalidToken},
+		"expired":   {old, nonce.ErrTokenExpired},
+	}
+	for name, tt := range tests {
+		_, err := FromJWT(tt.token, signer)
+		if err != tt.err {
+			t.Errorf("%s: Expected %v. Instead got: %v", name, tt.err, err)
+		}
+	}
+}
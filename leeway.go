@@ -0,0 +1,36 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import "time"
+
+// WithExpiryLeeway keeps accepting a nonce for d after its ExpiresAt, so one
+// checked on a server whose clock runs slightly ahead of the server that
+// created it isn't rejected as expired early. It applies wherever a nonce is
+// checked, consumed, renewed or found with Get, and expired nonces are kept
+// until the leeway runs out. MongoDB's TTL index still removes nonces at
+// expires_at. ExpiresAt itself is unchanged.
+func WithExpiryLeeway(d time.Duration) Option {
+	return func(cfg *config) {
+		if d > 0 {
+			cfg.expiryLeeway = d
+		}
+	}
+}
+
+// expiryCutoff is the ExpiresAt a nonce must be after to be accepted at t
+func (c config) expiryCutoff(t time.Time) time.Time {
+	return t.Add(-c.expiryLeeway)
+}
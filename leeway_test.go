@@ -0,0 +1,85 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestExpiryLeeway(t *testing.T) {
+	interval := RemoveExpiredInterval
+	RemoveExpiredInterval = time.Hour
+	defer func() { RemoveExpiredInterval = interval }()
+
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := &testClock{}
+			s := newService(WithClock(clock), WithExpiryLeeway(10*time.Second))
+			defer s.Shutdown()
+
+			uid := uuid.NewV4()
+			n, err := s.New("login", uid, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+			}
+			other, err := s.New("reset", uid, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+			}
+			clock.Add(65 * time.Second)
+
+			err = s.Check(n.Token, "login", uid)
+			if err != nil {
+				t.Fatalf("Expected Check to accept a nonce expired within the leeway. Instead got: %v", err)
+			}
+			_, err = s.Get("login", uid)
+			if err != nil {
+				t.Fatalf("Expected Get to find a nonce expired within the leeway. Instead got: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			_, err = s.(Awaiter).AwaitConsumption(ctx, other.ID)
+			cancel()
+			if err != context.DeadlineExceeded {
+				t.Fatalf("Expected AwaitConsumption to keep waiting on a nonce expired within the leeway. Instead got: %v", err)
+			}
+			purged, err := s.(Purger).PurgeExpired(context.Background(), 0)
+			if err != nil || purged != 0 {
+				t.Fatalf("Expected nonces within the leeway to be kept. Instead got: %d, %v", purged, err)
+			}
+			_, err = s.CheckThenConsume(n.Token, "login", uid)
+			if err != nil {
+				t.Fatalf("Expected CheckThenConsume to accept a nonce expired within the leeway. Instead got: %v", err)
+			}
+
+			clock.Add(10 * time.Second)
+			err = s.Check(other.Token, "reset", uid)
+			if !errors.Is(err, ErrTokenExpired) {
+				t.Fatalf("Expected ErrTokenExpired once the leeway ran out. Instead got: %v", err)
+			}
+			_, err = s.(Awaiter).AwaitConsumption(context.Background(), other.ID)
+			if err != ErrTokenExpired {
+				t.Fatalf("Expected AwaitConsumption to return ErrTokenExpired once the leeway ran out. Instead got: %v", err)
+			}
+		})
+	}
+}
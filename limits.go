@@ -0,0 +1,70 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+// Limits caps the size in bytes of values a Service stores.
+// Writes over a limit fail with ErrPayloadTooLarge before reaching the backend,
+// instead of failing inside a driver or being silently truncated by a column.
+// A zero field is unlimited.
+type Limits struct {
+	Action int
+	Token  int
+	// Meta caps each field of a ConsumeMeta
+	Meta int
+	// Payload caps Nonce.Payload
+	Payload int
+}
+
+// DefaultLimits fit the columns of the bundled schema
+var DefaultLimits = Limits{
+	Action:  255,
+	Token:   128,
+	Meta:    512,
+	Payload: 16384,
+}
+
+// WithLimits sets the Limits a Service enforces
+func WithLimits(l Limits) Option {
+	return func(cfg *config) {
+		cfg.limits = l
+	}
+}
+
+// checkLimits returns ErrPayloadTooLarge if n doesn't fit the configured Limits
+func (c config) checkLimits(n Nonce) error {
+	if c.limits.Action > 0 && len(n.Action) > c.limits.Action {
+		return ErrPayloadTooLarge
+	}
+	if c.limits.Token > 0 && len(n.Token) > c.limits.Token {
+		return ErrPayloadTooLarge
+	}
+	return c.checkPayload(n.Payload)
+}
+
+// checkPayload returns ErrPayloadTooLarge if payload is over the Payload limit
+func (c config) checkPayload(payload string) error {
+	if c.limits.Payload > 0 && len(payload) > c.limits.Payload {
+		return ErrPayloadTooLarge
+	}
+	return nil
+}
+
+// checkMeta returns ErrPayloadTooLarge if a field of meta is over the Meta limit
+func (c config) checkMeta(meta ConsumeMeta) error {
+	if c.limits.Meta > 0 && (len(meta.IP) > c.limits.Meta || len(meta.UserAgent) > c.limits.Meta || len(meta.SessionID) > c.limits.Meta) {
+		return ErrPayloadTooLarge
+	}
+	return nil
+}
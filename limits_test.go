@@ -0,0 +1,41 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	s := NewInMemoryService()
+	defer s.Shutdown()
+
+	_, err := s.New(strings.Repeat("a", DefaultLimits.Action+1), tNonce.UserID, tNonce.ExpiresIn)
+	if err != ErrPayloadTooLarge {
+		t.Fatalf("Expected ErrPayloadTooLarge. Instead got: %v", err)
+	}
+	_, err = s.(Putter).PutNonce(Nonce{Token: strings.Repeat("a", DefaultLimits.Token+1), Action: tNonce.Action})
+	if err != ErrPayloadTooLarge {
+		t.Fatalf("Expected ErrPayloadTooLarge. Instead got: %v", err)
+	}
+
+	s = NewInMemoryService(WithLimits(Limits{}))
+	defer s.Shutdown()
+	_, err = s.New(strings.Repeat("a", DefaultLimits.Action+1), tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected zero Limits to be unlimited. Instead got the error: %v", err)
+	}
+}
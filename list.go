@@ -0,0 +1,127 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// ListChunkSize is how many nonces List reads per chunk.
+// Cancellation is checked between chunks, so it bounds how much work a
+// cancelled scan can still do. It is read when a Service is created.
+var ListChunkSize = 500
+
+// Filter selects nonces for List. The zero Filter matches every nonce.
+type Filter struct {
+	// Action and UserID match exactly when set
+	Action string
+	UserID uuid.UUID
+
+	// Outstanding only matches valid, unused nonces that haven't expired
+	Outstanding bool
+
+	// Consumed only matches nonces that have been used
+	Consumed bool
+
+	// ExpiredBefore only matches nonces that expired before it when set
+	ExpiredBefore time.Time
+
+	// Deleted only matches the nonces sqlx.WithSoftDelete tombstoned, which are
+	// skipped otherwise
+	Deleted bool
+
+	// namespace is set by List to the Service's WithNamespace namespace.
+	// anyNamespace matches every namespace instead, for purges.
+	namespace    string
+	anyNamespace bool
+}
+
+// Matches reports whether n is selected by f at time t
+func (f Filter) Matches(n Nonce, t time.Time) bool {
+	if !f.anyNamespace && n.Namespace != f.namespace {
+		return false
+	}
+	if f.Action != "" && n.Action != f.Action {
+		return false
+	}
+	if f.UserID != uuid.Nil && n.UserID != f.UserID {
+		return false
+	}
+	if f.Outstanding && (!n.IsValid || n.IsUsed || !n.ExpiresAt.After(t)) {
+		return false
+	}
+	if f.Consumed && !n.IsUsed {
+		return false
+	}
+	if !f.ExpiredBefore.IsZero() && !n.ExpiresAt.Before(f.ExpiredBefore) {
+		return false
+	}
+	if f.Deleted != (n.DeletedAt != 0) {
+		return false
+	}
+	return true
+}
+
+// Namespace returns the namespace a Service's List limited f to, or false if
+// f matches every namespace. Backends that can't use Matches filter by it.
+func (f Filter) Namespace() (string, bool) {
+	return f.namespace, !f.anyNamespace
+}
+
+// Lister is implemented by Services that can scan their stored nonces
+type Lister interface {
+	// List calls fn for every nonce matching f, oldest first. The in-memory store
+	// lists shard by shard, in insertion order within each, so it never has to sort,
+	// and the etcd store lists in token order. It reads in chunks
+	// of ListChunkSize and stops between chunks once ctx is done, returning ctx.Err().
+	// An error from fn also stops the scan and is returned.
+	List(ctx context.Context, f Filter, fn func(Nonce) error) error
+}
+
+func (s *nonceInMemoryService) List(ctx context.Context, f Filter, fn func(Nonce) error) error {
+	t := s.cfg.clock.Now()
+	f = s.cfg.scope(f)
+	return s.store.scan(ctx, s.cfg.listChunkSize, func(n Nonce) error {
+		if !f.Matches(n, t) {
+			return nil
+		}
+		return fn(n)
+	})
+}
+
+// GetAll returns every nonce stored for action and uid, whatever its state,
+// newest first. Get only returns the newest usable one. Like Get it returns
+// ErrUserRequired for uuid.Nil.
+func GetAll(ctx context.Context, l Lister, action string, uid uuid.UUID) ([]Nonce, error) {
+	if uid == uuid.Nil {
+		return nil, ErrUserRequired
+	}
+	var nonces []Nonce
+	err := l.List(ctx, Filter{Action: action, UserID: uid}, func(n Nonce) error {
+		nonces = append(nonces, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(nonces, func(i, j int) bool {
+		return nonces[i].CreatedAt > nonces[j].CreatedAt
+	})
+	return nonces, nil
+}
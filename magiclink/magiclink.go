@@ -0,0 +1,201 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package magiclink creates signed one-time login URLs, e.g. for emailing to
+// a user who has forgotten their password, and logs users in when they follow
+// them. A link carries a nonce for the user, the user's ID and where to
+// redirect to afterwards, signed with an HMAC-SHA256 so none of them can be
+// changed:
+//
+//	https://example.com/login?token=...&uid=...&redirect=%2Finbox&sig=...
+//
+// Issue links with Links.URL and serve BaseURL with the Links as an http.Handler.
+package magiclink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/middleware"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	// ErrInvalidLink is returned for a link that is missing a parameter or
+	// whose signature doesn't match
+	ErrInvalidLink = errors.New("magiclink: invalid link")
+
+	// ErrInvalidRedirect is returned for a redirect that isn't a path on this site
+	ErrInvalidRedirect = errors.New("magiclink: invalid redirect")
+)
+
+// Config configures Links
+type Config struct {
+	// Service stores the login nonces
+	Service nonce.Service
+
+	// BaseURL is where the Links are served, e.g. "https://example.com/login"
+	BaseURL string
+
+	// Secret keys the link signatures
+	Secret []byte
+
+	// Action the nonces are created for. Empty uses "login".
+	Action string
+
+	// TTL is how long a link works for. Zero uses 15 minutes.
+	TTL time.Duration
+
+	// Reusable lets a link log in again until it expires instead of only once.
+	// Creating a link still replaces the user's last one.
+	Reusable bool
+
+	// Login starts a session for uid, e.g. by setting a cookie, once a link
+	// has been verified. The user is then redirected unless it returns an error.
+	Login func(w http.ResponseWriter, r *http.Request, uid uuid.UUID) error
+
+	// Failed writes the response when a link is rejected or Login fails.
+	// nil writes the status middleware.Status gives, or 400 Bad Request for
+	// ErrInvalidLink.
+	Failed func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// Links issues login links and is the http.Handler that verifies them
+type Links struct {
+	cfg Config
+}
+
+// New returns Links for cfg
+func New(cfg Config) *Links {
+	if cfg.Action == "" {
+		cfg.Action = "login"
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 15 * time.Minute
+	}
+	if cfg.Failed == nil {
+		cfg.Failed = failed
+	}
+	return &Links{cfg: cfg}
+}
+
+// URL creates a nonce for uid and returns the link that logs them in with it,
+// then sends them to redirect. redirect must be a path on this site, such as
+// "/inbox", or empty for "/".
+func (l *Links) URL(uid uuid.UUID, redirect string) (string, error) {
+	if redirect == "" {
+		redirect = "/"
+	}
+	if !localPath(redirect) {
+		return "", ErrInvalidRedirect
+	}
+	n, err := l.cfg.Service.New(l.cfg.Action, uid, l.cfg.TTL)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("token", n.Token)
+	q.Set("uid", uid.String())
+	q.Set("redirect", redirect)
+	q.Set("sig", l.sign(n.Token, uid.String(), redirect))
+	sep := "?"
+	if strings.Contains(l.cfg.BaseURL, "?") {
+		sep = "&"
+	}
+	return l.cfg.BaseURL + sep + q.Encode(), nil
+}
+
+// Verify checks the link r was made for and, unless the Links are Reusable or
+// r is a HEAD, e.g. from a mail client prefetching links, consumes its nonce. It returns the user the link logs in and where to send
+// them next.
+func (l *Links) Verify(r *http.Request) (uuid.UUID, string, error) {
+	q := r.URL.Query()
+	token, redirect := q.Get("token"), q.Get("redirect")
+	uid, err := uuid.FromString(q.Get("uid"))
+	if err != nil || token == "" || !localPath(redirect) {
+		return uuid.Nil, "", ErrInvalidLink
+	}
+	sig := l.sign(token, uid.String(), redirect)
+	if !hmac.Equal([]byte(sig), []byte(q.Get("sig"))) {
+		return uuid.Nil, "", ErrInvalidLink
+	}
+
+	if l.cfg.Reusable || r.Method == http.MethodHead {
+		err = l.cfg.Service.Check(token, l.cfg.Action, uid)
+	} else {
+		_, err = l.cfg.Service.CheckThenConsume(token, l.cfg.Action, uid)
+	}
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return uid, redirect, nil
+}
+
+// ServeHTTP verifies the link r was made for, logs its user in with Login and
+// redirects them with 303 See Other. A HEAD only checks the link, answering
+// 200 OK without logging anyone in, so it doesn't use the link up.
+func (l *Links) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	uid, redirect, err := l.Verify(r)
+	if err == nil && r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err == nil && l.cfg.Login != nil {
+		err = l.cfg.Login(w, r, uid)
+	}
+	if err != nil {
+		l.cfg.Failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// sign returns the URL-safe HMAC-SHA256 of a link's parameters
+func (l *Links) sign(token, uid, redirect string) string {
+	mac := hmac.New(sha256.New, l.cfg.Secret)
+	mac.Write([]byte(l.cfg.Action + "\n" + token + "\n" + uid + "\n" + redirect))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// localPath reports whether p is an absolute path on this site. "//host" and
+// "/\host" are taken by browsers as other sites.
+func localPath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return false
+	}
+	u, err := url.Parse(p)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+func failed(w http.ResponseWriter, r *http.Request, err error) {
+	status := middleware.Status(err)
+	if err == ErrInvalidLink {
+		status = http.StatusBadRequest
+	}
+	http.Error(w, http.StatusText(status), status)
+}
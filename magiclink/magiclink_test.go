@@ -0,0 +1,127 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package magiclink
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestLinks(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	var loggedIn uuid.UUID
+	l := New(Config{
+		Service: s,
+		BaseURL: "https://example.com/login",
+		Secret:  []byte("shh"),
+		TTL:     time.Minute,
+		Login: func(w http.ResponseWriter, r *http.Request, uid uuid.UUID) error {
+			loggedIn = uid
+			return nil
+		},
+	})
+	uid := uuid.NewV4()
+
+	link, err := l.URL(uid, "/inbox?folder=1")
+	if err != nil {
+		t.Fatalf("Expected to create a link. Instead got the error: %v", err)
+	}
+	w := serve(l, link)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/inbox?folder=1" || loggedIn != uid {
+		t.Fatalf("Expected the link to log the user in and redirect. Instead got: %d %q %v", w.Code, w.Header().Get("Location"), loggedIn)
+	}
+	w = serve(l, link)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected a used link to get %d. Instead got: %d", http.StatusConflict, w.Code)
+	}
+
+	link, _ = l.URL(uid, "")
+	u, _ := url.Parse(link)
+	q := u.Query()
+	q.Set("redirect", "/admin")
+	u.RawQuery = q.Encode()
+	w = serve(l, u.String())
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a link with a changed redirect to get %d. Instead got: %d", http.StatusBadRequest, w.Code)
+	}
+
+	for _, redirect := range []string{"https://evil.example", "//evil.example", "/\\evil.example", "inbox"} {
+		_, err = l.URL(uid, redirect)
+		if err != ErrInvalidRedirect {
+			t.Errorf("Expected redirect %q to be rejected with %v. Instead got: %v", redirect, ErrInvalidRedirect, err)
+		}
+	}
+}
+
+func TestHeadLeavesLinkUnused(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	var loggedIn uuid.UUID
+	l := New(Config{
+		Service: s,
+		BaseURL: "/login",
+		Secret:  []byte("shh"),
+		Login: func(w http.ResponseWriter, r *http.Request, uid uuid.UUID) error {
+			loggedIn = uid
+			return nil
+		},
+	})
+	uid := uuid.NewV4()
+
+	link, err := l.URL(uid, "/")
+	if err != nil {
+		t.Fatalf("Expected to create a link. Instead got the error: %v", err)
+	}
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest(http.MethodHead, link, nil))
+	if w.Code != http.StatusOK || loggedIn != uuid.Nil {
+		t.Fatalf("Expected a HEAD to only check the link. Instead got: %d %v", w.Code, loggedIn)
+	}
+	w = serve(l, link)
+	if w.Code != http.StatusSeeOther || loggedIn != uid {
+		t.Errorf("Expected a GET after a HEAD to log the user in. Instead got: %d %v", w.Code, loggedIn)
+	}
+}
+
+func TestReusableLinks(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	l := New(Config{Service: s, BaseURL: "/login", Secret: []byte("shh"), Reusable: true})
+	uid := uuid.NewV4()
+
+	link, err := l.URL(uid, "/")
+	if err != nil {
+		t.Fatalf("Expected to create a link. Instead got the error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		w := serve(l, link)
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("Expected a reusable link to work again. Instead got: %d", w.Code)
+		}
+	}
+}
+
+func serve(h http.Handler, link string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, link, nil))
+	return w
+}
@@ -0,0 +1,268 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// nonceJSON is how a Nonce looks in JSON
+type nonceJSON struct {
+	ID                uuid.UUID `json:"id"`
+	UserID            uuid.UUID `json:"user_id"`
+	Token             string    `json:"token"`
+	Action            string    `json:"action"`
+	Salt              string    `json:"salt,omitempty"`
+	IsUsed            bool      `json:"is_used"`
+	IsValid           bool      `json:"is_valid"`
+	CreatedAt         string    `json:"created_at"`
+	ExpiresAt         string    `json:"expires_at"`
+	ConsumedAt        string    `json:"consumed_at,omitempty"`
+	ConsumedIP        string    `json:"consumed_ip,omitempty"`
+	ConsumedUserAgent string    `json:"consumed_user_agent,omitempty"`
+	Binding           string    `json:"binding,omitempty"`
+	ParentID          string    `json:"parent_id,omitempty"`
+	Namespace         string    `json:"namespace,omitempty"`
+	Payload           string    `json:"payload,omitempty"`
+}
+
+// MarshalJSON encodes n with snake_case keys and RFC 3339 times in UTC.
+// Salt and Binding are left out; use JSONWithSalt when the receiver needs them.
+func (n Nonce) MarshalJSON() ([]byte, error) {
+	j := n.toJSON()
+	j.Salt, j.Binding = "", ""
+	return json.Marshal(j)
+}
+
+// JSONWithSalt is MarshalJSON but includes Salt and Binding, e.g. to copy nonces between stores
+func (n Nonce) JSONWithSalt() ([]byte, error) {
+	return json.Marshal(n.toJSON())
+}
+
+// UnmarshalJSON decodes what MarshalJSON or JSONWithSalt produced
+func (n *Nonce) UnmarshalJSON(b []byte) error {
+	var j nonceJSON
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+
+	out := Nonce{
+		ID:                j.ID,
+		UserID:            j.UserID,
+		Token:             j.Token,
+		Action:            j.Action,
+		Salt:              j.Salt,
+		IsUsed:            j.IsUsed,
+		IsValid:           j.IsValid,
+		ConsumedIP:        j.ConsumedIP,
+		ConsumedUserAgent: j.ConsumedUserAgent,
+		Binding:           j.Binding,
+		Namespace:         j.Namespace,
+		Payload:           j.Payload,
+	}
+	if j.ParentID != "" {
+		out.ParentID, err = uuid.FromString(j.ParentID)
+		if err != nil {
+			return err
+		}
+	}
+	if j.CreatedAt != "" {
+		t, err := time.Parse(time.RFC3339, j.CreatedAt)
+		if err != nil {
+			return err
+		}
+		out.CreatedAt = t.Unix()
+	}
+	if j.ExpiresAt != "" {
+		out.ExpiresAt, err = time.Parse(time.RFC3339, j.ExpiresAt)
+		if err != nil {
+			return err
+		}
+	}
+	if j.ConsumedAt != "" {
+		t, err := time.Parse(time.RFC3339, j.ConsumedAt)
+		if err != nil {
+			return err
+		}
+		out.ConsumedAt = t.Unix()
+	}
+	*n = out
+	return nil
+}
+
+func (n Nonce) toJSON() nonceJSON {
+	j := nonceJSON{
+		ID:                n.ID,
+		UserID:            n.UserID,
+		Token:             n.Token,
+		Action:            n.Action,
+		Salt:              n.Salt,
+		IsUsed:            n.IsUsed,
+		IsValid:           n.IsValid,
+		CreatedAt:         time.Unix(n.CreatedAt, 0).UTC().Format(time.RFC3339),
+		ExpiresAt:         n.ExpiresAt.UTC().Format(time.RFC3339),
+		ConsumedIP:        n.ConsumedIP,
+		ConsumedUserAgent: n.ConsumedUserAgent,
+		Binding:           n.Binding,
+		Namespace:         n.Namespace,
+		Payload:           n.Payload,
+	}
+	if n.ParentID != uuid.Nil {
+		j.ParentID = n.ParentID.String()
+	}
+	if n.ConsumedAt != 0 {
+		j.ConsumedAt = time.Unix(n.ConsumedAt, 0).UTC().Format(time.RFC3339)
+	}
+	return j
+}
+
+// nonceBinaryVersion is the first byte of MarshalBinary's output. Bound
+// nonces are written as nonceBinaryBound, with their Binding at the end, so
+// unbound nonces encode as they did before bindings. Chained nonces are
+// written as nonceBinaryChained, with their Binding, even if it is empty,
+// and then their ParentID. Namespaced nonces are written as
+// nonceBinaryNamespaced, which is nonceBinaryChained followed by the Namespace,
+// and nonces with a Payload as nonceBinaryPayload, which adds the Payload.
+const (
+	nonceBinaryVersion    = 1
+	nonceBinaryBound      = 2
+	nonceBinaryChained    = 3
+	nonceBinaryNamespaced = 4
+	nonceBinaryPayload    = 5
+)
+
+var errNonceEncoding = errors.New("nonce: invalid binary encoding")
+
+// MarshalBinary encodes every field of n, Salt included, compactly enough to
+// cache nonces in a store such as Redis. UnmarshalBinary reverses it exactly.
+func (n Nonce) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 80+len(n.Token)+len(n.Action)+len(n.Salt)+len(n.Payload))
+	version := byte(nonceBinaryVersion)
+	switch {
+	case n.Payload != "":
+		version = nonceBinaryPayload
+	case n.Namespace != "":
+		version = nonceBinaryNamespaced
+	case n.ParentID != uuid.Nil:
+		version = nonceBinaryChained
+	case n.Binding != "":
+		version = nonceBinaryBound
+	}
+	b = append(b, version)
+	b = append(b, n.ID.Bytes()...)
+	b = append(b, n.UserID.Bytes()...)
+	var flags byte
+	if n.IsUsed {
+		flags |= 1
+	}
+	if n.IsValid {
+		flags |= 2
+	}
+	b = append(b, flags)
+	b = binary.AppendVarint(b, n.CreatedAt)
+	b = binary.AppendVarint(b, n.ExpiresAt.Unix())
+	b = binary.AppendVarint(b, int64(n.ExpiresAt.Nanosecond()))
+	b = binary.AppendVarint(b, n.ConsumedAt)
+	strs := []string{n.Token, n.Action, n.Salt, n.ConsumedIP, n.ConsumedUserAgent}
+	if version != nonceBinaryVersion {
+		strs = append(strs, n.Binding)
+	}
+	for _, s := range strs {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	if version >= nonceBinaryChained {
+		b = append(b, n.ParentID.Bytes()...)
+	}
+	if version >= nonceBinaryNamespaced {
+		b = binary.AppendUvarint(b, uint64(len(n.Namespace)))
+		b = append(b, n.Namespace...)
+	}
+	if version == nonceBinaryPayload {
+		b = binary.AppendUvarint(b, uint64(len(n.Payload)))
+		b = append(b, n.Payload...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes what MarshalBinary produced. ExpiresAt comes back in UTC.
+func (n *Nonce) UnmarshalBinary(b []byte) error {
+	if len(b) < 34 || b[0] < nonceBinaryVersion || b[0] > nonceBinaryPayload {
+		return errNonceEncoding
+	}
+	version := b[0]
+	out := Nonce{}
+	copy(out.ID[:], b[1:17])
+	copy(out.UserID[:], b[17:33])
+	out.IsUsed = b[33]&1 != 0
+	out.IsValid = b[33]&2 != 0
+	b = b[34:]
+
+	var ints [4]int64
+	for i := range ints {
+		v, l := binary.Varint(b)
+		if l <= 0 {
+			return errNonceEncoding
+		}
+		ints[i], b = v, b[l:]
+	}
+	out.CreatedAt = ints[0]
+	out.ExpiresAt = time.Unix(ints[1], ints[2]).UTC()
+	out.ConsumedAt = ints[3]
+
+	strs := []*string{&out.Token, &out.Action, &out.Salt, &out.ConsumedIP, &out.ConsumedUserAgent}
+	if version != nonceBinaryVersion {
+		strs = append(strs, &out.Binding)
+	}
+	for _, s := range strs {
+		l, k := binary.Uvarint(b)
+		if k <= 0 || uint64(len(b)-k) < l {
+			return errNonceEncoding
+		}
+		*s, b = string(b[k:k+int(l)]), b[k+int(l):]
+	}
+	if version >= nonceBinaryChained {
+		if len(b) < 16 {
+			return errNonceEncoding
+		}
+		copy(out.ParentID[:], b)
+		b = b[16:]
+	}
+	if version >= nonceBinaryNamespaced {
+		l, k := binary.Uvarint(b)
+		if k <= 0 || uint64(len(b)-k) < l {
+			return errNonceEncoding
+		}
+		out.Namespace, b = string(b[k:k+int(l)]), b[k+int(l):]
+	}
+	if version == nonceBinaryPayload {
+		l, k := binary.Uvarint(b)
+		if k <= 0 || uint64(len(b)-k) < l {
+			return errNonceEncoding
+		}
+		out.Payload, b = string(b[k:k+int(l)]), b[k+int(l):]
+	}
+	if len(b) != 0 {
+		return errNonceEncoding
+	}
+	*n = out
+	return nil
+}
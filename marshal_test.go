@@ -0,0 +1,104 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func testMarshalNonce() Nonce {
+	return Nonce{
+		ID:                uuid.NewV4(),
+		UserID:            tNonce.UserID,
+		Token:             "dG9rZW4=",
+		Action:            tNonce.Action,
+		Salt:              "salt",
+		IsUsed:            true,
+		IsValid:           true,
+		CreatedAt:         1500000000,
+		ExpiresAt:         time.Unix(1500003600, 0).UTC(),
+		ConsumedAt:        1500000060,
+		ConsumedIP:        "203.0.113.7",
+		ConsumedUserAgent: "test-agent/1.0",
+		Binding:           "n=203.0.113.0%2F24",
+		ParentID:          uuid.NewV4(),
+	}
+}
+
+func TestNonceJSON(t *testing.T) {
+	n := testMarshalNonce()
+	n.Payload = "new@example.com"
+	b, err := json.Marshal(n)
+	if err != nil {
+		t.Fatalf("Expected to marshal nonce. Instead got the error: %v", err)
+	}
+	if strings.Contains(string(b), "salt") {
+		t.Fatalf("Expected Salt to be left out. Instead got: %s", b)
+	}
+	if !strings.Contains(string(b), `"created_at":"2017-07-14T02:40:00Z"`) {
+		t.Fatalf("Expected an RFC 3339 created_at. Instead got: %s", b)
+	}
+
+	var got Nonce
+	err = json.Unmarshal(b, &got)
+	if err != nil {
+		t.Fatalf("Expected to unmarshal nonce. Instead got the error: %v", err)
+	}
+	want := n
+	want.Salt, want.Binding = "", ""
+	if got != want {
+		t.Fatalf("Expected %+v. Instead got: %+v", want, got)
+	}
+
+	b, err = n.JSONWithSalt()
+	if err != nil {
+		t.Fatalf("Expected to marshal nonce. Instead got the error: %v", err)
+	}
+	err = json.Unmarshal(b, &got)
+	if err != nil || got != n {
+		t.Fatalf("Expected %+v. Instead got: %+v, %v", n, got, err)
+	}
+}
+
+func TestNonceBinary(t *testing.T) {
+	unchained := testMarshalNonce()
+	unchained.ParentID = uuid.Nil
+	namespaced := unchained
+	namespaced.Namespace = "tenant-a"
+	payload := testMarshalNonce()
+	payload.Payload = "new@example.com"
+	for _, n := range []Nonce{testMarshalNonce(), unchained, namespaced, payload, {}} {
+		b, err := n.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Expected to marshal nonce. Instead got the error: %v", err)
+		}
+		var got Nonce
+		err = got.UnmarshalBinary(b)
+		if err != nil || got != n {
+			t.Fatalf("Expected %+v. Instead got: %+v, %v", n, got, err)
+		}
+
+		for i := 0; i < len(b); i++ {
+			if got.UnmarshalBinary(b[:i]) == nil {
+				t.Fatalf("Expected a truncated encoding of %d bytes to be rejected.", i)
+			}
+		}
+	}
+}
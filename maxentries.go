@@ -0,0 +1,61 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"sync/atomic"
+)
+
+// WithMaxEntries bounds an in-memory Service to n stored nonces. Storing one
+// more evicts the nonces due to expire soonest, which are the oldest when every
+// nonce has the same lifetime, whether or not they have been used.
+// The nonce being stored is never the one evicted. Other backends ignore it.
+func WithMaxEntries(n int) Option {
+	return func(cfg *config) {
+		if n > 0 {
+			cfg.maxEntries = n
+		}
+	}
+}
+
+// trim evicts the nonces due to be purged soonest, other than keep, until the
+// store is within max
+func (st *inMemStore) trim(keep string) {
+	if st.max <= 0 {
+		return
+	}
+
+	var kept []expiryEntry
+	for atomic.LoadInt64(&st.size) > int64(st.max) {
+		e, ok := st.expiry.first()
+		if !ok {
+			break
+		}
+		if e.token == keep {
+			kept = append(kept, e)
+			continue
+		}
+		removed := st.remove(e.token, func(n Nonce) bool {
+			// a renewed nonce was pushed again with its new expiry
+			return !n.ExpiresAt.After(e.at)
+		})
+		if removed {
+			atomic.AddInt64(&st.evicted, 1)
+		}
+	}
+	for _, e := range kept {
+		st.expiry.push(e.at, e.token)
+	}
+}
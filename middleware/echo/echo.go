@@ -0,0 +1,44 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package echo adapts a middleware.Verifier to echo:
+//
+//	v := middleware.NewVerifier(s, "csrf", "header:X-CSRF-Token,form:nonce_token", false)
+//	e.Use(nonceecho.Middleware(v, func(c *echo.Context) uuid.UUID { return currentUser(c) }))
+package echo
+
+import (
+	"net/http"
+
+	"github.com/bryanjeal/go-nonce/middleware"
+	"github.com/labstack/echo/v5"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Middleware returns an *echo.HTTPError with the status middleware.Status
+// returns, wrapping the error, for requests v doesn't accept.
+// userID tells which user made a request.
+func Middleware(v *middleware.Verifier, userID func(c *echo.Context) uuid.UUID) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c *echo.Context) error {
+			r := c.Request()
+			err := v.Verify(r.Method, middleware.HTTPLookup(r), userID(c))
+			if err != nil {
+				status := middleware.Status(err)
+				return echo.NewHTTPError(status, http.StatusText(status)).Wrap(err)
+			}
+			return next(c)
+		}
+	}
+}
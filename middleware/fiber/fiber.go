@@ -0,0 +1,54 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fiber adapts a middleware.Verifier to fiber:
+//
+//	v := middleware.NewVerifier(s, "csrf", "header:X-CSRF-Token,form:nonce_token", false)
+//	app.Use(noncefiber.Middleware(v, func(c fiber.Ctx) uuid.UUID { return currentUser(c) }))
+package fiber
+
+import (
+	"github.com/bryanjeal/go-nonce/middleware"
+	"github.com/gofiber/fiber/v3"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Middleware returns a *fiber.Error with the status middleware.Status returns
+// for requests v doesn't accept. userID tells which user made a request.
+func Middleware(v *middleware.Verifier, userID func(c fiber.Ctx) uuid.UUID) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		err := v.Verify(c.Method(), lookup(c), userID(c))
+		if err != nil {
+			return fiber.NewError(middleware.Status(err), err.Error())
+		}
+		return c.Next()
+	}
+}
+
+// lookup reads values from the request c is handling
+func lookup(c fiber.Ctx) middleware.Lookup {
+	return func(source, name string) string {
+		switch source {
+		case "header":
+			return c.Get(name)
+		case "form":
+			return c.FormValue(name)
+		case "query":
+			return c.Query(name)
+		case "cookie":
+			return c.Cookies(name)
+		}
+		return ""
+	}
+}
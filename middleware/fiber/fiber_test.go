@@ -0,0 +1,54 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fiber
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/middleware"
+	"github.com/gofiber/fiber/v3"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestMiddleware(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+
+	uid := uuid.NewV4()
+	n, err := s.New("csrf", uid, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	app := fiber.New()
+	app.Use(Middleware(middleware.NewVerifier(s, "csrf", "", false), func(c fiber.Ctx) uuid.UUID { return uid }))
+	app.Post("/", func(c fiber.Ctx) error { return c.SendStatus(http.StatusNoContent) })
+
+	for token, want := range map[string]int{"": http.StatusForbidden, n.Token: http.StatusNoContent} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("X-CSRF-Token", token)
+		res, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Expected the request to be served. Instead got the error: %v", err)
+		}
+		if res.StatusCode != want {
+			t.Errorf("Expected status %d for token %q. Instead got %d", want, token, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gin adapts a middleware.Verifier to gin:
+//
+//	v := middleware.NewVerifier(s, "csrf", "header:X-CSRF-Token,form:nonce_token", false)
+//	r.Use(noncegin.Middleware(v, func(c *gin.Context) uuid.UUID { return currentUser(c) }))
+package gin
+
+import (
+	"github.com/bryanjeal/go-nonce/middleware"
+	"github.com/gin-gonic/gin"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Middleware aborts requests v doesn't accept with the status
+// middleware.Status returns, recording the error on the gin.Context.
+// userID tells which user made a request.
+func Middleware(v *middleware.Verifier, userID func(c *gin.Context) uuid.UUID) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := v.Verify(c.Request.Method, middleware.HTTPLookup(c.Request), userID(c))
+		if err != nil {
+			c.AbortWithError(middleware.Status(err), err)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package middleware rejects requests that don't carry a valid nonce, e.g.
+// as CSRF protection or to stop API calls being replayed. Its subpackages
+// adapt the Verifier to gin, echo and fiber; Handler wraps a net/http handler:
+//
+//	v := middleware.NewVerifier(s, "csrf", "header:X-CSRF-Token,form:nonce_token", false)
+//	http.Handle("/settings", v.Handler(settings, userID))
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultTokenLookup is where a Verifier looks for tokens unless told otherwise
+var DefaultTokenLookup = "header:X-CSRF-Token,form:nonce_token"
+
+// Lookup returns the value called name from one part of a request: "header",
+// "form", "query" or "cookie". It returns "" for values that aren't there.
+type Lookup func(source, name string) string
+
+// Verifier checks the nonce submitted with each request that isn't safe
+type Verifier struct {
+	s       nonce.Service
+	action  string
+	lookups [][2]string
+	consume bool
+}
+
+// NewVerifier returns a Verifier that requires requests to carry a nonce s
+// issued for action. tokenLookup is a comma separated list of source:name
+// pairs, where source is header, form, query or cookie, tried in order until
+// one has a token; "" uses DefaultTokenLookup. With consume set each nonce is
+// consumed, so it is good for one request. It panics if tokenLookup is invalid.
+func NewVerifier(s nonce.Service, action, tokenLookup string, consume bool) *Verifier {
+	if tokenLookup == "" {
+		tokenLookup = DefaultTokenLookup
+	}
+	v := &Verifier{s: s, action: action, consume: consume}
+	for _, l := range strings.Split(tokenLookup, ",") {
+		parts := strings.SplitN(strings.TrimSpace(l), ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			panic(fmt.Sprintf("middleware: invalid token lookup %q", l))
+		}
+		switch parts[0] {
+		case "header", "form", "query", "cookie":
+		default:
+			panic(fmt.Sprintf("middleware: unknown token source %q", parts[0]))
+		}
+		v.lookups = append(v.lookups, [2]string{parts[0], parts[1]})
+	}
+	return v
+}
+
+// Verify checks the nonce lookup finds for a request made with method by uid.
+// GET, HEAD, OPTIONS and TRACE requests pass without one.
+func (v *Verifier) Verify(method string, lookup Lookup, uid uuid.UUID) error {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	}
+
+	token := ""
+	for _, l := range v.lookups {
+		token = lookup(l[0], l[1])
+		if token != "" {
+			break
+		}
+	}
+	if token == "" {
+		return nonce.ErrNoToken
+	}
+	if v.consume {
+		_, err := v.s.CheckThenConsume(token, v.action, uid)
+		return err
+	}
+	return v.s.Check(token, v.action, uid)
+}
+
+// Handler wraps next so requests are only passed on once Verify accepts them.
+// userID tells which user made a request. Rejected requests get the status
+// Status returns for the error.
+func (v *Verifier) Handler(next http.Handler, userID func(r *http.Request) uuid.UUID) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := v.Verify(r.Method, HTTPLookup(r), userID(r))
+		if err != nil {
+			status := Status(err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HTTPLookup looks values up in r
+func HTTPLookup(r *http.Request) Lookup {
+	return func(source, name string) string {
+		switch source {
+		case "header":
+			return r.Header.Get(name)
+		case "form":
+			return r.PostFormValue(name)
+		case "query":
+			return r.URL.Query().Get(name)
+		case "cookie":
+			c, err := r.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return c.Value
+		}
+		return ""
+	}
+}
+
+// rejected are the errors that mean the request, rather than the Service, is at fault
+var rejected = []error{
+	nonce.ErrNoToken, nonce.ErrInvalidToken, nonce.ErrTokenExpired,
+	nonce.ErrTokenNotFound, nonce.ErrUserRequired, nonce.ErrBindingMismatch,
+}
+
+// Status is the HTTP status to reject a request with after Verify returned err:
+// 409 Conflict when the nonce was already used, 403 Forbidden when it was
+// otherwise missing or rejected, 429 Too Many Requests once a WithAttemptLimit
+// lockout starts, or 500 when the Service failed
+func Status(err error) int {
+	switch {
+	case errors.Is(err, nonce.ErrTokenUsed):
+		return http.StatusConflict
+	case err == nonce.ErrTooManyAttempts:
+		return http.StatusTooManyRequests
+	}
+	for _, e := range rejected {
+		if errors.Is(err, e) {
+			return http.StatusForbidden
+		}
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestHandler(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+
+	uid := uuid.NewV4()
+	n, err := s.New("csrf", uid, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	v := NewVerifier(s, "csrf", "header:X-Token,form:token", true)
+	h := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(r *http.Request) uuid.UUID { return uid })
+	serve := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := serve(httptest.NewRequest("GET", "/", nil)); code != http.StatusOK {
+		t.Errorf("Expected a GET to pass without a token. Instead got status %d", code)
+	}
+	if code := serve(httptest.NewRequest("POST", "/", nil)); code != http.StatusForbidden {
+		t.Errorf("Expected a POST without a token to be forbidden. Instead got status %d", code)
+	}
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(url.Values{"token": {n.Token}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if code := serve(r); code != http.StatusOK {
+		t.Errorf("Expected a POST with the token in the form to pass. Instead got status %d", code)
+	}
+	r = httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("X-Token", n.Token)
+	if code := serve(r); code != http.StatusConflict {
+		t.Errorf("Expected the consumed token to conflict. Instead got status %d", code)
+	}
+}
+
+func TestNewVerifierInvalidLookup(t *testing.T) {
+	for _, l := range []string{"header", "body:token", "form:"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected NewVerifier to panic for %q", l)
+				}
+			}()
+			NewVerifier(nil, "csrf", l, false)
+		}()
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// NonceHeader carries the nonces of replay protected API calls
+var NonceHeader = "X-Nonce"
+
+// Replay wraps next so every request that isn't safe must carry a nonce s
+// issued for action in NonceHeader. The nonce is consumed, so replaying the
+// request gets 409 Conflict. userID tells which user made a request.
+func Replay(s nonce.Service, action string, next http.Handler, userID func(r *http.Request) uuid.UUID) http.Handler {
+	return NewVerifier(s, action, "header:"+NonceHeader, true).Handler(next, userID)
+}
+
+// NonceHandler issues the nonces Replay checks. Each request gets an empty
+// 204 response with a new nonce for action and its user, lasting expiresIn,
+// in NonceHeader. A new nonce replaces the user's last one.
+func NonceHandler(s nonce.Service, action string, expiresIn time.Duration, userID func(r *http.Request) uuid.UUID) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := s.New(action, userID(r), expiresIn)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set(NonceHeader, n.Token)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// replayTransport attaches a fresh nonce to each request that isn't safe
+type replayTransport struct {
+	nonceURL string
+	base     http.RoundTripper
+
+	// mu sends one request needing a nonce at a time, since each nonce
+	// fetched replaces the one before it
+	mu sync.Mutex
+}
+
+// NewReplayTransport returns an http.RoundTripper for calling APIs protected
+// by Replay. Before each request that isn't safe it fetches a nonce from the
+// NonceHandler at nonceURL, sending the request's headers so the user is
+// authenticated the same way, and sends it in NonceHeader. Those requests are
+// sent one at a time. A nil base uses http.DefaultTransport.
+func NewReplayTransport(nonceURL string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &replayTransport{nonceURL: nonceURL, base: base}
+}
+
+func (t *replayTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return t.base.RoundTrip(r)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	token, err := t.fetch(r)
+	if err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set(NonceHeader, token)
+	return t.base.RoundTrip(r)
+}
+
+// fetch gets a nonce for the user r is sent as
+func (t *replayTransport) fetch(r *http.Request) (string, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, t.nonceURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Del("Content-Type")
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	token := resp.Header.Get(NonceHeader)
+	if resp.StatusCode >= 300 || token == "" {
+		return "", fmt.Errorf("middleware: fetching nonce: unexpected response %s", resp.Status)
+	}
+	return token, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestReplay(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+
+	uid := uuid.NewV4()
+	userID := func(r *http.Request) uuid.UUID { return uid }
+	var last string
+	mux := http.NewServeMux()
+	mux.Handle("/nonce", NonceHandler(s, "api", time.Minute, userID))
+	mux.Handle("/orders", Replay(s, "api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = r.Header.Get(NonceHeader)
+		w.WriteHeader(http.StatusCreated)
+	}), userID))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := &http.Client{Transport: NewReplayTransport(srv.URL+"/nonce", nil)}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Post(srv.URL+"/orders", "application/json", nil)
+		if err != nil {
+			t.Fatalf("Expected the call to be sent. Instead got the error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected call %d to be accepted. Instead got status %d", i+1, resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", srv.URL+"/orders", nil)
+	req.Header.Set(NonceHeader, last)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected the replay to be sent. Instead got the error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a replayed call to get 409. Instead got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"time"
+)
+
+// MigrateOptions says how MigrateStore copies nonces
+type MigrateOptions struct {
+	// BatchSize is how many nonces are copied between Progress calls.
+	// Zero uses PurgeBatchSize.
+	BatchSize int
+
+	// Rate caps copying at this many nonces a second, so a live store isn't
+	// swamped. Zero leaves it uncapped.
+	Rate int
+
+	// Overwrite replaces the nonces to already has. By default they are
+	// skipped when to is an Inspector, as a copy NewDualWriteService made
+	// while MigrateStore ran is newer than the one being copied.
+	Overwrite bool
+
+	// Progress is called after each batch with the totals so far
+	Progress func(MigrateProgress)
+}
+
+// MigrateProgress counts the nonces MigrateStore has handled
+type MigrateProgress struct {
+	Copied  int
+	Skipped int
+}
+
+// MigrateStore copies every nonce from lists into to, with its token, salt
+// and state, so an app can move to another backend without invalidating
+// outstanding links. To swap backends while serving, put a
+// NewDualWriteService in front of both stores first, so nonces written during
+// the copy reach to, run MigrateStore, then switch to to. It returns what it
+// did before any error stopped it.
+func MigrateStore(ctx context.Context, from Lister, to Putter, opts MigrateOptions) (MigrateProgress, error) {
+	size := opts.BatchSize
+	if size <= 0 {
+		size = PurgeBatchSize
+	}
+	inspect, _ := to.(Inspector)
+	if opts.Overwrite {
+		inspect = nil
+	}
+
+	var p MigrateProgress
+	start := time.Now()
+	batch := make([]Nonce, 0, size)
+	flush := func() error {
+		for _, n := range batch {
+			if inspect != nil {
+				_, err := inspect.GetByID(n.ID)
+				if err == nil {
+					p.Skipped++
+					continue
+				} else if err != ErrTokenNotFound {
+					return err
+				}
+			}
+			_, err := to.PutNonce(n)
+			if err != nil {
+				return err
+			}
+			p.Copied++
+		}
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+		return waitForRate(ctx, start, p.Copied+p.Skipped, opts.Rate)
+	}
+
+	err := from.List(ctx, Filter{}, func(n Nonce) error {
+		batch = append(batch, n)
+		if len(batch) >= size {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	return p, err
+}
+
+// waitForRate sleeps until done nonces are no more than rate a second since start
+func waitForRate(ctx context.Context, start time.Time, done, rate int) error {
+	if rate <= 0 {
+		return nil
+	}
+	wait := time.Until(start.Add(time.Duration(done) * time.Second / time.Duration(rate)))
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
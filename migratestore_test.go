@@ -0,0 +1,82 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMigrateStore(t *testing.T) {
+	newService := openTestStore(t, "sqlx")
+	from := newService()
+	to := NewInMemoryService()
+	ctx := context.Background()
+
+	var live []Nonce
+	for _, action := range []string{"a", "b", "c"} {
+		n, err := from.New(action, tNonce.UserID, time.Hour)
+		if err != nil {
+			t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+		}
+		live = append(live, n)
+	}
+
+	// a nonce consumed while dual writing reaches to first
+	dual, err := NewDualWriteService(from, to, DualWriteMode{})
+	if err != nil {
+		t.Fatalf("Expected to create the dual write Service. Instead got the error: %v", err)
+	}
+	defer dual.Shutdown()
+	used, err := dual.New("used", tNonce.UserID, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	_, err = dual.Consume(used.Token)
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+
+	var reports []MigrateProgress
+	p, err := MigrateStore(ctx, from.(Lister), to.(Putter), MigrateOptions{
+		BatchSize: 2,
+		Rate:      1000,
+		Progress:  func(p MigrateProgress) { reports = append(reports, p) },
+	})
+	if err != nil || p != (MigrateProgress{Copied: 3, Skipped: 1}) {
+		t.Fatalf("Expected 3 nonces to be copied and 1 skipped. Instead got: %+v, %v", p, err)
+	}
+	if len(reports) != 2 || reports[1] != p {
+		t.Errorf("Expected progress after each batch. Instead got: %+v", reports)
+	}
+	for _, n := range live {
+		err = to.Check(n.Token, n.Action, tNonce.UserID)
+		if err != nil {
+			t.Errorf("Expected nonce %s to check out after migrating. Instead got the error: %v", n.Action, err)
+		}
+	}
+	_, err = to.Consume(used.Token)
+	if err != ErrTokenUsed {
+		t.Errorf("Expected the nonce consumed while dual writing to stay used. Instead got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = MigrateStore(ctx, from.(Lister), to.(Putter), MigrateOptions{Overwrite: true})
+	if err != context.Canceled {
+		t.Errorf("Expected a cancelled migration to stop. Instead got: %v", err)
+	}
+}
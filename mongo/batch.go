@@ -0,0 +1,72 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (s *service) NewBatch(ctx context.Context, requests []nonce.NewRequest) ([]nonce.Nonce, error) {
+	nonces, newest, err := s.cfg.NewBatch(requests)
+	if err != nil || len(nonces) == 0 {
+		return nonces, err
+	}
+
+	// insert everything in one round trip, then invalidate what the batch replaced
+	docs := make([]interface{}, len(nonces))
+	for i, n := range nonces {
+		sealed, err := s.cfg.Seal(n)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = toMongoNonce(sealed)
+	}
+	_, err = s.coll.InsertMany(ctx, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]mongo.WriteModel, 0, len(newest))
+	var others []nonce.Nonce
+	for _, n := range newest {
+		o, err := s.invalidating(ctx, othersFilter(n))
+		if err != nil {
+			return nil, err
+		}
+		others = append(others, o...)
+		models = append(models, mongo.NewUpdateManyModel().
+			SetFilter(othersFilter(n)).
+			SetUpdate(bson.M{"$set": bson.M{"is_valid": false}}))
+	}
+	_, err = s.coll.BulkWrite(ctx, models)
+	if err != nil {
+		return nil, err
+	}
+
+	t := s.cfg.Clock().Now()
+	for _, n := range nonces {
+		s.cfg.Remember(n, t)
+	}
+	for _, n := range newest {
+		s.cfg.RememberInvalidated(n)
+	}
+	s.cfg.Created(nonces...)
+	s.cfg.Invalidated(others...)
+	return s.cfg.IssueAll(nonces), nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func (s *service) DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error) {
+	return s.cfg.DeleteAllForUser(uid, func() (int64, error) {
+		res, err := s.coll.DeleteMany(ctx, mongoFilter(s.cfg.Scope(nonce.Filter{UserID: uid}), s.cfg.Clock().Now()))
+		if err != nil {
+			return 0, err
+		}
+		return res.DeletedCount, nil
+	})
+}
@@ -0,0 +1,83 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (s *service) List(ctx context.Context, f nonce.Filter, fn func(nonce.Nonce) error) error {
+	cur, err := s.coll.Find(ctx, mongoFilter(s.cfg.Scope(f), s.cfg.Clock().Now()), options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetBatchSize(int32(s.cfg.ListChunkSize())))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+
+	// the cursor fetches a chunk per batch and stops once ctx is done
+	for cur.Next(ctx) {
+		m := mongoNonce{}
+		err = cur.Decode(&m)
+		if err != nil {
+			return err
+		}
+		err = fn(s.cfg.Open(m.nonce()))
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// mongoFilter renders f as a MongoDB query at time t
+func mongoFilter(f nonce.Filter, t time.Time) bson.M {
+	q := bson.M{}
+	if ns, scoped := f.Namespace(); scoped {
+		q["namespace"] = namespaceFilter(ns)
+	}
+	if f.Action != "" {
+		q["action"] = f.Action
+	}
+	if f.UserID != uuid.Nil {
+		q["user_id"] = f.UserID.String()
+	}
+	expires := bson.M{}
+	if f.Outstanding {
+		q["is_valid"] = true
+		q["is_used"] = false
+		expires["$gt"] = t
+	}
+	if f.Consumed {
+		q["is_used"] = true
+	}
+	if !f.ExpiredBefore.IsZero() {
+		expires["$lt"] = f.ExpiredBefore
+	}
+	if len(expires) > 0 {
+		q["expires_at"] = expires
+	}
+	if f.Deleted {
+		// nothing is tombstoned in MongoDB
+		q["deleted_at"] = bson.M{"$gt": 0}
+	}
+	return q
+}
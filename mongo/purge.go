@@ -0,0 +1,68 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func (s *service) PurgeExpired(ctx context.Context, limit int) (int64, error) {
+	return s.cfg.PurgeExpired(ctx, s, limit, func(batch []nonce.Nonce, t time.Time) (int64, error) {
+		ids := make([]string, len(batch))
+		for i, n := range batch {
+			ids[i] = n.ID.String()
+		}
+		res, err := s.coll.DeleteMany(ctx, bson.M{
+			"_id":        bson.M{"$in": ids},
+			"expires_at": bson.M{"$lt": t},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return res.DeletedCount, s.removed(ctx, batch, ids, res.DeletedCount)
+	})
+}
+
+// removed calls the OnExpiredRemoved hook for the nonces in batch that were
+// deleted, asking which are left if some were renewed after being listed
+func (s *service) removed(ctx context.Context, batch []nonce.Nonce, ids []string, deleted int64) error {
+	if s.cfg.Hooks().OnExpiredRemoved == nil {
+		return nil
+	}
+	if deleted == int64(len(batch)) {
+		s.cfg.ExpiredRemoved(batch...)
+		return nil
+	}
+
+	left, err := s.find(ctx, bson.M{"_id": bson.M{"$in": ids}}, func(*nonce.Nonce) {})
+	if err != nil {
+		return err
+	}
+	kept := make(map[uuid.UUID]bool, len(left))
+	for _, n := range left {
+		kept[n.ID] = true
+	}
+	for _, n := range batch {
+		if !kept[n.ID] {
+			s.cfg.ExpiredRemoved(n)
+		}
+	}
+	return nil
+}
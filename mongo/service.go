@@ -0,0 +1,622 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mongo stores nonces in a MongoDB collection.
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type service struct {
+	coll *mongo.Collection
+	cfg  *nonce.Backend
+}
+
+// NewService creates an Nonce Service that stores nonces in a MongoDB collection
+// A TTL index on expires_at removes expired nonces, so there is no cleanup goroutine.
+func NewService(coll *mongo.Collection, opts ...nonce.Option) nonce.Service {
+	cfg := nonce.NewBackend(opts...)
+	s := &service{
+		coll: coll,
+		cfg:  cfg,
+	}
+	err := s.ensureIndexes()
+	if err != nil {
+		s.cfg.Logger().Printf("nonce: error creating MongoDB indexes: %v", err)
+	}
+	return s.cfg.Wrap(s)
+}
+
+// mongoNonce is how a Nonce is stored in a MongoDB collection
+type mongoNonce struct {
+	ID        string    `bson:"_id"`
+	UserID    string    `bson:"user_id"`
+	Token     string    `bson:"token"`
+	Action    string    `bson:"action"`
+	Salt      string    `bson:"salt"`
+	IsUsed    bool      `bson:"is_used"`
+	IsValid   bool      `bson:"is_valid"`
+	CreatedAt int64     `bson:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+
+	ConsumedAt        int64  `bson:"consumed_at"`
+	ConsumedIP        string `bson:"consumed_ip"`
+	ConsumedUserAgent string `bson:"consumed_user_agent"`
+
+	Binding   string `bson:"binding,omitempty"`
+	ParentID  string `bson:"parent_id,omitempty"`
+	Namespace string `bson:"namespace,omitempty"`
+	Payload   string `bson:"payload,omitempty"`
+}
+
+func toMongoNonce(n nonce.Nonce) mongoNonce {
+	return mongoNonce{
+		ID:        n.ID.String(),
+		UserID:    n.UserID.String(),
+		Token:     n.Token,
+		Action:    n.Action,
+		Salt:      n.Salt,
+		IsUsed:    n.IsUsed,
+		IsValid:   n.IsValid,
+		CreatedAt: n.CreatedAt,
+		ExpiresAt: n.ExpiresAt,
+
+		ConsumedAt:        n.ConsumedAt,
+		ConsumedIP:        n.ConsumedIP,
+		ConsumedUserAgent: n.ConsumedUserAgent,
+
+		Binding:   n.Binding,
+		ParentID:  parentString(n.ParentID),
+		Namespace: n.Namespace,
+		Payload:   n.Payload,
+	}
+}
+
+// parentString leaves ParentID out of unchained nonces' documents
+func parentString(id uuid.UUID) string {
+	if id == uuid.Nil {
+		return ""
+	}
+	return id.String()
+}
+
+func (m mongoNonce) nonce() nonce.Nonce {
+	return nonce.Nonce{
+		ID:        uuid.FromStringOrNil(m.ID),
+		UserID:    uuid.FromStringOrNil(m.UserID),
+		Token:     m.Token,
+		Action:    m.Action,
+		Salt:      m.Salt,
+		IsUsed:    m.IsUsed,
+		IsValid:   m.IsValid,
+		CreatedAt: m.CreatedAt,
+		ExpiresAt: m.ExpiresAt.In(time.Local),
+
+		ConsumedAt:        m.ConsumedAt,
+		ConsumedIP:        m.ConsumedIP,
+		ConsumedUserAgent: m.ConsumedUserAgent,
+
+		Binding:   m.Binding,
+		ParentID:  uuid.FromStringOrNil(m.ParentID),
+		Namespace: m.Namespace,
+		Payload:   m.Payload,
+	}
+}
+
+// bindingFilter matches nonces stored with binding
+func bindingFilter(binding string) interface{} {
+	if binding == "" {
+		return bson.M{"$exists": false}
+	}
+	return binding
+}
+
+// namespaceFilter matches nonces stored in namespace
+func namespaceFilter(namespace string) interface{} {
+	if namespace == "" {
+		return bson.M{"$exists": false}
+	}
+	return namespace
+}
+
+// ensureIndexes creates the TTL index that expires nonces plus the lookup indexes
+func (s *service) ensureIndexes() error {
+	_, err := s.coll.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "namespace", Value: 1}, {Key: "user_id", Value: 1}, {Key: "action", Value: 1}, {Key: "is_valid", Value: 1}},
+		},
+	})
+	return err
+}
+
+func (s *service) New(action string, uid uuid.UUID, expiresIn time.Duration) (nonce.Nonce, error) {
+	return s.NewBound(action, uid, expiresIn, nonce.Binding{})
+}
+
+func (s *service) NewBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, b, "")
+}
+
+func (s *service) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, nonce.Binding{}, payload)
+}
+
+func (s *service) newBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding, payload string) (nonce.Nonce, error) {
+	n, err := s.cfg.NewBoundNonce(action, uid, expiresIn, b, payload)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n.ID = s.cfg.NewID()
+
+	sealed, err := s.cfg.Seal(n)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// Save nonce
+	ctx := context.Background()
+	_, err = s.coll.InsertOne(ctx, toMongoNonce(sealed))
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Remember(n, s.cfg.Clock().Now())
+
+	// Invalidate existing tokens for same user & action; anonymous nonces stand alone
+	var others []nonce.Nonce
+	if n.UserID != uuid.Nil {
+		others, err = s.invalidating(ctx, othersFilter(n))
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		_, err = s.coll.UpdateMany(ctx, othersFilter(n), bson.M{"$set": bson.M{"is_valid": false}})
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+	}
+	s.cfg.RememberInvalidated(n)
+	s.cfg.Created(n)
+	s.cfg.Invalidated(others...)
+
+	// return new nonce
+	return s.cfg.Issue(n), nil
+}
+
+func (s *service) Check(token, action string, uid uuid.UUID) error {
+	return s.CheckBound(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckBound(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) error {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return err
+	}
+
+	// get Nonce data from collection
+	n, err := s.getNonce(token)
+	if err != nil {
+		return err
+	}
+
+	err = s.cfg.CheckBound(n, action, uid, meta, s.cfg.Clock().Now())
+	return err
+}
+
+func (s *service) Consume(token string) (nonce.Nonce, error) {
+	return s.ConsumeWithMeta(token, nonce.ConsumeMeta{})
+}
+
+func (s *service) ConsumeWithMeta(token string, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// mark as used only if it isn't already, atomically
+	t := s.cfg.Clock().Now()
+	sealed, err := s.cfg.SealMeta(meta, token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n, err := s.findAndUpdate(bson.M{"token": token, "is_used": false}, bson.M{
+		"is_used":             true,
+		"consumed_at":         t.Unix(),
+		"consumed_ip":         sealed.IP,
+		"consumed_user_agent": sealed.UserAgent,
+	})
+	if err == mongo.ErrNoDocuments {
+		// either there is no such token or it has been used
+		_, err = s.getNonce(token)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		return nonce.Nonce{}, nonce.ErrTokenUsed
+	} else if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	s.cfg.Remember(n, t)
+	s.cfg.Consumed(n)
+	return n, nil
+}
+
+func (s *service) CheckThenConsume(token, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	return s.CheckThenConsumeWithMeta(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// check and consume in one findOneAndUpdate
+	t := s.cfg.Clock().Now()
+	sealed, err := s.cfg.SealMeta(meta, token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	consume := func(action, binding string) (nonce.Nonce, error) {
+		return s.findAndUpdate(bson.M{
+			"token":      token,
+			"action":     action,
+			"user_id":    uid.String(),
+			"is_valid":   true,
+			"is_used":    false,
+			"expires_at": bson.M{"$gt": s.cfg.ExpiryCutoff(t)},
+			"binding":    bindingFilter(binding),
+		}, bson.M{
+			"is_used":             true,
+			"consumed_at":         t.Unix(),
+			"consumed_ip":         sealed.IP,
+			"consumed_user_agent": sealed.UserAgent,
+		})
+	}
+	n, err := consume(action, "")
+	if err == mongo.ErrNoDocuments {
+		// read the nonce back to work out why it wasn't consumed
+		var stored nonce.Nonce
+		stored, err = s.getNonce(token)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		err = s.cfg.CheckBound(stored, action, uid, meta, t)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		if stored.Binding == "" && stored.Action == action {
+			return nonce.Nonce{}, nonce.NewTokenError(nonce.ErrTokenUsed, stored, t)
+		}
+		// meta matches the binding and action is in the nonce's scope,
+		// neither of which ever changes, so consume it as stored
+		n, err = consume(stored.Action, stored.Binding)
+		if err == mongo.ErrNoDocuments {
+			return nonce.Nonce{}, nonce.NewTokenError(nonce.ErrTokenUsed, stored, t)
+		}
+	}
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	s.cfg.Remember(n, t)
+	s.cfg.Consumed(n)
+	return n, nil
+}
+
+func (s *service) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	// check and consume in one findOneAndUpdate
+	t := s.cfg.Clock().Now()
+	consume := func(action string) (nonce.Nonce, error) {
+		return s.findAndUpdate(bson.M{
+			"_id":        id.String(),
+			"action":     action,
+			"user_id":    uid.String(),
+			"is_valid":   true,
+			"is_used":    false,
+			"expires_at": bson.M{"$gt": s.cfg.ExpiryCutoff(t)},
+			"binding":    bindingFilter(""),
+		}, bson.M{"is_used": true, "consumed_at": t.Unix()})
+	}
+	n, err := consume(action)
+	if err == mongo.ErrNoDocuments {
+		// read the nonce back to work out why it wasn't consumed
+		var stored nonce.Nonce
+		stored, err = s.getNonceByID(id)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		err = s.cfg.CheckNonce(stored, action, uid, t)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		if stored.Action == action {
+			return nonce.Nonce{}, nonce.NewTokenError(nonce.ErrTokenUsed, stored, t)
+		}
+		// action is in the nonce's scope, so consume it by its own
+		n, err = consume(stored.Action)
+		if err == mongo.ErrNoDocuments {
+			return nonce.Nonce{}, nonce.NewTokenError(nonce.ErrTokenUsed, stored, t)
+		}
+	}
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	s.cfg.Remember(n, t)
+	s.cfg.Consumed(n)
+	return n, nil
+}
+
+func (s *service) Get(action string, uid uuid.UUID) (nonce.Nonce, error) {
+	if uid == uuid.Nil {
+		return nonce.Nonce{}, nonce.ErrUserRequired
+	}
+	m := mongoNonce{}
+	t := s.cfg.Clock().Now()
+	err := s.coll.FindOne(context.Background(),
+		bson.M{
+			"action":     action,
+			"user_id":    uid.String(),
+			"namespace":  namespaceFilter(s.cfg.Namespace()),
+			"is_valid":   true,
+			"is_used":    false,
+			"expires_at": bson.M{"$gt": s.cfg.ExpiryCutoff(t)},
+		},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	).Decode(&m)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nonce.Nonce{}, err
+	}
+
+	// prefer a newer nonce this instance wrote if the read hasn't caught up
+	if w, ok := s.cfg.NewestRemembered(action, uid, t); ok && (err == mongo.ErrNoDocuments || w.CreatedAt > m.CreatedAt) {
+		return w, nil
+	} else if err == mongo.ErrNoDocuments {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+
+	n := s.cfg.Merge(s.cfg.Open(m.nonce()), t)
+	if !s.cfg.Usable(n, t) {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+	return n, nil
+}
+
+func (s *service) Renew(token string, extendBy time.Duration) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.getNonce(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	t := s.cfg.Clock().Now()
+	renewed, err := s.cfg.RenewNonce(n, extendBy, t)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// only extend if nothing consumed, invalidated or renewed the nonce since we read it
+	_, err = s.findAndUpdate(bson.M{
+		"_id":        n.ID.String(),
+		"is_valid":   true,
+		"is_used":    false,
+		"expires_at": n.ExpiresAt,
+	}, bson.M{"expires_at": renewed.ExpiresAt})
+	if err == mongo.ErrNoDocuments {
+		// lost a race; re-read so the caller gets the reason
+		cur, err := s.getNonce(token)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		_, err = s.cfg.RenewNonce(cur, extendBy, t)
+		if err == nil {
+			err = nonce.NewTokenError(nonce.ErrInvalidToken, cur, t)
+		}
+		return nonce.Nonce{}, err
+	} else if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	s.cfg.Remember(renewed, t)
+	return renewed, nil
+}
+
+func (s *service) AwaitConsumption(ctx context.Context, id uuid.UUID) (nonce.Nonce, error) {
+	return s.cfg.Await(ctx, id, s.getNonceByID)
+}
+
+func (s *service) PutNonce(n nonce.Nonce) (nonce.Nonce, error) {
+	n, err := s.cfg.FillNonce(n, s.cfg.Clock().Now())
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if n.ID == uuid.Nil {
+		n.ID = s.cfg.NewID()
+	}
+	sealed, err := s.cfg.Seal(n)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// replace any existing nonce with the same token
+	ctx := context.Background()
+	_, err = s.coll.DeleteOne(ctx, bson.M{"token": n.Token, "namespace": namespaceFilter(n.Namespace)})
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	_, err = s.coll.InsertOne(ctx, toMongoNonce(sealed))
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	s.cfg.Remember(n, s.cfg.Clock().Now())
+	return s.cfg.Issue(n), nil
+}
+
+// Shutdown does nothing; MongoDB's TTL monitor removes expired nonces
+func (s *service) Shutdown() {}
+
+// commands lists the operations each method issues, for the debug journal
+func (s *service) Commands(method string) []string {
+	switch method {
+	case "New", "NewBound", "NewWithPayload":
+		return []string{"insertOne", "updateMany {user_id, action, namespace, is_valid: true, _id: {$ne}} $set is_valid: false"}
+	case "Check", "CheckBound":
+		return []string{"findOne {token}"}
+	case "Consume", "ConsumeWithMeta":
+		return []string{"findOneAndUpdate {token, is_used: false} $set is_used: true, consumed_at, consumed_ip, consumed_user_agent", "findOne {token}"}
+	case "CheckThenConsume", "CheckThenConsumeWithMeta":
+		return []string{"findOneAndUpdate {token, action, user_id, is_valid: true, is_used: false, expires_at: {$gt}, binding} $set is_used: true, consumed_at, consumed_ip, consumed_user_agent", "findOne {token}"}
+	case "ConsumeByID":
+		return []string{"findOneAndUpdate {_id, action, user_id, is_valid: true, is_used: false, expires_at: {$gt}, binding: {$exists: false}} $set is_used: true, consumed_at", "findOne {_id}"}
+	case "AwaitConsumption", "GetByID":
+		return []string{"findOne {_id}"}
+	case "GetByToken":
+		return []string{"findOne {token}"}
+	case "Get":
+		return []string{"findOne {action, user_id, is_valid: true, is_used: false, expires_at: {$gt}} sort created_at: -1"}
+	case "Renew":
+		return []string{"findOne {token}", "findOneAndUpdate {_id, is_valid: true, is_used: false, expires_at} $set expires_at"}
+	case "PutNonce":
+		return []string{"deleteOne {token}", "insertOne"}
+	}
+	return nil
+}
+
+// othersFilter matches the valid nonces New invalidates for n
+func othersFilter(n nonce.Nonce) bson.M {
+	return bson.M{
+		"user_id":   n.UserID.String(),
+		"action":    n.Action,
+		"namespace": namespaceFilter(n.Namespace),
+		"is_valid":  true,
+		"_id":       bson.M{"$ne": n.ID.String()},
+	}
+}
+
+// invalidating reads the unused nonces matching filter as they will be once
+// invalidated. It only queries when an OnInvalidated hook needs them.
+func (s *service) invalidating(ctx context.Context, filter bson.M) ([]nonce.Nonce, error) {
+	if s.cfg.Hooks().OnInvalidated == nil {
+		return nil, nil
+	}
+	unused := bson.M{"is_used": false}
+	for k, v := range filter {
+		unused[k] = v
+	}
+	return s.find(ctx, unused, func(n *nonce.Nonce) { n.IsValid = false })
+}
+
+// find returns every nonce matching filter, passed through fix
+func (s *service) find(ctx context.Context, filter bson.M, fix func(n *nonce.Nonce)) ([]nonce.Nonce, error) {
+	cur, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var nonces []nonce.Nonce
+	for cur.Next(ctx) {
+		var m mongoNonce
+		err = cur.Decode(&m)
+		if err != nil {
+			return nil, err
+		}
+		n := s.cfg.Open(m.nonce())
+		fix(&n)
+		nonces = append(nonces, n)
+	}
+	return nonces, cur.Err()
+}
+
+// getNonce gets a Nonce from the collection
+func (s *service) getNonce(token string) (nonce.Nonce, error) {
+	m := mongoNonce{}
+	t := s.cfg.Clock().Now()
+	err := s.coll.FindOne(context.Background(), bson.M{"token": token, "namespace": namespaceFilter(s.cfg.Namespace())}).Decode(&m)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nonce.Nonce{}, err
+	} else if err == mongo.ErrNoDocuments {
+		// the read may lag behind a write this instance just made
+		if w, ok := s.cfg.Remembered(token, t); ok && s.cfg.InNamespace(w) {
+			return w, nil
+		}
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+
+	return s.cfg.Merge(s.cfg.Open(m.nonce()), t), nil
+}
+
+// getNonceByID gets the Nonce with id from the collection
+func (s *service) getNonceByID(id uuid.UUID) (nonce.Nonce, error) {
+	m := mongoNonce{}
+	err := s.coll.FindOne(context.Background(), bson.M{"_id": id.String(), "namespace": namespaceFilter(s.cfg.Namespace())}).Decode(&m)
+	if err == mongo.ErrNoDocuments {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	} else if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	return s.cfg.Merge(s.cfg.Open(m.nonce()), s.cfg.Clock().Now()), nil
+}
+
+// findAndUpdate atomically sets fields on the document in the Service's
+// namespace matching filter and returns it updated
+func (s *service) findAndUpdate(filter, set bson.M) (nonce.Nonce, error) {
+	filter["namespace"] = namespaceFilter(s.cfg.Namespace())
+	m := mongoNonce{}
+	err := s.coll.FindOneAndUpdate(context.Background(), filter, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&m)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	return s.cfg.Open(m.nonce()), nil
+}
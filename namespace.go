@@ -0,0 +1,126 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"net/url"
+)
+
+// WithNamespace puts every nonce the Service creates in the tenant namespace ns
+// and makes the Service ignore nonces in any other, so tenants can share one
+// table, collection or key space without seeing each other's nonces: tokens
+// and IDs from another namespace are ErrTokenNotFound, Get and List only find
+// the Service's own nonces, and New only invalidates them. PutNonce moves the
+// nonce it is given into ns. The default is the empty namespace.
+func WithNamespace(ns string) Option {
+	return func(cfg *config) {
+		cfg.namespace = ns
+	}
+}
+
+// inNamespace reports whether n belongs to the Service's namespace
+func (c config) inNamespace(n Nonce) bool {
+	return n.Namespace == c.namespace
+}
+
+// scope restricts f to the Service's namespace
+func (c config) scope(f Filter) Filter {
+	f.namespace = c.namespace
+	return f
+}
+
+// WithKeyPrefix sets the prefix the etcd and badger packages store their
+// keys under. It defaults to "nonce/" and should end in a slash.
+func WithKeyPrefix(prefix string) Option {
+	return func(cfg *config) {
+		cfg.keyPrefix = prefix
+	}
+}
+
+// namespacePrefix is the WithKeyPrefix prefix with the namespace's keys under it
+func (c config) namespacePrefix() string {
+	if c.namespace == "" {
+		return c.keyPrefix
+	}
+	return c.keyPrefix + "ns/" + url.PathEscape(c.namespace) + "/"
+}
+
+// Tenanter is implemented by Services ForTenant can scope. Call ForTenant
+// rather than the method, which doesn't stop the handle shutting down the Service.
+type Tenanter interface {
+	// ForTenant returns a Service sharing the receiver's store for namespace ns
+	ForTenant(ns string) (Service, error)
+}
+
+// ForTenant returns a handle on s for the namespace tenantID, as if s had been
+// created with WithNamespace(tenantID), so request handlers can be given a
+// Service that only sees their tenant's nonces. The handle shares s's store,
+// connections, limits and cleanup, so creating one is cheap. Its Shutdown does
+// nothing; shut down s instead, after which the handle can't be used.
+// s must come from one of the package's constructors, optionally wrapped by
+// Decorate, NewRetryingService or NewCircuitBreakerService; ForTenant returns
+// ErrNotScopable otherwise.
+func ForTenant(s Service, tenantID string) (Service, error) {
+	t, ok := s.(Tenanter)
+	if !ok {
+		return nil, ErrNotScopable
+	}
+	scoped, err := t.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return Decorate(scoped, keepOpen), nil
+}
+
+// keepOpen stops a tenant handle shutting down the Service it shares
+func keepOpen(c Call, next func() error) error {
+	if c.Method == "Shutdown" {
+		return nil
+	}
+	return next()
+}
+
+// ForTenant scopes the wrapped Service, keeping the interceptors around it.
+// Those config.wrap added are rebuilt by the backend for the tenant instead.
+func (d *decorated) ForTenant(ns string) (Service, error) {
+	t, ok := d.next.(Tenanter)
+	if !ok {
+		return nil, ErrNotScopable
+	}
+	next, err := t.ForTenant(ns)
+	if err != nil || d.options {
+		return next, err
+	}
+	return &decorated{next: next, intercept: d.intercept}, nil
+}
+
+// tenant returns the Service's config for namespace ns
+func (c config) tenant(ns string) config {
+	c.namespace = ns
+	return c
+}
+
+func (s *nonceInMemoryService) ForTenant(ns string) (Service, error) {
+	cfg := s.cfg.tenant(ns)
+	return cfg.wrap(&nonceInMemoryService{
+		store:   s.store,
+		keys:    s.keys,
+		cfg:     cfg,
+		origin:  s.origin,
+		waiters: s.waiters,
+		sweeps:  s.sweeps,
+		quit:    s.quit,
+	}), nil
+}
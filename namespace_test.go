@@ -0,0 +1,205 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestNamespaces(t *testing.T) {
+	newService := openTestStore(t, "sqlx")
+	a := newService(WithNamespace("tenant-a"))
+	defer a.Shutdown()
+	b := newService(WithNamespace("tenant-b"))
+	defer b.Shutdown()
+	uid := uuid.NewV4()
+
+	na, err := a.New("login", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+	}
+	if na.Namespace != "tenant-a" {
+		t.Fatalf("Expected the nonce to be in tenant-a. Instead got: %q", na.Namespace)
+	}
+	nb, err := b.New("login", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+	}
+
+	// the same user and action in another namespace is left alone
+	err = a.Check(na.Token, "login", uid)
+	if err != nil {
+		t.Fatalf("Expected New in tenant-b not to invalidate tenant-a's nonce. Instead got: %v", err)
+	}
+	got, err := a.Get("login", uid)
+	if err != nil || got.ID != na.ID {
+		t.Fatalf("Expected Get to find tenant-a's nonce. Instead got: %+v, %v", got, err)
+	}
+	got, err = b.Get("login", uid)
+	if err != nil || got.ID != nb.ID {
+		t.Fatalf("Expected Get to find tenant-b's nonce. Instead got: %+v, %v", got, err)
+	}
+
+	err = b.Check(na.Token, "login", uid)
+	if err != ErrTokenNotFound {
+		t.Errorf("Expected Check in tenant-b to return %v. Instead got: %v", ErrTokenNotFound, err)
+	}
+	_, err = b.Consume(na.Token)
+	if err != ErrTokenNotFound {
+		t.Errorf("Expected Consume in tenant-b to return %v. Instead got: %v", ErrTokenNotFound, err)
+	}
+	_, err = b.CheckThenConsume(na.Token, "login", uid)
+	if err != ErrTokenNotFound {
+		t.Errorf("Expected CheckThenConsume in tenant-b to return %v. Instead got: %v", ErrTokenNotFound, err)
+	}
+	_, err = b.ConsumeByID(na.ID, "login", uid)
+	if err != ErrTokenNotFound {
+		t.Errorf("Expected ConsumeByID in tenant-b to return %v. Instead got: %v", ErrTokenNotFound, err)
+	}
+	_, err = b.Renew(na.Token, time.Minute)
+	if err != ErrTokenNotFound {
+		t.Errorf("Expected Renew in tenant-b to return %v. Instead got: %v", ErrTokenNotFound, err)
+	}
+
+	var listed []Nonce
+	b.(Lister).List(context.Background(), Filter{}, func(n Nonce) error {
+		listed = append(listed, n)
+		return nil
+	})
+	if len(listed) != 1 || listed[0].ID != nb.ID {
+		t.Errorf("Expected List in tenant-b to only find its nonce. Instead got: %+v", listed)
+	}
+
+	_, err = a.CheckThenConsume(na.Token, "login", uid)
+	if err != nil {
+		t.Fatalf("Expected tenant-a to consume its nonce. Instead got the error: %v", err)
+	}
+}
+
+func TestNamespacesInMemory(t *testing.T) {
+	a := NewInMemoryService(WithNamespace("tenant-a"))
+	defer a.Shutdown()
+	b := NewInMemoryService(WithNamespace("tenant-b"))
+	defer b.Shutdown()
+	uid := uuid.NewV4()
+
+	na, _ := a.New("login", uid, time.Minute)
+	var snap bytes.Buffer
+	err := a.(Snapshotter).Snapshot(&snap)
+	if err == nil {
+		err = b.(Snapshotter).Restore(&snap)
+	}
+	if err != nil {
+		t.Fatalf("Expected to copy tenant-a's nonces into tenant-b's store. Instead got the error: %v", err)
+	}
+
+	err = b.Check(na.Token, "login", uid)
+	if err != ErrTokenNotFound {
+		t.Errorf("Expected Check in tenant-b to return %v. Instead got: %v", ErrTokenNotFound, err)
+	}
+	_, err = b.Get("login", uid)
+	if err != ErrTokenNotFound {
+		t.Errorf("Expected Get in tenant-b to return %v. Instead got: %v", ErrTokenNotFound, err)
+	}
+	nb, _ := b.New("login", uid, time.Minute)
+	err = b.Check(nb.Token, "login", uid)
+	if err != nil {
+		t.Errorf("Expected tenant-b's own nonce to be valid. Instead got: %v", err)
+	}
+}
+
+func TestForTenant(t *testing.T) {
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := &testClock{}
+			s := newService(WithClock(clock))
+			defer s.Shutdown()
+			a, err := ForTenant(NewRetryingService(s, DefaultRetryPolicy), "tenant-a")
+			if err != nil {
+				t.Fatalf("Expected to create the tenant-a handle. Instead got the error: %v", err)
+			}
+			b, err := ForTenant(s, "tenant-b")
+			if err != nil {
+				t.Fatalf("Expected to create the tenant-b handle. Instead got the error: %v", err)
+			}
+			uid := uuid.NewV4()
+
+			na, err := a.New("login", uid, time.Minute)
+			if err != nil || na.Namespace != "tenant-a" {
+				t.Fatalf("Expected to add a nonce in tenant-a. Instead got: %+v, %v", na, err)
+			}
+			nb, _ := b.New("login", uid, 2*time.Minute)
+			err = b.Check(na.Token, "login", uid)
+			if err != ErrTokenNotFound {
+				t.Errorf("Expected tenant-b not to find tenant-a's nonce. Instead got: %v", err)
+			}
+			_, err = s.Get("login", uid)
+			if err != ErrTokenNotFound {
+				t.Errorf("Expected the default namespace not to find the tenants' nonces. Instead got: %v", err)
+			}
+			got, err := a.Get("login", uid)
+			if err != nil || got.ID != na.ID {
+				t.Errorf("Expected tenant-a to find its nonce. Instead got: %+v, %v", got, err)
+			}
+
+			// a handle's Shutdown leaves the shared Service running
+			a.Shutdown()
+			err = b.Check(nb.Token, "login", uid)
+			if err != nil {
+				t.Fatalf("Expected tenant-b's nonce to be valid after tenant-a's handle was shut down. Instead got: %v", err)
+			}
+
+			// the shared Service's purge removes every tenant's expired nonces
+			clock.Add(90 * time.Second)
+			purged, err := s.(Purger).PurgeExpired(context.Background(), 0)
+			if err != nil || purged != 1 {
+				t.Fatalf("Expected to purge tenant-a's expired nonce. Instead got: %d, %v", purged, err)
+			}
+			_, err = a.(Inspector).GetByID(na.ID)
+			if err != ErrTokenNotFound {
+				t.Errorf("Expected tenant-a's nonce to be purged. Instead got: %v", err)
+			}
+		})
+	}
+}
+
+func TestForTenantUnsupported(t *testing.T) {
+	s := mustService(NewCachedService(NewInMemoryService(), NewInMemoryService()))
+	defer s.Shutdown()
+	_, err := ForTenant(Decorate(s), "tenant-a")
+	if err != ErrNotScopable {
+		t.Errorf("Expected ForTenant to return %v for a Service it can't scope. Instead got: %v", ErrNotScopable, err)
+	}
+	_, err = ForTenant(s, "tenant-a")
+	if err != ErrNotScopable {
+		t.Errorf("Expected ForTenant to return %v for a Service it can't scope. Instead got: %v", ErrNotScopable, err)
+	}
+}
+
+// mustService returns s, panicking on err, for Services a test builds in an expression
+func mustService(s Service, err error) Service {
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
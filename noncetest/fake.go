@@ -0,0 +1,94 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package noncetest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// Fake is an in-memory nonce.Service running on a Clock that can be told to
+// fail, so failure paths such as an expired link can be tested on demand:
+//
+//	f := noncetest.NewFake()
+//	defer f.Shutdown()
+//	f.FailNext("CheckThenConsume", nonce.ErrTokenExpired)
+//
+// Its optional interfaces, such as nonce.Lister, are on f.Service.
+type Fake struct {
+	nonce.Service
+	Clock *Clock
+
+	mu     sync.Mutex
+	queued map[string][]error
+	always map[string]error
+}
+
+// NewFake returns a Fake on a Clock stopped at the current time. opts are
+// passed to nonce.NewInMemoryService after nonce.WithClock.
+func NewFake(opts ...nonce.Option) *Fake {
+	f := &Fake{
+		Clock:  NewClock(time.Time{}),
+		queued: map[string][]error{},
+		always: map[string]error{},
+	}
+	opts = append([]nonce.Option{nonce.WithClock(f.Clock)}, opts...)
+	f.Service = nonce.Decorate(nonce.NewInMemoryService(opts...), f.intercept)
+	return f
+}
+
+// FailNext makes the next call to method, e.g. "Check", return err without
+// reaching the store. Errors queued for the same method are returned in order.
+func (f *Fake) FailNext(method string, err error) {
+	f.mu.Lock()
+	f.queued[method] = append(f.queued[method], err)
+	f.mu.Unlock()
+}
+
+// Fail makes every call to method return err until Reset
+func (f *Fake) Fail(method string, err error) {
+	f.mu.Lock()
+	f.always[method] = err
+	f.mu.Unlock()
+}
+
+// Reset clears the errors set with FailNext and Fail
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	f.queued = map[string][]error{}
+	f.always = map[string]error{}
+	f.mu.Unlock()
+}
+
+func (f *Fake) intercept(c nonce.Call, next func() error) error {
+	err := f.forced(c.Method)
+	if err != nil {
+		return err
+	}
+	return next()
+}
+
+// forced returns the error method should fail with, if any
+func (f *Fake) forced(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if q := f.queued[method]; len(q) > 0 {
+		f.queued[method] = q[1:]
+		return q[0]
+	}
+	return f.always[method]
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package noncetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+func TestFake(t *testing.T) {
+	f := NewFake()
+	defer f.Shutdown()
+
+	n, err := f.New("reset", testUserID, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	f.FailNext("Check", nonce.ErrTokenExpired)
+	err = f.Check(n.Token, "reset", testUserID)
+	if err != nonce.ErrTokenExpired {
+		t.Errorf("Expected the forced error. Instead got: %v", err)
+	}
+	err = f.Check(n.Token, "reset", testUserID)
+	if err != nil {
+		t.Errorf("Expected the forced error to be used up. Instead got: %v", err)
+	}
+
+	backendDown := errors.New("backend down")
+	f.Fail("Consume", backendDown)
+	for i := 0; i < 2; i++ {
+		_, err = f.Consume(n.Token)
+		if err != backendDown {
+			t.Errorf("Expected every Consume to fail. Instead got: %v", err)
+		}
+	}
+	f.Reset()
+
+	f.Clock.Add(2 * time.Minute)
+	_, err = f.CheckThenConsume(n.Token, "reset", testUserID)
+	if !errors.Is(err, nonce.ErrTokenExpired) {
+		t.Errorf("Expected the nonce to expire with the clock. Instead got: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package noncetest provides helpers for testing code that uses nonce.
+package noncetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultExpiresIn is how long nonces created by a Fixture stay valid
+var DefaultExpiresIn = time.Hour
+
+// Fixture creates nonces in specific states directly in a Service's store,
+// so tests can set up edge cases without going through New, Consume etc.
+type Fixture struct {
+	t     testing.TB
+	store nonce.Putter
+	clock nonce.Clock
+}
+
+// NewFixture returns a Fixture that stores nonces in s.
+// s must implement nonce.Putter; both built in Services do.
+// clock should be the Clock s was created with. A nil clock uses nonce.SystemClock.
+func NewFixture(t testing.TB, s nonce.Service, clock nonce.Clock) *Fixture {
+	p, ok := s.(nonce.Putter)
+	if !ok {
+		t.Fatalf("Expected Service %T to implement nonce.Putter", s)
+	}
+	if clock == nil {
+		clock = nonce.SystemClock
+	}
+
+	return &Fixture{
+		t:     t,
+		store: p,
+		clock: clock,
+	}
+}
+
+// Put stores n as given, filling in any missing Token, Salt, CreatedAt or ID
+func (f *Fixture) Put(n nonce.Nonce) nonce.Nonce {
+	n, err := f.store.PutNonce(n)
+	if err != nil {
+		f.t.Fatalf("Expected to store fixture nonce. Instead got the error: %v", err)
+	}
+	return n
+}
+
+// Valid stores a valid, unused nonce that expires after DefaultExpiresIn
+func (f *Fixture) Valid(action string, uid uuid.UUID) nonce.Nonce {
+	return f.Put(f.base(action, uid))
+}
+
+// Expired stores a valid, unused nonce that expired a minute ago
+func (f *Fixture) Expired(action string, uid uuid.UUID) nonce.Nonce {
+	n := f.base(action, uid)
+	n.ExpiresAt = f.clock.Now().Add(-time.Minute).Truncate(time.Second)
+	return f.Put(n)
+}
+
+// Used stores a nonce that has already been consumed
+func (f *Fixture) Used(action string, uid uuid.UUID) nonce.Nonce {
+	n := f.base(action, uid)
+	n.IsUsed = true
+	return f.Put(n)
+}
+
+// Invalid stores a nonce that has been invalidated, e.g. by a newer New
+func (f *Fixture) Invalid(action string, uid uuid.UUID) nonce.Nonce {
+	n := f.base(action, uid)
+	n.IsValid = false
+	return f.Put(n)
+}
+
+// base returns an unsaved, valid nonce for action and uid
+func (f *Fixture) base(action string, uid uuid.UUID) nonce.Nonce {
+	t := f.clock.Now()
+	return nonce.Nonce{
+		UserID:    uid,
+		Action:    action,
+		IsValid:   true,
+		CreatedAt: t.Unix(),
+		ExpiresAt: t.Add(DefaultExpiresIn).Truncate(time.Second),
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package noncetest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+var testUserID = uuid.FromStringOrNil("7d0d3e0a-6a3c-4f5e-9e0e-1c2b3a4d5e6f")
+
+func TestFixture(t *testing.T) {
+	s := nonce.NewInMemoryService()
+	defer s.Shutdown()
+	f := NewFixture(t, s, nil)
+
+	tests := []struct {
+		name string
+		make func(string, uuid.UUID) nonce.Nonce
+		want error
+	}{
+		{"Valid", f.Valid, nil},
+		{"Expired", f.Expired, nonce.ErrTokenExpired},
+		{"Used", f.Used, nonce.ErrTokenUsed},
+		{"Invalid", f.Invalid, nonce.ErrInvalidToken},
+	}
+
+	var nonces []nonce.Nonce
+	for _, tt := range tests {
+		n := tt.make(tt.name, testUserID)
+		err := s.Check(n.Token, tt.name, testUserID)
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("Expected %s fixture check to return: %v. Instead got: %v", tt.name, tt.want, err)
+		}
+		nonces = append(nonces, n)
+	}
+
+	Golden(t, "testdata/fixtures.golden", nonces...)
+}
@@ -0,0 +1,79 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package noncetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// UpdateGolden makes Golden rewrite golden files instead of comparing against them.
+// It defaults to true when the NONCETEST_UPDATE_GOLDEN environment variable is set.
+var UpdateGolden = os.Getenv("NONCETEST_UPDATE_GOLDEN") != ""
+
+// goldenNonce is the stable part of a Nonce. Tokens, salts, IDs and
+// timestamps change on every run so only the lifetime is recorded.
+type goldenNonce struct {
+	UserID    string `json:"user_id"`
+	Action    string `json:"action"`
+	IsUsed    bool   `json:"is_used"`
+	IsValid   bool   `json:"is_valid"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// Golden compares nonces against the golden file at path (usually under testdata/)
+func Golden(t testing.TB, path string, nonces ...nonce.Nonce) {
+	g := make([]goldenNonce, 0, len(nonces))
+	for _, n := range nonces {
+		g = append(g, goldenNonce{
+			UserID:    n.UserID.String(),
+			Action:    n.Action,
+			IsUsed:    n.IsUsed,
+			IsValid:   n.IsValid,
+			ExpiresIn: n.ExpiresAt.Sub(time.Unix(n.CreatedAt, 0)).Truncate(time.Second).String(),
+		})
+	}
+	got, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		t.Fatalf("Expected to marshal nonces. Instead got the error: %v", err)
+	}
+	got = append(got, '\n')
+
+	if UpdateGolden {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, got, 0644)
+		}
+		if err != nil {
+			t.Fatalf("Expected to write golden file: %s. Instead got the error: %v", path, err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected to read golden file: %s. Instead got the error: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Expected nonces to match golden file: %s.\nWant:\n%s\nGot:\n%s", path, want, got)
+	}
+}
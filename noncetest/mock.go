@@ -0,0 +1,124 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package noncetest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Mock is a nonce.Service that records every call made to it and answers with
+// the function set for the method. A method without one returns the zero
+// Nonce and no error. It is safe for concurrent use once its functions are set.
+type Mock struct {
+	NewFunc              func(action string, uid uuid.UUID, expiresIn time.Duration) (nonce.Nonce, error)
+	CheckFunc            func(token, action string, uid uuid.UUID) error
+	ConsumeFunc          func(token string) (nonce.Nonce, error)
+	CheckThenConsumeFunc func(token, action string, uid uuid.UUID) (nonce.Nonce, error)
+	ConsumeByIDFunc      func(id uuid.UUID, action string, uid uuid.UUID) (nonce.Nonce, error)
+	GetFunc              func(action string, uid uuid.UUID) (nonce.Nonce, error)
+	RenewFunc            func(token string, extendBy time.Duration) (nonce.Nonce, error)
+
+	mu    sync.Mutex
+	calls []nonce.Call
+}
+
+// Calls returns the calls made so far, oldest first
+func (m *Mock) Calls() []nonce.Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]nonce.Call(nil), m.calls...)
+}
+
+// CallsTo returns the calls made so far to method, oldest first
+func (m *Mock) CallsTo(method string) []nonce.Call {
+	var calls []nonce.Call
+	for _, c := range m.Calls() {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// record adds a call to method with params set to args
+func (m *Mock) record(method string, params []string, args ...interface{}) {
+	m.mu.Lock()
+	m.calls = append(m.calls, nonce.Call{Method: method, Params: params, Args: args})
+	m.mu.Unlock()
+}
+
+func (m *Mock) New(action string, uid uuid.UUID, expiresIn time.Duration) (nonce.Nonce, error) {
+	m.record("New", []string{"action", "uid", "expiresIn"}, action, uid, expiresIn)
+	if m.NewFunc == nil {
+		return nonce.Nonce{}, nil
+	}
+	return m.NewFunc(action, uid, expiresIn)
+}
+
+func (m *Mock) Check(token, action string, uid uuid.UUID) error {
+	m.record("Check", []string{"token", "action", "uid"}, token, action, uid)
+	if m.CheckFunc == nil {
+		return nil
+	}
+	return m.CheckFunc(token, action, uid)
+}
+
+func (m *Mock) Consume(token string) (nonce.Nonce, error) {
+	m.record("Consume", []string{"token"}, token)
+	if m.ConsumeFunc == nil {
+		return nonce.Nonce{}, nil
+	}
+	return m.ConsumeFunc(token)
+}
+
+func (m *Mock) CheckThenConsume(token, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	m.record("CheckThenConsume", []string{"token", "action", "uid"}, token, action, uid)
+	if m.CheckThenConsumeFunc == nil {
+		return nonce.Nonce{}, nil
+	}
+	return m.CheckThenConsumeFunc(token, action, uid)
+}
+
+func (m *Mock) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	m.record("ConsumeByID", []string{"id", "action", "uid"}, id, action, uid)
+	if m.ConsumeByIDFunc == nil {
+		return nonce.Nonce{}, nil
+	}
+	return m.ConsumeByIDFunc(id, action, uid)
+}
+
+func (m *Mock) Get(action string, uid uuid.UUID) (nonce.Nonce, error) {
+	m.record("Get", []string{"action", "uid"}, action, uid)
+	if m.GetFunc == nil {
+		return nonce.Nonce{}, nil
+	}
+	return m.GetFunc(action, uid)
+}
+
+func (m *Mock) Renew(token string, extendBy time.Duration) (nonce.Nonce, error) {
+	m.record("Renew", []string{"token", "extendBy"}, token, extendBy)
+	if m.RenewFunc == nil {
+		return nonce.Nonce{}, nil
+	}
+	return m.RenewFunc(token, extendBy)
+}
+
+func (m *Mock) Shutdown() {
+	m.record("Shutdown", nil)
+}
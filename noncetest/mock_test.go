@@ -0,0 +1,50 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package noncetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestMock(t *testing.T) {
+	var s nonce.Service = &Mock{
+		CheckThenConsumeFunc: func(token, action string, uid uuid.UUID) (nonce.Nonce, error) {
+			return nonce.Nonce{}, nonce.ErrTokenUsed
+		},
+	}
+	m := s.(*Mock)
+
+	_, err := s.New("reset", testUserID, time.Hour)
+	if err != nil {
+		t.Errorf("Expected a method without a function to succeed. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume("token", "reset", testUserID)
+	if err != nonce.ErrTokenUsed {
+		t.Errorf("Expected the function's error. Instead got: %v", err)
+	}
+
+	calls := m.Calls()
+	if len(calls) != 2 || calls[0].Method != "New" || calls[1].Method != "CheckThenConsume" {
+		t.Fatalf("Expected New then CheckThenConsume to be recorded. Instead got: %+v", calls)
+	}
+	c := m.CallsTo("CheckThenConsume")
+	if len(c) != 1 || c[0].Args[0] != "token" || c[0].Params[2] != "uid" || c[0].Args[2] != testUserID {
+		t.Errorf("Expected the call's arguments to be recorded. Instead got: %+v", c)
+	}
+}
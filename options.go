@@ -0,0 +1,159 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"io"
+	"time"
+)
+
+// Option configures a Service when it is created
+type Option func(*config)
+
+// config holds the settings shared by all Service implementations
+type config struct {
+	clock          Clock
+	hasher         Hasher
+	encoding       TokenEncoding
+	versionTokens  bool
+	paseto         *pasetoKey
+	peppers        *pepperSet
+	legacyHashers  []Hasher
+	logger         Logger
+	auditor        Auditor
+	hooks          Hooks
+	broadcaster    Broadcaster
+	sampling       SampleRates
+	limits         Limits
+	journal        *journal
+	attempts       *attemptLimit
+	rateLimit      *rateLimit
+	outstanding    outstandingLimit
+	readYourWrites time.Duration
+	retention      time.Duration
+	expiryLeeway   time.Duration
+	userRequired   bool
+	maxEntries     int
+	persist        *persistence
+	counters       *Counters
+	cipher         *fieldCipher
+	hashTokens     bool
+	tokenKey       []byte
+	random         io.Reader
+
+	keyPrefix string
+	namespace string
+
+	// values holds the BackendOptions, by key
+	values map[interface{}]interface{}
+
+	// copied from the package tuning variables when the Service is created
+	// so changing them later can't race with its goroutines
+	listChunkSize         int
+	purgeBatchSize        int
+	removeExpiredInterval time.Duration
+	removeExpiredJitter   time.Duration
+	sweepStallIntervals   int
+	awaitPollInterval     time.Duration
+}
+
+// newConfig returns the default config with opts applied
+func newConfig(opts []Option) config {
+	c := config{
+		clock:         SystemClock,
+		hasher:        DefaultHasher,
+		encoding:      Base64URL,
+		legacyHashers: []Hasher{SHA512},
+		logger:        nopLogger{},
+		limits:        DefaultLimits,
+		keyPrefix:     "nonce/",
+
+		listChunkSize:         ListChunkSize,
+		purgeBatchSize:        PurgeBatchSize,
+		removeExpiredInterval: RemoveExpiredInterval,
+		removeExpiredJitter:   RemoveExpiredJitter,
+		sweepStallIntervals:   SweepStallIntervals,
+		awaitPollInterval:     AwaitPollInterval,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	c.checkTuning()
+	return c
+}
+
+// checkTuning panics if a package tuning variable was set to a value that
+// would make the Service's goroutines spin or its scans never finish
+func (c config) checkTuning() {
+	switch {
+	case c.listChunkSize < 1:
+		panic("nonce: ListChunkSize must be positive")
+	case c.purgeBatchSize < 1:
+		panic("nonce: PurgeBatchSize must be positive")
+	case c.removeExpiredInterval <= 0:
+		panic("nonce: RemoveExpiredInterval must be positive")
+	case c.removeExpiredJitter < 0:
+		panic("nonce: RemoveExpiredJitter must not be negative")
+	case c.sweepStallIntervals < 1:
+		panic("nonce: SweepStallIntervals must be positive")
+	case c.awaitPollInterval <= 0:
+		panic("nonce: AwaitPollInterval must be positive")
+	}
+}
+
+// Clock tells a Service what time it is.
+// Supplying your own Clock lets tests and replay tooling control expiry.
+// It must be safe for concurrent use.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the default Clock and reports the local system time
+var SystemClock Clock = systemClock{}
+
+// WithClock sets the Clock the Service uses when creating and checking nonces
+func WithClock(c Clock) Option {
+	return func(cfg *config) {
+		if c != nil {
+			cfg.clock = c
+		}
+	}
+}
+
+// Logger receives errors a Service can't return to a caller,
+// such as failures while removing expired nonces. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+// WithLogger sets the Logger internal errors are reported to.
+// By default they are discarded.
+func WithLogger(l Logger) Option {
+	return func(cfg *config) {
+		if l != nil {
+			cfg.logger = l
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// errNotEvicted stops a store invalidating a nonce that changed since it was listed
+var errNotEvicted = errors.New("nonce: not evicted")
+
+// Eviction is what New does when it would go over a WithMaxOutstanding cap
+type Eviction int
+
+const (
+	// RejectNew fails New with ErrTooManyNonces
+	RejectNew Eviction = iota
+	// InvalidateOldest invalidates the oldest outstanding nonces to make room
+	InvalidateOldest
+)
+
+// WithMaxOutstanding caps the valid, unused nonces that haven't expired at
+// perUser for each user across all their actions, and at total for the whole
+// of an in-memory Service; other backends ignore total. A nonce New is about to
+// replace doesn't count. New and NewBatch calls over a cap get ErrTooManyNonces
+// or make room as eviction says. A zero cap is unlimited. The caps are checked
+// before the nonces are created, so concurrent calls can briefly overshoot them.
+func WithMaxOutstanding(perUser, total int, eviction Eviction) Option {
+	return func(cfg *config) {
+		cfg.outstanding = outstandingLimit{perUser: perUser, total: total, eviction: eviction}
+	}
+}
+
+// outstandingLimit holds the caps set by WithMaxOutstanding
+type outstandingLimit struct {
+	perUser  int
+	total    int
+	eviction Eviction
+}
+
+func (l outstandingLimit) enabled() bool {
+	return l.perUser > 0 || l.total > 0
+}
+
+// Evicter is implemented by backends that can invalidate a single nonce, for
+// WithMaxOutstanding
+type Evicter interface {
+	Lister
+	// Evict invalidates n unless it was used or invalidated since it was listed
+	Evict(n Nonce) (bool, error)
+}
+
+func (l outstandingLimit) interceptor(s Service) Interceptor {
+	e, ok := s.(Evicter)
+	_, inMem := s.(*nonceInMemoryService)
+	return func(c Call, next func() error) error {
+		var reqs []NewRequest
+		switch c.Method {
+		case "New", "NewBound", "NewWithPayload":
+			reqs = []NewRequest{{Action: c.Args[0].(string), UserID: c.Args[1].(uuid.UUID)}}
+		case "NewBatch":
+			reqs = c.Args[1].([]NewRequest)
+		default:
+			return next()
+		}
+		if !ok {
+			return next()
+		}
+
+		err := l.makeRoom(e, reqs, inMem)
+		if err != nil {
+			return err
+		}
+		return next()
+	}
+}
+
+// makeRoom checks the caps against what creating reqs would leave outstanding
+func (l outstandingLimit) makeRoom(e Evicter, reqs []NewRequest, inMem bool) error {
+	replaced := make(map[userAction]bool)
+	created := make(map[uuid.UUID]int)
+	anonymous := 0
+	for _, r := range reqs {
+		if r.UserID == uuid.Nil {
+			// anonymous nonces replace nothing and have no user to cap
+			anonymous++
+			continue
+		}
+		k := userAction{action: r.Action, uid: r.UserID}
+		if !replaced[k] {
+			replaced[k] = true
+			created[r.UserID]++
+		}
+	}
+
+	// reject requests no eviction could make room for before evicting anything
+	if l.perUser > 0 {
+		for _, n := range created {
+			if n > l.perUser {
+				return ErrTooManyNonces
+			}
+		}
+	}
+	total := l.total > 0 && inMem
+	if total && len(replaced)+anonymous > l.total {
+		return ErrTooManyNonces
+	}
+
+	if l.perUser > 0 {
+		for uid, n := range created {
+			err := l.fit(e, Filter{UserID: uid, Outstanding: true}, replaced, l.perUser-n)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if total {
+		return l.fit(e, Filter{Outstanding: true}, replaced, l.total-len(replaced)-anonymous)
+	}
+	return nil
+}
+
+// fit leaves at most room of the nonces matching f outstanding, not counting
+// those being replaced, or returns ErrTooManyNonces if l doesn't evict
+func (l outstandingLimit) fit(e Evicter, f Filter, replaced map[userAction]bool, room int) error {
+	var others []Nonce
+	err := e.List(context.Background(), f, func(n Nonce) error {
+		if !replaced[userAction{action: n.Action, uid: n.UserID}] {
+			others = append(others, n)
+		}
+		return nil
+	})
+	if err != nil || len(others) <= room {
+		return err
+	}
+	if l.eviction != InvalidateOldest {
+		return ErrTooManyNonces
+	}
+
+	sort.SliceStable(others, func(i, j int) bool {
+		return others[i].CreatedAt < others[j].CreatedAt
+	})
+	for _, n := range others[:len(others)-room] {
+		_, err = e.Evict(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *nonceInMemoryService) Evict(n Nonce) (bool, error) {
+	n, err := s.store.update(n.Token, evictNonce)
+	if err == errNotEvicted || err == ErrTokenNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	s.cfg.invalidated(n)
+	s.broadcast(broadcastInvalid, n)
+	return true, nil
+}
+
+// evictNonce is the update the key-value stores apply to evict a nonce
+func evictNonce(n Nonce) (Nonce, error) {
+	if n.IsValid == false || n.IsUsed == true {
+		return Nonce{}, errNotEvicted
+	}
+	n.IsValid = false
+	return n, nil
+}
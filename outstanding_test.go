@@ -0,0 +1,160 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestMaxOutstanding(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func(opts ...Option) Service
+	}{
+		{"inmem", func(opts ...Option) Service { return NewInMemoryService(opts...) }},
+		{"sqlx", openTestStore(t, "sqlx")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &testClock{}
+			hooks, ch := recordHooks()
+			reject := tt.new(WithClock(clock), WithMaxOutstanding(2, 0, RejectNew))
+			defer reject.Shutdown()
+			evict := tt.new(WithClock(clock), WithMaxOutstanding(2, 0, InvalidateOldest),
+				WithHooks(Hooks{OnInvalidated: hooks.OnInvalidated}))
+			defer evict.Shutdown()
+
+			uid := uuid.NewV4()
+			_, err := reject.New("a", uid, time.Hour)
+			if err != nil {
+				t.Fatalf("Expected New to succeed. Instead got: %v", err)
+			}
+			clock.Add(time.Second)
+			_, err = reject.New("b", uid, time.Hour)
+			if err != nil {
+				t.Fatalf("Expected New to succeed. Instead got: %v", err)
+			}
+			_, err = reject.New("c", uid, time.Hour)
+			if err != ErrTooManyNonces {
+				t.Fatalf("Expected ErrTooManyNonces. Instead got: %v", err)
+			}
+
+			// replacing one of the user's nonces doesn't need room
+			_, err = reject.New("a", uid, time.Hour)
+			if err != nil {
+				t.Fatalf("Expected New replacing a nonce to succeed. Instead got: %v", err)
+			}
+
+			// consumed nonces aren't outstanding
+			n, err := reject.New("a", uid, time.Hour)
+			if err != nil {
+				t.Fatalf("Expected New to succeed. Instead got: %v", err)
+			}
+			_, err = reject.Consume(n.Token)
+			if err != nil {
+				t.Fatalf("Expected Consume to succeed. Instead got: %v", err)
+			}
+			_, err = reject.New("c", uid, time.Hour)
+			if err != nil {
+				t.Fatalf("Expected New to succeed once a nonce was consumed. Instead got: %v", err)
+			}
+
+			// with eviction the oldest nonce is invalidated to make room
+			uid = uuid.NewV4()
+			first, err := evict.New("a", uid, time.Hour)
+			if err != nil {
+				t.Fatalf("Expected New to succeed. Instead got: %v", err)
+			}
+			clock.Add(time.Second)
+			second, err := evict.New("b", uid, time.Hour)
+			if err != nil {
+				t.Fatalf("Expected New to succeed. Instead got: %v", err)
+			}
+			clock.Add(time.Second)
+			_, err = evict.New("c", uid, time.Hour)
+			if err != nil {
+				t.Fatalf("Expected New to evict and succeed. Instead got: %v", err)
+			}
+			err = evict.Check(first.Token, "a", uid)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Expected the oldest nonce to be invalidated. Instead got: %v", err)
+			}
+			err = evict.Check(second.Token, "b", uid)
+			if err != nil {
+				t.Fatalf("Expected the newer nonce to stay valid. Instead got: %v", err)
+			}
+			expectHooks(t, ch, map[string]uuid.UUID{"invalidated": first.ID})
+
+			// a batch that can't fit is rejected whatever the eviction
+			_, err = evict.(Batcher).NewBatch(context.Background(), []NewRequest{
+				{Action: "x", UserID: uid, ExpiresIn: time.Hour},
+				{Action: "y", UserID: uid, ExpiresIn: time.Hour},
+				{Action: "z", UserID: uid, ExpiresIn: time.Hour},
+			})
+			if err != ErrTooManyNonces {
+				t.Fatalf("Expected ErrTooManyNonces for the batch. Instead got: %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxOutstandingTotal(t *testing.T) {
+	clock := &testClock{}
+	s := NewInMemoryService(WithClock(clock), WithMaxOutstanding(0, 2, InvalidateOldest))
+	defer s.Shutdown()
+
+	var nonces []Nonce
+	for i := 0; i < 3; i++ {
+		n, err := s.New("total", uuid.NewV4(), time.Hour)
+		if err != nil {
+			t.Fatalf("Expected New to succeed. Instead got: %v", err)
+		}
+		nonces = append(nonces, n)
+		clock.Add(time.Second)
+	}
+
+	err := s.Check(nonces[0].Token, "total", nonces[0].UserID)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Expected the oldest nonce to be invalidated. Instead got: %v", err)
+	}
+	for _, n := range nonces[1:] {
+		err = s.Check(n.Token, "total", n.UserID)
+		if err != nil {
+			t.Fatalf("Expected the newer nonces to stay valid. Instead got: %v", err)
+		}
+	}
+
+	// expired nonces don't count against the cap
+	reject := NewInMemoryService(WithClock(clock), WithMaxOutstanding(0, 1, RejectNew))
+	defer reject.Shutdown()
+	_, err = reject.New("total", uuid.NewV4(), time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	_, err = reject.New("total", uuid.NewV4(), time.Minute)
+	if err != ErrTooManyNonces {
+		t.Fatalf("Expected ErrTooManyNonces. Instead got: %v", err)
+	}
+	clock.Add(2 * time.Minute)
+	_, err = reject.New("total", uuid.NewV4(), time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed once the nonce expired. Instead got: %v", err)
+	}
+}
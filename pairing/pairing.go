@@ -0,0 +1,198 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pairing confirms a sign in on one device from another, like the
+// TV-style flow where a TV shows a short code and the user enters it on
+// their phone, already signed in, to sign the TV in too:
+//
+//	// on the TV
+//	p, err := pairings.Start()
+//	show(p.Code)
+//	uid, err := pairings.Wait(ctx, p.ID)
+//
+//	// on the phone, for the signed in user
+//	err := pairings.Approve(code, uid)
+//
+// The code is the token of an anonymous nonce, which approving consumes, so
+// each code can be approved once. The approval is itself a nonce for the
+// approving user, which Wait consumes, so the TV is signed in once.
+package pairing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Actions the nonces are created for. An approval's action is ApprovedAction
+// followed by the ID of the pairing it approves.
+const (
+	PairAction     = "pair"
+	ApprovedAction = "pair-approved:"
+)
+
+var (
+	// ErrPending is returned by Poll for a pairing that hasn't been approved yet
+	ErrPending = errors.New("pairing: not approved yet")
+
+	// ErrNotAwaiter is returned by New for a Service that isn't a nonce.Awaiter
+	ErrNotAwaiter = errors.New("pairing: Service must implement nonce.Awaiter")
+
+	// ErrNotLister is returned by New for a Service that isn't a nonce.Lister
+	ErrNotLister = errors.New("pairing: Service must implement nonce.Lister")
+)
+
+// ApprovalPollInterval is how often Wait looks for the approval of a pairing
+// whose code has been consumed but whose approval hasn't been stored yet
+var ApprovalPollInterval = 50 * time.Millisecond
+
+// CodeOptions make a Service issue 8 character codes, e.g. "MFRGG43F", that are
+// easy to type. Codes are only 40 bits, so rate limit whatever calls Approve.
+func CodeOptions() []nonce.Option {
+	return []nonce.Option{nonce.WithHasher(nonce.RandomBytes(5)), nonce.WithTokenEncoding(nonce.Base32)}
+}
+
+// Config configures Pairings
+type Config struct {
+	// Service stores the pairings, e.g. one created with CodeOptions. It must
+	// implement nonce.Awaiter and nonce.Lister.
+	Service nonce.Service
+
+	// TTL is how long a code can be approved for, and then how long the device
+	// has to notice the approval. Zero uses 10 minutes.
+	TTL time.Duration
+}
+
+// Pairings starts, approves and waits on pairings
+type Pairings struct {
+	cfg   Config
+	await nonce.Awaiter
+	list  nonce.Lister
+}
+
+// New returns Pairings for cfg, or ErrNotAwaiter or ErrNotLister if
+// cfg.Service isn't a nonce.Awaiter and a nonce.Lister
+func New(cfg Config) (*Pairings, error) {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 10 * time.Minute
+	}
+	await, ok := cfg.Service.(nonce.Awaiter)
+	if !ok {
+		return nil, ErrNotAwaiter
+	}
+	list, ok := cfg.Service.(nonce.Lister)
+	if !ok {
+		return nil, ErrNotLister
+	}
+	return &Pairings{cfg: cfg, await: await, list: list}, nil
+}
+
+// Pairing is a pairing waiting to be approved
+type Pairing struct {
+	// ID identifies the pairing to Wait and Poll. Only the device being
+	// signed in should know it.
+	ID uuid.UUID
+
+	// Code is what the user enters on the other device
+	Code string
+
+	ExpiresAt time.Time
+}
+
+// Start creates a pairing for a device to show the code of
+func (p *Pairings) Start() (Pairing, error) {
+	n, err := p.cfg.Service.New(PairAction, uuid.Nil, p.cfg.TTL)
+	if err != nil {
+		return Pairing{}, err
+	}
+	return Pairing{ID: n.ID, Code: n.Token, ExpiresAt: n.ExpiresAt}, nil
+}
+
+// Approve approves the pairing with code for uid, consuming the code. It
+// returns the Service's error for a code that is unknown, expired or already
+// approved, e.g. nonce.ErrTokenUsed.
+func (p *Pairings) Approve(code string, uid uuid.UUID) error {
+	if uid == uuid.Nil {
+		return nonce.ErrUserRequired
+	}
+	n, err := p.cfg.Service.CheckThenConsume(code, PairAction, uuid.Nil)
+	if err != nil {
+		return err
+	}
+	_, err = p.cfg.Service.New(ApprovedAction+n.ID.String(), uid, p.cfg.TTL)
+	return err
+}
+
+// Wait blocks until the pairing with id is approved and returns the user who
+// approved it. It returns nonce.ErrTokenExpired once the code can no longer
+// be approved, nonce.ErrTokenUsed if the approval was already returned, or
+// ctx.Err() when ctx is done.
+func (p *Pairings) Wait(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	_, err := p.await.AwaitConsumption(ctx, id)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	// the approval is stored just after the code is consumed
+	for {
+		uid, err := p.claim(ctx, id)
+		if err != ErrPending {
+			return uid, err
+		}
+		select {
+		case <-time.After(ApprovalPollInterval):
+		case <-ctx.Done():
+			return uuid.Nil, ctx.Err()
+		}
+	}
+}
+
+// Poll is Wait for devices that ask again later instead of blocking. It
+// returns ErrPending if the pairing hasn't been approved yet.
+func (p *Pairings) Poll(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	// a done context makes AwaitConsumption look at the nonce only once
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := p.await.AwaitConsumption(done, id)
+	if err == context.Canceled && ctx.Err() == nil {
+		return uuid.Nil, ErrPending
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+	return p.claim(ctx, id)
+}
+
+// claim consumes the approval of the pairing with id, returning ErrPending
+// if there isn't one yet
+func (p *Pairings) claim(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	action := ApprovedAction + id.String()
+	var approval nonce.Nonce
+	err := p.list.List(ctx, nonce.Filter{Action: action}, func(n nonce.Nonce) error {
+		approval = n
+		return nil
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if approval.ID == uuid.Nil {
+		return uuid.Nil, ErrPending
+	}
+	_, err = p.cfg.Service.ConsumeByID(approval.ID, action, approval.UserID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return approval.UserID, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pairing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/noncetest"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPairing(t *testing.T) {
+	s := nonce.NewInMemoryService(CodeOptions()...)
+	defer s.Shutdown()
+	pairings, err := New(Config{Service: s})
+	if err != nil {
+		t.Fatalf("Expected to create Pairings. Instead got the error: %v", err)
+	}
+	uid := uuid.NewV4()
+
+	p, err := pairings.Start()
+	if err != nil || len(p.Code) != 8 {
+		t.Fatalf("Expected an 8 character code. Instead got: %q, %v", p.Code, err)
+	}
+	_, err = pairings.Poll(context.Background(), p.ID)
+	if err != ErrPending {
+		t.Errorf("Expected %v before the pairing is approved. Instead got: %v", ErrPending, err)
+	}
+
+	approved := make(chan error, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		approved <- pairings.Approve(" "+p.Code+"\n", uid)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := pairings.Wait(ctx, p.ID)
+	if err != nil || !uuid.Equal(got, uid) {
+		t.Fatalf("Expected Wait to return the approving user. Instead got: %v, %v", got, err)
+	}
+	if err = <-approved; err != nil {
+		t.Errorf("Expected the code to be approved. Instead got the error: %v", err)
+	}
+
+	if err = pairings.Approve(p.Code, uuid.NewV4()); !errors.Is(err, nonce.ErrTokenUsed) {
+		t.Errorf("Expected approving the code again to fail with %v. Instead got: %v", nonce.ErrTokenUsed, err)
+	}
+	if _, err = pairings.Wait(ctx, p.ID); !errors.Is(err, nonce.ErrTokenUsed) {
+		t.Errorf("Expected waiting again to fail with %v. Instead got: %v", nonce.ErrTokenUsed, err)
+	}
+	if err = pairings.Approve(p.Code, uuid.Nil); err != nonce.ErrUserRequired {
+		t.Errorf("Expected an anonymous approval to fail with %v. Instead got: %v", nonce.ErrUserRequired, err)
+	}
+}
+
+func TestPairingPoll(t *testing.T) {
+	s := nonce.NewInMemoryService(CodeOptions()...)
+	defer s.Shutdown()
+	pairings, err := New(Config{Service: s})
+	if err != nil {
+		t.Fatalf("Expected to create Pairings. Instead got the error: %v", err)
+	}
+	uid := uuid.NewV4()
+
+	p, _ := pairings.Start()
+	err = pairings.Approve(p.Code, uid)
+	if err != nil {
+		t.Fatalf("Expected the code to be approved. Instead got the error: %v", err)
+	}
+	got, err := pairings.Poll(context.Background(), p.ID)
+	if err != nil || !uuid.Equal(got, uid) {
+		t.Errorf("Expected Poll to return the approving user. Instead got: %v, %v", got, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	other, _ := pairings.Start()
+	_, err = pairings.Wait(ctx, other.ID)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected an unapproved pairing to wait until ctx is done. Instead got: %v", err)
+	}
+}
+
+func TestNewUnsupported(t *testing.T) {
+	_, err := New(Config{Service: &noncetest.Mock{}})
+	if err != ErrNotAwaiter {
+		t.Errorf("Expected %v for a Service without AwaitConsumption. Instead got: %v", ErrNotAwaiter, err)
+	}
+}
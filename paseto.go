@@ -0,0 +1,169 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// pasetoHeader starts every PASETO v4.local token
+const pasetoHeader = "v4.local."
+
+var errPASETO = errors.New("nonce: invalid PASETO token")
+
+// WithPASETO makes New issue each token as a PASETO v4.local token encrypted
+// under key, so systems that already validate PASETO can read what it is for
+// without asking the Service. Its claims are
+//
+//	jti     the nonce's own token, which is what the Service stores
+//	action  the nonce's Action
+//	sub     the nonce's UserID, left out for anonymous nonces
+//	iat     when it was created, as RFC 3339
+//	exp     when it expires, as RFC 3339
+//
+// with no footer or implicit assertion. Whether the nonce has been used is
+// still only known to the Service, which checks and consumes PASETO tokens
+// like any other after decrypting them. Nonces read back from the store carry
+// the jti in Token.
+func WithPASETO(key [32]byte) Option {
+	return func(cfg *config) {
+		cfg.paseto = &pasetoKey{key: key}
+	}
+}
+
+// pasetoKey seals and opens PASETO v4.local tokens
+type pasetoKey struct {
+	key [32]byte
+}
+
+// pasetoClaims is the payload of a nonce's PASETO token
+type pasetoClaims struct {
+	TokenID  string `json:"jti"`
+	Action   string `json:"action"`
+	Subject  string `json:"sub,omitempty"`
+	IssuedAt string `json:"iat"`
+	Expires  string `json:"exp"`
+}
+
+// issue returns the PASETO token for n, whose Token is its plain token
+func (k *pasetoKey) issue(n Nonce) (string, error) {
+	claims := pasetoClaims{
+		TokenID:  n.Token,
+		Action:   n.Action,
+		IssuedAt: time.Unix(n.CreatedAt, 0).UTC().Format(time.RFC3339),
+		Expires:  n.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if n.UserID != uuid.Nil {
+		claims.Subject = n.UserID.String()
+	}
+	m, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, 32)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return k.encrypt(m, nonce), nil
+}
+
+// tokenID returns the jti of a PASETO token sealed under k
+func (k *pasetoKey) tokenID(token string) (string, error) {
+	m, err := k.decrypt(token)
+	if err != nil {
+		return "", err
+	}
+	var claims pasetoClaims
+	err = json.Unmarshal(m, &claims)
+	if err != nil || claims.TokenID == "" {
+		return "", errPASETO
+	}
+	return claims.TokenID, nil
+}
+
+// encrypt is v4.local Encrypt with an empty footer and implicit assertion
+func (k *pasetoKey) encrypt(m, nonce []byte) string {
+	ek, n2, ak := k.split(nonce)
+	c := make([]byte, len(m))
+	xor, _ := chacha20.NewUnauthenticatedCipher(ek, n2)
+	xor.XORKeyStream(c, m)
+	t := pasetoMAC(ak, nonce, c)
+
+	b := make([]byte, 0, len(nonce)+len(c)+len(t))
+	b = append(append(append(b, nonce...), c...), t...)
+	return pasetoHeader + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decrypt is v4.local Decrypt for tokens without a footer
+func (k *pasetoKey) decrypt(token string) ([]byte, error) {
+	if !strings.HasPrefix(token, pasetoHeader) {
+		return nil, errPASETO
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token[len(pasetoHeader):])
+	if err != nil || len(b) < 64 {
+		return nil, errPASETO
+	}
+	nonce, c, t := b[:32], b[32:len(b)-32], b[len(b)-32:]
+	ek, n2, ak := k.split(nonce)
+	if subtle.ConstantTimeCompare(t, pasetoMAC(ak, nonce, c)) != 1 {
+		return nil, errPASETO
+	}
+	m := make([]byte, len(c))
+	xor, _ := chacha20.NewUnauthenticatedCipher(ek, n2)
+	xor.XORKeyStream(m, c)
+	return m, nil
+}
+
+// split derives the encryption key, XChaCha20 nonce and authentication key for nonce
+func (k *pasetoKey) split(nonce []byte) (ek, n2, ak []byte) {
+	tmp := pasetoHash(56, k.key[:], []byte("paseto-encryption-key"), nonce)
+	return tmp[:32], tmp[32:], pasetoHash(32, k.key[:], []byte("paseto-auth-key-for-aead"), nonce)
+}
+
+func pasetoMAC(ak, nonce, c []byte) []byte {
+	return pasetoHash(32, ak, pae([]byte(pasetoHeader), nonce, c, nil, nil))
+}
+
+// pasetoHash is keyed BLAKE2b of size bytes over msg
+func pasetoHash(size int, key []byte, msg ...[]byte) []byte {
+	// New only fails for sizes over 64 bytes and keys over 64 bytes
+	h, _ := blake2b.New(size, key)
+	for _, m := range msg {
+		h.Write(m)
+	}
+	return h.Sum(nil)
+}
+
+// pae is PASETO's pre-authentication encoding of pieces
+func pae(pieces ...[]byte) []byte {
+	b := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, p := range pieces {
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(p)))
+		b = append(b, p...)
+	}
+	return b
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// test vector 4-E-1 from the PASETO specification
+func TestPASETOVector(t *testing.T) {
+	var k pasetoKey
+	hex.Decode(k.key[:], []byte("707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"))
+	m := `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`
+	want := "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg"
+
+	got := k.encrypt([]byte(m), make([]byte, 32))
+	if got != want {
+		t.Fatalf("Expected %s. Instead got: %s", want, got)
+	}
+	b, err := k.decrypt(want)
+	if err != nil || string(b) != m {
+		t.Fatalf("Expected to decrypt %s. Instead got: %s, %v", m, b, err)
+	}
+}
+
+func TestPASETO(t *testing.T) {
+	key := [32]byte{7}
+	for name, opts := range map[string][]Option{
+		"plain":  {WithPASETO(key)},
+		"hashed": {WithPASETO(key), WithTokenHashing(nil)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewInMemoryService(opts...)
+			defer s.Shutdown()
+			uid := uuid.NewV4()
+
+			n, err := s.New("login", uid, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+			}
+			if !strings.HasPrefix(n.Token, pasetoHeader) {
+				t.Fatalf("Expected a PASETO token. Instead got: %q", n.Token)
+			}
+			k := pasetoKey{key: key}
+			b, err := k.decrypt(n.Token)
+			if err != nil || !strings.Contains(string(b), `"action":"login"`) || !strings.Contains(string(b), `"sub":"`+uid.String()+`"`) {
+				t.Errorf("Expected the token to carry the nonce's claims. Instead got: %s, %v", b, err)
+			}
+
+			err = s.Check(" "+n.Token+"\n", "login", uid)
+			if err != nil {
+				t.Fatalf("Expected Check to succeed. Instead got: %v", err)
+			}
+			tampered := n.Token[:len(n.Token)-2] + "AA"
+			err = s.Check(tampered, "login", uid)
+			if err != ErrInvalidToken {
+				t.Errorf("Expected a tampered token to return %v. Instead got: %v", ErrInvalidToken, err)
+			}
+			other := NewInMemoryService(WithPASETO([32]byte{8}))
+			defer other.Shutdown()
+			err = other.Check(n.Token, "login", uid)
+			if err != ErrInvalidToken {
+				t.Errorf("Expected another key to return %v. Instead got: %v", ErrInvalidToken, err)
+			}
+
+			_, err = s.Consume(n.Token)
+			if err != nil {
+				t.Errorf("Expected Consume to succeed. Instead got: %v", err)
+			}
+			_, err = s.Consume(n.Token)
+			if err == nil {
+				t.Errorf("Expected the token to be single use")
+			}
+		})
+	}
+}
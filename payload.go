@@ -0,0 +1,50 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Payloader is implemented by Services that can store application data with
+// a nonce, so the data a link confirms, such as a new email address, doesn't
+// have to be packed into its action. The payload comes back as Nonce.Payload
+// from every method that returns the nonce. WithEncryption encrypts it.
+type Payloader interface {
+	// NewWithPayload is New for a nonce carrying payload. It returns
+	// ErrPayloadTooLarge if payload is over the Payload limit.
+	NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (Nonce, error)
+}
+
+// newBoundNonce is newNonce for NewBound and NewWithPayload, binding the
+// nonce to b and storing payload with it
+func (c config) newBoundNonce(action string, uid uuid.UUID, expiresIn time.Duration, b Binding, payload string) (Nonce, error) {
+	binding, err := c.encodeBinding(b)
+	if err != nil {
+		return Nonce{}, err
+	}
+	err = c.checkPayload(payload)
+	if err != nil {
+		return Nonce{}, err
+	}
+	n, err := c.newNonce(action, uid, expiresIn, c.clock.Now())
+	if err != nil {
+		return Nonce{}, err
+	}
+	n.Binding, n.Payload = binding, payload
+	return n, nil
+}
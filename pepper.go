@@ -0,0 +1,145 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"strconv"
+	"strings"
+)
+
+// WithPepper mixes secret into every token the Service issues and tags the
+// token with id, as "p<id>." after any version segment. Tokens are stored as
+// an HMAC-SHA256 under secret, like WithTokenHashing does under its key, so a
+// copy of the store holds nothing that can be redeemed or checked against a
+// guess without the pepper. Deterministic Hashers such as SHA512 also hash an
+// HMAC-SHA512 of the nonce under secret instead of the nonce itself, so
+// knowing a stored nonce's action, user, creation time and salt isn't enough
+// to forge its token. As under WithTokenHashing, only the call that creates a
+// nonce sees its token. Keep secret out of the database it protects.
+//
+// Tokens issued before the Service had a pepper carry no id and are stored
+// and looked up as they were. To rotate, switch WithPepper to a new id and
+// pass the old pepper to WithVerifyPepper until its tokens have expired.
+// New returns ErrInvalidPepper if id isn't positive, secret is empty or id is
+// given twice.
+func WithPepper(id int, secret []byte) Option {
+	return func(cfg *config) {
+		cfg.addPepper(id, secret)
+		cfg.peppers.current = id
+	}
+}
+
+// WithVerifyPepper accepts tokens issued under a pepper WithPepper no longer
+// uses, without issuing new ones under it
+func WithVerifyPepper(id int, secret []byte) Option {
+	return func(cfg *config) {
+		cfg.addPepper(id, secret)
+	}
+}
+
+// pepperSet holds a Service's peppers by id
+type pepperSet struct {
+	// current is the id new tokens are issued under, 0 for none
+	current int
+	secrets map[int][]byte
+	// err is set when an option was given a bad pepper
+	err error
+}
+
+func (c *config) addPepper(id int, secret []byte) {
+	if c.peppers == nil {
+		c.peppers = &pepperSet{secrets: make(map[int][]byte)}
+	}
+	old, dup := c.peppers.secrets[id]
+	if id < 1 || len(secret) == 0 || (dup && !bytes.Equal(old, secret)) {
+		c.peppers.err = ErrInvalidPepper
+		return
+	}
+	c.peppers.secrets[id] = append([]byte(nil), secret...)
+}
+
+// pepperErr returns the error New fails with when a pepper option was bad
+func (c config) pepperErr() error {
+	if c.peppers == nil {
+		return nil
+	}
+	return c.peppers.err
+}
+
+// currentPepper returns the id and secret new tokens are issued under
+func (c config) currentPepper() (int, []byte) {
+	if c.peppers == nil || c.peppers.current == 0 {
+		return 0, nil
+	}
+	return c.peppers.current, c.peppers.secrets[c.peppers.current]
+}
+
+// tokenPepper returns the secret of the pepper token was issued under.
+// ok is false for a token with an id the Service doesn't know.
+func (c config) tokenPepper(token string) (secret []byte, ok bool) {
+	_, body := splitTokenVersion(token)
+	id, _, _ := splitTokenPepper(body)
+	if id == 0 {
+		return nil, true
+	}
+	if c.peppers == nil {
+		return nil, false
+	}
+	secret, ok = c.peppers.secrets[id]
+	return secret, ok
+}
+
+// pepperInput returns what the Hasher is given for the nonce described by raw
+func (c config) pepperInput(raw string) []byte {
+	_, secret := c.currentPepper()
+	if secret == nil {
+		return []byte(raw)
+	}
+	mac := hmac.New(sha512.New, secret)
+	mac.Write([]byte(raw))
+	return mac.Sum(nil)
+}
+
+// pepperToken tags body with the current pepper's id
+func (c config) pepperToken(body string) string {
+	id, _ := c.currentPepper()
+	if id == 0 {
+		return body
+	}
+	return "p" + strconv.Itoa(id) + "." + body
+}
+
+// splitTokenPepper splits a leading "p<id>." off a token body, returning 0
+// for a body without one. Like the version segment, '.' keeps it apart from
+// any token body.
+func splitTokenPepper(body string) (id int, prefix, rest string) {
+	i := strings.IndexByte(body, '.')
+	if i < 2 || body[0] != 'p' {
+		return 0, "", body
+	}
+	for _, r := range body[1:i] {
+		if r < '0' || r > '9' {
+			return 0, "", body
+		}
+	}
+	id, err := strconv.Atoi(body[1:i])
+	if err != nil || id < 1 {
+		return 0, "", body
+	}
+	return id, body[:i+1], body[i+1:]
+}
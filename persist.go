@@ -0,0 +1,109 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// WithPersistence makes an in-memory Service load the nonces saved at path
+// when it is created, save them there every flushInterval and once more on
+// Shutdown, giving small apps durability without a database. Nonces created
+// since the last save are lost if the process dies. Each save writes a
+// Snapshot to a temporary file that is renamed over path, so a crash mid-write
+// leaves the previous copy. Errors are reported to the Logger.
+// Other backends ignore it.
+func WithPersistence(path string, flushInterval time.Duration) Option {
+	return func(cfg *config) {
+		if path != "" && flushInterval > 0 {
+			cfg.persist = &persistence{path: path, interval: flushInterval}
+		}
+	}
+}
+
+// persistence serializes the saves of one Service's store
+type persistence struct {
+	sync.Mutex
+	path     string
+	interval time.Duration
+}
+
+// load restores the nonces saved at the persistence path, if there are any
+func (s *nonceInMemoryService) load() {
+	f, err := os.Open(s.cfg.persist.path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		s.cfg.logger.Printf("nonce: error opening persisted nonces: %v", err)
+		return
+	}
+	defer f.Close()
+
+	err = s.Restore(f)
+	if err != nil {
+		s.cfg.logger.Printf("nonce: error loading persisted nonces: %v", err)
+	}
+}
+
+// persistAll saves the store every interval until the Service is shut down
+func (s *nonceInMemoryService) persistAll() {
+	t := time.NewTicker(s.cfg.persist.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-t.C:
+			s.save()
+		}
+	}
+}
+
+// save writes a Snapshot of the store to the persistence path
+func (s *nonceInMemoryService) save() {
+	p := s.cfg.persist
+	p.Lock()
+	defer p.Unlock()
+
+	err := s.writeSnapshot(p.path)
+	if err != nil {
+		s.cfg.logger.Printf("nonce: error persisting nonces: %v", err)
+	}
+}
+
+func (s *nonceInMemoryService) writeSnapshot(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = s.Snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	cerr := f.Close()
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return cerr
+	}
+	return os.Rename(f.Name(), path)
+}
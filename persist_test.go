@@ -0,0 +1,78 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nonce-persist")
+	if err != nil {
+		t.Fatalf("Expected to create a temp dir. Instead got: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nonces.jsonl")
+
+	s := NewInMemoryService(WithPersistence(path, 10*time.Millisecond))
+	n, err := s.New(tNonce.Action, tNonce.UserID, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	// saved on the interval while the Service is running
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = os.Stat(path)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the nonces to be saved. Instead got: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// and once more on Shutdown, so the consume isn't lost
+	_, err = s.Consume(n.Token)
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+	kept, err := s.New("kept", tNonce.UserID, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	s.Shutdown()
+
+	restarted := NewInMemoryService(WithPersistence(path, time.Hour))
+	defer restarted.Shutdown()
+	err = restarted.Check(kept.Token, "kept", tNonce.UserID)
+	if err != nil {
+		t.Fatalf("Expected the saved nonce to be loaded. Instead got: %v", err)
+	}
+	got, err := restarted.(Inspector).GetByToken(n.Token)
+	if err != nil || !got.IsUsed {
+		t.Fatalf("Expected the consumed nonce to be loaded as used. Instead got: %+v, %v", got, err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected only the saved file to be left. Instead got: %d files, %v", len(files), err)
+	}
+}
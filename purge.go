@@ -0,0 +1,122 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PurgeBatchSize is how many expired nonces are deleted per statement.
+// Small batches keep each delete from locking a large table for long.
+// It is read when a Service is created.
+var PurgeBatchSize = 500
+
+// Purger is implemented by Services that can delete expired nonces on demand
+type Purger interface {
+	// PurgeExpired deletes up to limit expired nonces, or all of them if limit
+	// isn't positive, in batches of PurgeBatchSize. Consumed nonces still within
+	// the WithRetention window are kept. Expired nonces in every WithNamespace
+	// namespace sharing the store are deleted. It returns how many were
+	// deleted and stops between batches with ctx.Err() once ctx is done.
+	// Under sqlx.WithSoftDelete they are tombstoned instead.
+	PurgeExpired(ctx context.Context, limit int) (int64, error)
+}
+
+// errPurgeLimit stops the List in purgeExpired once limit nonces are queued
+var errPurgeLimit = errors.New("purge limit reached")
+
+// purgeExpired lists the nonces that expired before now and passes them to remove
+// a batch at a time. remove returns how many of the batch it deleted.
+func (c config) purgeExpired(ctx context.Context, l Lister, limit int, remove func(batch []Nonce, t time.Time) (int64, error)) (int64, error) {
+	// nonces within the expiry leeway can still be checked
+	t := c.expiryCutoff(c.clock.Now())
+	var purged int64
+	queued := 0
+	batch := make([]Nonce, 0, c.purgeBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := remove(batch, t)
+		purged += n
+		batch = batch[:0]
+		return err
+	}
+
+	err := l.List(ctx, Filter{ExpiredBefore: t, anyNamespace: true}, func(n Nonce) error {
+		if c.retained(n, t) {
+			return nil
+		}
+		batch = append(batch, n)
+		queued++
+		if len(batch) >= c.purgeBatchSize {
+			err := flush()
+			if err != nil {
+				return err
+			}
+		}
+		if limit > 0 && queued >= limit {
+			return errPurgeLimit
+		}
+		return nil
+	})
+	if err != nil && err != errPurgeLimit {
+		return purged, err
+	}
+
+	err = flush()
+	return purged, err
+}
+
+// PurgeExpired pops expired tokens off the store's expiry heap rather than
+// scanning, so it costs time proportional to what expired. ctx is checked
+// every PurgeBatchSize tokens.
+func (s *nonceInMemoryService) PurgeExpired(ctx context.Context, limit int) (int64, error) {
+	t := s.cfg.expiryCutoff(s.cfg.clock.Now())
+	var purged int64
+	for i := 0; limit <= 0 || purged < int64(limit); i++ {
+		if i%s.cfg.purgeBatchSize == 0 {
+			err := ctx.Err()
+			if err != nil {
+				return purged, err
+			}
+		}
+
+		e, ok := s.store.expiry.pop(t)
+		if !ok {
+			break
+		}
+		var gone Nonce
+		removed := s.store.remove(e.token, func(n Nonce) bool {
+			// a renewed nonce was pushed again with its new expiry
+			if !n.ExpiresAt.Before(t) {
+				return false
+			}
+			if s.cfg.retained(n, t) {
+				s.store.expiry.push(time.Unix(n.ConsumedAt, 0).Add(s.cfg.retention), n.Token)
+				return false
+			}
+			gone = n
+			return true
+		})
+		if removed {
+			purged++
+			s.cfg.expiredRemoved(gone)
+		}
+	}
+	return purged, nil
+}
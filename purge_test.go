@@ -0,0 +1,64 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPurgeExpiredLimit(t *testing.T) {
+	batchSize := PurgeBatchSize
+	PurgeBatchSize = 2
+	defer func() { PurgeBatchSize = batchSize }()
+
+	// build the service by hand so no background sweeper races the test
+	s := &nonceInMemoryService{
+		store:   newInMemStore(),
+		cfg:     newConfig(nil),
+		waiters: newConsumeWaiters(),
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := s.PutNonce(Nonce{
+			UserID:    uuid.NewV4(),
+			Action:    tNonce.Action,
+			IsValid:   true,
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+		}
+	}
+
+	purged, err := s.PurgeExpired(context.Background(), 3)
+	if err != nil || purged != 3 {
+		t.Fatalf("Expected 3 nonces to be purged. Instead got: %d, %v", purged, err)
+	}
+	purged, err = s.PurgeExpired(context.Background(), 0)
+	if err != nil || purged != 2 {
+		t.Fatalf("Expected the remaining 2 nonces to be purged. Instead got: %d, %v", purged, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.PurgeExpired(ctx, 0)
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled. Instead got: %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qrcode
+
+import "strings"
+
+// level is an error correction level
+type level struct {
+	// bits are the level's format information bits
+	bits int
+	// blocks is the block structure for each version, from 1
+	blocks [maxVersion]blockSpec
+}
+
+// blockSpec is how a version's codewords are split into blocks: count1 blocks
+// of data1 data codewords followed by count2 of data1+1, each with ec error
+// correction codewords
+type blockSpec struct {
+	ec, count1, data1, count2 int
+}
+
+func (b blockSpec) dataCodewords() int {
+	return b.count1*b.data1 + b.count2*(b.data1+1)
+}
+
+const maxVersion = 10
+
+var (
+	levelM = level{bits: 0, blocks: [maxVersion]blockSpec{
+		{10, 1, 16, 0}, {16, 1, 28, 0}, {26, 1, 44, 0}, {18, 2, 32, 0}, {24, 2, 43, 0},
+		{16, 4, 27, 0}, {18, 4, 31, 0}, {22, 2, 38, 2}, {22, 3, 36, 2}, {26, 4, 43, 1},
+	}}
+	levelL = level{bits: 1, blocks: [maxVersion]blockSpec{
+		{7, 1, 19, 0}, {10, 1, 34, 0}, {15, 1, 55, 0}, {20, 1, 80, 0}, {26, 1, 108, 0},
+		{18, 2, 68, 0}, {20, 2, 78, 0}, {24, 2, 97, 0}, {30, 2, 116, 0}, {18, 2, 68, 2},
+	}}
+)
+
+// alignment are the centre coordinates of the alignment patterns for each version
+var alignment = [maxVersion][]int{
+	nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+}
+
+const alphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// bitBuffer collects the data bits of a symbol
+type bitBuffer []bool
+
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, v>>uint(i)&1 == 1)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return out
+}
+
+// segment is content encoded in a single mode
+type segment struct {
+	mode     int
+	count    int
+	data     bitBuffer
+	alphanum bool
+}
+
+func newSegment(content string) segment {
+	if strings.Trim(content, alphanumeric) == "" {
+		s := segment{mode: 0x2, count: len(content), alphanum: true}
+		for i := 0; i+1 < len(content); i += 2 {
+			s.data.append(strings.IndexByte(alphanumeric, content[i])*45+strings.IndexByte(alphanumeric, content[i+1]), 11)
+		}
+		if len(content)%2 == 1 {
+			s.data.append(strings.IndexByte(alphanumeric, content[len(content)-1]), 6)
+		}
+		return s
+	}
+	s := segment{mode: 0x4, count: len(content)}
+	for i := 0; i < len(content); i++ {
+		s.data.append(int(content[i]), 8)
+	}
+	return s
+}
+
+// countBits is the width of the character count for version v
+func (s segment) countBits(v int) int {
+	switch {
+	case s.alphanum && v < 10:
+		return 9
+	case s.alphanum:
+		return 11
+	case v < 10:
+		return 8
+	}
+	return 16
+}
+
+// codewords returns the data codewords of s for version v at l, padded to
+// fill the symbol, or false if they don't fit
+func (s segment) codewords(v int, l level) ([]byte, bool) {
+	capacity := l.blocks[v-1].dataCodewords() * 8
+	bits := bitBuffer{}
+	bits.append(s.mode, 4)
+	if s.count >= 1<<uint(s.countBits(v)) {
+		return nil, false
+	}
+	bits.append(s.count, s.countBits(v))
+	bits = append(bits, s.data...)
+	if len(bits) > capacity {
+		return nil, false
+	}
+
+	// terminator, then zeros to a byte boundary and alternating pad bytes
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.bytes(), true
+}
+
+// interleave splits data into the blocks spec describes, adds each block's
+// error correction and returns the codewords in the order they are placed
+func interleave(data []byte, spec blockSpec) []byte {
+	var blocks, ecs [][]byte
+	gen := generator(spec.ec)
+	for i := 0; i < spec.count1+spec.count2; i++ {
+		n := spec.data1
+		if i >= spec.count1 {
+			n++
+		}
+		blocks = append(blocks, data[:n])
+		ecs = append(ecs, remainder(data[:n], gen))
+		data = data[n:]
+	}
+
+	var out []byte
+	for i := 0; i <= spec.data1; i++ {
+		for _, b := range blocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < spec.ec; i++ {
+		for _, ec := range ecs {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+// GF(256) arithmetic with the QR code polynomial x^8 + x^4 + x^3 + x^2 + 1
+var gfExp, gfLog [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x >= 256 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+// generator returns the Reed-Solomon generator polynomial of degree n,
+// highest power first without its leading 1
+func generator(n int) []byte {
+	g := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(g)+1)
+		for j, c := range g {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, gfExp[i])
+		}
+		g = next
+	}
+	return g[1:]
+}
+
+// remainder returns the error correction codewords for data
+func remainder(data, gen []byte) []byte {
+	r := make([]byte, len(gen))
+	for _, d := range data {
+		factor := d ^ r[0]
+		copy(r, r[1:])
+		r[len(r)-1] = 0
+		for i, g := range gen {
+			r[i] ^= gfMul(g, factor)
+		}
+	}
+	return r
+}
+
+// symbol is the module matrix being built
+type symbol struct {
+	size     int
+	dark     []bool
+	reserved []bool
+}
+
+func newSymbol(v int) *symbol {
+	size := 17 + 4*v
+	s := &symbol{size: size, dark: make([]bool, size*size), reserved: make([]bool, size*size)}
+
+	for i := 0; i < size; i++ {
+		s.set(6, i, i%2 == 0)
+		s.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				d := ring(dx, dy)
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					s.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	pos := alignment[v-1]
+	for i, x := range pos {
+		for j, y := range pos {
+			// skip the three that would overlap the finder patterns
+			if i == 0 && j == 0 || i == 0 && j == len(pos)-1 || i == len(pos)-1 && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					s.set(x+dx, y+dy, ring(dx, dy) != 1)
+				}
+			}
+		}
+	}
+
+	// reserve the format information, written once the mask is chosen
+	s.format(0, 0)
+	if v >= 7 {
+		bits := versionBits(v)
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			s.set(a, b, bits>>uint(i)&1 == 1)
+			s.set(b, a, bits>>uint(i)&1 == 1)
+		}
+	}
+	return s
+}
+
+func (s *symbol) set(x, y int, dark bool) {
+	s.dark[y*s.size+x] = dark
+	s.reserved[y*s.size+x] = true
+}
+
+// place writes codewords into the modules that aren't reserved, in two module
+// wide columns zigzagging up and down from the bottom right
+func (s *symbol) place(codewords []byte) {
+	i := 0
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < s.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = s.size - 1 - vert
+				}
+				if !s.reserved[y*s.size+x] && i < len(codewords)*8 {
+					s.dark[y*s.size+x] = codewords[i/8]>>uint(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// mask inverts the data modules that mask pattern m selects
+func (s *symbol) mask(m int) {
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			if !s.reserved[y*s.size+x] && maskBit(m, x, y) {
+				s.dark[y*s.size+x] = !s.dark[y*s.size+x]
+			}
+		}
+	}
+}
+
+func maskBit(m, x, y int) bool {
+	switch m {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	}
+	return ((x+y)%2+x*y%3)%2 == 0
+}
+
+// format writes the format information for level bits l and mask m
+func (s *symbol) format(l, m int) {
+	bits := formatBits(l, m)
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		s.set(8, i, bit(i))
+	}
+	s.set(8, 7, bit(6))
+	s.set(8, 8, bit(7))
+	s.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		s.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		s.set(s.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		s.set(8, s.size-15+i, bit(i))
+	}
+	s.set(8, s.size-8, true)
+}
+
+// formatBits returns the 15 bit BCH coded format information
+func formatBits(l, m int) int {
+	data := l<<3 | m
+	r := data
+	for i := 0; i < 10; i++ {
+		r = r<<1 ^ (r>>9)*0x537
+	}
+	return (data<<10 | r) ^ 0x5412
+}
+
+// versionBits returns the 18 bit BCH coded version information
+func versionBits(v int) int {
+	r := v
+	for i := 0; i < 12; i++ {
+		r = r<<1 ^ (r>>11)*0x1f25
+	}
+	return v<<12 | r
+}
+
+// penalty scores how hard the symbol is to scan, lower being better
+func (s *symbol) penalty() int {
+	p, dark := 0, 0
+	at := func(x, y int, horizontal bool) bool {
+		if horizontal {
+			return s.dark[y*s.size+x]
+		}
+		return s.dark[x*s.size+y]
+	}
+	for _, horizontal := range []bool{true, false} {
+		for y := 0; y < s.size; y++ {
+			run := 0
+			for x := 0; x < s.size; x++ {
+				if x > 0 && at(x, y, horizontal) == at(x-1, y, horizontal) {
+					run++
+				} else {
+					run = 1
+				}
+				if run == 5 {
+					p += 3
+				} else if run > 5 {
+					p++
+				}
+				// 1:1:3:1:1 finder-like patterns with light space on one side
+				if x >= 10 {
+					var line [11]bool
+					for i := range line {
+						line[i] = at(x-10+i, y, horizontal)
+					}
+					if line == finderLeft || line == finderRight {
+						p += 40
+					}
+				}
+			}
+		}
+	}
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			c := s.dark[y*s.size+x]
+			if c {
+				dark++
+			}
+			if x > 0 && y > 0 && c == s.dark[y*s.size+x-1] && c == s.dark[(y-1)*s.size+x] && c == s.dark[(y-1)*s.size+x-1] {
+				p += 3
+			}
+		}
+	}
+	return p + abs(dark*20-len(s.dark)*10)/len(s.dark)*10
+}
+
+var (
+	finderLeft  = [11]bool{false, false, false, false, true, false, true, true, true, false, true}
+	finderRight = [11]bool{true, false, true, true, true, false, true, false, false, false, false}
+)
+
+// encode returns the modules of the smallest symbol that holds content,
+// preferring level M to level L
+func encode(content string) (*symbol, bool) {
+	seg := newSegment(content)
+	for _, l := range []level{levelM, levelL} {
+		for v := 1; v <= maxVersion; v++ {
+			data, ok := seg.codewords(v, l)
+			if !ok {
+				continue
+			}
+			codewords := interleave(data, l.blocks[v-1])
+
+			var best *symbol
+			bestPenalty := 0
+			for m := 0; m < 8; m++ {
+				s := newSymbol(v)
+				s.place(codewords)
+				s.mask(m)
+				s.format(l.bits, m)
+				if p := s.penalty(); best == nil || p < bestPenalty {
+					best, bestPenalty = s, p
+				}
+			}
+			return best, true
+		}
+	}
+	return nil, false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ring is how many modules out from a pattern's centre dx, dy is
+func ring(dx, dy int) int {
+	if abs(dx) > abs(dy) {
+		return abs(dx)
+	}
+	return abs(dy)
+}
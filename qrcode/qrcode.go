@@ -0,0 +1,119 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package qrcode renders nonce tokens and links, such as magic login links, as
+// QR codes, e.g. to pair a device or confirm a payment at a till by scanning.
+// Tokens issued WithTokenEncoding(nonce.Base32) make the smallest codes, as
+// they only use characters QR codes store in 5.5 bits rather than 8.
+//
+//	code, err := qrcode.Encode(link)
+//	...
+//	w.Header().Set("Content-Type", "image/png")
+//	code.PNG(w, 8)
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// ErrTooLong is returned for content that doesn't fit in a version 10 QR code,
+// 271 bytes, or 395 characters of digits, upper case letters and " $%*+-./:"
+var ErrTooLong = errors.New("qrcode: content too long")
+
+// quietZone is the light border, in modules, scanners need around a code
+const quietZone = 4
+
+// Code is a QR code
+type Code struct {
+	// Size is the width and height of the code in modules, without the quiet zone
+	Size int
+
+	dark []bool
+}
+
+// Encode returns the smallest QR code holding content, using error correction
+// level M, which survives 15% of the code being damaged, when it fits and
+// level L otherwise
+func Encode(content string) (*Code, error) {
+	s, ok := encode(content)
+	if !ok {
+		return nil, ErrTooLong
+	}
+	return &Code{Size: s.size, dark: s.dark}, nil
+}
+
+// Token returns the QR code for n's token, as returned by New
+func Token(n nonce.Nonce) (*Code, error) {
+	if n.Token == "" {
+		return nil, nonce.ErrNoToken
+	}
+	return Encode(n.Token)
+}
+
+// Dark reports whether the module at x, y is dark, counting from the top left
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && x < c.Size && y >= 0 && y < c.Size && c.dark[y*c.Size+x]
+}
+
+// Image returns the code, with its quiet zone, as an image with scale pixels
+// per module
+func (c *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+	width := (c.Size + 2*quietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, width, width), color.Palette{color.White, color.Black})
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			if c.Dark(x/scale-quietZone, y/scale-quietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// PNG writes the code to w as a PNG with scale pixels per module
+func (c *Code) PNG(w io.Writer, scale int) error {
+	return png.Encode(w, c.Image(scale))
+}
+
+// SVG writes the code to w as an SVG image scale pixels per module wide.
+// It scales without blurring, so scale only sets its default size.
+func (c *Code) SVG(w io.Writer, scale int) error {
+	if scale < 1 {
+		scale = 1
+	}
+	width := c.Size + 2*quietZone
+	var path strings.Builder
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`,
+		width*scale, width*scale, width, width, width, width, path.String())
+	return err
+}
@@ -0,0 +1,262 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestCodewords(t *testing.T) {
+	// the worked example from the QR code specification's tutorials
+	data, ok := newSegment("HELLO WORLD").codewords(1, levelM)
+	want := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	if !ok || !bytes.Equal(data, want) {
+		t.Fatalf("Expected the data codewords %v. Instead got: %v", want, data)
+	}
+	ec := remainder(data, generator(10))
+	want = []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if !bytes.Equal(ec, want) {
+		t.Errorf("Expected the error correction codewords %v. Instead got: %v", want, ec)
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	if b := formatBits(levelM.bits, 0); b != 0x5412 {
+		t.Errorf("Expected the level M mask 0 format bits to be %015b. Instead got: %015b", 0x5412, b)
+	}
+	if b := formatBits(levelL.bits, 4); b != 0x662f {
+		t.Errorf("Expected the level L mask 4 format bits to be %015b. Instead got: %015b", 0x662f, b)
+	}
+	if b := versionBits(7); b != 0x07c94 {
+		t.Errorf("Expected the version 7 bits to be %018b. Instead got: %018b", 0x07c94, b)
+	}
+}
+
+func TestBlockSpecs(t *testing.T) {
+	for v := 1; v <= maxVersion; v++ {
+		s := newSymbol(v)
+		free := 0
+		for _, r := range s.reserved {
+			if !r {
+				free++
+			}
+		}
+		remainderBits := 0
+		if v >= 2 && v <= 6 {
+			remainderBits = 7
+		}
+		for name, l := range map[string]level{"M": levelM, "L": levelL} {
+			b := l.blocks[v-1]
+			total := b.dataCodewords() + (b.count1+b.count2)*b.ec
+			if total*8+remainderBits != free {
+				t.Errorf("Expected version %d-%s to have %d codewords. Instead got: %d", v, name, (free-remainderBits)/8, total)
+			}
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		size    int
+	}{
+		"alphanumeric": {"HELLO WORLD", 21},
+		"base32 token": {strings.Repeat("ABCDEFGH234567", 8)[:103], 37},
+		"link":         {"https://example.com/login?token=" + strings.Repeat("a", 88), 45},
+		"longest":      {strings.Repeat("a", 271), 57},
+	}
+	for name, tt := range tests {
+		c, err := Encode(tt.content)
+		if err != nil {
+			t.Errorf("%s: Expected a code. Instead got the error: %v", name, err)
+			continue
+		}
+		if c.Size != tt.size {
+			t.Errorf("%s: Expected a %d module code. Instead got: %d", name, tt.size, c.Size)
+		}
+		// the finder pattern's centre and the timing pattern
+		if !c.Dark(3, 3) || c.Dark(1, 1) || !c.Dark(8, 6) || c.Dark(9, 6) {
+			t.Errorf("%s: Expected the function patterns to be drawn", name)
+		}
+		if got := decode(t, c); got != tt.content {
+			t.Errorf("%s: Expected the code to read back %q. Instead got: %q", name, tt.content, got)
+		}
+	}
+
+	_, err := Encode(strings.Repeat("a", 272))
+	if err != ErrTooLong {
+		t.Errorf("Expected %v. Instead got: %v", ErrTooLong, err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	_, err := Token(nonce.Nonce{})
+	if err != nonce.ErrNoToken {
+		t.Errorf("Expected %v for a nonce without a token. Instead got: %v", nonce.ErrNoToken, err)
+	}
+	s := nonce.NewInMemoryService(nonce.WithTokenEncoding(nonce.Base32))
+	defer s.Shutdown()
+	n, _ := s.New("pair", uuid.Nil, time.Minute)
+	c, err := Token(n)
+	if err != nil {
+		t.Fatalf("Expected a code for the token. Instead got the error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = c.PNG(&buf, 4)
+	if err != nil {
+		t.Fatalf("Expected to write a PNG. Instead got the error: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil || img.Bounds().Dx() != (c.Size+8)*4 {
+		t.Fatalf("Expected a %dpx PNG. Instead got: %v, %v", (c.Size+8)*4, img.Bounds(), err)
+	}
+	if r, _, _, _ := img.At(16, 16).RGBA(); r != 0 {
+		t.Errorf("Expected the top left of the finder pattern to be dark")
+	}
+
+	buf.Reset()
+	err = c.SVG(&buf, 4)
+	if err != nil || !strings.HasPrefix(buf.String(), "<svg") || !strings.Contains(buf.String(), "M4,4h1v1h-1z") {
+		t.Errorf("Expected an SVG. Instead got: %q, %v", buf.String(), err)
+	}
+}
+
+// decode reads a code's content back, undoing encode step by step
+func decode(t *testing.T, c *Code) string {
+	v := (c.Size - 17) / 4
+	s := newSymbol(v)
+
+	// the format bits around the top left finder
+	bits := 0
+	for i := 0; i <= 5; i++ {
+		bits |= b2i(c.Dark(8, i)) << uint(i)
+	}
+	bits |= b2i(c.Dark(8, 7))<<6 | b2i(c.Dark(8, 8))<<7 | b2i(c.Dark(7, 8))<<8
+	for i := 9; i < 15; i++ {
+		bits |= b2i(c.Dark(14-i, 8)) << uint(i)
+	}
+	var l level
+	m := -1
+	for _, lv := range []level{levelM, levelL} {
+		for mm := 0; mm < 8; mm++ {
+			if formatBits(lv.bits, mm) == bits {
+				l, m = lv, mm
+			}
+		}
+	}
+	if m < 0 {
+		t.Fatalf("Expected valid format bits. Instead got: %015b", bits)
+	}
+
+	copy(s.dark, c.dark)
+	s.mask(m)
+	spec := l.blocks[v-1]
+	n := spec.dataCodewords() + (spec.count1+spec.count2)*spec.ec
+	placed := make([]byte, n)
+	i := 0
+	for right := s.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < s.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = s.size - 1 - vert
+				}
+				if !s.reserved[y*s.size+x] && i < n*8 {
+					if s.dark[y*s.size+x] {
+						placed[i/8] |= 0x80 >> uint(i%8)
+					}
+					i++
+				}
+			}
+		}
+	}
+
+	// undo the interleaving, checking the error correction
+	blocks := make([][]byte, spec.count1+spec.count2)
+	k := 0
+	for col := 0; col <= spec.data1; col++ {
+		for b := range blocks {
+			if col < spec.data1 || b >= spec.count1 {
+				blocks[b] = append(blocks[b], placed[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for b := range blocks {
+		var ec []byte
+		for col := 0; col < spec.ec; col++ {
+			ec = append(ec, placed[k+col*len(blocks)+b])
+		}
+		if !reflect.DeepEqual(ec, remainder(blocks[b], generator(spec.ec))) {
+			t.Fatalf("Expected block %d's error correction to match", b)
+		}
+		data = append(data, blocks[b]...)
+	}
+
+	r := bitReader{data: data}
+	mode := r.read(4)
+	seg := segment{alphanum: mode == 0x2}
+	count := r.read(seg.countBits(v))
+	var out []byte
+	switch {
+	case seg.alphanum:
+		for ; count >= 2; count -= 2 {
+			p := r.read(11)
+			out = append(out, alphanumeric[p/45], alphanumeric[p%45])
+		}
+		if count == 1 {
+			out = append(out, alphanumeric[r.read(6)])
+		}
+	default:
+		for ; count > 0; count-- {
+			out = append(out, byte(r.read(8)))
+		}
+	}
+	return string(out)
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) read(n int) int {
+	v := 0
+	for ; n > 0; n-- {
+		v = v<<1 | int(r.data[r.pos/8]>>uint(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
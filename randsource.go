@@ -0,0 +1,93 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"io"
+	"sync"
+
+	"github.com/bryanjeal/go-helpers"
+	uuid "github.com/satori/go.uuid"
+)
+
+// WithRandSource makes the Service read the salts, IDs and RandomBytes tokens
+// of the nonces it creates from r instead of crypto/rand. With WithClock it
+// gives tests the same nonces on every run, e.g. for golden files:
+//
+//	s := nonce.NewInMemoryService(
+//		nonce.WithClock(clock),
+//		nonce.WithRandSource(rand.New(rand.NewSource(1))),
+//	)
+//
+// Reads from r are serialized, so it needn't be safe for concurrent use, but
+// the nonces only repeat when they are created in the same order. WithEncryption
+// still seals salts with crypto/rand. Anyone who knows r can predict tokens, so
+// never use it outside tests.
+func WithRandSource(r io.Reader) Option {
+	return func(cfg *config) {
+		if r != nil {
+			cfg.random = &lockedReader{r: r}
+		}
+	}
+}
+
+// lockedReader fills each read from r, one at a time
+type lockedReader struct {
+	sync.Mutex
+	r io.Reader
+}
+
+func (l *lockedReader) Read(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return io.ReadFull(l.r, p)
+}
+
+// randomBytes returns n bytes from the WithRandSource reader or crypto/rand
+func (c config) randomBytes(n int) ([]byte, error) {
+	if c.random == nil {
+		return helpers.Crypto.GenerateRandomKey(n)
+	}
+	b := make([]byte, n)
+	_, err := c.random.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// sum is the Hasher's Sum, reading RandomBytes from the WithRandSource reader
+func (c config) sum(input []byte) ([]byte, error) {
+	if h, ok := c.hasher.(randomHasher); ok && c.random != nil {
+		return c.randomBytes(h.size)
+	}
+	return c.hasher.Sum(input)
+}
+
+// newID returns a version 4 UUID for a new nonce
+func (c config) newID() uuid.UUID {
+	if c.random == nil {
+		return uuid.NewV4()
+	}
+	var id uuid.UUID
+	_, err := c.random.Read(id[:])
+	if err != nil {
+		c.logger.Printf("nonce: error reading rand source, using crypto/rand: %v", err)
+		return uuid.NewV4()
+	}
+	id.SetVersion(4)
+	id.SetVariant()
+	return id
+}
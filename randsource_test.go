@@ -0,0 +1,53 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWithRandSource(t *testing.T) {
+	clock := &testClock{}
+	create := func() Nonce {
+		s := NewInMemoryService(WithClock(clock), WithRandSource(rand.New(rand.NewSource(1))))
+		defer s.Shutdown()
+		n, err := s.New(tNonce.Action, tNonce.UserID, time.Hour)
+		if err != nil {
+			t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+		}
+		return n
+	}
+
+	a, b := create(), create()
+	if a.Token != b.Token || a.Salt != b.Salt || a.ID != b.ID {
+		t.Errorf("Expected the same seed to give the same nonce. Instead got: %+v and %+v", a, b)
+	}
+	if a.ID.Version() != 4 {
+		t.Errorf("Expected a version 4 ID. Instead got: %d", a.ID.Version())
+	}
+
+	s := NewInMemoryService(WithClock(clock), WithRandSource(rand.New(rand.NewSource(2))))
+	defer s.Shutdown()
+	c, _ := s.New(tNonce.Action, tNonce.UserID, time.Hour)
+	if c.Token == a.Token {
+		t.Errorf("Expected another seed to give another token. Instead got: %s", c.Token)
+	}
+	err := s.Check(c.Token, tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Errorf("Expected the token to check out. Instead got the error: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// WithRateLimit caps how many nonces New and NewBatch create for each user and
+// action within window, e.g. WithRateLimit("password-reset", 5, time.Hour).
+// Calls over the limit get ErrRateLimited and create nothing; a batch is
+// rejected whole. An empty action sets the limit for every action without one
+// of its own. Use it once per action. Nonces are counted by this Service instance only.
+func WithRateLimit(action string, max int, window time.Duration) Option {
+	return func(cfg *config) {
+		if max <= 0 || window <= 0 {
+			return
+		}
+		if cfg.rateLimit == nil {
+			cfg.rateLimit = &rateLimit{
+				rules:   make(map[string]rateRule),
+				created: make(map[rateKey][]time.Time),
+			}
+		}
+		cfg.rateLimit.rules[action] = rateRule{max: max, window: window}
+	}
+}
+
+// rateLimit remembers when nonces were created for each user and action
+type rateLimit struct {
+	sync.Mutex
+	rules   map[string]rateRule
+	created map[rateKey][]time.Time
+}
+
+type rateRule struct {
+	max    int
+	window time.Duration
+}
+
+type rateKey struct {
+	action string
+	uid    uuid.UUID
+}
+
+func (r *rateLimit) interceptor(clock Clock) Interceptor {
+	return func(c Call, next func() error) error {
+		var keys []rateKey
+		switch c.Method {
+		case "New", "NewBound", "NewWithPayload":
+			keys = []rateKey{{action: c.Args[0].(string), uid: c.Args[1].(uuid.UUID)}}
+		case "NewBatch":
+			for _, req := range c.Args[1].([]NewRequest) {
+				keys = append(keys, rateKey{action: req.Action, uid: req.UserID})
+			}
+		default:
+			return next()
+		}
+
+		t := clock.Now()
+		if !r.take(keys, t) {
+			return ErrRateLimited
+		}
+		err := next()
+		if err != nil {
+			r.giveBack(keys, t)
+		}
+		return err
+	}
+}
+
+// rule returns the limit for action and whether there is one
+func (r *rateLimit) rule(action string) (rateRule, bool) {
+	rule, ok := r.rules[action]
+	if !ok {
+		rule, ok = r.rules[""]
+	}
+	return rule, ok
+}
+
+// take records a creation at t for each key, or none of them if that would
+// put any key over its limit
+func (r *rateLimit) take(keys []rateKey, t time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	// drop creations whose window has passed so the map doesn't grow forever
+	for k, times := range r.created {
+		times = r.recent(k, times, t)
+		if len(times) == 0 {
+			delete(r.created, k)
+		} else {
+			r.created[k] = times
+		}
+	}
+
+	want := make(map[rateKey]int)
+	for _, k := range keys {
+		if _, ok := r.rule(k.action); ok {
+			want[k]++
+		}
+	}
+	for k, n := range want {
+		rule, _ := r.rule(k.action)
+		if len(r.created[k])+n > rule.max {
+			return false
+		}
+	}
+	for k, n := range want {
+		for i := 0; i < n; i++ {
+			r.created[k] = append(r.created[k], t)
+		}
+	}
+	return true
+}
+
+// giveBack forgets the creations take recorded at t after the call failed
+func (r *rateLimit) giveBack(keys []rateKey, t time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	for _, k := range keys {
+		times := r.created[k]
+		for i := len(times) - 1; i >= 0; i-- {
+			if times[i].Equal(t) {
+				r.created[k] = append(times[:i], times[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// recent returns the times that are still within k's window at t
+func (r *rateLimit) recent(k rateKey, times []time.Time, t time.Time) []time.Time {
+	rule, ok := r.rule(k.action)
+	if !ok {
+		return nil
+	}
+	i := 0
+	for i < len(times) && t.Sub(times[i]) >= rule.window {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestRateLimit(t *testing.T) {
+	clock := &testClock{}
+	s := NewInMemoryService(WithClock(clock),
+		WithRateLimit("reset", 2, time.Hour),
+		WithLimits(Limits{Action: 8}))
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+
+	for i := 0; i < 2; i++ {
+		_, err := s.New("reset", uid, time.Minute)
+		if err != nil {
+			t.Fatalf("Expected New %d to succeed. Instead got: %v", i, err)
+		}
+	}
+	_, err := s.New("reset", uid, time.Minute)
+	if err != ErrRateLimited {
+		t.Fatalf("Expected ErrRateLimited. Instead got: %v", err)
+	}
+
+	// other users and actions without a limit aren't affected
+	_, err = s.New("reset", uuid.NewV4(), time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New for another user to succeed. Instead got: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = s.New("login", uid, time.Minute)
+		if err != nil {
+			t.Fatalf("Expected New for an unlimited action to succeed. Instead got: %v", err)
+		}
+	}
+
+	// failed calls don't count against the limit
+	other := uuid.NewV4()
+	_, err = s.New("reset", other, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	_, err = s.(Batcher).NewBatch(context.Background(), []NewRequest{
+		{Action: "reset", UserID: other, ExpiresIn: time.Minute},
+		{Action: "much too long", UserID: other, ExpiresIn: time.Minute},
+	})
+	if err != ErrPayloadTooLarge {
+		t.Fatalf("Expected ErrPayloadTooLarge. Instead got: %v", err)
+	}
+
+	// a batch over the limit is rejected whole
+	_, err = s.(Batcher).NewBatch(context.Background(), []NewRequest{
+		{Action: "reset", UserID: other, ExpiresIn: time.Minute},
+		{Action: "reset", UserID: other, ExpiresIn: time.Minute},
+	})
+	if err != ErrRateLimited {
+		t.Fatalf("Expected ErrRateLimited for the batch. Instead got: %v", err)
+	}
+	_, err = s.New("reset", other, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed after the rejected batch. Instead got: %v", err)
+	}
+
+	// once the window passes the user can create nonces again
+	clock.Add(time.Hour)
+	_, err = s.New("reset", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed after the window. Instead got: %v", err)
+	}
+}
+
+func TestRateLimitDefault(t *testing.T) {
+	s := NewInMemoryService(WithRateLimit("", 1, time.Hour), WithRateLimit("reset", 2, time.Hour))
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+
+	_, err := s.New("login", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	_, err = s.New("login", uid, time.Minute)
+	if err != ErrRateLimited {
+		t.Fatalf("Expected the default limit to apply. Instead got: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, err = s.New("reset", uid, time.Minute)
+		if err != nil {
+			t.Fatalf("Expected the action's own limit to apply. Instead got: %v", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"time"
+)
+
+// WithRetention keeps consumed nonces for d after they were consumed instead of
+// removing them with the other expired nonces, so History can prove when a token was used.
+// MongoDB's TTL index removes nonces at expires_at, so it has no effect on the mongo package.
+// etcd and Cassandra keep the nonce's lease or TTL running until the window ends.
+func WithRetention(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.retention = d
+	}
+}
+
+// retained reports whether n must be kept at time t even though it has expired
+func (c config) retained(n Nonce, t time.Time) bool {
+	return c.retention > 0 && n.IsUsed && t.Sub(time.Unix(n.ConsumedAt, 0)) < c.retention
+}
+
+// removeAt is when a store that expires nonces itself, such as etcd, should
+// remove n: when its expiry leeway runs out, or once its retention window ends
+// if that is later
+func (c config) removeAt(n Nonce) time.Time {
+	at := n.ExpiresAt.Add(c.expiryLeeway)
+	if c.retention > 0 && n.IsUsed {
+		kept := time.Unix(n.ConsumedAt, 0).Add(c.retention)
+		if kept.After(at) {
+			at = kept
+		}
+	}
+	return at
+}
+
+// History returns the consumed nonces matching f, oldest first, e.g. to show when
+// a password reset token was used. Consumed nonces are only kept past their
+// expiry by Services created with WithRetention.
+func History(ctx context.Context, l Lister, f Filter) ([]Nonce, error) {
+	f.Consumed = true
+	var nonces []Nonce
+	err := l.List(ctx, f, func(n Nonce) error {
+		nonces = append(nonces, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nonces, nil
+}
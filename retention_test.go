@@ -0,0 +1,63 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestRetention(t *testing.T) {
+	clock := &testClock{}
+	// build the service by hand so no background sweeper races the test
+	s := &nonceInMemoryService{
+		store:   newInMemStore(),
+		cfg:     newConfig([]Option{WithClock(clock), WithRetention(24 * time.Hour)}),
+		waiters: newConsumeWaiters(),
+	}
+
+	used, err := s.New(tNonce.Action, tNonce.UserID, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	_, err = s.New(tNonce.Action, uuid.NewV4(), time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	_, err = s.Consume(used.Token)
+	if err != nil {
+		t.Fatalf("Expected token to be consumed. Instead got the error: %v", err)
+	}
+
+	// both have expired but the consumed one is kept for the retention window
+	clock.Add(time.Hour)
+	purged, err := s.PurgeExpired(context.Background(), 0)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected 1 nonce to be purged. Instead got: %d, %v", purged, err)
+	}
+	history, err := History(context.Background(), s, Filter{UserID: tNonce.UserID})
+	if err != nil || len(history) != 1 || history[0].ID != used.ID {
+		t.Fatalf("Expected history to hold the consumed nonce. Instead got: %v, %v", history, err)
+	}
+
+	clock.Add(24 * time.Hour)
+	purged, err = s.PurgeExpired(context.Background(), 0)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected the consumed nonce to be purged after the retention window. Instead got: %d, %v", purged, err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy says how a RetryingService retries calls
+type RetryPolicy struct {
+	// Attempts is the most times a call is made, including the first
+	Attempts int
+
+	// Backoff is the wait before the first retry. It doubles for each retry
+	// after that, up to MaxBackoff when it is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Budget caps retries across all calls at this many per call, e.g. 0.1
+	// allows one retry for every ten calls, so a backend that is down isn't
+	// sent several times the usual load. Unused budget builds up to
+	// RetryBudgetBurst retries. Zero leaves retries uncapped.
+	Budget float64
+
+	// Retryable reports whether a call that failed with err should be made
+	// again. When it is nil IsTransient decides.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy makes up to three attempts, 50ms then 100ms apart,
+// with retries capped at one for every ten calls
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	Backoff:    50 * time.Millisecond,
+	MaxBackoff: time.Second,
+	Budget:     0.1,
+}
+
+// RetryBudgetBurst is how many retries a RetryPolicy's Budget can save up.
+// It is read when a RetryingService is created.
+var RetryBudgetBurst = 10
+
+// NewRetryingService creates a Service that makes each call to s again, as p
+// says, when it fails with a transient error. A retried Consume whose first
+// attempt reached the backend before the connection failed gets ErrTokenUsed.
+// Shutdown is never retried. RetryInterceptor uses the same loop.
+func NewRetryingService(s Service, p RetryPolicy) Service {
+	return Decorate(s, p.interceptor())
+}
+
+func (p RetryPolicy) interceptor() Interceptor {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	var budget *retryBudget
+	if p.Budget > 0 {
+		budget = &retryBudget{ratio: p.Budget, max: float64(RetryBudgetBurst), left: float64(RetryBudgetBurst)}
+	}
+
+	return func(c Call, next func() error) error {
+		if budget != nil {
+			budget.deposit()
+		}
+		err := next()
+		wait := p.Backoff
+		for i := 1; i < p.Attempts && err != nil && c.Method != "Shutdown" && retryable(err); i++ {
+			if budget != nil && !budget.withdraw() {
+				break
+			}
+			time.Sleep(wait)
+			wait *= 2
+			if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+				wait = p.MaxBackoff
+			}
+			err = next()
+		}
+		return err
+	}
+}
+
+// retryBudget is a pool of retries that every call adds ratio to
+type retryBudget struct {
+	sync.Mutex
+	ratio, max, left float64
+}
+
+func (b *retryBudget) deposit() {
+	b.Lock()
+	b.left += b.ratio
+	if b.left > b.max {
+		b.left = b.max
+	}
+	b.Unlock()
+}
+
+// withdraw takes one retry from the pool, reporting false if there isn't one
+func (b *retryBudget) withdraw() bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.left < 1 {
+		return false
+	}
+	b.left--
+	return true
+}
+
+// IsTransient reports whether err looks like a failure to reach the backend
+// that may go away if the call is made again: a dropped, reset or refused
+// connection, a timeout, or a driver saying its connection is bad. The
+// package's own errors, such as ErrTokenUsed, cancelled contexts and
+// ErrBackendUnavailable from an open circuit breaker are never transient.
+func IsTransient(err error) bool {
+	switch {
+	case err == nil, isServiceError(err), err == ErrBackendUnavailable,
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE):
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	// some drivers only say so in the message
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"connection reset", "connection refused", "broken pipe", "bad connection", "i/o timeout"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// failingService fails the next fails calls to Check with err
+type failingService struct {
+	Service
+	err   error
+	fails int
+	calls int
+}
+
+func (s *failingService) Check(token, action string, uid uuid.UUID) error {
+	s.calls++
+	if s.fails > 0 {
+		s.fails--
+		return s.err
+	}
+	return s.Service.Check(token, action, uid)
+}
+
+func TestRetryingService(t *testing.T) {
+	backend := &failingService{Service: NewInMemoryService(), err: syscall.ECONNRESET}
+	s := NewRetryingService(backend, RetryPolicy{Attempts: 3, Backoff: time.Millisecond})
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	backend.fails = 2
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != nil || backend.calls != 3 {
+		t.Fatalf("Expected the third attempt to succeed. Instead got %d calls and: %v", backend.calls, err)
+	}
+
+	backend.fails, backend.calls = 3, 0
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != syscall.ECONNRESET || backend.calls != 3 {
+		t.Fatalf("Expected 3 attempts ending in the backend error. Instead got %d calls and: %v", backend.calls, err)
+	}
+
+	// the nonce's own errors are final
+	backend.calls = 0
+	err = s.Check(n.Token, tNonce.Action, uuid.NewV4())
+	if !errors.Is(err, ErrInvalidToken) || backend.calls != 1 {
+		t.Fatalf("Expected ErrInvalidToken not to be retried. Instead got %d calls and: %v", backend.calls, err)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	defer func(b int) { RetryBudgetBurst = b }(RetryBudgetBurst)
+	RetryBudgetBurst = 1
+
+	backend := &failingService{Service: NewInMemoryService(), err: syscall.ECONNRESET}
+	s := NewRetryingService(backend, RetryPolicy{Attempts: 5, Backoff: time.Millisecond, Budget: 0.5})
+	defer s.Shutdown()
+
+	// the saved up retry is spent, then each call only earns half of one
+	backend.fails = 10
+	s.Check("token", tNonce.Action, tNonce.UserID)
+	if backend.calls != 2 {
+		t.Fatalf("Expected one retry from the burst. Instead got %d calls", backend.calls)
+	}
+	backend.calls = 0
+	s.Check("token", tNonce.Action, tNonce.UserID)
+	if backend.calls != 1 {
+		t.Fatalf("Expected no retry with half a retry saved. Instead got %d calls", backend.calls)
+	}
+	backend.calls = 0
+	s.Check("token", tNonce.Action, tNonce.UserID)
+	if backend.calls != 2 {
+		t.Fatalf("Expected a retry once a whole one was saved. Instead got %d calls", backend.calls)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{ErrTokenUsed, false},
+		{NewTokenError(ErrTokenExpired, Nonce{}, time.Now()), false},
+		{ErrRateLimited, false},
+		{context.Canceled, false},
+		{errors.New("syntax error at or near"), false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("query: %w", syscall.ECONNRESET), true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{errors.New("read tcp 10.0.0.1:5432: connection reset by peer"), true},
+		{&net.DNSError{IsTimeout: true}, true},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("Expected IsTransient(%v) to be %v", tt.err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Resolver picks the Service that stores nonces for action,
+// e.g. to keep EU tenants' nonces in an EU database
+type Resolver func(action string) (Service, error)
+
+// routingService sends each call to the Service its action resolves to
+type routingService struct {
+	resolve Resolver
+	stores  []Service
+}
+
+// NewRoutingService creates a Service that stores each nonce in the Service
+// resolve returns for its action. Calls that only have a token (Consume and Renew)
+// try every store in stores, so stores must list every Service resolve can return.
+func NewRoutingService(resolve Resolver, stores ...Service) Service {
+	// drop duplicates so fan out calls reach each store once
+	seen := make(map[Service]bool, len(stores))
+	unique := make([]Service, 0, len(stores))
+	for _, s := range stores {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+
+	return &routingService{
+		resolve: resolve,
+		stores:  unique,
+	}
+}
+
+func (s *routingService) New(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error) {
+	store, err := s.resolve(action)
+	if err != nil {
+		return Nonce{}, err
+	}
+	return store.New(action, uid, expiresIn)
+}
+
+func (s *routingService) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (Nonce, error) {
+	store, err := s.resolve(action)
+	if err != nil {
+		return Nonce{}, err
+	}
+	p, ok := store.(Payloader)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	return p.NewWithPayload(action, uid, expiresIn, payload)
+}
+
+// NewBatch splits requests by store. Each store's share is created as that
+// store's NewBatch allows, but a failure part way can leave earlier stores written.
+func (s *routingService) NewBatch(ctx context.Context, requests []NewRequest) ([]Nonce, error) {
+	var order []Service
+	byStore := make(map[Service][]int)
+	for i, r := range requests {
+		store, err := s.resolve(r.Action)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := byStore[store]; !ok {
+			order = append(order, store)
+		}
+		byStore[store] = append(byStore[store], i)
+	}
+
+	nonces := make([]Nonce, len(requests))
+	for _, store := range order {
+		b, ok := store.(Batcher)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		idx := byStore[store]
+		batch := make([]NewRequest, len(idx))
+		for j, i := range idx {
+			batch[j] = requests[i]
+		}
+		created, err := b.NewBatch(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range idx {
+			nonces[i] = created[j]
+		}
+	}
+	return nonces, nil
+}
+
+func (s *routingService) Check(token, action string, uid uuid.UUID) error {
+	store, err := s.resolve(action)
+	if err != nil {
+		return err
+	}
+	err = store.Check(token, action, uid)
+	if err == ErrTokenNotFound {
+		return s.notFound(store, token, action, uid)
+	}
+	return err
+}
+
+func (s *routingService) Consume(token string) (Nonce, error) {
+	return s.eachStore(func(store Service) (Nonce, error) {
+		return store.Consume(token)
+	})
+}
+
+func (s *routingService) CheckThenConsume(token, action string, uid uuid.UUID) (Nonce, error) {
+	store, err := s.resolve(action)
+	if err != nil {
+		return Nonce{}, err
+	}
+	n, err := store.CheckThenConsume(token, action, uid)
+	if err == ErrTokenNotFound {
+		return Nonce{}, s.notFound(store, token, action, uid)
+	}
+	return n, err
+}
+
+// GetByID asks every store, since an id doesn't say which action it belongs to.
+// Stores that aren't an Inspector report ErrNotSupported.
+func (s *routingService) GetByID(id uuid.UUID) (Nonce, error) {
+	return s.eachStore(func(store Service) (Nonce, error) {
+		in, ok := store.(Inspector)
+		if !ok {
+			return Nonce{}, ErrNotSupported
+		}
+		return in.GetByID(id)
+	})
+}
+
+// GetByToken asks every store like Consume
+func (s *routingService) GetByToken(token string) (Nonce, error) {
+	return s.eachStore(func(store Service) (Nonce, error) {
+		in, ok := store.(Inspector)
+		if !ok {
+			return Nonce{}, ErrNotSupported
+		}
+		return in.GetByToken(token)
+	})
+}
+
+// ConsumeWithMeta tries every store like Consume.
+// Stores that aren't a MetaConsumer report ErrNotSupported.
+func (s *routingService) ConsumeWithMeta(token string, meta ConsumeMeta) (Nonce, error) {
+	return s.eachStore(func(store Service) (Nonce, error) {
+		m, ok := store.(MetaConsumer)
+		if !ok {
+			return Nonce{}, ErrNotSupported
+		}
+		return m.ConsumeWithMeta(token, meta)
+	})
+}
+
+func (s *routingService) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta ConsumeMeta) (Nonce, error) {
+	store, err := s.resolve(action)
+	if err != nil {
+		return Nonce{}, err
+	}
+	m, ok := store.(MetaConsumer)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	n, err := m.CheckThenConsumeWithMeta(token, action, uid, meta)
+	if err == ErrTokenNotFound {
+		return Nonce{}, s.notFound(store, token, action, uid)
+	}
+	return n, err
+}
+
+// ConsumeByID only asks the store action resolves to, so an id stored for a
+// different action is reported as ErrTokenNotFound rather than ErrInvalidToken
+func (s *routingService) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (Nonce, error) {
+	store, err := s.resolve(action)
+	if err != nil {
+		return Nonce{}, err
+	}
+	return store.ConsumeByID(id, action, uid)
+}
+
+func (s *routingService) Get(action string, uid uuid.UUID) (Nonce, error) {
+	store, err := s.resolve(action)
+	if err != nil {
+		return Nonce{}, err
+	}
+	return store.Get(action, uid)
+}
+
+// AwaitConsumption waits on every store at once since only the id is known
+func (s *routingService) AwaitConsumption(ctx context.Context, id uuid.UUID) (Nonce, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		n   Nonce
+		err error
+	}
+	results := make(chan result, len(s.stores))
+	for _, store := range s.stores {
+		a, ok := store.(Awaiter)
+		if !ok {
+			return Nonce{}, ErrNotSupported
+		}
+		go func(a Awaiter) {
+			n, err := a.AwaitConsumption(ctx, id)
+			results <- result{n, err}
+		}(a)
+	}
+
+	for range s.stores {
+		r := <-results
+		if r.err != ErrTokenNotFound {
+			return r.n, r.err
+		}
+	}
+	return Nonce{}, ErrTokenNotFound
+}
+
+func (s *routingService) Renew(token string, extendBy time.Duration) (Nonce, error) {
+	return s.eachStore(func(store Service) (Nonce, error) {
+		return store.Renew(token, extendBy)
+	})
+}
+
+func (s *routingService) PutNonce(n Nonce) (Nonce, error) {
+	store, err := s.resolve(n.Action)
+	if err != nil {
+		return Nonce{}, err
+	}
+	p, ok := store.(Putter)
+	if !ok {
+		return Nonce{}, ErrNotSupported
+	}
+	return p.PutNonce(n)
+}
+
+func (s *routingService) List(ctx context.Context, f Filter, fn func(Nonce) error) error {
+	for _, store := range s.stores {
+		l, ok := store.(Lister)
+		if !ok {
+			return ErrNotSupported
+		}
+		err := l.List(ctx, f, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExtendExpiry extends the matching nonces in every store
+func (s *routingService) ExtendExpiry(filter Filter, by time.Duration) (int, error) {
+	count := 0
+	for _, store := range s.stores {
+		e, ok := store.(ExpiryExtender)
+		if !ok {
+			return count, ErrNotSupported
+		}
+		n, err := e.ExtendExpiry(filter, by)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// PurgeExpired purges every store, sharing limit between them
+func (s *routingService) PurgeExpired(ctx context.Context, limit int) (int64, error) {
+	var purged int64
+	for _, store := range s.stores {
+		p, ok := store.(Purger)
+		if !ok {
+			return purged, ErrNotSupported
+		}
+		left := limit
+		if limit > 0 {
+			left = limit - int(purged)
+			if left <= 0 {
+				break
+			}
+		}
+		n, err := p.PurgeExpired(ctx, left)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// Shutdown shuts down every store
+func (s *routingService) Shutdown() {
+	for _, store := range s.stores {
+		store.Shutdown()
+	}
+}
+
+// notFound is called when the store action resolved to doesn't know token.
+// If another store does, the token belongs to a different action, which a single
+// store would report as ErrInvalidToken.
+func (s *routingService) notFound(tried Service, token, action string, uid uuid.UUID) error {
+	for _, store := range s.stores {
+		if store == tried {
+			continue
+		}
+		if store.Check(token, action, uid) != ErrTokenNotFound {
+			return ErrInvalidToken
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// eachStore calls fn on each store until one knows the token
+func (s *routingService) eachStore(fn func(Service) (Nonce, error)) (Nonce, error) {
+	for _, store := range s.stores {
+		n, err := fn(store)
+		if err != ErrTokenNotFound {
+			return n, err
+		}
+	}
+	return Nonce{}, ErrTokenNotFound
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import "math/rand"
+
+// SampleRates maps an event to the fraction of such events that are recorded,
+// from 0 (none) to 1 (all). Keys are a method name, optionally followed by
+// ":ok" or ":error" to only match successful or failed calls, or "*" to match
+// everything else. The most specific key wins and unmatched events are always recorded.
+//
+//	SampleRates{"Check:error": 0.01, "Consume": 1}
+type SampleRates map[string]float64
+
+// WithSampling records only a sample of debug journal entries and audit events,
+// so busy deployments can bound what observability costs them while keeping
+// every event that matters, such as consumes.
+// rates is copied, so changing it afterwards doesn't affect the Service.
+func WithSampling(rates SampleRates) Option {
+	return func(cfg *config) {
+		cfg.sampling = make(SampleRates, len(rates))
+		for k, v := range rates {
+			cfg.sampling[k] = v
+		}
+	}
+}
+
+// keep reports whether an event for method with outcome err should be recorded
+func (r SampleRates) keep(method string, err error) bool {
+	outcome := ":ok"
+	if err != nil {
+		outcome = ":error"
+	}
+	rate, ok := r[method+outcome]
+	if !ok {
+		rate, ok = r[method]
+	}
+	if !ok {
+		rate, ok = r["*"]
+	}
+	if !ok || rate >= 1 {
+		return true
+	}
+	return rand.Float64() < rate
+}
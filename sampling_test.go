@@ -0,0 +1,63 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSampleRates(t *testing.T) {
+	r := SampleRates{"Check:error": 0, "Check": 1, "*": 0}
+	cases := []struct {
+		method string
+		err    error
+		keep   bool
+	}{
+		{"Check", ErrInvalidToken, false},
+		{"Check", nil, true},
+		{"Consume", nil, false},
+	}
+	for _, c := range cases {
+		if r.keep(c.method, c.err) != c.keep {
+			t.Fatalf("Expected keep(%s, %v) to be %v", c.method, c.err, c.keep)
+		}
+	}
+	if !SampleRates(nil).keep("Check", ErrInvalidToken) {
+		t.Fatalf("Expected no SampleRates to keep everything")
+	}
+}
+
+func TestSampledJournal(t *testing.T) {
+	var b bytes.Buffer
+	s := NewInMemoryService(WithDebugJournal(&b), WithSampling(SampleRates{"Check:error": 0}))
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	s.Check(n.Token, "wrong", tNonce.UserID)
+	_, err = s.Consume(n.Token)
+	if err != nil {
+		t.Fatalf("Expected token to be consumed. Instead got the error: %v", err)
+	}
+
+	out := b.String()
+	if strings.Count(out, "\n") != 2 || strings.Contains(out, `"Check"`) {
+		t.Fatalf("Expected the failed check to be sampled out of the journal. Instead got: %s", out)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// scopeSeparator joins the actions of a scoped nonce in its Action. Every
+// backend stores the joined list as it would any other action.
+const scopeSeparator = "\x1f"
+
+// ScopedAction returns the Action of a nonce that is valid for any of
+// actions. They are sorted and deduplicated, so the same set always gives
+// the same Action and a New for it invalidates the user's last nonce for the
+// set. It returns ErrInvalidScope if there are no actions or one is empty or
+// contains the ASCII unit separator.
+func ScopedAction(actions ...string) (string, error) {
+	if len(actions) == 0 {
+		return "", ErrInvalidScope
+	}
+	sorted := make([]string, 0, len(actions))
+	for _, a := range actions {
+		if a == "" || strings.Contains(a, scopeSeparator) {
+			return "", ErrInvalidScope
+		}
+		sorted = append(sorted, a)
+	}
+	sort.Strings(sorted)
+	deduped := sorted[:1]
+	for _, a := range sorted[1:] {
+		if a != deduped[len(deduped)-1] {
+			deduped = append(deduped, a)
+		}
+	}
+	return strings.Join(deduped, scopeSeparator), nil
+}
+
+// NewScoped creates a nonce in s for uid that Check and the consuming methods
+// accept for any of actions, e.g. "reset-password" or "unlock-account".
+// Get only finds it by its whole ScopedAction.
+func NewScoped(s Service, actions []string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error) {
+	action, err := ScopedAction(actions...)
+	if err != nil {
+		return Nonce{}, err
+	}
+	return s.New(action, uid, expiresIn)
+}
+
+// Actions returns the actions n is valid for
+func (n Nonce) Actions() []string {
+	return strings.Split(n.Action, scopeSeparator)
+}
+
+// actionMatches reports whether a nonce created for stored may be checked for action
+func actionMatches(stored, action string) bool {
+	if stored == action {
+		return true
+	}
+	if action == "" || !strings.Contains(stored, scopeSeparator) {
+		return false
+	}
+	for _, a := range strings.Split(stored, scopeSeparator) {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestScopedAction(t *testing.T) {
+	a, err := ScopedAction("unlock-account", "reset-password", "unlock-account")
+	if err != nil {
+		t.Fatalf("Expected a scoped action. Instead got the error: %v", err)
+	}
+	b, _ := ScopedAction("reset-password", "unlock-account")
+	if a != b {
+		t.Errorf("Expected the same set of actions to give the same action. Instead got: %q and %q", a, b)
+	}
+	if got := (Nonce{Action: a}).Actions(); len(got) != 2 || got[0] != "reset-password" || got[1] != "unlock-account" {
+		t.Errorf("Expected Actions to return both actions. Instead got: %q", got)
+	}
+	for _, actions := range [][]string{nil, {""}, {"a\x1fb"}} {
+		_, err = ScopedAction(actions...)
+		if err != ErrInvalidScope {
+			t.Errorf("Expected %q to return %v. Instead got: %v", actions, ErrInvalidScope, err)
+		}
+	}
+}
+
+func TestScopedNonces(t *testing.T) {
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newService()
+			defer s.Shutdown()
+			uid := uuid.NewV4()
+
+			n, err := NewScoped(s, []string{"reset-password", "unlock-account"}, uid, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add a scoped nonce. Instead got the error: %v", err)
+			}
+			for _, action := range []string{"reset-password", "unlock-account", n.Action} {
+				err = s.Check(n.Token, action, uid)
+				if err != nil {
+					t.Errorf("Expected the nonce to be valid for %q. Instead got: %v", action, err)
+				}
+			}
+			err = s.Check(n.Token, "delete-account", uid)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected the nonce to be invalid for another action. Instead got: %v", err)
+			}
+
+			_, err = s.CheckThenConsume(n.Token, "unlock-account", uid)
+			if err != nil {
+				t.Fatalf("Expected to consume the nonce for one of its actions. Instead got the error: %v", err)
+			}
+			err = s.Check(n.Token, "reset-password", uid)
+			if !errors.Is(err, ErrTokenUsed) {
+				t.Errorf("Expected consuming for one action to use the nonce up for all. Instead got: %v", err)
+			}
+
+			n, _ = NewScoped(s, []string{"reset-password", "unlock-account"}, uid, time.Minute)
+			_, err = s.ConsumeByID(n.ID, "reset-password", uid)
+			if err != nil {
+				t.Fatalf("Expected to consume the nonce by ID for one of its actions. Instead got the error: %v", err)
+			}
+			_, err = s.ConsumeByID(n.ID, "reset-password", uid)
+			if !errors.Is(err, ErrTokenUsed) {
+				t.Errorf("Expected a second consume to fail with %v. Instead got: %v", ErrTokenUsed, err)
+			}
+
+			n, _ = NewScoped(s, []string{"reset-password", "unlock-account"}, uid, time.Minute)
+			next, err := s.(Chainer).ConsumeAndChain(n.Token, "reset-password", uid, "confirm", time.Minute)
+			if err != nil || next.ParentID != n.ID {
+				t.Errorf("Expected to chain from the nonce for one of its actions. Instead got: %+v, %v", next, err)
+			}
+		})
+	}
+}
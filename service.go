@@ -15,7 +15,6 @@
 package nonce
 
 import (
-	"crypto/sha512"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -23,30 +22,69 @@ import (
 	"sync"
 	"time"
 
-	"github.com/bryanjeal/go-helpers"
-
-	// handle mysql database
-	_ "github.com/go-sql-driver/mysql"
-	// handle sqlite3 database
-	_ "github.com/mattn/go-sqlite3"
-
-	"github.com/jmoiron/sqlx"
 	uuid "github.com/satori/go.uuid"
 )
 
 // Errors
 var (
-	ErrNoToken       = errors.New("no token supplied")
-	ErrInvalidToken  = errors.New("invalid token")
-	ErrTokenUsed     = errors.New("duplicate submission")
-	ErrTokenExpired  = errors.New("token expired")
-	ErrTokenNotFound = errors.New("token not found")
+	// ErrInvalidToken, ErrTokenUsed and ErrTokenExpired are usually wrapped in a
+	// *TokenError, so check for them with errors.Is.
+	ErrNoToken         = errors.New("no token supplied")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrTokenUsed       = errors.New("duplicate submission")
+	ErrTokenExpired    = errors.New("token expired")
+	ErrTokenNotFound   = errors.New("token not found")
+	ErrNotSupported    = errors.New("not supported by this service")
+	ErrTooManyAttempts = errors.New("too many failed attempts")
+	ErrPayloadTooLarge = errors.New("payload too large")
+	ErrRateLimited     = errors.New("too many nonces created")
+	ErrTooManyNonces   = errors.New("too many outstanding nonces")
+	ErrUserRequired    = errors.New("user required")
+	ErrBindingMismatch = errors.New("binding mismatch")
+	ErrInvalidBinding  = errors.New("invalid binding")
+	ErrKeyInProgress   = errors.New("idempotency key in progress")
+	ErrInvalidKey      = errors.New("invalid idempotency key")
+	ErrInvalidScope    = errors.New("invalid action scope")
+	// ErrNotInspector, ErrNotPutter, ErrNotLister and ErrNotIdempotencyStore
+	// are returned by the constructors that wrap a Service which must
+	// implement that interface
+	ErrNotInspector        = errors.New("service must implement Inspector")
+	ErrNotPutter           = errors.New("service must implement Putter")
+	ErrNotLister           = errors.New("service must implement Lister")
+	ErrNotIdempotencyStore = errors.New("service must implement IdempotencyStore")
+	// ErrNotScopable is returned by ForTenant for a Service it can't scope
+	ErrNotScopable = errors.New("service can't be scoped to a tenant")
+	// ErrInvalidPepper is returned by New when WithPepper or WithVerifyPepper
+	// was given an id that isn't positive, an empty secret, or one id twice
+	ErrInvalidPepper = errors.New("invalid pepper")
+	// ErrBackendUnavailable is returned without calling the backend while a
+	// NewCircuitBreakerService is open
+	ErrBackendUnavailable = errors.New("backend unavailable")
+	// ErrSweepStalled is returned by Health when expired nonces haven't been
+	// removed for SweepStallIntervals RemoveExpiredIntervals
+	ErrSweepStalled = errors.New("expired nonce sweep stalled")
 )
 
 // Service is the interface that provides auth methods.
+//
+// A Service always sees its own writes when its backend reads and writes the
+// same store. If reads may be served by a lagging replica or cache use
+// WithReadYourWrites so Check, Consume and Get straight after New still find the nonce.
+//
+// Every Service is safe for concurrent use by multiple goroutines, and so is
+// every Service this package wraps one in. Nonce is a plain value; the copies
+// Services return share nothing with what they store. Options are applied once,
+// when the Service is created, and there is no way to change them afterwards.
+// Clocks, Hashers, Loggers and Auditors passed to options are called from many
+// goroutines at once, so they must be safe for concurrent use too.
 type Service interface {
 	// NewUserLocal registers a new user by a local account (email and password)
 	// NOTE: time.Duraction is Truncated to the Second due to MySQL Date resolution
+	//
+	// A uid of uuid.Nil creates an anonymous nonce, for forms shown before
+	// sign in, such as signup or anonymous CSRF. Each anonymous nonce stands
+	// alone: New doesn't invalidate other anonymous nonces for the action, and
+	// it is checked by passing uuid.Nil. See WithUserRequired.
 	New(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error)
 
 	// Check takes a Nonce token and checks to see if it is valid
@@ -58,17 +96,43 @@ type Service interface {
 	// CheckThenConsume checks to make sure Nonce token is valid and then marks it as used
 	CheckThenConsume(token, action string, uid uuid.UUID) (Nonce, error)
 
-	// Get takes a uid and action and returns the newest, valid nonce if it exists
+	// ConsumeByID checks the Nonce with id is valid for action and uid and then marks it as used.
+	// It is for workflows that track nonces by ID and never handle the token.
+	ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (Nonce, error)
+
+	// Get takes a uid and action and returns the newest nonce that is valid, unused
+	// and unexpired, or ErrTokenNotFound if there is none. GetAll returns every match.
+	// Anonymous nonces belong to no one, so Get returns ErrUserRequired for uuid.Nil.
 	Get(action string, uid uuid.UUID) (Nonce, error)
 
-	// Shutdown stops the removedExpired() function
+	// Renew pushes the expiry of a valid, unused Nonce token forward by extendBy
+	// NOTE: ExpiresAt is Truncated to the Second due to MySQL Date resolution
+	Renew(token string, extendBy time.Duration) (Nonce, error)
+
+	// Shutdown stops the removedExpired() function.
+	// It may be called more than once.
 	Shutdown()
 }
 
+// Putter is implemented by Services that can store a Nonce exactly as given.
+// It skips all validation and the invalidation New performs, so it is meant
+// for tests, imports and migrations rather than regular use.
+type Putter interface {
+	// PutNonce stores n, replacing any nonce with the same token.
+	// A missing Token, Salt, CreatedAt or ID is generated.
+	PutNonce(n Nonce) (Nonce, error)
+}
+
 // RemoveExpiredInterval can/should be set by applications using nonce.
-// Default RemoveExpiredInterval is 24 Hours
+// Default RemoveExpiredInterval is 24 Hours.
+// It is read when a Service is created, so set it before creating one.
 var RemoveExpiredInterval = 24 * time.Hour
 
+// RemoveExpiredJitter delays each sweep after the first by a random duration
+// up to it, so Services started together don't all sweep a shared database at
+// once. Default is no jitter. It is read when a Service is created.
+var RemoveExpiredJitter time.Duration
+
 // Nonce Model holds token and token details
 type Nonce struct {
 	ID        uuid.UUID
@@ -80,76 +144,175 @@ type Nonce struct {
 	IsValid   bool      `db:"is_valid"`
 	CreatedAt int64     `db:"created_at"`
 	ExpiresAt time.Time `db:"expires_at"`
-}
 
-type nonceService struct {
-	db   *sqlx.DB
-	quit chan struct{}
+	// ConsumedAt is the Unix time the nonce was consumed, or 0 if it hasn't been.
+	// ConsumedIP and ConsumedUserAgent are whatever ConsumeMeta the consumer supplied.
+	ConsumedAt        int64  `db:"consumed_at"`
+	ConsumedIP        string `db:"consumed_ip"`
+	ConsumedUserAgent string `db:"consumed_user_agent"`
+
+	// Binding is the encoded Binding the nonce was created with, or empty
+	Binding string
+
+	// ParentID is the ID of the nonce ConsumeAndChain consumed to create this one, or uuid.Nil
+	ParentID uuid.UUID `db:"parent_id"`
+
+	// Namespace is the WithNamespace tenant the nonce belongs to, or empty
+	Namespace string
+
+	// Payload is what the application stored with NewWithPayload, e.g. the
+	// address an email change is confirming, or empty
+	Payload string
+
+	// DeletedAt is the Unix time a sqlx.WithSoftDelete sweep tombstoned the nonce, or 0
+	DeletedAt int64 `db:"deleted_at"`
+
+	// plainToken is the token to return from New when it isn't the stored Token
+	plainToken string
 }
 
 type nonceInMemoryService struct {
-	store *inMemStore
-	quit  chan struct{}
+	store   *inMemStore
+	keys    *idempotencyKeys
+	cfg     config
+	origin  uuid.UUID
+	waiters *consumeWaiters
+	sweeps  *sweepTracker
+	quit    chan struct{}
+	stop    sync.Once
 }
+
+// inMemShards is how many shards the in-memory store spreads tokens over
+const inMemShards = 64
+
+// inMemStore shards nonces by a hash of their token so callers working on
+// different tokens rarely wait on the same lock
 type inMemStore struct {
-	*sync.RWMutex
+	// size and evicted are accessed atomically
+	size    int64
+	evicted int64
+	max     int
+
+	shards [inMemShards]inMemShard
+	index  inMemIndex
+	expiry inMemExpiry
+	counts inMemCounts
+}
+
+type inMemShard struct {
+	sync.RWMutex
 	nonceMap map[string]Nonce
+
+	// order holds tokens in insertion order so scans can walk the shard a
+	// chunk at a time instead of ranging over nonceMap under one lock.
+	// Removed tokens leave an empty slot until compact drops them.
+	order    []string
+	slot     map[string]int
+	removed  int
+	scanners int32
 }
 
-// NewService creates an Nonce Service that connects to provided DB information
-// See service.sqlx.go for implementation details
-func NewService(db *sqlx.DB) Service {
-	s := &nonceService{
-		db:   db,
-		quit: make(chan struct{}),
-	}
-	go s.removeExpired()
-	return s
+// inMemIndex finds tokens by ID and by action and user without a scan.
+// It is locked after a shard lock, never before one.
+type inMemIndex struct {
+	sync.RWMutex
+	byID   map[uuid.UUID]string
+	byUser map[userAction]map[string]struct{}
+}
+
+// inMemExpiry orders tokens by when they can next be purged, so a sweep only
+// visits nonces that have expired. Entries are not removed when a nonce is
+// renewed or deleted; the purge checks the stored nonce and skips stale ones.
+type inMemExpiry struct {
+	sync.Mutex
+	entries expiryHeap
+}
+
+type expiryEntry struct {
+	at    time.Time
+	token string
+}
+
+type userAction struct {
+	action    string
+	uid       uuid.UUID
+	namespace string
 }
 
 // NewInMemoryService creates an Nonce Service that stores all nonces in memory
 // See service.inmem.go for implementation details
-func NewInMemoryService() Service {
+func NewInMemoryService(opts ...Option) Service {
+	cfg := newConfig(opts)
 	s := &nonceInMemoryService{
-		store: &inMemStore{
-			RWMutex:  &sync.RWMutex{},
-			nonceMap: make(map[string]Nonce),
-		},
-		quit: make(chan struct{}),
+		store:   newInMemStore(),
+		keys:    newIdempotencyKeys(),
+		cfg:     cfg,
+		origin:  uuid.NewV4(),
+		waiters: newConsumeWaiters(),
+		sweeps:  newSweepTracker(cfg),
+		quit:    make(chan struct{}),
 	}
-	go s.removeExpired()
-	return s
+	s.store.max = s.cfg.maxEntries
+	if s.cfg.persist != nil {
+		s.load()
+		go s.persistAll()
+	}
+	s.subscribe()
+	go s.cfg.removeExpired(s.quit, s.sweeps, s.PurgeExpired)
+	return s.cfg.wrap(s)
 }
 
-// checkToken token does a basic check of the token based on length
-func checkToken(token string) error {
+// checkToken token does a basic check of the token based on the lengths the Hashers produce.
+// The token is normalized first in case it was mangled in transit, and the
+// normalized token is returned for the lookup, hashed under WithTokenHashing.
+func (c config) checkToken(token string) (string, error) {
 	if len(strings.TrimSpace(token)) == 0 {
-		return ErrNoToken
-	} else if len(token) != 88 {
-		return ErrInvalidToken
+		return "", ErrNoToken
+	}
+	// a PASETO token is unpadded, so it is unwrapped before normalizing
+	if t := strings.TrimSpace(token); c.paseto != nil && strings.HasPrefix(t, pasetoHeader) {
+		id, err := c.paseto.tokenID(t)
+		if err != nil {
+			return "", ErrInvalidToken
+		}
+		token = id
 	}
 
-	return nil
+	token = normalizeToken(token, c.encoding)
+	info, err := ParseEncodedToken(token, c.encoding)
+	if err != nil || !c.validTokenLen(len(info.Body)) {
+		return "", ErrInvalidToken
+	}
+	if _, ok := c.tokenPepper(token); !ok {
+		return "", ErrInvalidToken
+	}
+	return c.storedToken(token), nil
 }
 
 // All nonces have the same creation code. This stub generates the Nonce itself
 // The services are responsible for storing the created Nonce
-func newNonce(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error) {
+// t is the creation time as reported by the service's Clock
+func (c config) newNonce(action string, uid uuid.UUID, expiresIn time.Duration, t time.Time) (Nonce, error) {
+	if err := c.pepperErr(); err != nil {
+		return Nonce{}, err
+	}
 	// Generate salt
-	rawSalt, err := helpers.Crypto.GenerateRandomKey(16)
+	rawSalt, err := c.randomBytes(16)
 	if err != nil {
 		return Nonce{}, err
 	}
 	salt := base64.StdEncoding.EncodeToString(rawSalt)
 
-	// get current time
-	t := time.Now()
-
 	// Generate new token
 	rawToken := fmt.Sprintf("%s::%s::%d::%s", action, uid.String(), t.Unix(), salt)
-	hasher := sha512.New()
-	hasher.Write([]byte(rawToken))
-	token := base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+	if c.namespace != "" {
+		rawToken = c.namespace + "::" + rawToken
+	}
+	sum, err := c.sum(c.pepperInput(rawToken))
+	if err != nil {
+		return Nonce{}, err
+	}
+	token := c.versionToken(c.pepperToken(c.encoding.EncodeToString(sum)))
 
 	// We Truncate ExpiresAt because MySQL DateTime doesn't store past Seconds
 	n := Nonce{
@@ -161,27 +324,102 @@ func newNonce(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, err
 		IsValid:   true,
 		CreatedAt: t.Unix(),
 		ExpiresAt: t.Add(expiresIn).Truncate(time.Second),
+		Namespace: c.namespace,
+	}
+	err = c.checkLimits(n)
+	if err != nil {
+		return Nonce{}, err
 	}
 
+	n = c.hideToken(n)
+	if c.paseto != nil {
+		n.plainToken, err = c.paseto.issue(n.issue())
+		if err != nil {
+			return Nonce{}, err
+		}
+	}
 	return n, nil
 }
 
-// checkNonce stub checks to make sure the nonce itself is valid
-func checkNonce(n Nonce, action string, uid uuid.UUID) error {
+// fillNonce stub generates whatever identifying fields n is missing at time t
+// and moves it into the Service's namespace
+func (c config) fillNonce(n Nonce, t time.Time) (Nonce, error) {
+	n.Namespace = c.namespace
+	err := c.checkLimits(n)
+	if err != nil {
+		return Nonce{}, err
+	}
+	if n.Token != "" && n.Salt != "" && n.CreatedAt != 0 {
+		return c.hideToken(n), nil
+	}
+
+	g, err := c.newNonce(n.Action, n.UserID, 0, t)
+	if err != nil {
+		return Nonce{}, err
+	}
+	if n.Token == "" {
+		n.Token, n.plainToken = g.Token, g.plainToken
+	}
+	if n.Salt == "" {
+		n.Salt = g.Salt
+	}
+	if n.CreatedAt == 0 {
+		n.CreatedAt = g.CreatedAt
+	}
+	return c.hideToken(n), nil
+}
+
+// usable reports whether n is valid, unused and unexpired at t
+func (c config) usable(n Nonce, t time.Time) bool {
+	return n.IsValid && !n.IsUsed && n.ExpiresAt.After(c.expiryCutoff(t))
+}
+
+// checkNonce stub checks to make sure the nonce itself is valid at time t
+// for a request that didn't describe itself
+func (c config) checkNonce(n Nonce, action string, uid uuid.UUID, t time.Time) error {
+	return c.checkBound(n, action, uid, ConsumeMeta{}, t)
+}
+
+// checkBound is checkNonce for the request meta describes
+func (c config) checkBound(n Nonce, action string, uid uuid.UUID, meta ConsumeMeta, t time.Time) error {
 	// make sure token is still valid
-	if n.IsValid == false || n.Action != action || n.UserID != uid {
-		return ErrInvalidToken
+	if n.IsValid == false || !actionMatches(n.Action, action) {
+		return NewTokenError(ErrInvalidToken, n, t)
+	}
+	if n.UserID != uid && uid == uuid.Nil {
+		// the caller checked anonymously but the nonce belongs to a user
+		return NewTokenError(ErrUserRequired, n, t)
+	} else if n.UserID != uid {
+		return NewTokenError(ErrInvalidToken, n, t)
+	}
+	if !bindingMatches(n.Binding, meta) {
+		return NewTokenError(ErrBindingMismatch, n, t)
 	}
 
 	// make sure token hasn't been used
 	if n.IsUsed == true {
-		return ErrTokenUsed
+		return NewTokenError(ErrTokenUsed, n, t)
 	}
 
 	// make sure token isn't expired
-	t := time.Now()
-	if n.ExpiresAt.After(t) == false {
-		return ErrTokenExpired
+	if n.ExpiresAt.After(c.expiryCutoff(t)) == false {
+		return NewTokenError(ErrTokenExpired, n, t)
 	}
 	return nil
 }
+
+// renewNonce stub checks that the nonce can be renewed at time t and extends it
+func (c config) renewNonce(n Nonce, extendBy time.Duration, t time.Time) (Nonce, error) {
+	if n.IsValid == false {
+		return Nonce{}, NewTokenError(ErrInvalidToken, n, t)
+	}
+	if n.IsUsed == true {
+		return Nonce{}, NewTokenError(ErrTokenUsed, n, t)
+	}
+	if n.ExpiresAt.After(c.expiryCutoff(t)) == false {
+		return Nonce{}, NewTokenError(ErrTokenExpired, n, t)
+	}
+
+	n.ExpiresAt = n.ExpiresAt.Add(extendBy).Truncate(time.Second)
+	return n, nil
+}
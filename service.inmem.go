@@ -15,13 +15,29 @@
 package nonce
 
 import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"sync/atomic"
 	"time"
 
 	"github.com/satori/go.uuid"
 )
 
 func (s *nonceInMemoryService) New(action string, uid uuid.UUID, expiresIn time.Duration) (Nonce, error) {
-	n, err := newNonce(action, uid, expiresIn)
+	return s.NewBound(action, uid, expiresIn, Binding{})
+}
+
+func (s *nonceInMemoryService) NewBound(action string, uid uuid.UUID, expiresIn time.Duration, b Binding) (Nonce, error) {
+	return s.newBound(action, uid, expiresIn, b, "")
+}
+
+func (s *nonceInMemoryService) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (Nonce, error) {
+	return s.newBound(action, uid, expiresIn, Binding{}, payload)
+}
+
+func (s *nonceInMemoryService) newBound(action string, uid uuid.UUID, expiresIn time.Duration, b Binding, payload string) (Nonce, error) {
+	n, err := s.cfg.newBoundNonce(action, uid, expiresIn, b, payload)
 	if err != nil {
 		return Nonce{}, err
 	}
@@ -30,22 +46,26 @@ func (s *nonceInMemoryService) New(action string, uid uuid.UUID, expiresIn time.
 	n = s.saveNonce(n)
 
 	// Invalidate existing tokens for same user & action
-	s.store.Lock()
-	for k, v := range s.store.nonceMap {
-		if v.IsValid && v.UserID == n.UserID && v.Action == n.Action && v.ID != n.ID {
-			v.IsValid = false
-			s.store.nonceMap[k] = v
-		}
-	}
-	s.store.Unlock()
+	others := s.store.invalidateOthers(n)
+	s.cfg.created(n)
+	s.cfg.invalidated(others...)
+	s.broadcast(broadcastInvalidated, n)
 
 	// return new nonce
-	return n, nil
+	return n.issue(), nil
 }
 
 func (s *nonceInMemoryService) Check(token, action string, uid uuid.UUID) error {
+	return s.CheckBound(token, action, uid, ConsumeMeta{})
+}
+
+func (s *nonceInMemoryService) CheckBound(token, action string, uid uuid.UUID, meta ConsumeMeta) error {
 	// make sure token was passed
-	err := checkToken(token)
+	token, err := s.cfg.checkToken(token)
+	if err != nil {
+		return err
+	}
+	err = s.cfg.checkMeta(meta)
 	if err != nil {
 		return err
 	}
@@ -56,128 +76,563 @@ func (s *nonceInMemoryService) Check(token, action string, uid uuid.UUID) error
 		return err
 	}
 
-	err = checkNonce(n, action, uid)
+	err = s.cfg.checkBound(n, action, uid, meta, s.cfg.clock.Now())
 	return err
 }
 
 func (s *nonceInMemoryService) Consume(token string) (Nonce, error) {
+	return s.ConsumeWithMeta(token, ConsumeMeta{})
+}
+
+func (s *nonceInMemoryService) ConsumeWithMeta(token string, meta ConsumeMeta) (Nonce, error) {
 	// make sure token was passed
-	err := checkToken(token)
+	token, err := s.cfg.checkToken(token)
 	if err != nil {
 		return Nonce{}, err
 	}
-
-	// get Nonce data from store
-	n, err := s.getNonce(token)
+	err = s.cfg.checkMeta(meta)
 	if err != nil {
 		return Nonce{}, err
 	}
 
-	// make sure token hasn't been used
-	if n.IsUsed == true {
-		return Nonce{}, ErrTokenUsed
-	}
+	n, err := s.update(token, func(n Nonce) (Nonce, error) {
+		// make sure token hasn't been used
+		if n.IsUsed == true {
+			return Nonce{}, ErrTokenUsed
+		}
 
-	// set token as used
-	n.IsUsed = true
-	n = s.saveNonce(n)
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = s.cfg.clock.Now().Unix()
+		n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+		return n, nil
+	})
+	if err != nil {
+		return Nonce{}, err
+	}
+	s.waiters.consumed(n)
+	s.cfg.consumed(n)
+	s.broadcast(broadcastConsumed, n)
 
 	return n, nil
 }
 
 func (s *nonceInMemoryService) CheckThenConsume(token, action string, uid uuid.UUID) (Nonce, error) {
-	err := s.Check(token, action, uid)
+	return s.CheckThenConsumeWithMeta(token, action, uid, ConsumeMeta{})
+}
+
+func (s *nonceInMemoryService) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta ConsumeMeta) (Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.checkToken(token)
+	if err != nil {
+		return Nonce{}, err
+	}
+	err = s.cfg.checkMeta(meta)
 	if err != nil {
 		return Nonce{}, err
 	}
 
-	n, err := s.Consume(token)
-	return n, err
-}
+	// check and consume under one lock so concurrent callers can't both succeed
+	n, err := s.update(token, func(n Nonce) (Nonce, error) {
+		t := s.cfg.clock.Now()
+		err := s.cfg.checkBound(n, action, uid, meta, t)
+		if err != nil {
+			return Nonce{}, err
+		}
 
-func (s *nonceInMemoryService) Get(action string, uid uuid.UUID) (Nonce, error) {
-	var nonces []Nonce
-	nonces = make([]Nonce, 1, 1)
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = t.Unix()
+		n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+		return n, nil
+	})
+	if err != nil {
+		return Nonce{}, err
+	}
+	s.waiters.consumed(n)
+	s.cfg.consumed(n)
+	s.broadcast(broadcastConsumed, n)
 
-	s.store.RLock()
-	for _, n := range s.store.nonceMap {
-		if n.Action == action && n.UserID == uid {
-			nonces = append(nonces, n)
+	return n, nil
+}
+
+func (s *nonceInMemoryService) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (Nonce, error) {
+	// check and consume under one lock so concurrent callers can't both succeed
+	n, err := s.update(s.store.tokenFor(id), func(n Nonce) (Nonce, error) {
+		t := s.cfg.clock.Now()
+		err := s.cfg.checkNonce(n, action, uid, t)
+		if err != nil {
+			return Nonce{}, err
 		}
-	}
-	s.store.RUnlock()
 
-	if len(nonces) == 0 {
-		return Nonce{}, ErrTokenNotFound
-	} else if len(nonces) == 1 {
-		return nonces[0], nil
+		// set token as used
+		n.IsUsed = true
+		n.ConsumedAt = t.Unix()
+		return n, nil
+	})
+	if err != nil {
+		return Nonce{}, err
 	}
+	s.waiters.consumed(n)
+	s.cfg.consumed(n)
+	s.broadcast(broadcastConsumed, n)
+
+	return n, nil
+}
 
-	newestN := nonces[0]
-	for _, n := range nonces {
-		if newestN.CreatedAt < n.CreatedAt && n.IsValid {
+func (s *nonceInMemoryService) Get(action string, uid uuid.UUID) (Nonce, error) {
+	if uid == uuid.Nil {
+		return Nonce{}, ErrUserRequired
+	}
+	t := s.cfg.clock.Now()
+	var newestN Nonce
+	found := false
+	for _, n := range s.store.forUser(userAction{action, uid, s.cfg.namespace}) {
+		if !s.cfg.usable(n, t) {
+			continue
+		}
+		if !found || newestN.CreatedAt < n.CreatedAt {
 			newestN = n
+			found = true
 		}
 	}
 
-	if newestN.IsValid == false {
+	if !found {
 		return Nonce{}, ErrTokenNotFound
 	}
 
 	return newestN, nil
 }
 
+func (s *nonceInMemoryService) Renew(token string, extendBy time.Duration) (Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.checkToken(token)
+	if err != nil {
+		return Nonce{}, err
+	}
+
+	// check and extend under one lock so a concurrent Consume can't slip in between
+	return s.update(token, func(n Nonce) (Nonce, error) {
+		return s.cfg.renewNonce(n, extendBy, s.cfg.clock.Now())
+	})
+}
+
+func (s *nonceInMemoryService) AwaitConsumption(ctx context.Context, id uuid.UUID) (Nonce, error) {
+	return s.waiters.await(ctx, id, s.cfg, s.getNonceByID)
+}
+
+func (s *nonceInMemoryService) PutNonce(n Nonce) (Nonce, error) {
+	n, err := s.cfg.fillNonce(n, s.cfg.clock.Now())
+	if err != nil {
+		return Nonce{}, err
+	}
+
+	n = s.saveNonce(n)
+	// a cache in front of a shared store learns about consumes through PutNonce
+	switch {
+	case n.IsUsed:
+		s.broadcast(broadcastConsumed, n)
+	case !n.IsValid:
+		s.broadcast(broadcastInvalid, n)
+	}
+	return n.issue(), nil
+}
+
+// Shutdown stops the removeExpired goroutine, cancelling a sweep that is running
 func (s *nonceInMemoryService) Shutdown() {
-	s.quit <- struct{}{}
+	s.stop.Do(func() {
+		close(s.quit)
+		if s.cfg.persist != nil {
+			s.save()
+		}
+	})
 }
 
 // getNonce gets a Nonce from the store
 func (s *nonceInMemoryService) getNonce(token string) (Nonce, error) {
-	s.store.RLock()
-	n, ok := s.store.nonceMap[token]
-	s.store.RUnlock()
-	if !ok {
+	n, ok := s.store.get(token)
+	if !ok || !s.cfg.inNamespace(n) {
+		return Nonce{}, ErrTokenNotFound
+	}
+
+	return n, nil
+}
+
+// getNonceByID gets the Nonce with id from the store
+func (s *nonceInMemoryService) getNonceByID(id uuid.UUID) (Nonce, error) {
+	n, ok := s.store.get(s.store.tokenFor(id))
+	if !ok || !s.cfg.inNamespace(n) {
 		return Nonce{}, ErrTokenNotFound
 	}
 
 	return n, nil
 }
 
+// update is store.update for the nonces in the Service's namespace
+func (s *nonceInMemoryService) update(token string, fn func(n Nonce) (Nonce, error)) (Nonce, error) {
+	return s.store.update(token, func(n Nonce) (Nonce, error) {
+		if !s.cfg.inNamespace(n) {
+			return Nonce{}, ErrTokenNotFound
+		}
+		return fn(n)
+	})
+}
+
 // saveNonce saves or updates a Nonce
 func (s *nonceInMemoryService) saveNonce(n Nonce) Nonce {
 	// if id is nil then it is a new nonce
 	if n.ID == uuid.Nil {
 		// generate ID
-		n.ID = uuid.NewV4()
+		n.ID = s.cfg.newID()
 	}
 
-	s.store.Lock()
-	s.store.nonceMap[n.Token] = n
-	s.store.Unlock()
+	s.store.put(n)
 
 	return n
 }
 
-// removeExpired removes expired nonces after a certain amount of time.
-func (s *nonceInMemoryService) removeExpired() {
-	for {
-		select {
-		case <-s.quit:
-			return
-		default:
-			t := time.Now()
-			s.store.Lock()
-			for k, v := range s.store.nonceMap {
-				if v.ExpiresAt.Before(t) {
-					delete(s.store.nonceMap, k)
+// userAction is the index key for n
+func (n Nonce) userAction() userAction {
+	return userAction{n.Action, n.UserID, n.Namespace}
+}
+
+func newInMemStore() *inMemStore {
+	st := &inMemStore{}
+	st.reset()
+	return st
+}
+
+// reset empties the store
+func (st *inMemStore) reset() {
+	atomic.StoreInt64(&st.size, 0)
+	for i := range st.shards {
+		sh := &st.shards[i]
+		sh.Lock()
+		sh.nonceMap = make(map[string]Nonce)
+		sh.order, sh.slot, sh.removed = nil, make(map[string]int), 0
+		sh.Unlock()
+	}
+	st.index.Lock()
+	st.index.byID = make(map[uuid.UUID]string)
+	st.index.byUser = make(map[userAction]map[string]struct{})
+	st.index.Unlock()
+	st.expiry.Lock()
+	st.expiry.entries = nil
+	st.expiry.Unlock()
+	st.counts.reset()
+}
+
+// shard returns the shard token is stored in
+func (st *inMemStore) shard(token string) *inMemShard {
+	h := fnv.New32a()
+	h.Write([]byte(token))
+	return &st.shards[h.Sum32()%inMemShards]
+}
+
+// get returns the nonce stored for token
+func (st *inMemStore) get(token string) (Nonce, bool) {
+	sh := st.shard(token)
+	sh.RLock()
+	n, ok := sh.nonceMap[token]
+	sh.RUnlock()
+	return n, ok
+}
+
+// tokenFor returns the token of the nonce with id
+func (st *inMemStore) tokenFor(id uuid.UUID) string {
+	st.index.RLock()
+	defer st.index.RUnlock()
+	return st.index.byID[id]
+}
+
+// put stores n, replacing any nonce with the same token
+func (st *inMemStore) put(n Nonce) {
+	// only the caller creating n gets its token under WithTokenHashing
+	n.plainToken = ""
+	if st.insert(n) {
+		st.trim(n.Token)
+	}
+}
+
+// insert stores n and reports whether it wasn't stored before
+func (st *inMemStore) insert(n Nonce) bool {
+	sh := st.shard(n.Token)
+	sh.Lock()
+	defer sh.Unlock()
+
+	old, ok := sh.nonceMap[n.Token]
+	if !ok {
+		sh.slot[n.Token] = len(sh.order)
+		sh.order = append(sh.order, n.Token)
+		atomic.AddInt64(&st.size, 1)
+	}
+	sh.nonceMap[n.Token] = n
+
+	st.index.Lock()
+	if ok {
+		st.index.drop(old)
+	}
+	st.index.add(n)
+	st.index.Unlock()
+	st.counts.move(old, ok, n, true)
+
+	if !ok || !old.ExpiresAt.Equal(n.ExpiresAt) {
+		st.expiry.push(n.ExpiresAt, n.Token)
+	}
+	return !ok
+}
+
+// update calls fn with the nonce stored for token and stores what it returns,
+// all under the shard lock so concurrent updates to a token can't interleave.
+// fn must not change the nonce's Token, ID, Action or UserID.
+func (st *inMemStore) update(token string, fn func(n Nonce) (Nonce, error)) (Nonce, error) {
+	sh := st.shard(token)
+	sh.Lock()
+	defer sh.Unlock()
+
+	old, ok := sh.nonceMap[token]
+	if !ok {
+		return Nonce{}, ErrTokenNotFound
+	}
+	n, err := fn(old)
+	if err != nil {
+		return Nonce{}, err
+	}
+	sh.nonceMap[token] = n
+	st.counts.move(old, true, n, true)
+
+	if !old.ExpiresAt.Equal(n.ExpiresAt) {
+		st.expiry.push(n.ExpiresAt, token)
+	}
+	return n, nil
+}
+
+// remove deletes the nonce for token if remove reports it should go
+func (st *inMemStore) remove(token string, remove func(n Nonce) bool) bool {
+	sh := st.shard(token)
+	sh.Lock()
+	defer sh.Unlock()
+
+	n, ok := sh.nonceMap[token]
+	if !ok || !remove(n) {
+		return false
+	}
+	sh.order[sh.slot[token]] = ""
+	sh.removed++
+	delete(sh.slot, token)
+	delete(sh.nonceMap, token)
+	atomic.AddInt64(&st.size, -1)
+
+	st.index.Lock()
+	st.index.drop(n)
+	st.index.Unlock()
+	st.counts.move(n, true, Nonce{}, false)
+
+	sh.compact()
+	return true
+}
+
+// tokensFor returns the tokens of every nonce stored for key
+func (st *inMemStore) tokensFor(key userAction) []string {
+	st.index.RLock()
+	defer st.index.RUnlock()
+	set := st.index.byUser[key]
+	tokens := make([]string, 0, len(set))
+	for token := range set {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// forUser returns every nonce stored for key
+func (st *inMemStore) forUser(key userAction) []Nonce {
+	tokens := st.tokensFor(key)
+	nonces := make([]Nonce, 0, len(tokens))
+	for _, token := range tokens {
+		if n, ok := st.get(token); ok {
+			nonces = append(nonces, n)
+		}
+	}
+	return nonces
+}
+
+// invalidateOthers marks every valid nonce for n's action, user and namespace other than n
+// invalid and returns the ones that hadn't been used
+func (st *inMemStore) invalidateOthers(n Nonce) []Nonce {
+	if n.UserID == uuid.Nil {
+		return nil
+	}
+	var changed []Nonce
+	for _, token := range st.tokensFor(n.userAction()) {
+		st.update(token, func(c Nonce) (Nonce, error) {
+			if c.ID != n.ID && c.IsValid {
+				c.IsValid = false
+				if !c.IsUsed {
+					changed = append(changed, c)
 				}
+			}
+			return c, nil
+		})
+	}
+	return changed
+}
+
+// scan calls fn for every stored nonce, a shard at a time
+func (st *inMemStore) scan(ctx context.Context, size int, fn func(Nonce) error) error {
+	for i := range st.shards {
+		err := st.shards[i].scan(ctx, size, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add indexes n. The caller must hold the index write lock.
+func (idx *inMemIndex) add(n Nonce) {
+	idx.byID[n.ID] = n.Token
+	if n.UserID == uuid.Nil {
+		// anonymous nonces are never found by user
+		return
+	}
+	key := n.userAction()
+	if idx.byUser[key] == nil {
+		idx.byUser[key] = make(map[string]struct{})
+	}
+	idx.byUser[key][n.Token] = struct{}{}
+}
+
+// drop removes n from the index. The caller must hold the index write lock.
+func (idx *inMemIndex) drop(n Nonce) {
+	if idx.byID[n.ID] == n.Token {
+		delete(idx.byID, n.ID)
+	}
+	key := n.userAction()
+	delete(idx.byUser[key], n.Token)
+	if len(idx.byUser[key]) == 0 {
+		delete(idx.byUser, key)
+	}
+}
+
+// compact drops empty slots from order once they make up half of it.
+// Slots move while compacting, so it waits until no scan is running.
+// The caller must hold the write lock.
+func (sh *inMemShard) compact() {
+	if sh.removed == 0 || sh.removed*2 < len(sh.order) || atomic.LoadInt32(&sh.scanners) > 0 {
+		return
+	}
+	order := make([]string, 0, len(sh.nonceMap))
+	for _, k := range sh.order {
+		if k != "" {
+			sh.slot[k] = len(order)
+			order = append(order, k)
+		}
+	}
+	sh.order = order
+	sh.removed = 0
+}
+
+// scan calls fn for every nonce in the shard in insertion order. Only the
+// read lock is held, and only while copying out each chunk of size nonces,
+// so writers are never blocked for the length of the scan. Nonces written
+// during a scan may or may not be seen; each token is seen at most once.
+func (sh *inMemShard) scan(ctx context.Context, size int, fn func(Nonce) error) error {
+	atomic.AddInt32(&sh.scanners, 1)
+	defer atomic.AddInt32(&sh.scanners, -1)
+
+	chunk := make([]Nonce, 0, size)
+	for pos := 0; ; {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
 
+		chunk = chunk[:0]
+		sh.RLock()
+		end := pos + size
+		if end > len(sh.order) {
+			end = len(sh.order)
+		}
+		for _, k := range sh.order[pos:end] {
+			if n, ok := sh.nonceMap[k]; ok {
+				chunk = append(chunk, n)
 			}
-			s.store.Unlock()
+		}
+		sh.RUnlock()
+		if pos >= end {
+			return nil
+		}
+		pos = end
 
-			//delay until the next interval
-			time.Sleep(RemoveExpiredInterval)
+		for _, n := range chunk {
+			err = fn(n)
+			if err != nil {
+				return err
+			}
 		}
+	}
+}
+
+// push schedules token to be looked at by the purge once at has passed
+func (e *inMemExpiry) push(at time.Time, token string) {
+	e.Lock()
+	heap.Push(&e.entries, expiryEntry{at: at, token: token})
+	e.Unlock()
+}
+
+// pop removes and returns the earliest entry if it is before t
+func (e *inMemExpiry) pop(t time.Time) (expiryEntry, bool) {
+	e.Lock()
+	defer e.Unlock()
+	if len(e.entries) == 0 || !e.entries[0].at.Before(t) {
+		return expiryEntry{}, false
+	}
+	return heap.Pop(&e.entries).(expiryEntry), true
+}
 
+// first removes and returns the earliest entry
+func (e *inMemExpiry) first() (expiryEntry, bool) {
+	e.Lock()
+	defer e.Unlock()
+	if len(e.entries) == 0 {
+		return expiryEntry{}, false
 	}
+	return heap.Pop(&e.entries).(expiryEntry), true
+}
+
+// due returns the tokens of the entries before t, visiting only those entries
+// and their children in the heap
+func (e *inMemExpiry) due(t time.Time) []string {
+	e.Lock()
+	defer e.Unlock()
+	var tokens []string
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if i >= len(e.entries) || !e.entries[i].at.Before(t) {
+			continue
+		}
+		tokens = append(tokens, e.entries[i].token)
+		stack = append(stack, 2*i+1, 2*i+2)
+	}
+	return tokens
+}
+
+// expiryHeap is a min-heap of expiryEntry ordered by at, for container/heap
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x interface{}) {
+	*h = append(*h, x.(expiryEntry))
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
 }
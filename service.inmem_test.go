@@ -0,0 +1,139 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestInMemStoreIndex(t *testing.T) {
+	st := newInMemStore()
+	cfg := newConfig(nil)
+
+	// enough nonces for one user that they land in different shards
+	var nonces []Nonce
+	for i := 0; i < 2*inMemShards; i++ {
+		n, err := cfg.newNonce(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn, cfg.clock.Now())
+		if err != nil {
+			t.Fatalf("Expected to create nonce. Instead got the error: %v", err)
+		}
+		n.ID = uuid.NewV4()
+		st.put(n)
+		nonces = append(nonces, n)
+	}
+
+	newest := nonces[len(nonces)-1]
+	st.invalidateOthers(newest)
+	for _, n := range nonces {
+		got, _ := st.get(n.Token)
+		if got.IsValid != (n.ID == newest.ID) {
+			t.Fatalf("Expected only the newest nonce to stay valid. Instead %v has IsValid %v", n.ID, got.IsValid)
+		}
+	}
+
+	for _, n := range nonces {
+		if !st.remove(n.Token, func(Nonce) bool { return true }) {
+			t.Fatalf("Expected to remove %v", n.ID)
+		}
+	}
+	if len(st.index.byID) != 0 || len(st.index.byUser) != 0 {
+		t.Fatalf("Expected removed nonces to leave the index. Instead got: %d ids, %d users", len(st.index.byID), len(st.index.byUser))
+	}
+}
+
+func TestInMemPurgeSkipsRenewed(t *testing.T) {
+	clock := &testClock{}
+	// build the service by hand so no background sweeper races the test
+	s := &nonceInMemoryService{
+		store:   newInMemStore(),
+		cfg:     newConfig([]Option{WithClock(clock)}),
+		waiters: newConsumeWaiters(),
+	}
+
+	expiring, err := s.New(tNonce.Action, uuid.NewV4(), time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	renewed, err := s.New(tNonce.Action, uuid.NewV4(), time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	_, err = s.Renew(renewed.Token, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to renew nonce. Instead got the error: %v", err)
+	}
+
+	clock.Add(2 * time.Minute)
+	purged, err := s.PurgeExpired(context.Background(), 0)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected 1 nonce to be purged. Instead got: %d, %v", purged, err)
+	}
+	if _, ok := s.store.get(expiring.Token); ok {
+		t.Fatalf("Expected the expired nonce to be purged.")
+	}
+	if _, ok := s.store.get(renewed.Token); !ok {
+		t.Fatalf("Expected the renewed nonce to be kept.")
+	}
+	if len(s.store.expiry.entries) != 1 {
+		t.Fatalf("Expected only the renewed nonce's entry to be left. Instead got: %d", len(s.store.expiry.entries))
+	}
+}
+
+func TestInMemMaxEntries(t *testing.T) {
+	s := NewInMemoryService(WithMaxEntries(3))
+	defer s.Shutdown()
+	stats := s.(StatsReporter)
+
+	// the soonest to expire goes first, even after a longer lived nonce was stored
+	var nonces []Nonce
+	for _, d := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour} {
+		n, err := s.New(tNonce.Action, uuid.NewV4(), d)
+		if err != nil {
+			t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+		}
+		nonces = append(nonces, n)
+	}
+	if st, err := stats.Stats(context.Background()); err != nil || st.Entries != 3 || st.MaxEntries != 3 || st.Evicted != 0 {
+		t.Fatalf("Expected 3 entries and none evicted. Instead got: %+v", st)
+	}
+	_, err := s.Renew(nonces[1].Token, 4*time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to renew nonce. Instead got the error: %v", err)
+	}
+
+	// the new nonce expires soonest but is kept; the renewed one no longer expires soonest
+	n, err := s.New(tNonce.Action, uuid.NewV4(), time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	if st, err := stats.Stats(context.Background()); err != nil || st.Entries != 3 || st.Evicted != 1 {
+		t.Fatalf("Expected 3 entries and 1 evicted. Instead got: %+v", st)
+	}
+	in := s.(Inspector)
+	_, err = in.GetByToken(nonces[2].Token)
+	if err != ErrTokenNotFound {
+		t.Fatalf("Expected the soonest expiring nonce to be evicted. Instead got: %v", err)
+	}
+	for _, kept := range []Nonce{nonces[0], nonces[1], n} {
+		_, err = in.GetByToken(kept.Token)
+		if err != nil {
+			t.Fatalf("Expected %v to be kept. Instead got: %v", kept.ID, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Snapshotter is implemented by Services that keep their nonces in process
+// memory, so they can be saved on shutdown and loaded into the next process
+type Snapshotter interface {
+	// Snapshot writes every stored nonce to w as JSON with its Salt, one per
+	// line. The Salt is encrypted when the Service was created WithEncryption.
+	Snapshot(w io.Writer) error
+
+	// Restore stores the nonces in a Snapshot read from r, replacing any with
+	// the same token. Nothing is stored if any line can't be decoded.
+	Restore(r io.Reader) error
+}
+
+func (s *nonceInMemoryService) Snapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	err := s.store.scan(context.Background(), s.cfg.listChunkSize, func(n Nonce) error {
+		n, err := s.cfg.seal(n)
+		if err != nil {
+			return err
+		}
+		b, err := n.JSONWithSalt()
+		if err != nil {
+			return err
+		}
+		bw.Write(b)
+		return bw.WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (s *nonceInMemoryService) Restore(r io.Reader) error {
+	var nonces []Nonce
+	dec := json.NewDecoder(r)
+	for {
+		var n Nonce
+		err := dec.Decode(&n)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if n.Token == "" || n.ID == uuid.Nil {
+			return fmt.Errorf("nonce: snapshot entry %d has no token or id", len(nonces)+1)
+		}
+		nonces = append(nonces, s.cfg.open(n))
+	}
+
+	for _, n := range nonces {
+		s.store.put(n)
+	}
+	return nil
+}
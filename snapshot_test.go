@@ -0,0 +1,96 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	s := NewInMemoryService()
+	defer s.Shutdown()
+
+	uid := uuid.NewV4()
+	used, err := s.New("used", uid, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	used, err = s.Consume(used.Token)
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+	valid, err := s.New("valid", uid, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = s.(Snapshotter).Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("Expected Snapshot to succeed. Instead got: %v", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Fatalf("Expected one line per nonce. Instead got %d: %s", lines, buf.String())
+	}
+
+	restored := NewInMemoryService()
+	defer restored.Shutdown()
+	err = restored.(Snapshotter).Restore(&buf)
+	if err != nil {
+		t.Fatalf("Expected Restore to succeed. Instead got: %v", err)
+	}
+	for _, want := range []Nonce{used, valid} {
+		got, err := restored.(Inspector).GetByToken(want.Token)
+		if err != nil {
+			t.Fatalf("Expected the restored nonce. Instead got: %v", err)
+		}
+		// JSON times come back in UTC
+		want.ExpiresAt = want.ExpiresAt.UTC()
+		if got != want {
+			t.Fatalf("Expected the nonce to be restored exactly.\nGot:  %+v\nWant: %+v", got, want)
+		}
+	}
+	_, err = restored.CheckThenConsume(used.Token, "used", uid)
+	if !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("Expected the restored nonce to stay used. Instead got: %v", err)
+	}
+	_, err = restored.Get("valid", uid)
+	if err != nil {
+		t.Fatalf("Expected the restored nonce to be found for its user. Instead got: %v", err)
+	}
+	_, err = restored.CheckThenConsume(valid.Token, "valid", uid)
+	if err != nil {
+		t.Fatalf("Expected the restored nonce to be usable. Instead got: %v", err)
+	}
+
+	// a snapshot that doesn't decode restores nothing
+	empty := NewInMemoryService()
+	defer empty.Shutdown()
+	b, _ := valid.JSONWithSalt()
+	err = empty.(Snapshotter).Restore(strings.NewReader(string(b) + "\n{not json"))
+	if err == nil {
+		t.Fatalf("Expected Restore to fail on a corrupt snapshot")
+	}
+	_, err = empty.(Inspector).GetByToken(valid.Token)
+	if err != ErrTokenNotFound {
+		t.Fatalf("Expected nothing to be restored. Instead got: %v", err)
+	}
+}
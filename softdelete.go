@@ -0,0 +1,33 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"time"
+)
+
+// Tombstoner is implemented by Services that can keep expired nonces as
+// tombstones
+type Tombstoner interface {
+	// PurgeDeleted deletes the nonces tombstoned before t in every
+	// WithNamespace namespace sharing the store, in batches of
+	// PurgeBatchSize. It returns how many were deleted.
+	PurgeDeleted(ctx context.Context, before time.Time) (int64, error)
+
+	// Undelete restores the nonces tombstoned at or after since, returning how
+	// many. They are still expired; revive them with ExtendExpiry.
+	Undelete(ctx context.Context, since time.Time) (int64, error)
+}
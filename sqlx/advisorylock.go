@@ -0,0 +1,92 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+// advisoryLock serialises consumption of each nonce across every process
+// that shares the same Postgres database
+type advisoryLock struct {
+	db      *sqlx.DB
+	inspect nonce.Inspector
+}
+
+// NewAdvisoryLockService wraps s so every call that consumes a nonce runs while
+// holding a Postgres transaction-level advisory lock keyed by a hash of the
+// nonce's ID. Tokens are looked up with GetByToken first, so Consume,
+// ConsumeByID and every other form of the same token queue on the same lock.
+// Racing app instances queue on the lock instead of all acting on a stale cached
+// read, so the side effect guarded by a consume runs exactly once.
+// It returns ErrNotInspector if s isn't an Inspector. db must be a Postgres
+// database; it only holds the locks and need not store the nonces.
+func NewAdvisoryLockService(s nonce.Service, db *sqlx.DB) (nonce.Service, error) {
+	inspect, ok := s.(nonce.Inspector)
+	if !ok {
+		return nil, nonce.ErrNotInspector
+	}
+	l := &advisoryLock{db: db, inspect: inspect}
+	return nonce.Decorate(s, l.interceptor()), nil
+}
+
+func (l *advisoryLock) interceptor() nonce.Interceptor {
+	return func(c nonce.Call, next func() error) error {
+		switch c.Method {
+		case "Consume", "CheckThenConsume", "ConsumeWithMeta", "CheckThenConsumeWithMeta", "ConsumeAndChain":
+			n, err := l.inspect.GetByToken(c.Args[0].(string))
+			if err != nil {
+				// the call fails the same way, without anything to lock
+				return next()
+			}
+			return l.withLock(n.ID, next)
+		case "ConsumeByID":
+			return l.withLock(c.Args[0].(uuid.UUID), next)
+		}
+		return next()
+	}
+}
+
+// withLock runs fn while holding the advisory lock for id.
+// The lock is released when the transaction ends.
+func (l *advisoryLock) withLock(id uuid.UUID, fn func() error) error {
+	tx, err := l.db.Beginx()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", advisoryKey(id.String()))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = fn()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// advisoryKey maps a nonce id or other name onto Postgres' 64 bit advisory lock key space
+func advisoryKey(key string) int64 {
+	sum := sha256.Sum256([]byte(key))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
@@ -0,0 +1,125 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// takenLocks returns the advisory lock keys taken since the last call
+func takenLocks() []int64 {
+	advisoryLocks.Lock()
+	defer advisoryLocks.Unlock()
+	taken := advisoryLocks.taken
+	advisoryLocks.taken = nil
+	return taken
+}
+
+func TestAdvisoryLockService(t *testing.T) {
+	db := newAdvisoryLockDB(t, "postgres")
+	defer db.Close()
+	s, err := NewAdvisoryLockService(nonce.NewInMemoryService(), db)
+	if err != nil {
+		t.Fatalf("Expected to create the advisory lock Service. Instead got the error: %v", err)
+	}
+	defer s.Shutdown()
+	takenLocks()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	if taken := takenLocks(); len(taken) != 0 {
+		t.Errorf("Expected New not to lock. Instead got: %v", taken)
+	}
+
+	// a quoting mail client's prefix is dropped when the token is normalized
+	_, err = s.Consume("> " + n.Token)
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+	_, err = s.ConsumeByID(n.ID, tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, nonce.ErrTokenUsed) {
+		t.Errorf("Expected %v. Instead got: %v", nonce.ErrTokenUsed, err)
+	}
+	_, err = s.CheckThenConsume(strings.TrimRight(n.Token, "="), tNonce.Action, tNonce.UserID)
+	if !errors.Is(err, nonce.ErrTokenUsed) {
+		t.Errorf("Expected %v. Instead got: %v", nonce.ErrTokenUsed, err)
+	}
+	want := advisoryKey(n.ID.String())
+	taken := takenLocks()
+	if len(taken) != 3 || taken[0] != want || taken[1] != want || taken[2] != want {
+		t.Errorf("Expected every consume to lock %d. Instead got: %v", want, taken)
+	}
+
+	// optional interfaces are forwarded
+	l, ok := s.(nonce.Lister)
+	if !ok {
+		t.Fatal("Expected the Service to be a Lister")
+	}
+	count := 0
+	err = l.List(context.Background(), nonce.Filter{}, func(nonce.Nonce) error {
+		count++
+		return nil
+	})
+	if err != nil || count != 1 {
+		t.Errorf("Expected to list 1 nonce. Instead got: %d, %v", count, err)
+	}
+}
+
+func TestAdvisoryLockServiceUnsupported(t *testing.T) {
+	bare := struct{ nonce.Service }{nonce.NewInMemoryService()}
+	defer bare.Shutdown()
+
+	_, err := NewAdvisoryLockService(bare, nil)
+	if err != nonce.ErrNotInspector {
+		t.Errorf("Expected %v for a Service without GetByToken. Instead got: %v", nonce.ErrNotInspector, err)
+	}
+}
+
+func TestConsumeIsConditional(t *testing.T) {
+	db := newPreparedTestDB(t)
+	defer db.Close()
+	s := NewService(db).(*service)
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	// another caller consumes it after this one has read it
+	st, err := s.stmt(sqlConsume)
+	if err != nil {
+		t.Fatalf("Expected to prepare sqlConsume. Instead got the error: %v", err)
+	}
+	res, err := st.Exec(int64(1), "", "", n.Token, "")
+	if err != nil {
+		t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+	}
+	res, err = st.Exec(int64(2), "", "", n.Token, "")
+	if err != nil {
+		t.Fatalf("Expected the second update to run. Instead got the error: %v", err)
+	}
+	rows, _ := res.RowsAffected()
+	if rows != 0 {
+		t.Errorf("Expected a used nonce not to be consumed again. Instead got: %d rows", rows)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+func (s *service) NewBatch(ctx context.Context, requests []nonce.NewRequest) ([]nonce.Nonce, error) {
+	nonces, newest, err := s.cfg.NewBatch(requests)
+	if err != nil {
+		return nil, err
+	}
+
+	insert, err := s.namedStmt(sqlInsertNonce)
+	if err != nil {
+		return nil, err
+	}
+	invalidate, err := s.namedStmt(sqlInvalidateOthers)
+	if err != nil {
+		return nil, err
+	}
+
+	// insert the nonces and invalidate the ones they replace in a single transaction
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	insert, invalidate = tx.NamedStmt(insert), tx.NamedStmt(invalidate)
+	for i := range nonces {
+		err = ctx.Err()
+		if err != nil {
+			s.rollback(tx)
+			return nil, err
+		}
+		sealed, err := s.cfg.Seal(nonces[i])
+		if err != nil {
+			s.rollback(tx)
+			return nil, err
+		}
+		_, err = insert.Exec(&sealed)
+		if err != nil {
+			s.rollback(tx)
+			return nil, err
+		}
+	}
+	var others []nonce.Nonce
+	for _, n := range newest {
+		o, err := s.invalidating(tx, n)
+		if err != nil {
+			s.rollback(tx)
+			return nil, err
+		}
+		others = append(others, o...)
+		_, err = invalidate.Exec(&n)
+		if err != nil {
+			s.rollback(tx)
+			return nil, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	t := s.cfg.Clock().Now()
+	for _, n := range nonces {
+		s.cfg.Remember(n, t)
+	}
+	for _, n := range newest {
+		s.cfg.RememberInvalidated(n)
+	}
+	s.cfg.Created(nonces...)
+	s.cfg.Invalidated(others...)
+	return s.cfg.IssueAll(nonces), nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+// errNotConsumed rolls back a ConsumeAndChain whose nonce was consumed by another caller
+var errNotConsumed = errors.New("nonce not consumed")
+
+func (s *service) ConsumeAndChain(token, action string, uid uuid.UUID, nextAction string, expiresIn time.Duration) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	t := s.cfg.Clock().Now()
+	next, err := s.cfg.NewNonce(nextAction, uid, expiresIn, t)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	// check first to learn the nonce's own action, which may be a scope holding action
+	stored, err := s.getNonce(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckNonce(stored, action, uid, t)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	st, err := s.stmt(sqlCheckThenConsume)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// consume the nonce in the transaction that creates its successor
+	var parent nonce.Nonce
+	others, err := s.create(&next, func(tx *sqlx.Tx) error {
+		res, err := tx.Stmtx(st).Exec(t.Unix(), "", "", token, stored.Action, uid, s.cfg.ExpiryCutoff(t), "", s.cfg.Namespace())
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return errNotConsumed
+		}
+		err = tx.Get(&parent, s.sql.q(sqlSelectByToken), token, s.cfg.Namespace())
+		parent = s.cfg.Open(parent)
+		next.ParentID = parent.ID
+		return err
+	})
+	if err == errNotConsumed {
+		// another caller consumed it after our check
+		return nonce.Nonce{}, nonce.NewTokenError(nonce.ErrTokenUsed, stored, t)
+	}
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	s.cfg.Remember(parent, t)
+	s.cfg.Consumed(parent)
+	s.cfg.Remember(next, t)
+	s.cfg.RememberInvalidated(next)
+	s.cfg.Created(next)
+	s.cfg.Invalidated(others...)
+	return s.cfg.Issue(next), nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// WithSingletonCleanup makes the sqlx backend take a database lock before each
+// pass removing expired nonces, so when several instances share a database only
+// one of them deletes at a time instead of all issuing the same DELETE, which
+// can deadlock on MySQL. An instance that doesn't get the lock skips the pass;
+// it counts as a successful pass with nothing purged, so Health doesn't report
+// ErrSweepStalled while another instance is sweeping. The lock is a session
+// advisory lock on Postgres and GET_LOCK on MySQL, named after the table, and
+// holds a connection for the pass, so the pool needs at least two. MySQL's
+// locks are server-wide, so Services sharing a table name in different
+// databases on one server also take turns.
+// Other databases sweep without a lock. Other backends ignore it.
+func WithSingletonCleanup() nonce.Option {
+	return nonce.BackendOption(optSingletonCleanup, true)
+}
+
+// cleanupLock holds the statements that take and release the cleanup lock
+type cleanupLock struct {
+	lock, unlock string
+	key          interface{}
+}
+
+// cleanupLock returns the cleanup lock for the Service's database, reporting
+// false if it doesn't have one
+func (s *service) cleanupLock() (cleanupLock, bool) {
+	switch s.db.DriverName() {
+	case "postgres", "pgx":
+		return cleanupLock{
+			lock:   "SELECT pg_try_advisory_lock($1)",
+			unlock: "SELECT pg_advisory_unlock($1)",
+			key:    advisoryKey("nonce cleanup " + s.sql.table),
+		}, true
+	case "mysql":
+		return cleanupLock{
+			lock:   "SELECT GET_LOCK(?, 0)",
+			unlock: "SELECT RELEASE_LOCK(?)",
+			key:    mysqlLockName("nonce_cleanup_" + s.sql.table),
+		}, true
+	}
+	return cleanupLock{}, false
+}
+
+// mysqlLockName returns name, or a digest of it when it is longer than the
+// 64 characters GET_LOCK accepts
+func mysqlLockName(name string) string {
+	if len(name) <= 64 {
+		return name
+	}
+	sum := sha1.Sum([]byte(name))
+	return "nonce_cleanup_" + hex.EncodeToString(sum[:])
+}
+
+// purgeAsLeader runs PurgeExpired while holding the cleanup lock, doing
+// nothing if another instance holds it
+func (s *service) purgeAsLeader(ctx context.Context, limit int) (int64, error) {
+	l, ok := s.cleanupLock()
+	if !ok {
+		return s.PurgeExpired(ctx, limit)
+	}
+
+	// the lock belongs to the session, so take and release it on one connection
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var locked sql.NullBool
+	err = conn.QueryRowContext(ctx, l.lock, l.key).Scan(&locked)
+	if err != nil {
+		return 0, err
+	}
+	if !locked.Bool {
+		return 0, nil
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.Background(), l.unlock, l.key)
+		if err != nil {
+			s.cfg.Logger().Printf("nonce: error releasing cleanup lock: %v", err)
+			// close the session rather than return it to the pool holding the lock
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+
+	return s.PurgeExpired(ctx, limit)
+}
@@ -0,0 +1,181 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// advisoryLocks stands in for Postgres' advisory locks and MySQL's named
+// locks in sqlite3_advisory
+var advisoryLocks = struct {
+	sync.Mutex
+	held map[interface{}]bool
+	// taken records the keys pg_advisory_xact_lock was called with
+	taken []int64
+}{held: make(map[interface{}]bool)}
+
+var registerSqliteAdvisory sync.Once
+
+// newAdvisoryLockDB returns a migrated sqlite database the Service takes for
+// driver, with pg_try_advisory_lock, pg_advisory_unlock, pg_advisory_xact_lock,
+// GET_LOCK and RELEASE_LOCK backed by advisoryLocks
+func newAdvisoryLockDB(t *testing.T, driver string) *sqlx.DB {
+	registerSqliteAdvisory.Do(func() {
+		sql.Register("sqlite3_advisory", &sqlite3.SQLiteDriver{
+			ConnectHook: func(c *sqlite3.SQLiteConn) error {
+				err := c.RegisterFunc("pg_try_advisory_lock", func(key int64) bool {
+					advisoryLocks.Lock()
+					defer advisoryLocks.Unlock()
+					if advisoryLocks.held[key] {
+						return false
+					}
+					advisoryLocks.held[key] = true
+					return true
+				}, false)
+				if err != nil {
+					return err
+				}
+				err = c.RegisterFunc("pg_advisory_unlock", func(key int64) bool {
+					advisoryLocks.Lock()
+					defer advisoryLocks.Unlock()
+					held := advisoryLocks.held[key]
+					delete(advisoryLocks.held, key)
+					return held
+				}, false)
+				if err != nil {
+					return err
+				}
+				err = c.RegisterFunc("pg_advisory_xact_lock", func(key int64) int64 {
+					advisoryLocks.Lock()
+					defer advisoryLocks.Unlock()
+					advisoryLocks.taken = append(advisoryLocks.taken, key)
+					return 0
+				}, false)
+				if err != nil {
+					return err
+				}
+				err = c.RegisterFunc("GET_LOCK", func(name string, timeout int64) int64 {
+					advisoryLocks.Lock()
+					defer advisoryLocks.Unlock()
+					if advisoryLocks.held[name] {
+						return 0
+					}
+					advisoryLocks.held[name] = true
+					return 1
+				}, false)
+				if err != nil {
+					return err
+				}
+				return c.RegisterFunc("RELEASE_LOCK", func(name string) int64 {
+					advisoryLocks.Lock()
+					defer advisoryLocks.Unlock()
+					if !advisoryLocks.held[name] {
+						return 0
+					}
+					delete(advisoryLocks.held, name)
+					return 1
+				}, false)
+			},
+		})
+	})
+	sqlDB, err := sql.Open("sqlite3_advisory", filepath.Join(t.TempDir(), "nonce.sdb"))
+	if err != nil {
+		t.Fatalf("Expected to open sqlite3_advisory. Instead got: %v", err)
+	}
+	err = Migrate(context.Background(), sqlx.NewDb(sqlDB, "sqlite3"))
+	if err != nil {
+		t.Fatalf("Expected Migrate to succeed. Instead got: %v", err)
+	}
+	return sqlx.NewDb(sqlDB, driver)
+}
+
+func TestSingletonCleanup(t *testing.T) {
+	interval := nonce.RemoveExpiredInterval
+	nonce.RemoveExpiredInterval = time.Hour
+	defer func() { nonce.RemoveExpiredInterval = interval }()
+
+	for _, driver := range []string{"postgres", "mysql"} {
+		t.Run(driver, func(t *testing.T) {
+			testSingletonCleanup(t, driver)
+		})
+	}
+}
+
+func testSingletonCleanup(t *testing.T, driver string) {
+	db := newAdvisoryLockDB(t, driver)
+	defer db.Close()
+	clock := &testClock{}
+	s := NewService(db, nonce.WithClock(clock), WithSingletonCleanup()).(*service)
+	defer s.Shutdown()
+
+	waitForFirstSweep(t, s)
+
+	_, err := s.New(tNonce.Action, tNonce.UserID, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	clock.Add(time.Hour)
+
+	// another instance is sweeping
+	l, ok := s.cleanupLock()
+	if !ok {
+		t.Fatalf("Expected %s to have a cleanup lock", driver)
+	}
+	key := l.key
+	advisoryLocks.Lock()
+	advisoryLocks.held[key] = true
+	advisoryLocks.Unlock()
+	purged, err := s.purgeAsLeader(context.Background(), 0)
+	if err != nil || purged != 0 {
+		t.Fatalf("Expected the pass to be skipped while the lock is held. Instead got: %d, %v", purged, err)
+	}
+
+	advisoryLocks.Lock()
+	delete(advisoryLocks.held, key)
+	advisoryLocks.Unlock()
+	purged, err = s.purgeAsLeader(context.Background(), 0)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected the expired nonce to be purged once the lock is free. Instead got: %d, %v", purged, err)
+	}
+	advisoryLocks.Lock()
+	held := advisoryLocks.held[key]
+	advisoryLocks.Unlock()
+	if held {
+		t.Fatal("Expected the cleanup lock to be released after the pass")
+	}
+}
+
+func TestMySQLLockName(t *testing.T) {
+	name := mysqlLockName("nonce_cleanup_nonce")
+	if name != "nonce_cleanup_nonce" {
+		t.Fatalf("Expected a short name to be kept. Instead got: %s", name)
+	}
+	long := "nonce_cleanup_" + strings.Repeat("t", 64)
+	name = mysqlLockName(long)
+	if len(name) > 64 || name != mysqlLockName(long) {
+		t.Fatalf("Expected a stable name of at most 64 characters. Instead got: %s", name)
+	}
+}
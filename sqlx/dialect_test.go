@@ -0,0 +1,195 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestStatementsDialectNeutral(t *testing.T) {
+	numbered := regexp.MustCompile(`\$[0-9]`)
+	boolInt := regexp.MustCompile(`(is_used|is_valid|is_completed)\s*=\s*[0-9]`)
+
+	outstanding, _ := where(nonce.Filter{Outstanding: true}, time.Now())
+	consumed, _ := where(nonce.Filter{Consumed: true}, time.Now())
+	stmts := append([]string{
+		outstanding, consumed, sqlStats,
+		sqlInsertKey, sqlSelectKey, sqlDeleteExpiredKey, sqlCompleteKey, sqlReleaseKey, sqlDeleteExpiredKeys,
+	}, sqlStatements...)
+	for _, stmt := range stmts {
+		// sqlx only rebinds ?, so a $N never reaches mysql or sqlite as a placeholder
+		if numbered.MatchString(stmt) {
+			t.Errorf("Expected statement to use ? placeholders. Instead got: %s", stmt)
+		}
+		// postgres has no cast from integer to its BOOLEAN columns
+		if boolInt.MatchString(stmt) {
+			t.Errorf("Expected statement to compare booleans with TRUE/FALSE. Instead got: %s", stmt)
+		}
+	}
+}
+
+func TestDialects(t *testing.T) {
+	// sqlite takes both ? and $N placeholders, so the same migrated database
+	// runs every statement as each dialect would bind it
+	db := newPreparedTestDB(t)
+	defer db.Close()
+	for _, driver := range []string{"sqlite3", "mysql", "postgres"} {
+		t.Run(driver, func(t *testing.T) {
+			testDialect(t, sqlx.NewDb(db.DB, driver))
+		})
+	}
+}
+
+func TestDialectsLive(t *testing.T) {
+	for _, live := range []struct{ driver, env string }{
+		{"postgres", "NONCE_TEST_POSTGRES_DSN"},
+		{"mysql", "NONCE_TEST_MYSQL_DSN"},
+	} {
+		t.Run(live.driver, func(t *testing.T) {
+			dsn := os.Getenv(live.env)
+			if dsn == "" {
+				t.Skipf("%s is not set", live.env)
+			}
+			db, err := sqlx.Connect(live.driver, dsn)
+			if err != nil {
+				t.Fatalf("Expected to connect to %s. Instead got: %v", live.driver, err)
+			}
+			defer db.Close()
+			err = Migrate(context.Background(), db)
+			if err != nil {
+				t.Fatalf("Expected Migrate to succeed on %s. Instead got: %v", live.driver, err)
+			}
+			testDialect(t, db)
+
+			s := NewService(db, WithSingletonCleanup()).(*service)
+			defer s.Shutdown()
+			_, err = s.purgeAsLeader(context.Background(), 0)
+			if err != nil {
+				t.Fatalf("Expected a locked purge to succeed on %s. Instead got: %v", live.driver, err)
+			}
+		})
+	}
+}
+
+func testDialect(t *testing.T, db *sqlx.DB) {
+	ctx := context.Background()
+	s := NewService(db)
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+
+	n, err := s.New("dialect", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	err = s.Check(n.Token, "dialect", uid)
+	if err != nil {
+		t.Fatalf("Expected Check to succeed. Instead got: %v", err)
+	}
+	got, err := s.Get("dialect", uid)
+	if err != nil || got.ID != n.ID {
+		t.Fatalf("Expected Get to return %v. Instead got: %v, %v", n.ID, got.ID, err)
+	}
+	_, err = s.Renew(n.Token, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected Renew to succeed. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume(n.Token, "dialect", uid)
+	if err != nil {
+		t.Fatalf("Expected CheckThenConsume to succeed. Instead got: %v", err)
+	}
+	_, err = s.Consume(n.Token)
+	if !errors.Is(err, nonce.ErrTokenUsed) {
+		t.Fatalf("Expected Consume of a used token to return ErrTokenUsed. Instead got: %v", err)
+	}
+
+	n, err = s.New("dialect", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	_, err = s.Consume(n.Token)
+	if err != nil {
+		t.Fatalf("Expected Consume to succeed. Instead got: %v", err)
+	}
+	n, err = s.New("dialect", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	_, err = s.ConsumeByID(n.ID, "dialect", uid)
+	if err != nil {
+		t.Fatalf("Expected ConsumeByID to succeed. Instead got: %v", err)
+	}
+	_, err = s.New("dialect", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+
+	var consumed, outstanding int
+	err = s.(nonce.Lister).List(ctx, nonce.Filter{UserID: uid, Consumed: true}, func(nonce.Nonce) error {
+		consumed++
+		return nil
+	})
+	if err != nil || consumed != 3 {
+		t.Fatalf("Expected List to find 3 consumed nonces. Instead got: %d, %v", consumed, err)
+	}
+	err = s.(nonce.Lister).List(ctx, nonce.Filter{UserID: uid, Outstanding: true}, func(nonce.Nonce) error {
+		outstanding++
+		return nil
+	})
+	if err != nil || outstanding != 1 {
+		t.Fatalf("Expected List to find 1 outstanding nonce. Instead got: %d, %v", outstanding, err)
+	}
+	_, err = s.(nonce.StatsReporter).Stats(ctx)
+	if err != nil {
+		t.Fatalf("Expected Stats to succeed. Instead got: %v", err)
+	}
+	_, err = s.(nonce.Purger).PurgeExpired(ctx, 0)
+	if err != nil {
+		t.Fatalf("Expected PurgeExpired to succeed. Instead got: %v", err)
+	}
+
+	is, err := nonce.NewIdempotencyService(s, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to create the IdempotencyService. Instead got the error: %v", err)
+	}
+	key := uid.String()
+	_, err = is.Begin(key, uid)
+	if err != nil {
+		t.Fatalf("Expected Begin to succeed. Instead got: %v", err)
+	}
+	err = is.Complete(key, uid, []byte("done"))
+	if err != nil {
+		t.Fatalf("Expected Complete to succeed. Instead got: %v", err)
+	}
+	rec, err := is.Begin(key, uid)
+	if err != nil || !rec.Completed || string(rec.Result) != "done" {
+		t.Fatalf("Expected Begin to replay the completed result. Instead got: %+v, %v", rec, err)
+	}
+
+	deleted, err := s.(nonce.UserDeleter).DeleteAllForUser(ctx, uid)
+	if err != nil || deleted != 4 {
+		t.Fatalf("Expected DeleteAllForUser to delete 4 nonces. Instead got: %d, %v", deleted, err)
+	}
+}
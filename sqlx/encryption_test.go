@@ -0,0 +1,148 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// sealedPrefix marks the fields WithEncryption stored encrypted
+const sealedPrefix = "enc1:"
+
+func TestEncryption(t *testing.T) {
+	db := newPreparedTestDB(t)
+	defer db.Close()
+	oldKey, newKey := [32]byte{1}, [32]byte{2}
+	s := NewService(db, nonce.WithEncryption(oldKey))
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+
+	n, err := s.New("login", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+	}
+	var stored string
+	err = db.Get(&stored, `SELECT salt FROM nonce WHERE id=?`, n.ID)
+	if err != nil {
+		t.Fatalf("Expected to read the stored salt. Instead got the error: %v", err)
+	}
+	if !strings.HasPrefix(stored, sealedPrefix) || strings.Contains(stored, n.Salt) {
+		t.Fatalf("Expected the salt to be stored encrypted. Instead got: %q", stored)
+	}
+	got, err := s.(nonce.Inspector).GetByID(n.ID)
+	if err != nil || got.Salt != n.Salt {
+		t.Fatalf("Expected GetByID to decrypt the salt to %q. Instead got: %q, %v", n.Salt, got.Salt, err)
+	}
+	err = s.Check(n.Token, "login", uid)
+	if err != nil {
+		t.Fatalf("Expected Check to succeed. Instead got: %v", err)
+	}
+
+	// a rotated Service still reads what the old key sealed
+	rotated := NewService(db, nonce.WithEncryption(newKey, oldKey))
+	defer rotated.Shutdown()
+	got, err = rotated.(nonce.Inspector).GetByToken(n.Token)
+	if err != nil || got.Salt != n.Salt {
+		t.Errorf("Expected the previous key to decrypt the salt. Instead got: %q, %v", got.Salt, err)
+	}
+	_, err = rotated.Consume(n.Token)
+	if err != nil {
+		t.Errorf("Expected Consume to succeed. Instead got: %v", err)
+	}
+
+	// without the old key the salt stays sealed but the nonce is still usable
+	n, err = s.New("verify", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+	}
+	other := NewService(db, nonce.WithEncryption(newKey))
+	defer other.Shutdown()
+	got, err = other.(nonce.Inspector).GetByID(n.ID)
+	if err != nil || !strings.HasPrefix(got.Salt, sealedPrefix) {
+		t.Errorf("Expected an unknown key to leave the salt encrypted. Instead got: %q, %v", got.Salt, err)
+	}
+	err = other.Check(n.Token, "verify", uid)
+	if err != nil {
+		t.Errorf("Expected Check to succeed. Instead got: %v", err)
+	}
+
+	// salts stored before encryption was enabled are read as they are
+	plain := NewService(db)
+	defer plain.Shutdown()
+	n, err = plain.New("reset", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+	}
+	got, err = rotated.(nonce.Inspector).GetByID(n.ID)
+	if err != nil || got.Salt != n.Salt {
+		t.Errorf("Expected the plaintext salt %q. Instead got: %q, %v", n.Salt, got.Salt, err)
+	}
+}
+
+func TestEncryptionFields(t *testing.T) {
+	db := newPreparedTestDB(t)
+	defer db.Close()
+	s := NewService(db, nonce.WithEncryption([32]byte{4}))
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+	meta := nonce.ConsumeMeta{IP: "203.0.113.7", UserAgent: "test-agent/1.0"}
+
+	for _, consume := range []func(n nonce.Nonce) (nonce.Nonce, error){
+		func(n nonce.Nonce) (nonce.Nonce, error) { return s.(nonce.MetaConsumer).ConsumeWithMeta(n.Token, meta) },
+		func(n nonce.Nonce) (nonce.Nonce, error) {
+			return s.(nonce.MetaConsumer).CheckThenConsumeWithMeta(n.Token, "change-email", uid, meta)
+		},
+	} {
+		n, err := s.(nonce.Payloader).NewWithPayload("change-email", uid, time.Minute, "new@example.com")
+		if err != nil {
+			t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+		}
+		got, err := consume(n)
+		if err != nil || got.Payload != "new@example.com" || got.ConsumedIP != meta.IP {
+			t.Fatalf("Expected to consume the nonce with its payload and meta. Instead got: %+v, %v", got, err)
+		}
+
+		var stored struct {
+			Payload   string
+			IP        string `db:"consumed_ip"`
+			UserAgent string `db:"consumed_user_agent"`
+		}
+		err = db.Get(&stored, `SELECT payload, consumed_ip, consumed_user_agent FROM nonce WHERE id=?`, n.ID)
+		if err != nil {
+			t.Fatalf("Expected to read the stored fields. Instead got the error: %v", err)
+		}
+		for name, v := range map[string]string{"payload": stored.Payload, "consumed_ip": stored.IP, "consumed_user_agent": stored.UserAgent} {
+			if !strings.HasPrefix(v, sealedPrefix) {
+				t.Errorf("Expected %s to be stored encrypted. Instead got: %q", name, v)
+			}
+		}
+		got, err = s.(nonce.Inspector).GetByID(n.ID)
+		if err != nil || got.Payload != "new@example.com" || got.ConsumedIP != meta.IP || got.ConsumedUserAgent != meta.UserAgent {
+			t.Fatalf("Expected GetByID to decrypt the fields. Instead got: %+v, %v", got, err)
+		}
+
+		// a field sealed for another column doesn't open in this one
+		db.MustExec(`UPDATE nonce SET consumed_ip = payload WHERE id=?`, n.ID)
+		got, _ = s.(nonce.Inspector).GetByID(n.ID)
+		if !strings.HasPrefix(got.ConsumedIP, sealedPrefix) {
+			t.Errorf("Expected a moved field to stay encrypted. Instead got: %q", got.ConsumedIP)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const sqlDeleteByUser = `DELETE FROM nonce WHERE user_id=? AND namespace=?`
+
+func (s *service) DeleteAllForUser(ctx context.Context, uid uuid.UUID) (int64, error) {
+	return s.cfg.DeleteAllForUser(uid, func() (int64, error) {
+		res, err := s.db.ExecContext(ctx, s.sql.q(sqlDeleteByUser), uid, s.cfg.Namespace())
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	})
+}
@@ -0,0 +1,42 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// sqlExtendExpiry only moves expires_at if nothing changed the nonce since it was listed
+const sqlExtendExpiry = `UPDATE nonce SET expires_at=?
+	WHERE id=? AND is_valid=TRUE AND is_used=FALSE AND expires_at=?`
+
+func (s *service) ExtendExpiry(filter nonce.Filter, by time.Duration) (int, error) {
+	return s.cfg.ExtendExpiry(s, filter, by, func(n nonce.Nonce, expiresAt time.Time) (bool, error) {
+		res, err := s.db.Exec(s.sql.q(sqlExtendExpiry), expiresAt, n.ID, n.ExpiresAt)
+		if err != nil {
+			return false, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil || rows == 0 {
+			return false, err
+		}
+
+		n.ExpiresAt = expiresAt
+		s.cfg.Remember(n, s.cfg.Clock().Now())
+		return true, nil
+	})
+}
@@ -0,0 +1,32 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"time"
+)
+
+func (s *service) Health(ctx context.Context) error {
+	err := s.db.PingContext(ctx)
+	if err != nil {
+		return err
+	}
+	return s.cfg.CheckSweeps()
+}
+
+func (s *service) LastSweep() time.Time {
+	return s.cfg.LastSweep()
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+func TestHealth(t *testing.T) {
+	interval, jitter := nonce.RemoveExpiredInterval, nonce.RemoveExpiredJitter
+	nonce.RemoveExpiredInterval, nonce.RemoveExpiredJitter = 10*time.Millisecond, 0
+	defer func() { nonce.RemoveExpiredInterval, nonce.RemoveExpiredJitter = interval, jitter }()
+	db := newPreparedTestDB(t)
+	s := NewService(db).(*service)
+	defer s.Shutdown()
+	waitForFirstSweep(t, s)
+
+	err := s.Health(context.Background())
+	if err != nil {
+		t.Fatalf("Expected a new Service to be healthy. Instead got: %v", err)
+	}
+	if time.Since(s.LastSweep()) > time.Minute {
+		t.Fatalf("Expected LastSweep to be recent. Instead got: %v", s.LastSweep())
+	}
+
+	// without the table every sweep fails
+	db.MustExec("DROP TABLE nonce;")
+	time.Sleep(time.Duration(nonce.SweepStallIntervals+1) * nonce.RemoveExpiredInterval)
+	err = s.Health(context.Background())
+	if err != nonce.ErrSweepStalled {
+		t.Fatalf("Expected ErrSweepStalled once the sweep is overdue. Instead got: %v", err)
+	}
+
+	db.Close()
+	err = s.Health(context.Background())
+	if err == nil || err == nonce.ErrSweepStalled {
+		t.Fatalf("Expected the ping error from a closed database. Instead got: %v", err)
+	}
+}
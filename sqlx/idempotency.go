@@ -0,0 +1,99 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// SQL statements used by the sqlx backend's IdempotencyStore. They always use
+// the nonce_idempotency table Migrate creates, whatever WithTableName says.
+const (
+	sqlInsertKey = `INSERT INTO nonce_idempotency
+		(namespace, user_id, idempotency_key, is_completed, result, created_at, expires_at)
+		VALUES (:namespace, :user_id, :idempotency_key, :is_completed, :result, :created_at, :expires_at)`
+	sqlSelectKey        = `SELECT * FROM nonce_idempotency WHERE namespace=? AND user_id=? AND idempotency_key=?`
+	sqlDeleteExpiredKey = `DELETE FROM nonce_idempotency
+		WHERE namespace=? AND user_id=? AND idempotency_key=? AND expires_at <= ?`
+	sqlCompleteKey = `UPDATE nonce_idempotency SET is_completed = TRUE, result = ?
+		WHERE namespace=? AND user_id=? AND idempotency_key=? AND is_completed = FALSE AND expires_at > ?`
+	sqlReleaseKey = `DELETE FROM nonce_idempotency
+		WHERE namespace=? AND user_id=? AND idempotency_key=? AND is_completed = FALSE`
+	sqlDeleteExpiredKeys = `DELETE FROM nonce_idempotency WHERE namespace=? AND expires_at <= ?`
+)
+
+func (s *service) ReserveKey(key string, uid uuid.UUID, ttl time.Duration) (nonce.IdempotencyRecord, bool, error) {
+	t := s.cfg.Clock().Now()
+	ns := s.cfg.Namespace()
+	r := s.cfg.NewIdempotencyRecord(key, uid, ttl, t)
+
+	// an expired record no longer holds the key
+	_, err := s.db.Exec(s.db.Rebind(sqlDeleteExpiredKey), ns, uid, key, t)
+	if err != nil {
+		return nonce.IdempotencyRecord{}, false, err
+	}
+	_, err = s.db.NamedExec(sqlInsertKey, &r)
+	if err == nil {
+		return r, true, nil
+	}
+
+	// the primary key stops a second insert, so look for the record that won
+	var stored nonce.IdempotencyRecord
+	if s.db.Get(&stored, s.db.Rebind(sqlSelectKey), ns, uid, key) != nil {
+		return nonce.IdempotencyRecord{}, false, err
+	}
+	return stored, false, nil
+}
+
+func (s *service) CompleteKey(key string, uid uuid.UUID, result []byte) error {
+	t := s.cfg.Clock().Now()
+	ns := s.cfg.Namespace()
+	res, err := s.db.Exec(s.db.Rebind(sqlCompleteKey), result, ns, uid, key, t)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil || rows > 0 {
+		return err
+	}
+
+	var stored nonce.IdempotencyRecord
+	if s.db.Get(&stored, s.db.Rebind(sqlSelectKey), ns, uid, key) != nil {
+		return nonce.ErrTokenNotFound
+	}
+	err = stored.Completable(t)
+	if err != nil {
+		return err
+	}
+	// it expired between the update and the select
+	return nonce.ErrTokenNotFound
+}
+
+func (s *service) ReleaseKey(key string, uid uuid.UUID) error {
+	_, err := s.db.Exec(s.db.Rebind(sqlReleaseKey), s.cfg.Namespace(), uid, key)
+	return err
+}
+
+func (s *service) PurgeExpiredKeys(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.db.Rebind(sqlDeleteExpiredKeys), s.cfg.Namespace(), s.cfg.Clock().Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
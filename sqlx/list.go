@@ -0,0 +1,95 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+// where renders f as a SQL condition using ? placeholders
+func where(f nonce.Filter, t time.Time) (string, []interface{}) {
+	conds := []string{"1=1"}
+	var args []interface{}
+	add := func(cond string, vals ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, vals...)
+	}
+
+	if ns, scoped := f.Namespace(); scoped {
+		add("namespace=?", ns)
+	}
+	if f.Action != "" {
+		add("action=?", f.Action)
+	}
+	if f.UserID != uuid.Nil {
+		add("user_id=?", f.UserID)
+	}
+	if f.Outstanding {
+		add("is_valid=TRUE AND is_used=FALSE AND expires_at > ?", t)
+	}
+	if f.Consumed {
+		add("is_used=TRUE")
+	}
+	if !f.ExpiredBefore.IsZero() {
+		add("expires_at < ?", f.ExpiredBefore)
+	}
+	if f.Deleted {
+		add("deleted_at > 0")
+	} else {
+		add("deleted_at = 0")
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func (s *service) List(ctx context.Context, f nonce.Filter, fn func(nonce.Nonce) error) error {
+	t := s.cfg.Clock().Now()
+	where, args := where(s.cfg.Scope(f), t)
+
+	// page by (created_at, id) so every chunk resumes where the last one stopped
+	query := s.sql.q(fmt.Sprintf(`SELECT * FROM nonce
+		WHERE %s AND (created_at > ? OR (created_at = ? AND id > ?))
+		ORDER BY created_at, id LIMIT ?`, where))
+	var lastCreated int64 = -1 << 63
+	lastID := uuid.Nil
+	for {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		var chunk []nonce.Nonce
+		err = s.db.Select(&chunk, query, append(args, lastCreated, lastCreated, lastID, s.cfg.ListChunkSize())...)
+		if err != nil {
+			return err
+		}
+		for _, n := range chunk {
+			err = fn(s.cfg.Open(n))
+			if err != nil {
+				return err
+			}
+		}
+		if len(chunk) < s.cfg.ListChunkSize() {
+			return nil
+		}
+		last := chunk[len(chunk)-1]
+		lastCreated, lastID = last.CreatedAt, last.ID
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrationFS holds one directory of numbered .sql files per dialect
+//
+//go:embed migrations
+var migrationFS embed.FS
+
+// migration is one numbered .sql file
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+const (
+	sqlCreateMigrations = `CREATE TABLE IF NOT EXISTS nonce_schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`
+	sqlSelectMigrations = `SELECT version FROM nonce_schema_migrations`
+	sqlInsertMigration  = `INSERT INTO nonce_schema_migrations (version) VALUES (?)`
+)
+
+// Migrate creates the nonce table in db, or upgrades it, to the schema the sqlx
+// backend expects, including the indexes CheckSchema looks for. Migrations that
+// have already run are recorded in nonce_schema_migrations and skipped, so it is
+// safe to call Migrate every time an application starts. It always uses the
+// default table and column names, whatever WithTableName and WithColumnNames say.
+// sqlite3, mysql and postgres databases are supported.
+func Migrate(ctx context.Context, db *sqlx.DB) error {
+	migrations, err := loadMigrations(db.DriverName())
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, sqlCreateMigrations)
+	if err != nil {
+		return err
+	}
+	var done []int
+	err = db.Select(&done, sqlSelectMigrations)
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool, len(done))
+	for _, v := range done {
+		applied[v] = true
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		err = runMigration(ctx, db, m)
+		if err != nil {
+			return fmt.Errorf("nonce: migration %s: %v", m.name, err)
+		}
+	}
+	return nil
+}
+
+// runMigration runs every statement in m and records it, all in one transaction.
+// mysql commits DDL implicitly, so there a failed migration may be left half done.
+func runMigration(ctx context.Context, db *sqlx.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range splitStatements(m.sql) {
+		_, err = tx.ExecContext(ctx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, db.Rebind(sqlInsertMigration), m.version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadMigrations returns the migrations for driver ordered by version
+func loadMigrations(driver string) ([]migration, error) {
+	var dir string
+	switch driver {
+	case "sqlite3", "mysql":
+		dir = driver
+	case "postgres", "pgx":
+		dir = "postgres"
+	default:
+		return nil, fmt.Errorf("nonce: migrate does not support driver %q", driver)
+	}
+	dir = path.Join("migrations", dir)
+
+	entries, err := migrationFS.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		v, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("nonce: migration %s has no version prefix", e.Name())
+		}
+		b, err := migrationFS.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: v, name: e.Name(), sql: string(b)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// splitStatements splits sql on the semicolons that end a line.
+// Migrations don't put semicolons inside string literals or at the end of comments.
+func splitStatements(sql string) []string {
+	var stmts []string
+	var b strings.Builder
+	for _, line := range strings.Split(sql, "\n") {
+		b.WriteString(line)
+		b.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			stmts = appendStatement(stmts, b.String())
+			b.Reset()
+		}
+	}
+	return appendStatement(stmts, b.String())
+}
+
+func appendStatement(stmts []string, s string) []string {
+	s = strings.TrimSuffix(strings.TrimSpace(s), ";")
+	if s == "" {
+		return stmts
+	}
+	return append(stmts, s)
+}
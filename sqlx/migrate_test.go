@@ -0,0 +1,84 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestMigrate(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", ":memory:")
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// running it again must be a no-op
+	for i := 0; i < 2; i++ {
+		err := Migrate(context.Background(), db)
+		if err != nil {
+			t.Fatalf("Expected Migrate to succeed on run %d. Instead got: %v", i+1, err)
+		}
+	}
+
+	var versions []int
+	db.Select(&versions, "SELECT version FROM nonce_schema_migrations ORDER BY version")
+	if len(versions) != 11 || versions[0] != 1 || versions[10] != 11 {
+		t.Fatalf("Expected versions [1 2 3 4 5 6 7 8 9 10 11] to be recorded. Instead got: %v", versions)
+	}
+
+	drift, err := CheckSchema(db)
+	if err != nil {
+		t.Fatalf("Expected CheckSchema to succeed. Instead got: %v", err)
+	}
+	if len(drift) != 0 {
+		t.Fatalf("Expected no drift after Migrate. Instead got: %v", drift)
+	}
+
+	s := NewService(db)
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+	n, err := s.New("migrate", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed on a migrated table. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume(n.Token, "migrate", uid)
+	if err != nil {
+		t.Fatalf("Expected CheckThenConsume to succeed on a migrated table. Instead got: %v", err)
+	}
+}
+
+func TestMigrateUnknownDriver(t *testing.T) {
+	_, err := loadMigrations("oracle")
+	if err == nil {
+		t.Fatalf("Expected an error for an unsupported driver. Instead got: %v", err)
+	}
+	for _, d := range []string{"sqlite3", "mysql", "postgres"} {
+		m, err := loadMigrations(d)
+		if err != nil || len(m) != 11 {
+			t.Fatalf("Expected 11 migrations for %s. Instead got: %d, %v", d, len(m), err)
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements("-- comment\nCREATE TABLE a (x INT);\nCREATE INDEX b\n  ON a (x);\n\n")
+	if len(stmts) != 2 || stmts[1] != "CREATE INDEX b\n  ON a (x)" {
+		t.Fatalf("Expected 2 statements. Instead got: %q", stmts)
+	}
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS nonce (
+  id CHAR(36) NOT NULL PRIMARY KEY,
+  user_id CHAR(36) NOT NULL,
+  token VARCHAR(88) NOT NULL,
+  action VARCHAR(255) NOT NULL DEFAULT '',
+  salt VARCHAR(64) NOT NULL,
+  is_used TINYINT(1) NOT NULL DEFAULT 0,
+  is_valid TINYINT(1) NOT NULL DEFAULT 1,
+  created_at BIGINT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  UNIQUE INDEX nonce_token (token),
+  INDEX nonce_user_action (user_id, action, is_valid),
+  INDEX nonce_expires_at (expires_at)
+);
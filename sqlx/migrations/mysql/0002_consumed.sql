@@ -0,0 +1,4 @@
+ALTER TABLE nonce
+  ADD COLUMN consumed_at BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN consumed_ip VARCHAR(512) NOT NULL DEFAULT '',
+  ADD COLUMN consumed_user_agent VARCHAR(512) NOT NULL DEFAULT '';
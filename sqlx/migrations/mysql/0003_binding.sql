@@ -0,0 +1,2 @@
+ALTER TABLE nonce
+  ADD COLUMN binding VARCHAR(255) NOT NULL DEFAULT '';
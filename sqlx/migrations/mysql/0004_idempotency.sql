@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS nonce_idempotency (
+  user_id CHAR(36) NOT NULL,
+  idempotency_key VARCHAR(255) NOT NULL,
+  is_completed TINYINT(1) NOT NULL DEFAULT 0,
+  result MEDIUMBLOB,
+  created_at BIGINT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, idempotency_key),
+  INDEX nonce_idempotency_expires_at (expires_at)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE nonce
+  ADD COLUMN parent_id CHAR(36) NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
@@ -0,0 +1,3 @@
+ALTER TABLE nonce
+  ADD COLUMN namespace VARCHAR(255) NOT NULL DEFAULT '',
+  ADD INDEX nonce_namespace_user_action (namespace, user_id, action, is_valid);
@@ -0,0 +1 @@
+ALTER TABLE nonce MODIFY salt VARCHAR(255) NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE nonce MODIFY token VARCHAR(128) NOT NULL;
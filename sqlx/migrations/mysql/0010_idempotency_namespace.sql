@@ -0,0 +1,4 @@
+ALTER TABLE nonce_idempotency
+  ADD COLUMN namespace VARCHAR(255) NOT NULL DEFAULT '' FIRST,
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (namespace, user_id, idempotency_key);
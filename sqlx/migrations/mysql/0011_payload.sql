@@ -0,0 +1,5 @@
+-- TEXT columns can't have a default, so every INSERT names payload
+ALTER TABLE nonce
+  ADD COLUMN payload TEXT NOT NULL,
+  MODIFY consumed_ip VARCHAR(1024) NOT NULL DEFAULT '',
+  MODIFY consumed_user_agent VARCHAR(1024) NOT NULL DEFAULT '';
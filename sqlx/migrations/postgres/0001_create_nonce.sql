@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS nonce (
+  id UUID NOT NULL PRIMARY KEY,
+  user_id UUID NOT NULL,
+  token VARCHAR(88) NOT NULL,
+  action VARCHAR(255) NOT NULL DEFAULT '',
+  salt VARCHAR(64) NOT NULL,
+  is_used BOOLEAN NOT NULL DEFAULT FALSE,
+  is_valid BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at BIGINT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS nonce_token ON nonce (token);
+CREATE INDEX IF NOT EXISTS nonce_user_action ON nonce (user_id, action, is_valid);
+CREATE INDEX IF NOT EXISTS nonce_expires_at ON nonce (expires_at);
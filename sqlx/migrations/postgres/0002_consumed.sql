@@ -0,0 +1,4 @@
+ALTER TABLE nonce
+  ADD COLUMN IF NOT EXISTS consumed_at BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS consumed_ip VARCHAR(512) NOT NULL DEFAULT '',
+  ADD COLUMN IF NOT EXISTS consumed_user_agent VARCHAR(512) NOT NULL DEFAULT '';
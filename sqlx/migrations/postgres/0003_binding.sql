@@ -0,0 +1,2 @@
+ALTER TABLE nonce
+  ADD COLUMN IF NOT EXISTS binding VARCHAR(255) NOT NULL DEFAULT '';
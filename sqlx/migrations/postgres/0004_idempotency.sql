@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS nonce_idempotency (
+  user_id UUID NOT NULL,
+  idempotency_key VARCHAR(255) NOT NULL,
+  is_completed BOOLEAN NOT NULL DEFAULT FALSE,
+  result BYTEA,
+  created_at BIGINT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (user_id, idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS nonce_idempotency_expires_at ON nonce_idempotency (expires_at);
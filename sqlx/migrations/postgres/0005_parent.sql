@@ -0,0 +1,2 @@
+ALTER TABLE nonce
+  ADD COLUMN IF NOT EXISTS parent_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
@@ -0,0 +1,3 @@
+ALTER TABLE nonce
+  ADD COLUMN IF NOT EXISTS namespace VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS nonce_namespace_user_action ON nonce (namespace, user_id, action, is_valid);
@@ -0,0 +1 @@
+ALTER TABLE nonce ALTER COLUMN salt TYPE VARCHAR(255);
@@ -0,0 +1 @@
+ALTER TABLE nonce ALTER COLUMN token TYPE VARCHAR(128);
@@ -0,0 +1 @@
+ALTER TABLE nonce ADD COLUMN IF NOT EXISTS deleted_at BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+ALTER TABLE nonce_idempotency
+  ADD COLUMN IF NOT EXISTS namespace VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE nonce_idempotency DROP CONSTRAINT IF EXISTS nonce_idempotency_pkey;
+ALTER TABLE nonce_idempotency ADD PRIMARY KEY (namespace, user_id, idempotency_key);
@@ -0,0 +1,4 @@
+ALTER TABLE nonce
+  ADD COLUMN IF NOT EXISTS payload TEXT NOT NULL DEFAULT '',
+  ALTER COLUMN consumed_ip TYPE VARCHAR(1024),
+  ALTER COLUMN consumed_user_agent TYPE VARCHAR(1024);
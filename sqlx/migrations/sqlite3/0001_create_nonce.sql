@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS nonce (
+  id BINARY(16) NOT NULL PRIMARY KEY,
+  user_id BINARY(16) NOT NULL,
+  token VARCHAR(88) NOT NULL,
+  action VARCHAR(255) NOT NULL DEFAULT '',
+  salt VARCHAR(64) NOT NULL,
+  is_used BOOL NOT NULL DEFAULT 0,
+  is_valid BOOL NOT NULL DEFAULT 1,
+  created_at INTEGER NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS nonce_token ON nonce (token);
+CREATE INDEX IF NOT EXISTS nonce_user_action ON nonce (user_id, action, is_valid);
+CREATE INDEX IF NOT EXISTS nonce_expires_at ON nonce (expires_at);
@@ -0,0 +1,3 @@
+ALTER TABLE nonce ADD COLUMN consumed_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE nonce ADD COLUMN consumed_ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE nonce ADD COLUMN consumed_user_agent TEXT NOT NULL DEFAULT '';
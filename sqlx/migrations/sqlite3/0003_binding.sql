@@ -0,0 +1 @@
+ALTER TABLE nonce ADD COLUMN binding TEXT NOT NULL DEFAULT '';
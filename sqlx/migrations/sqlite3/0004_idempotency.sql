@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS nonce_idempotency (
+  user_id BINARY(16) NOT NULL,
+  idempotency_key VARCHAR(255) NOT NULL,
+  is_completed BOOL NOT NULL DEFAULT 0,
+  result BLOB,
+  created_at INTEGER NOT NULL,
+  expires_at DATETIME NOT NULL,
+  PRIMARY KEY (user_id, idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS nonce_idempotency_expires_at ON nonce_idempotency (expires_at);
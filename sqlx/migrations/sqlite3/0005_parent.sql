@@ -0,0 +1 @@
+ALTER TABLE nonce ADD COLUMN parent_id BINARY(16) NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
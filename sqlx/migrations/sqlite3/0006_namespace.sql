@@ -0,0 +1,2 @@
+ALTER TABLE nonce ADD COLUMN namespace TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS nonce_namespace_user_action ON nonce (namespace, user_id, action, is_valid);
@@ -0,0 +1,2 @@
+-- sqlite doesn't enforce VARCHAR lengths, so an encrypted salt already fits
+SELECT 1;
@@ -0,0 +1,2 @@
+-- sqlite doesn't enforce VARCHAR lengths, so a versioned token already fits
+SELECT 1;
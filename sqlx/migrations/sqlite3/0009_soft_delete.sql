@@ -0,0 +1 @@
+ALTER TABLE nonce ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0;
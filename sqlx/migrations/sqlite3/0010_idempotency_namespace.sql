@@ -0,0 +1,16 @@
+-- sqlite can't change a primary key, so the table is copied into a new one
+CREATE TABLE nonce_idempotency_ns (
+  namespace TEXT NOT NULL DEFAULT '',
+  user_id BINARY(16) NOT NULL,
+  idempotency_key VARCHAR(255) NOT NULL,
+  is_completed BOOL NOT NULL DEFAULT 0,
+  result BLOB,
+  created_at INTEGER NOT NULL,
+  expires_at DATETIME NOT NULL,
+  PRIMARY KEY (namespace, user_id, idempotency_key)
+);
+INSERT INTO nonce_idempotency_ns (user_id, idempotency_key, is_completed, result, created_at, expires_at)
+  SELECT user_id, idempotency_key, is_completed, result, created_at, expires_at FROM nonce_idempotency;
+DROP TABLE nonce_idempotency;
+ALTER TABLE nonce_idempotency_ns RENAME TO nonce_idempotency;
+CREATE INDEX IF NOT EXISTS nonce_idempotency_expires_at ON nonce_idempotency (expires_at);
@@ -0,0 +1 @@
+ALTER TABLE nonce ADD COLUMN payload TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,163 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+)
+
+// defaultTable is the table the sqlx backend's statements are written against
+const defaultTable = "nonce"
+
+// WithTableName makes the sqlx backend use table instead of "nonce", e.g. "auth_nonces".
+// table must be a plain, unqualified SQL identifier. Other backends ignore it.
+func WithTableName(table string) nonce.Option {
+	return nonce.BackendOption(optTable, table)
+}
+
+// WithColumnNames renames the sqlx backend's columns. columns maps the default
+// column names, e.g. "user_id", to the names in your table, e.g. "nonce_user_id".
+// Columns that aren't in the map keep their default names. Other backends ignore it.
+func WithColumnNames(columns map[string]string) nonce.Option {
+	copied := make(map[string]string, len(columns))
+	for k, v := range columns {
+		copied[k] = v
+	}
+	return nonce.BackendOption(optColumns, copied)
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlWord matches identifiers along with a leading : or $ so named and
+// numbered parameters can be told apart from column names
+var sqlWord = regexp.MustCompile(`[:$]?[A-Za-z_][A-Za-z0-9_]*`)
+
+// sqlNames rewrites the sqlx backend's statements for a renamed table and
+// columns and for the placeholders of the database's driver. The statements
+// are written with ? placeholders, which sqlx.Rebind turns into $1, $2, ...
+// for postgres.
+type sqlNames struct {
+	table   string
+	columns map[string]string
+	bind    int
+
+	// selectList replaces SELECT * so renamed columns still scan into Nonce
+	selectList string
+
+	// renamed and bound hold the rewritten form of every fixed statement,
+	// before and after rebinding
+	renamed map[string]string
+	bound   map[string]string
+}
+
+// newSQLNames checks the names set by WithTableName and WithColumnNames and
+// rewrites stmts with them, for the sqlx.BindType bind. It panics on a name
+// that isn't a plain identifier or a column the backend doesn't have, like checkTuning.
+func newSQLNames(bind int, table string, columns map[string]string, stmts ...string) *sqlNames {
+	if table == "" {
+		table = defaultTable
+	}
+	if !sqlIdentifier.MatchString(table) {
+		panic(fmt.Sprintf("nonce: invalid table name %q", table))
+	}
+	known := make(map[string]bool, len(expectedColumns))
+	for _, c := range expectedColumns {
+		known[c] = true
+	}
+	for k, v := range columns {
+		if !known[k] {
+			panic(fmt.Sprintf("nonce: unknown column %q", k))
+		}
+		if !sqlIdentifier.MatchString(v) {
+			panic(fmt.Sprintf("nonce: invalid column name %q for %s", v, k))
+		}
+	}
+
+	s := &sqlNames{
+		table:   table,
+		columns: columns,
+		bind:    bind,
+		renamed: make(map[string]string, len(stmts)),
+		bound:   make(map[string]string, len(stmts)),
+	}
+	if len(columns) > 0 {
+		list := make([]string, len(expectedColumns))
+		for i, c := range expectedColumns {
+			if v := s.column(c); v != c {
+				c = v + " AS " + c
+			}
+			list[i] = c
+		}
+		s.selectList = strings.Join(list, ", ")
+	}
+	for _, stmt := range stmts {
+		s.renamed[stmt] = s.rewrite(stmt)
+		s.bound[stmt] = sqlx.Rebind(bind, s.renamed[stmt])
+	}
+	return s
+}
+
+// column returns the name of column c in the live table
+func (s *sqlNames) column(c string) string {
+	if v, ok := s.columns[c]; ok {
+		return v
+	}
+	return c
+}
+
+// q returns stmt as it must be sent to the live table
+func (s *sqlNames) q(stmt string) string {
+	if r, ok := s.bound[stmt]; ok {
+		return r
+	}
+	return sqlx.Rebind(s.bind, s.rewrite(stmt))
+}
+
+// expandIn returns stmt as q would with its IN (?) list expanded for n
+// values, the query sqlx.In makes of it for n ids
+func (s *sqlNames) expandIn(stmt string, n int) string {
+	list := "IN (?" + strings.Repeat(", ?", n-1) + ")"
+	return sqlx.Rebind(s.bind, strings.Replace(s.rewrite(stmt), "IN (?)", list, 1))
+}
+
+// rewrite swaps the default table and column names in stmt for the live ones,
+// leaving its ? placeholders for sqlx.In to expand before it is rebound.
+// Parameters such as :user_id keep their names since they bind to Nonce's fields.
+func (s *sqlNames) rewrite(stmt string) string {
+	if r, ok := s.renamed[stmt]; ok {
+		return r
+	}
+	if s.table == defaultTable && len(s.columns) == 0 {
+		return stmt
+	}
+	stmt = sqlWord.ReplaceAllStringFunc(stmt, func(w string) string {
+		switch {
+		case w[0] == ':' || w[0] == '$':
+			return w
+		case w == defaultTable:
+			return s.table
+		}
+		return s.column(w)
+	})
+	if s.selectList != "" {
+		stmt = strings.Replace(stmt, "SELECT *", "SELECT "+s.selectList, 1)
+	}
+	return stmt
+}
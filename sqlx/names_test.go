@@ -0,0 +1,126 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+const sqlCreateRenamedTable = `
+CREATE TABLE auth_nonces (
+  id BINARY(16) NOT NULL PRIMARY KEY,
+  nonce_user_id BINARY(16) NOT NULL,
+  nonce_token CHAR(88) NOT NULL,
+  action TEXT,
+  salt CHAR(24) NOT NULL,
+  is_used BOOL NOT NULL DEFAULT 0,
+  is_valid BOOL NOT NULL DEFAULT 1,
+  created_at INTEGER NOT NULL,
+  expires_at DATETIME NOT NULL,
+  consumed_at INTEGER NOT NULL DEFAULT 0,
+  consumed_ip TEXT NOT NULL DEFAULT '',
+  consumed_user_agent TEXT NOT NULL DEFAULT '',
+  binding TEXT NOT NULL DEFAULT '',
+  parent_id TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000',
+  namespace TEXT NOT NULL DEFAULT '',
+  deleted_at INTEGER NOT NULL DEFAULT 0,
+  payload TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX auth_nonces_token ON auth_nonces (nonce_token);
+CREATE INDEX auth_nonces_user ON auth_nonces (nonce_user_id, action, is_valid);
+CREATE INDEX auth_nonces_expires ON auth_nonces (expires_at);`
+
+func TestTableAndColumnNames(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", ":memory:")
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.MustExec(sqlCreateRenamedTable)
+
+	clock := &testClock{}
+	s := NewService(db, nonce.WithClock(clock), WithTableName("auth_nonces"),
+		WithColumnNames(map[string]string{"user_id": "nonce_user_id", "token": "nonce_token"})).(*service)
+	defer s.Shutdown()
+	waitForFirstSweep(t, s)
+
+	uid := uuid.NewV4()
+	n, err := s.New("rename", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	err = s.Check(n.Token, "rename", uid)
+	if err != nil {
+		t.Fatalf("Expected Check to succeed. Instead got: %v", err)
+	}
+	got, err := s.Get("rename", uid)
+	if err != nil || got.Token != n.Token || got.UserID != uid {
+		t.Fatalf("Expected Get to return the new nonce. Instead got: %+v, %v", got, err)
+	}
+	_, err = s.CheckThenConsume(n.Token, "rename", uid)
+	if err != nil {
+		t.Fatalf("Expected CheckThenConsume to succeed. Instead got: %v", err)
+	}
+
+	count := 0
+	err = s.List(context.Background(), nonce.Filter{UserID: uid, Consumed: true}, func(nonce.Nonce) error {
+		count++
+		return nil
+	})
+	if err != nil || count != 1 {
+		t.Fatalf("Expected List to find 1 consumed nonce. Instead got: %d, %v", count, err)
+	}
+
+	_, err = s.New("rename", uid, time.Second)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	clock.Add(time.Hour)
+	purged, err := s.PurgeExpired(context.Background(), 0)
+	if err != nil || purged != 2 {
+		t.Fatalf("Expected PurgeExpired to remove 2 nonces. Instead got: %d, %v", purged, err)
+	}
+
+	drift, err := checkSchemaNames(db, s.sql)
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("Expected no drift on the renamed table. Instead got: %v, %v", drift, err)
+	}
+	drift, err = CheckSchema(db)
+	if err != nil || len(drift) == 0 {
+		t.Fatalf("Expected drift when checking the default table. Instead got: %v, %v", drift, err)
+	}
+}
+
+func TestInvalidNamesPanic(t *testing.T) {
+	for _, opt := range []nonce.Option{
+		WithTableName("nonce; DROP TABLE users"),
+		WithColumnNames(map[string]string{"user_id": "a b"}),
+		WithColumnNames(map[string]string{"userid": "uid"}),
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Expected an invalid name to panic")
+				}
+			}()
+			o := newOptions(nonce.NewBackend(opt))
+			newSQLNames(sqlx.QUESTION, o.table, o.columns)
+		}()
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// option keys the nonce.BackendOptions this package defines
+type option int
+
+const (
+	optTable option = iota
+	optColumns
+	optSoftDelete
+	optSingletonCleanup
+	optSchemaCheck
+)
+
+// options holds the settings only the sqlx backend reads
+type options struct {
+	table               string
+	columns             map[string]string
+	softDelete          bool
+	keepDeleted         time.Duration
+	singletonCleanup    bool
+	schemaCheckInterval time.Duration
+	schemaCheckReport   func(drift []SchemaDrift, err error)
+}
+
+// softDeleteOption and schemaCheckOption are what WithSoftDelete and
+// WithSchemaCheck record
+type softDeleteOption struct {
+	keep time.Duration
+}
+
+type schemaCheckOption struct {
+	interval time.Duration
+	report   func(drift []SchemaDrift, err error)
+}
+
+// newOptions reads the options recorded in cfg
+func newOptions(cfg *nonce.Backend) options {
+	var o options
+	o.table, _ = cfg.Value(optTable).(string)
+	o.columns, _ = cfg.Value(optColumns).(map[string]string)
+	if sd, ok := cfg.Value(optSoftDelete).(softDeleteOption); ok {
+		o.softDelete = true
+		o.keepDeleted = sd.keep
+	}
+	o.singletonCleanup, _ = cfg.Value(optSingletonCleanup).(bool)
+	if sc, ok := cfg.Value(optSchemaCheck).(schemaCheckOption); ok {
+		o.schemaCheckInterval = sc.interval
+		o.schemaCheckReport = sc.report
+	}
+	return o
+}
+
+// purgeStatement is the statement PurgeExpired removes a batch with. Full
+// batches all expand to the same query, so it is prepared for PurgeBatchSize ids.
+func (o options) purgeStatement() string {
+	if o.softDelete {
+		return sqlTombstoneExpiredIn
+	}
+	return sqlDeleteExpiredIn
+}
@@ -0,0 +1,38 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"github.com/bryanjeal/go-nonce"
+)
+
+// sqlEvict invalidates a nonce unless it was used or invalidated since it was listed
+const sqlEvict = `UPDATE nonce SET is_valid=FALSE WHERE id=? AND is_valid=TRUE AND is_used=FALSE`
+
+func (s *service) Evict(n nonce.Nonce) (bool, error) {
+	res, err := s.db.Exec(s.sql.q(sqlEvict), n.ID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil || rows == 0 {
+		return false, err
+	}
+
+	n.IsValid = false
+	s.cfg.Remember(n, s.cfg.Clock().Now())
+	s.cfg.Invalidated(n)
+	return true, nil
+}
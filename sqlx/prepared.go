@@ -0,0 +1,130 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"sync"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+)
+
+// sqlPrepared are the hot statements the sqlx backend prepares when it is created
+var sqlPrepared = []string{
+	sqlSelectByToken, sqlSelectByID, sqlSelectByUser,
+	sqlConsume, sqlCheckThenConsume, sqlConsumeByID, sqlRenew,
+}
+
+// sqlPreparedNamed are the hot statements that bind a Nonce by name
+var sqlPreparedNamed = []string{sqlInsertNonce, sqlInvalidateOthers}
+
+// preparedStmts caches statements prepared on db, keyed by their rewritten SQL.
+// A statement that can't be prepared when the Service is created, e.g. because
+// the table doesn't exist yet, is prepared again the first time it is used.
+type preparedStmts struct {
+	sync.RWMutex
+	db    *sqlx.DB
+	stmts map[string]*sqlx.Stmt
+	named map[string]*sqlx.NamedStmt
+}
+
+// newPreparedStmts prepares every statement in sqlPrepared and
+// sqlPreparedNamed, and opts' purgeStatement for a full batch
+func newPreparedStmts(db *sqlx.DB, names *sqlNames, cfg *nonce.Backend, opts options) *preparedStmts {
+	p := &preparedStmts{db: db}
+	p.reset()
+	queries := make([]string, 0, len(sqlPrepared)+1)
+	for _, query := range sqlPrepared {
+		queries = append(queries, names.q(query))
+	}
+	queries = append(queries, names.expandIn(opts.purgeStatement(), cfg.PurgeBatchSize()))
+	for _, query := range queries {
+		_, err := p.stmt(query)
+		if err != nil {
+			cfg.Logger().Printf("nonce: error preparing statement, will retry when it is used: %v", err)
+		}
+	}
+	for _, query := range sqlPreparedNamed {
+		_, err := p.namedStmt(names.q(query))
+		if err != nil {
+			cfg.Logger().Printf("nonce: error preparing statement, will retry when it is used: %v", err)
+		}
+	}
+	return p
+}
+
+func (p *preparedStmts) reset() {
+	p.stmts = make(map[string]*sqlx.Stmt)
+	p.named = make(map[string]*sqlx.NamedStmt)
+}
+
+// stmt returns query prepared, preparing it if it hasn't been
+func (p *preparedStmts) stmt(query string) (*sqlx.Stmt, error) {
+	p.RLock()
+	st, ok := p.stmts[query]
+	p.RUnlock()
+	if ok {
+		return st, nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	st, ok = p.stmts[query]
+	if ok {
+		return st, nil
+	}
+	st, err := p.db.Preparex(query)
+	if err != nil {
+		return nil, err
+	}
+	p.stmts[query] = st
+	return st, nil
+}
+
+// namedStmt is stmt for queries with :name parameters
+func (p *preparedStmts) namedStmt(query string) (*sqlx.NamedStmt, error) {
+	p.RLock()
+	st, ok := p.named[query]
+	p.RUnlock()
+	if ok {
+		return st, nil
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	st, ok = p.named[query]
+	if ok {
+		return st, nil
+	}
+	st, err := p.db.PrepareNamed(query)
+	if err != nil {
+		return nil, err
+	}
+	p.named[query] = st
+	return st, nil
+}
+
+// close closes every prepared statement. Using one afterwards prepares it again.
+func (p *preparedStmts) close() {
+	p.Lock()
+	defer p.Unlock()
+	for _, st := range p.stmts {
+		st.Close()
+	}
+	for _, st := range p.named {
+		st.Close()
+	}
+	p.reset()
+}
@@ -0,0 +1,368 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+func newPreparedTestDB(tb testing.TB) *sqlx.DB {
+	db := sqlx.MustConnect("sqlite3", ":memory:")
+	db.SetMaxOpenConns(1)
+	err := Migrate(context.Background(), db)
+	if err != nil {
+		tb.Fatalf("Expected Migrate to succeed. Instead got: %v", err)
+	}
+	return db
+}
+
+func TestPreparedBeforeTable(t *testing.T) {
+	db := sqlx.MustConnect("sqlite3", ":memory:")
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// nothing can be prepared yet, so every statement is prepared on first use
+	s := NewService(db).(*service)
+	defer s.Shutdown()
+	s.prepared.RLock()
+	prepared := len(s.prepared.stmts) + len(s.prepared.named)
+	s.prepared.RUnlock()
+	if prepared != 0 {
+		t.Fatalf("Expected no statements to be prepared without a table. Instead got: %d", prepared)
+	}
+
+	err := Migrate(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Expected Migrate to succeed. Instead got: %v", err)
+	}
+	uid := uuid.NewV4()
+	n, err := s.New("prepared", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed once the table exists. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume(n.Token, "prepared", uid)
+	if err != nil {
+		t.Fatalf("Expected CheckThenConsume to succeed once the table exists. Instead got: %v", err)
+	}
+	s.prepared.RLock()
+	_, ok := s.prepared.stmts[s.sql.q(sqlCheckThenConsume)]
+	s.prepared.RUnlock()
+	if !ok {
+		t.Fatalf("Expected the consume statement to be prepared after use")
+	}
+}
+
+func TestPreparedPurge(t *testing.T) {
+	db := newPreparedTestDB(t)
+	defer db.Close()
+	for name, opts := range map[string][]nonce.Option{
+		"delete":      nil,
+		"soft delete": {WithSoftDelete(0)},
+	} {
+		s := NewService(db, opts...).(*service)
+		query := s.sql.expandIn(s.opts.purgeStatement(), s.cfg.PurgeBatchSize())
+		s.prepared.RLock()
+		_, ok := s.prepared.stmts[query]
+		s.prepared.RUnlock()
+		if !ok {
+			t.Errorf("Expected the %s purge of a full batch to be prepared when the Service is created", name)
+		}
+
+		ids := make([]uuid.UUID, s.cfg.PurgeBatchSize())
+		for i := range ids {
+			ids[i] = uuid.NewV4()
+		}
+		args := []interface{}{time.Now(), ids}
+		if s.opts.softDelete {
+			args = append([]interface{}{time.Now().Unix()}, args...)
+		}
+		expanded, _, err := sqlx.In(s.sql.rewrite(s.opts.purgeStatement()), args...)
+		if err != nil || db.Rebind(expanded) != query {
+			t.Errorf("Expected the prepared %s purge to be what sqlx.In expands. Instead got: %q, %v", name, query, err)
+		}
+		s.Shutdown()
+	}
+}
+
+// beginCounter wraps a driver and counts the transactions opened on it
+type beginCounter struct {
+	driver.Driver
+	begins int32
+}
+
+func (d *beginCounter) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &beginCounterConn{Conn: c, d: d}, nil
+}
+
+type beginCounterConn struct {
+	driver.Conn
+	d *beginCounter
+}
+
+func (c *beginCounterConn) Begin() (driver.Tx, error) {
+	atomic.AddInt32(&c.d.begins, 1)
+	return c.Conn.Begin()
+}
+
+var (
+	sqliteBegins         = &beginCounter{}
+	registerSqliteBegins sync.Once
+)
+
+// newBeginCounterDB returns a migrated in-memory database whose transactions are
+// counted in sqliteBegins
+func newBeginCounterDB(t *testing.T) *sqlx.DB {
+	registerSqliteBegins.Do(func() {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("Expected to open sqlite3. Instead got: %v", err)
+		}
+		sqliteBegins.Driver = db.Driver()
+		db.Close()
+		sql.Register("sqlite3_begins", sqliteBegins)
+	})
+	sqlDB, err := sql.Open("sqlite3_begins", ":memory:")
+	if err != nil {
+		t.Fatalf("Expected to open sqlite3_begins. Instead got: %v", err)
+	}
+	db := sqlx.NewDb(sqlDB, "sqlite3")
+	db.SetMaxOpenConns(1)
+	err = Migrate(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Expected Migrate to succeed. Instead got: %v", err)
+	}
+	return db
+}
+
+func TestSingleStatementsSkipTransactions(t *testing.T) {
+	db := newBeginCounterDB(t)
+	defer db.Close()
+	s := NewService(db).(*service)
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+
+	// New writes twice, so it gets its own transaction and isn't counted
+	var nonces [3]nonce.Nonce
+	for i := range nonces {
+		n, err := s.New(fmt.Sprintf("single-%d", i), uid, time.Minute)
+		if err != nil {
+			t.Fatalf("Expected New to succeed. Instead got: %v", err)
+		}
+		nonces[i] = n
+	}
+
+	atomic.StoreInt32(&sqliteBegins.begins, 0)
+	n, err := s.Renew(nonces[0].Token, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected Renew to succeed. Instead got: %v", err)
+	}
+	_, err = s.Get(n.Action, uid)
+	if err != nil {
+		t.Fatalf("Expected Get to succeed. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume(n.Token, n.Action, uid)
+	if err != nil {
+		t.Fatalf("Expected CheckThenConsume to succeed. Instead got: %v", err)
+	}
+	_, err = s.ConsumeByID(nonces[1].ID, nonces[1].Action, uid)
+	if err != nil {
+		t.Fatalf("Expected ConsumeByID to succeed. Instead got: %v", err)
+	}
+	_, err = s.Consume(nonces[2].Token)
+	if err != nil {
+		t.Fatalf("Expected Consume to succeed. Instead got: %v", err)
+	}
+	_, err = s.PurgeExpired(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("Expected PurgeExpired to succeed. Instead got: %v", err)
+	}
+	if begins := atomic.LoadInt32(&sqliteBegins.begins); begins != 0 {
+		t.Fatalf("Expected single statement writes to run without a transaction. Instead %d were opened", begins)
+	}
+}
+
+func TestNewSingleTransaction(t *testing.T) {
+	db := newBeginCounterDB(t)
+	defer db.Close()
+	s := NewService(db)
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+
+	first, err := s.New("atomic", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	atomic.StoreInt32(&sqliteBegins.begins, 0)
+	_, err = s.New("atomic", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+	if begins := atomic.LoadInt32(&sqliteBegins.begins); begins != 1 {
+		t.Fatalf("Expected New to insert and invalidate in one transaction. Instead %d were opened", begins)
+	}
+	err = s.Check(first.Token, "atomic", uid)
+	if !errors.Is(err, nonce.ErrInvalidToken) {
+		t.Fatalf("Expected the first nonce to be invalidated. Instead got: %v", err)
+	}
+}
+
+func BenchmarkSelectByToken(b *testing.B) {
+	db := newPreparedTestDB(b)
+	defer db.Close()
+	s := NewService(db).(*service)
+	defer s.Shutdown()
+	n, err := s.New("bench", uuid.NewV4(), time.Hour)
+	if err != nil {
+		b.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+
+	b.Run("unprepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var got nonce.Nonce
+			err := db.Get(&got, s.sql.q(sqlSelectByToken), n.Token, "")
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("prepared", func(b *testing.B) {
+		st, err := s.stmt(sqlSelectByToken)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			var got nonce.Nonce
+			err := st.Get(&got, n.Token, "")
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkConsumeUpdate compares the consume update run the way the backend
+// used to, in its own transaction, with the prepared statement it uses now
+func BenchmarkConsumeUpdate(b *testing.B) {
+	db := newPreparedTestDB(b)
+	defer db.Close()
+	s := NewService(db).(*service)
+	defer s.Shutdown()
+	n, err := s.New("bench", uuid.NewV4(), time.Hour)
+	if err != nil {
+		b.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+
+	b.Run("transaction", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tx, err := db.Beginx()
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = tx.Exec(s.sql.q(sqlConsume), int64(i), "", "", n.Token, "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = tx.Commit()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("prepared", func(b *testing.B) {
+		st, err := s.stmt(sqlConsume)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			_, err := st.Exec(int64(i), "", "", n.Token, "")
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkNewAndConsume(b *testing.B) {
+	db := newPreparedTestDB(b)
+	defer db.Close()
+	s := NewService(db)
+	defer s.Shutdown()
+	uid := uuid.NewV4()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n, err := s.New("bench", uid, time.Hour)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = s.CheckThenConsume(n.Token, "bench", uid)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPurgeBatch(b *testing.B) {
+	db := newPreparedTestDB(b)
+	defer db.Close()
+	s := NewService(db).(*service)
+	defer s.Shutdown()
+	ids := make([]uuid.UUID, s.cfg.PurgeBatchSize())
+	for i := range ids {
+		ids[i] = uuid.NewV4()
+	}
+	query, args, err := sqlx.In(s.sql.rewrite(sqlDeleteExpiredIn), time.Now(), ids)
+	if err != nil {
+		b.Fatal(err)
+	}
+	query = db.Rebind(query)
+
+	b.Run("unprepared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := db.Exec(query, args...)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("prepared", func(b *testing.B) {
+		st, err := s.prepared.stmt(query)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			_, err := st.Exec(args...)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
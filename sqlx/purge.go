@@ -0,0 +1,104 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+// sqlDeleteExpiredIn is expanded by sqlx.In; expires_at is checked again in case a nonce was renewed after it was listed
+const sqlDeleteExpiredIn = `DELETE FROM nonce WHERE expires_at < ? AND id IN (?)`
+
+// sqlSelectIDsIn finds which of a purged batch survived
+const sqlSelectIDsIn = `SELECT id FROM nonce WHERE deleted_at = 0 AND id IN (?)`
+
+func (s *service) PurgeExpired(ctx context.Context, limit int) (int64, error) {
+	purged, err := s.cfg.PurgeExpired(ctx, s, limit, func(batch []nonce.Nonce, t time.Time) (int64, error) {
+		ids := make([]uuid.UUID, len(batch))
+		for i, n := range batch {
+			ids[i] = n.ID
+		}
+		stmt := s.opts.purgeStatement()
+		query, args, err := sqlx.In(s.sql.rewrite(stmt), t, ids)
+		if s.opts.softDelete {
+			query, args, err = sqlx.In(s.sql.rewrite(stmt), s.cfg.Clock().Now().Unix(), t, ids)
+		}
+		if err != nil {
+			return 0, err
+		}
+		// full batches use the statement prepared when the Service was created
+		var res sql.Result
+		if len(batch) == s.cfg.PurgeBatchSize() {
+			var st *sqlx.Stmt
+			st, err = s.prepared.stmt(s.sql.expandIn(stmt, len(batch)))
+			if err == nil {
+				res, err = st.ExecContext(ctx, args...)
+			}
+		} else {
+			res, err = s.db.ExecContext(ctx, s.db.Rebind(query), args...)
+		}
+		if err != nil {
+			return 0, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return rows, err
+		}
+		return rows, s.removed(batch, ids, rows)
+	})
+	if err == nil {
+		err = s.purgeTombstones(ctx)
+	}
+	return purged, err
+}
+
+// removed calls the OnExpiredRemoved hook for the nonces in batch that were
+// deleted. If some weren't, because they were renewed after being listed,
+// it asks the database which of the batch are still there.
+func (s *service) removed(batch []nonce.Nonce, ids []uuid.UUID, rows int64) error {
+	if s.cfg.Hooks().OnExpiredRemoved == nil {
+		return nil
+	}
+	if rows == int64(len(batch)) {
+		s.cfg.ExpiredRemoved(batch...)
+		return nil
+	}
+
+	query, args, err := sqlx.In(s.sql.rewrite(sqlSelectIDsIn), ids)
+	if err != nil {
+		return err
+	}
+	var left []uuid.UUID
+	err = s.db.Select(&left, s.db.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	kept := make(map[uuid.UUID]bool, len(left))
+	for _, id := range left {
+		kept[id] = true
+	}
+	for _, n := range batch {
+		if !kept[n.ID] {
+			s.cfg.ExpiredRemoved(n)
+		}
+	}
+	return nil
+}
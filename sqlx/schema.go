@@ -0,0 +1,293 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+)
+
+// Kinds of SchemaDrift
+const (
+	DriftMissingColumn  = "missing_column"
+	DriftMissingIndex   = "missing_index"
+	DriftIndexNotUnique = "index_not_unique"
+)
+
+// SchemaDrift describes one way the live nonce table differs from what the sqlx backend expects
+type SchemaDrift struct {
+	Kind   string
+	Name   string
+	Detail string
+}
+
+func (d SchemaDrift) String() string {
+	return fmt.Sprintf("%s %s: %s", d.Kind, d.Name, d.Detail)
+}
+
+// schemaIndex is an index the backend relies on
+type schemaIndex struct {
+	name    string
+	columns []string
+	unique  bool
+	reason  string
+}
+
+var (
+	expectedColumns = []string{
+		"id", "user_id", "token", "action", "salt", "is_used", "is_valid", "created_at", "expires_at", "consumed_at",
+		"consumed_ip", "consumed_user_agent", "binding", "parent_id", "namespace", "deleted_at",
+		"payload",
+	}
+	expectedIndexes = []schemaIndex{
+		{"token", []string{"token"}, true, "token lookups and consume atomicity"},
+		{"user_action", []string{"user_id", "action", "is_valid"}, false, "Get and the invalidation in New"},
+		{"expires_at", []string{"expires_at"}, false, "removing expired nonces"},
+	}
+)
+
+// liveIndex is an index found on the live table
+type liveIndex struct {
+	columns []string
+	unique  bool
+}
+
+// CheckSchema compares the live nonce table in db with the columns and indexes
+// the sqlx backend expects and returns every difference it finds.
+// sqlite3, mysql and postgres databases are supported.
+func CheckSchema(db *sqlx.DB) ([]SchemaDrift, error) {
+	return checkSchemaNames(db, newSQLNames(sqlx.BindType(db.DriverName()), "", nil))
+}
+
+// checkSchemaNames is CheckSchema for a table renamed by WithTableName and WithColumnNames.
+// Drift is reported using the default column names.
+func checkSchemaNames(db *sqlx.DB, names *sqlNames) ([]SchemaDrift, error) {
+	columns, indexes, err := inspectSchema(db, names.table)
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []SchemaDrift
+	have := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		have[strings.ToLower(c)] = true
+	}
+	for _, c := range expectedColumns {
+		if !have[strings.ToLower(names.column(c))] {
+			drift = append(drift, SchemaDrift{Kind: DriftMissingColumn, Name: c, Detail: "column not found on " + names.table + " table"})
+		}
+	}
+
+	for _, want := range expectedIndexes {
+		found, unique := false, false
+		live := make([]string, len(want.columns))
+		for i, c := range want.columns {
+			live[i] = strings.ToLower(names.column(c))
+		}
+		for _, idx := range indexes {
+			if coversColumns(idx.columns, live) {
+				found = true
+				unique = unique || idx.unique
+			}
+		}
+		cols := strings.Join(want.columns, ", ")
+		if !found {
+			drift = append(drift, SchemaDrift{Kind: DriftMissingIndex, Name: want.name, Detail: fmt.Sprintf("no index on (%s), needed for %s", cols, want.reason)})
+		} else if want.unique && !unique {
+			drift = append(drift, SchemaDrift{Kind: DriftIndexNotUnique, Name: want.name, Detail: fmt.Sprintf("index on (%s) must be unique for %s", cols, want.reason)})
+		}
+	}
+
+	return drift, nil
+}
+
+// coversColumns reports whether an index on have can serve lookups on want
+func coversColumns(have, want []string) bool {
+	if len(have) < len(want) {
+		return false
+	}
+	for i := range want {
+		if strings.ToLower(have[i]) != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// inspectSchema reads table's columns and indexes using the dialect of db
+func inspectSchema(db *sqlx.DB, table string) ([]string, []liveIndex, error) {
+	switch db.DriverName() {
+	case "sqlite3":
+		return inspectSQLite(db, table)
+	case "mysql":
+		return inspectMySQL(db, table)
+	case "postgres", "pgx":
+		return inspectPostgres(db, table)
+	}
+	return nil, nil, fmt.Errorf("nonce: schema check does not support driver %q", db.DriverName())
+}
+
+func inspectSQLite(db *sqlx.DB, table string) ([]string, []liveIndex, error) {
+	var cols []struct {
+		CID     int            `db:"cid"`
+		Name    string         `db:"name"`
+		Type    string         `db:"type"`
+		NotNull bool           `db:"notnull"`
+		Default sql.NullString `db:"dflt_value"`
+		PK      int            `db:"pk"`
+	}
+	err := db.Select(&cols, fmt.Sprintf("PRAGMA table_info(%q)", table))
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, 0, len(cols))
+	for _, c := range cols {
+		columns = append(columns, c.Name)
+	}
+
+	var list []struct {
+		Seq     int    `db:"seq"`
+		Name    string `db:"name"`
+		Unique  bool   `db:"unique"`
+		Origin  string `db:"origin"`
+		Partial bool   `db:"partial"`
+	}
+	err = db.Select(&list, fmt.Sprintf("PRAGMA index_list(%q)", table))
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes := make([]liveIndex, 0, len(list))
+	for _, l := range list {
+		var info []struct {
+			SeqNo int            `db:"seqno"`
+			CID   int            `db:"cid"`
+			Name  sql.NullString `db:"name"`
+		}
+		err = db.Select(&info, fmt.Sprintf("PRAGMA index_info(%q)", l.Name))
+		if err != nil {
+			return nil, nil, err
+		}
+		idx := liveIndex{unique: l.Unique}
+		for _, i := range info {
+			idx.columns = append(idx.columns, i.Name.String)
+		}
+		indexes = append(indexes, idx)
+	}
+
+	return columns, indexes, nil
+}
+
+func inspectMySQL(db *sqlx.DB, table string) ([]string, []liveIndex, error) {
+	var columns []string
+	err := db.Select(&columns, `SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []struct {
+		Name      string `db:"INDEX_NAME"`
+		NonUnique bool   `db:"NON_UNIQUE"`
+		Column    string `db:"COLUMN_NAME"`
+	}
+	err = db.Select(&rows, `SELECT INDEX_NAME, NON_UNIQUE, COLUMN_NAME FROM information_schema.STATISTICS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY INDEX_NAME, SEQ_IN_INDEX`, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes := groupIndexes(len(rows), func(i int) (string, bool, string) {
+		return rows[i].Name, !rows[i].NonUnique, rows[i].Column
+	})
+
+	return columns, indexes, nil
+}
+
+func inspectPostgres(db *sqlx.DB, table string) ([]string, []liveIndex, error) {
+	var columns []string
+	err := db.Select(&columns, `SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []struct {
+		Name   string `db:"index_name"`
+		Unique bool   `db:"is_unique"`
+		Column string `db:"column_name"`
+	}
+	err = db.Select(&rows, `SELECT i.relname AS index_name, ix.indisunique AS is_unique, a.attname AS column_name
+		FROM pg_class t
+		JOIN pg_index ix ON t.oid = ix.indrelid
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(ix.indkey)
+		WHERE t.relname = $1 AND t.relnamespace = current_schema()::regnamespace
+		ORDER BY i.relname, array_position(ix.indkey::int2[], a.attnum)`, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	indexes := groupIndexes(len(rows), func(i int) (string, bool, string) {
+		return rows[i].Name, rows[i].Unique, rows[i].Column
+	})
+
+	return columns, indexes, nil
+}
+
+// groupIndexes folds rows of (index, unique, column), ordered by index then position, into indexes
+func groupIndexes(n int, row func(i int) (string, bool, string)) []liveIndex {
+	var indexes []liveIndex
+	last := ""
+	for i := 0; i < n; i++ {
+		name, unique, column := row(i)
+		if i == 0 || name != last {
+			indexes = append(indexes, liveIndex{unique: unique})
+			last = name
+		}
+		idx := &indexes[len(indexes)-1]
+		idx.columns = append(idx.columns, column)
+	}
+	return indexes
+}
+
+// WithSchemaCheck makes the sqlx Service run CheckSchema every interval and pass
+// the result to report. Use it to alert when an index the backend depends on,
+// such as the unique token index, has been dropped. Other backends ignore it.
+func WithSchemaCheck(interval time.Duration, report func(drift []SchemaDrift, err error)) nonce.Option {
+	return nonce.BackendOption(optSchemaCheck, schemaCheckOption{interval: interval, report: report})
+}
+
+// checkSchema runs CheckSchema periodically until the Service is shut down
+func (s *service) checkSchema() {
+	if s.opts.schemaCheckInterval <= 0 || s.opts.schemaCheckReport == nil {
+		return
+	}
+
+	ticker := time.NewTicker(s.opts.schemaCheckInterval)
+	defer ticker.Stop()
+	for {
+		drift, err := checkSchemaNames(s.db, s.sql)
+		s.opts.schemaCheckReport(drift, err)
+
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,615 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sqlx stores nonces in SQL databases through jmoiron/sqlx. It
+// supports PostgreSQL, MySQL and SQLite; Migrate creates the nonce table.
+package sqlx
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+type service struct {
+	db       *sqlx.DB
+	cfg      *nonce.Backend
+	opts     options
+	sql      *sqlNames
+	prepared *preparedStmts
+	quit     chan struct{}
+	stop     sync.Once
+}
+
+// NewService creates an Nonce Service that connects to provided DB information
+// The package doesn't import any database driver; import the one db uses,
+// e.g. _ "github.com/mattn/go-sqlite3", in your own program.
+// The hot statements are prepared here and closed by Shutdown.
+func NewService(db *sqlx.DB, opts ...nonce.Option) nonce.Service {
+	cfg := nonce.NewBackend(opts...)
+	o := newOptions(cfg)
+	s := &service{
+		db:   db,
+		cfg:  cfg,
+		opts: o,
+		sql:  newSQLNames(sqlx.BindType(db.DriverName()), o.table, o.columns, sqlStatements...),
+		quit: make(chan struct{}),
+	}
+	s.prepared = newPreparedStmts(db, s.sql, cfg, o)
+	purge := s.PurgeExpired
+	if o.singletonCleanup {
+		purge = s.purgeAsLeader
+	}
+	go s.cfg.RemoveExpired(s.quit, purge)
+	go s.checkSchema()
+	return s.cfg.Wrap(s)
+}
+
+// SQL statements used by the sqlx backend
+const (
+	sqlInsertNonce = `INSERT INTO nonce 
+		(id, user_id, token, action, salt, is_used, is_valid, created_at, expires_at,
+		consumed_at, consumed_ip, consumed_user_agent, binding, parent_id, namespace, payload)
+		VALUES (:id, :user_id, :token, :action, :salt, :is_used, :is_valid, :created_at, :expires_at,
+		:consumed_at, :consumed_ip, :consumed_user_agent, :binding, :parent_id, :namespace, :payload)`
+	sqlInvalidateOthers = `UPDATE nonce 
+        SET is_valid = FALSE 
+        WHERE is_valid = TRUE AND user_id = :user_id AND action = :action AND namespace = :namespace AND id != :id`
+	sqlSelectOthers = `SELECT * FROM nonce
+        WHERE is_valid = TRUE AND is_used = FALSE AND user_id = ? AND action = ? AND namespace = ? AND id != ?`
+	sqlSelectByToken = `SELECT * FROM nonce WHERE token=? AND namespace=?`
+	sqlSelectByID    = `SELECT * FROM nonce WHERE id=? AND namespace=?`
+	sqlSelectByUser  = `SELECT * FROM nonce WHERE action=? AND user_id=? AND namespace=? AND is_valid=TRUE AND is_used=FALSE AND expires_at > ?
+		ORDER BY created_at DESC LIMIT 1`
+	sqlConsume = `UPDATE nonce SET is_used = TRUE, consumed_at = ?, consumed_ip = ?, consumed_user_agent = ?
+		WHERE token=? AND namespace=? AND is_used=FALSE`
+	sqlCheckThenConsume = `UPDATE nonce SET is_used = TRUE, consumed_at = ?, consumed_ip = ?, consumed_user_agent = ?
+		WHERE token=? AND action=? AND user_id=? AND is_valid=TRUE AND is_used=FALSE AND expires_at > ? AND binding=? AND namespace=?`
+	sqlConsumeByID = `UPDATE nonce SET is_used = TRUE, consumed_at = ?
+		WHERE id=? AND action=? AND user_id=? AND is_valid=TRUE AND is_used=FALSE AND expires_at > ? AND binding='' AND namespace=?`
+	sqlRenew = `UPDATE nonce SET expires_at=?
+		WHERE id=? AND is_valid=TRUE AND is_used=FALSE AND expires_at > ?`
+	sqlDeleteByToken = `DELETE FROM nonce WHERE token=? AND namespace=?`
+)
+
+// sqlStatements are rewritten once per Service for WithTableName and WithColumnNames
+var sqlStatements = []string{
+	sqlInsertNonce, sqlInvalidateOthers, sqlSelectOthers, sqlSelectByToken, sqlSelectByID, sqlSelectByUser,
+	sqlConsume, sqlCheckThenConsume, sqlConsumeByID, sqlRenew, sqlDeleteByToken, sqlExtendExpiry, sqlDeleteExpiredIn, sqlSelectIDsIn,
+	sqlEvict, sqlTombstoneExpiredIn, sqlDeleteTombstonesIn, sqlUndelete, sqlDeleteByUser,
+}
+
+func (s *service) New(action string, uid uuid.UUID, expiresIn time.Duration) (nonce.Nonce, error) {
+	return s.NewBound(action, uid, expiresIn, nonce.Binding{})
+}
+
+func (s *service) NewBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, b, "")
+}
+
+func (s *service) NewWithPayload(action string, uid uuid.UUID, expiresIn time.Duration, payload string) (nonce.Nonce, error) {
+	return s.newBound(action, uid, expiresIn, nonce.Binding{}, payload)
+}
+
+func (s *service) newBound(action string, uid uuid.UUID, expiresIn time.Duration, b nonce.Binding, payload string) (nonce.Nonce, error) {
+	n, err := s.cfg.NewBoundNonce(action, uid, expiresIn, b, payload)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// Save nonce to DB, invalidating existing tokens for same user & action
+	others, err := s.create(&n, nil)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	s.cfg.Remember(n, s.cfg.Clock().Now())
+	s.cfg.RememberInvalidated(n)
+	s.cfg.Created(n)
+	s.cfg.Invalidated(others...)
+
+	// return new nonce
+	return s.cfg.Issue(n), nil
+}
+
+func (s *service) Check(token, action string, uid uuid.UUID) error {
+	return s.CheckBound(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckBound(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) error {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return err
+	}
+
+	// get Nonce data from database
+	n, err := s.getNonce(token)
+	if err != nil {
+		return err
+	}
+
+	err = s.cfg.CheckBound(n, action, uid, meta, s.cfg.Clock().Now())
+	return err
+}
+
+func (s *service) Consume(token string) (nonce.Nonce, error) {
+	return s.ConsumeWithMeta(token, nonce.ConsumeMeta{})
+}
+
+func (s *service) ConsumeWithMeta(token string, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.getNonce(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// make sure token hasn't been used
+	if n.IsUsed == true {
+		return nonce.Nonce{}, nonce.ErrTokenUsed
+	}
+
+	// set token as used
+	t := s.cfg.Clock().Now()
+	st, err := s.stmt(sqlConsume)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	sealed, err := s.cfg.SealMeta(meta, token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	res, err := st.Exec(t.Unix(), sealed.IP, sealed.UserAgent, token, s.cfg.Namespace())
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if rows == 0 {
+		// another caller consumed it between our read and update
+		return nonce.Nonce{}, nonce.ErrTokenUsed
+	}
+
+	n.IsUsed = true
+	n.ConsumedAt = t.Unix()
+	n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+	s.cfg.Remember(n, t)
+	s.cfg.Consumed(n)
+	return n, nil
+}
+
+func (s *service) CheckThenConsume(token, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	return s.CheckThenConsumeWithMeta(token, action, uid, nonce.ConsumeMeta{})
+}
+
+func (s *service) CheckThenConsumeWithMeta(token, action string, uid uuid.UUID, meta nonce.ConsumeMeta) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	err = s.cfg.CheckMeta(meta)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// check and consume in one statement so concurrent callers can't both succeed
+	t := s.cfg.Clock().Now()
+	st, err := s.stmt(sqlCheckThenConsume)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	sealed, err := s.cfg.SealMeta(meta, token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	consume := func(action, binding string) (int64, error) {
+		res, err := st.Exec(t.Unix(), sealed.IP, sealed.UserAgent, token, action, uid, s.cfg.ExpiryCutoff(t), binding, s.cfg.Namespace())
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+	rows, err := consume(action, "")
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// read the nonce back to return it or to work out why it wasn't consumed
+	n, err := s.getNonce(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if rows == 0 {
+		err = s.cfg.CheckBound(n, action, uid, meta, t)
+		if err == nil && (n.Binding != "" || n.Action != action) {
+			// meta matches the binding and action is in the nonce's scope,
+			// neither of which ever changes, so consume it as stored
+			rows, err = consume(n.Action, n.Binding)
+		}
+		if err == nil && rows == 0 {
+			// another caller consumed it between our update and read
+			err = nonce.NewTokenError(nonce.ErrTokenUsed, n, t)
+		}
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+	}
+
+	n.IsUsed = true
+	n.ConsumedAt = t.Unix()
+	n.ConsumedIP, n.ConsumedUserAgent = meta.IP, meta.UserAgent
+	s.cfg.Remember(n, t)
+	s.cfg.Consumed(n)
+	return n, nil
+}
+
+func (s *service) ConsumeByID(id uuid.UUID, action string, uid uuid.UUID) (nonce.Nonce, error) {
+	// check and consume in one statement so concurrent callers can't both succeed
+	t := s.cfg.Clock().Now()
+	st, err := s.stmt(sqlConsumeByID)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	consume := func(action string) (int64, error) {
+		res, err := st.Exec(t.Unix(), id, action, uid, s.cfg.ExpiryCutoff(t), s.cfg.Namespace())
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+	rows, err := consume(action)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// read the nonce back to return it or to work out why it wasn't consumed
+	n, err := s.getNonceByID(id)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if rows == 0 {
+		err = s.cfg.CheckNonce(n, action, uid, t)
+		if err == nil && n.Action != action {
+			// action is in the nonce's scope, so consume it by its own
+			rows, err = consume(n.Action)
+		}
+		if err == nil && rows == 0 {
+			// another caller consumed it between our update and read
+			err = nonce.NewTokenError(nonce.ErrTokenUsed, n, t)
+		}
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+	}
+
+	n.IsUsed = true
+	n.ConsumedAt = t.Unix()
+	s.cfg.Remember(n, t)
+	s.cfg.Consumed(n)
+	return n, nil
+}
+
+func (s *service) Get(action string, uid uuid.UUID) (nonce.Nonce, error) {
+	if uid == uuid.Nil {
+		return nonce.Nonce{}, nonce.ErrUserRequired
+	}
+	// get Nonce data from database
+	st, err := s.stmt(sqlSelectByUser)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n := nonce.Nonce{}
+	t := s.cfg.Clock().Now()
+	err = st.Get(&n, action, uid, s.cfg.Namespace(), s.cfg.ExpiryCutoff(t))
+	if err != nil && err != sql.ErrNoRows {
+		return nonce.Nonce{}, err
+	}
+	n = s.cfg.Open(n)
+
+	// prefer a newer nonce this instance wrote if the read hasn't caught up
+	if w, ok := s.cfg.NewestRemembered(action, uid, t); ok && (err == sql.ErrNoRows || w.CreatedAt > n.CreatedAt) {
+		return w, nil
+	} else if err == sql.ErrNoRows {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+
+	n = s.cfg.Merge(n, t)
+	if !s.cfg.Usable(n, t) {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+	return n, nil
+}
+
+func (s *service) Renew(token string, extendBy time.Duration) (nonce.Nonce, error) {
+	// make sure token was passed
+	token, err := s.cfg.CheckToken(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	n, err := s.getNonce(token)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	t := s.cfg.Clock().Now()
+	n, err = s.cfg.RenewNonce(n, extendBy, t)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// only extend if nothing consumed or invalidated the nonce since we read it
+	st, err := s.stmt(sqlRenew)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	res, err := st.Exec(n.ExpiresAt, n.ID, s.cfg.ExpiryCutoff(t))
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if rows == 0 {
+		// lost a race; re-read so the caller gets the reason
+		cur, err := s.getNonce(token)
+		if err != nil {
+			return nonce.Nonce{}, err
+		}
+		_, err = s.cfg.RenewNonce(cur, extendBy, t)
+		if err == nil {
+			err = nonce.NewTokenError(nonce.ErrInvalidToken, cur, t)
+		}
+		return nonce.Nonce{}, err
+	}
+
+	s.cfg.Remember(n, t)
+	return n, nil
+}
+
+func (s *service) AwaitConsumption(ctx context.Context, id uuid.UUID) (nonce.Nonce, error) {
+	return s.cfg.Await(ctx, id, s.getNonceByID)
+}
+
+func (s *service) PutNonce(n nonce.Nonce) (nonce.Nonce, error) {
+	n, err := s.cfg.FillNonce(n, s.cfg.Clock().Now())
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	if n.ID == uuid.Nil {
+		n.ID = s.cfg.NewID()
+	}
+	sealed, err := s.cfg.Seal(n)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	// replace any existing nonce with the same token
+	insert, err := s.namedStmt(sqlInsertNonce)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	_, err = tx.Exec(s.sql.q(sqlDeleteByToken), n.Token, n.Namespace)
+	if err != nil {
+		s.rollback(tx)
+		return nonce.Nonce{}, err
+	}
+	_, err = tx.NamedStmt(insert).Exec(&sealed)
+	if err != nil {
+		s.rollback(tx)
+		return nonce.Nonce{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	s.cfg.Remember(n, s.cfg.Clock().Now())
+	return s.cfg.Issue(n), nil
+}
+
+// Shutdown stops the background goroutines, closing quit reaches every one of
+// them, and closes the prepared statements
+func (s *service) Shutdown() {
+	s.stop.Do(func() {
+		close(s.quit)
+		s.prepared.close()
+	})
+}
+
+// stmt returns query, rewritten for the Service's names, prepared
+func (s *service) stmt(query string) (*sqlx.Stmt, error) {
+	return s.prepared.stmt(s.sql.q(query))
+}
+
+// namedStmt is stmt for queries with :name parameters
+func (s *service) namedStmt(query string) (*sqlx.NamedStmt, error) {
+	return s.prepared.namedStmt(s.sql.q(query))
+}
+
+// commands lists the statements each method issues, for the debug journal
+func (s *service) Commands(method string) []string {
+	stmts := s.statements(method)
+	for i, stmt := range stmts {
+		stmts[i] = s.sql.q(stmt)
+	}
+	return stmts
+}
+
+func (s *service) statements(method string) []string {
+	switch method {
+	case "New", "NewBound", "NewWithPayload":
+		return []string{sqlInsertNonce, sqlInvalidateOthers}
+	case "Check", "CheckBound":
+		return []string{sqlSelectByToken}
+	case "Consume", "ConsumeWithMeta":
+		return []string{sqlSelectByToken, sqlConsume}
+	case "CheckThenConsume", "CheckThenConsumeWithMeta":
+		return []string{sqlCheckThenConsume, sqlSelectByToken}
+	case "ConsumeByID":
+		return []string{sqlConsumeByID, sqlSelectByID}
+	case "AwaitConsumption", "GetByID":
+		return []string{sqlSelectByID}
+	case "GetByToken":
+		return []string{sqlSelectByToken}
+	case "Get":
+		return []string{sqlSelectByUser}
+	case "Renew":
+		return []string{sqlSelectByToken, sqlRenew}
+	case "PutNonce":
+		return []string{sqlDeleteByToken, sqlInsertNonce}
+	case "ConsumeAndChain":
+		return []string{sqlSelectByToken, sqlCheckThenConsume, sqlInsertNonce, sqlInvalidateOthers}
+	}
+	return nil
+}
+
+// getNonce gets a Nonce from the database
+func (s *service) getNonce(token string) (nonce.Nonce, error) {
+	st, err := s.stmt(sqlSelectByToken)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n := nonce.Nonce{}
+	t := s.cfg.Clock().Now()
+	err = st.Get(&n, token, s.cfg.Namespace())
+	if err != nil && err != sql.ErrNoRows {
+		return nonce.Nonce{}, err
+	} else if err == sql.ErrNoRows {
+		// the read may lag behind a write this instance just made
+		if w, ok := s.cfg.Remembered(token, t); ok && s.cfg.InNamespace(w) {
+			return w, nil
+		}
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	}
+
+	return s.cfg.Merge(s.cfg.Open(n), t), nil
+}
+
+// getNonceByID gets the Nonce with id from the database
+func (s *service) getNonceByID(id uuid.UUID) (nonce.Nonce, error) {
+	st, err := s.stmt(sqlSelectByID)
+	if err != nil {
+		return nonce.Nonce{}, err
+	}
+	n := nonce.Nonce{}
+	err = st.Get(&n, id, s.cfg.Namespace())
+	if err == sql.ErrNoRows {
+		return nonce.Nonce{}, nonce.ErrTokenNotFound
+	} else if err != nil {
+		return nonce.Nonce{}, err
+	}
+
+	return s.cfg.Merge(s.cfg.Open(n), s.cfg.Clock().Now()), nil
+}
+
+// create inserts n under a new ID and invalidates the other nonces for its user
+// and action in one transaction, so a failure can't leave both of them valid.
+// If first isn't nil it runs in the transaction beforehand, and n isn't
+// inserted if it fails.
+func (s *service) create(n *nonce.Nonce, first func(tx *sqlx.Tx) error) ([]nonce.Nonce, error) {
+	insert, err := s.namedStmt(sqlInsertNonce)
+	if err != nil {
+		return nil, err
+	}
+	invalidate, err := s.namedStmt(sqlInvalidateOthers)
+	if err != nil {
+		return nil, err
+	}
+	n.ID = s.cfg.NewID()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	if first != nil {
+		err = first(tx)
+		if err != nil {
+			s.rollback(tx)
+			return nil, err
+		}
+	}
+	sealed, err := s.cfg.Seal(*n)
+	if err != nil {
+		s.rollback(tx)
+		return nil, err
+	}
+	_, err = tx.NamedStmt(insert).Exec(&sealed)
+	if err != nil {
+		s.rollback(tx)
+		return nil, err
+	}
+	if n.UserID == uuid.Nil {
+		return nil, tx.Commit()
+	}
+	others, err := s.invalidating(tx, *n)
+	if err != nil {
+		s.rollback(tx)
+		return nil, err
+	}
+	_, err = tx.NamedStmt(invalidate).Exec(n)
+	if err != nil {
+		s.rollback(tx)
+		return nil, err
+	}
+	return others, tx.Commit()
+}
+
+// invalidating reads the unused nonces New is about to invalidate for n, as they
+// will be afterwards. It only queries when an OnInvalidated hook needs them.
+func (s *service) invalidating(tx *sqlx.Tx, n nonce.Nonce) ([]nonce.Nonce, error) {
+	if s.cfg.Hooks().OnInvalidated == nil {
+		return nil, nil
+	}
+	var others []nonce.Nonce
+	err := tx.Select(&others, s.sql.q(sqlSelectOthers), n.UserID, n.Action, n.Namespace, n.ID)
+	for i := range others {
+		others[i] = s.cfg.Open(others[i])
+		others[i].IsValid = false
+	}
+	return others, err
+}
+
+// rollback rolls tx back, logging any failure since callers are already returning an error
+func (s *service) rollback(tx *sqlx.Tx) {
+	err := tx.Rollback()
+	if err != nil {
+		s.cfg.Logger().Printf("nonce: error rolling back transaction: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	// the tests run against sqlite3
+	_ "github.com/mattn/go-sqlite3"
+	uuid "github.com/satori/go.uuid"
+)
+
+const sqlCreateNonceTable string = `
+PRAGMA foreign_keys = OFF;
+-- Schema: nonce
+ATTACH "nonce.sdb" AS "nonce";
+BEGIN;
+CREATE TABLE "nonce"."nonce"(
+  "id" BINARY(16) NOT NULL,
+  "user_id" BINARY(16) NOT NULL,
+  "token" CHAR(88) NOT NULL,
+  "action" TEXT,
+  "salt" CHAR(24) NOT NULL,
+  "is_used" BOOL NOT NULL DEFAULT 0,
+  "is_valid" BOOL NOT NULL DEFAULT 1,
+  "created_at" INTEGER NOT NULL,
+  "expires_at" DATETIME NOT NULL,
+  "consumed_at" INTEGER NOT NULL DEFAULT 0,
+  "consumed_ip" TEXT NOT NULL DEFAULT '',
+  "consumed_user_agent" TEXT NOT NULL DEFAULT '',
+  "binding" TEXT NOT NULL DEFAULT '',
+  "parent_id" TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000',
+  "namespace" TEXT NOT NULL DEFAULT '',
+  "deleted_at" INTEGER NOT NULL DEFAULT 0,
+  "payload" TEXT NOT NULL DEFAULT ''
+);
+COMMIT;`
+
+// tNonce holds the testing data
+type NonceTest struct {
+	Action    string
+	UserID    uuid.UUID
+	ExpiresIn time.Duration
+}
+
+var tNonce = NonceTest{
+	Action:    "test-action",
+	UserID:    uuid.NewV4(),
+	ExpiresIn: time.Minute,
+}
+
+// testClock is a Clock that runs offset from the system time
+type testClock struct {
+	sync.Mutex
+	offset time.Duration
+}
+
+func (c *testClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+// Add moves the clock forward by d
+func (c *testClock) Add(d time.Duration) {
+	c.Lock()
+	c.offset += d
+	c.Unlock()
+}
+
+// waitForFirstSweep waits for the pass a Service makes on creation, so it
+// can't race with the sweeps a test makes
+func waitForFirstSweep(t *testing.T, s *service) {
+	for i := 0; ; i++ {
+		var st nonce.Stats
+		s.cfg.ReportSweeps(&st)
+		if st.Sweeps > 0 {
+			return
+		} else if i == 100 {
+			t.Fatal("Expected the Service to sweep on creation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// The rest of the Service is tested with every other store by the nonce
+// package's TestServices; these are the parts that need the database
+func TestService(t *testing.T) {
+	dbFile := "nonce.sdb"
+	// create database
+	db := sqlx.MustConnect("sqlite3", dbFile)
+	// create user table
+	db.MustExec(sqlCreateNonceTable)
+
+	t.Run("ReadYourWrites", func(t *testing.T) {
+		s := NewService(db, nonce.WithReadYourWrites(time.Minute))
+		defer s.Shutdown()
+
+		n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+		if err != nil {
+			t.Fatalf("Expected to add nonce to DB. Instead got the error: %v", err)
+		}
+
+		// simulate a read replica that hasn't seen the insert yet
+		db.MustExec("DELETE FROM nonce;")
+
+		err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+		if err != nil {
+			t.Fatalf("Expected nonce check to see our own write. Instead got the error: %v", err)
+		}
+		getN, err := s.Get(tNonce.Action, tNonce.UserID)
+		if err != nil {
+			t.Fatalf("Expected Get to see our own write. Instead got the error: %v", err)
+		}
+		if getN.ID != n.ID {
+			t.Fatalf("Expected Get to return the nonce just added. N: %s. getN: %s", n.ID.String(), getN.ID.String())
+		}
+
+		// simulate a replica that has the insert but not the renewal
+		n, _ = s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+		renewed, err := s.Renew(n.Token, time.Hour)
+		if err != nil {
+			t.Fatalf("Expected to renew nonce. Instead got the error: %v", err)
+		}
+		db.MustExec(db.Rebind("UPDATE nonce SET expires_at=? WHERE id=?;"), n.ExpiresAt, n.ID)
+		getN, err = s.Get(tNonce.Action, tNonce.UserID)
+		if err != nil || !getN.ExpiresAt.Equal(renewed.ExpiresAt) {
+			t.Fatalf("Expected Get to see our own renewal to %v. Instead got: %v, %v", renewed.ExpiresAt, getN.ExpiresAt, err)
+		}
+	})
+
+	t.Run("CheckSchema", func(t *testing.T) {
+		// the test table has no indexes
+		drift, err := CheckSchema(db)
+		if err != nil {
+			t.Fatalf("Expected to check schema. Instead got the error: %v", err)
+		}
+		if len(drift) != len(expectedIndexes) {
+			t.Fatalf("Expected every index to be reported missing. Instead got: %v", drift)
+		}
+
+		db.MustExec("CREATE INDEX nonce_token ON nonce (token);")
+		db.MustExec("CREATE INDEX nonce_user_action ON nonce (user_id, action, is_valid, created_at);")
+		db.MustExec("CREATE INDEX nonce_expires_at ON nonce (expires_at);")
+		drift, err = CheckSchema(db)
+		if err != nil {
+			t.Fatalf("Expected to check schema. Instead got the error: %v", err)
+		}
+		if len(drift) != 1 || drift[0].Kind != DriftIndexNotUnique {
+			t.Fatalf("Expected only the non unique token index to be reported. Instead got: %v", drift)
+		}
+
+		db.MustExec("DROP INDEX nonce_token;")
+		db.MustExec("DROP INDEX nonce_user_action;")
+		db.MustExec("DROP INDEX nonce_expires_at;")
+	})
+
+	// Drop the Table(s) we created
+	// Close the DB
+	db.MustExec("drop table nonce;")
+	db.Close()
+	err := os.Remove(dbFile)
+	if err != nil {
+		t.Fatalf("Expected to remove dbFile: %s. Instead got the error: %v", dbFile, err)
+	}
+}
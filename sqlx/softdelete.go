@@ -0,0 +1,104 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// sqlTombstoneExpiredIn is sqlDeleteExpiredIn for WithSoftDelete
+	sqlTombstoneExpiredIn = `UPDATE nonce SET deleted_at = ? WHERE expires_at < ? AND deleted_at = 0 AND id IN (?)`
+	sqlDeleteTombstonesIn = `DELETE FROM nonce WHERE deleted_at > 0 AND deleted_at < ? AND id IN (?)`
+	sqlUndelete           = `UPDATE nonce SET deleted_at = 0 WHERE deleted_at > 0 AND deleted_at >= ? AND namespace = ?`
+)
+
+// WithSoftDelete makes the sqlx backend's sweeps mark expired nonces deleted,
+// setting deleted_at, instead of deleting them, so an accidental mass expiry
+// can be investigated with List and Filter.Deleted and rolled back with
+// Undelete. Tombstones are skipped by everything else and deleted for good
+// by PurgeDeleted once they are keep old; each sweep runs it when keep is
+// positive. It needs migration 0009. Other backends ignore it.
+func WithSoftDelete(keep time.Duration) nonce.Option {
+	return nonce.BackendOption(optSoftDelete, softDeleteOption{keep: keep})
+}
+
+func (s *service) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	ids := make([]uuid.UUID, 0, s.cfg.PurgeBatchSize())
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+		query, args, err := sqlx.In(s.sql.rewrite(sqlDeleteTombstonesIn), before.Unix(), ids)
+		if err != nil {
+			return err
+		}
+		res, err := s.db.ExecContext(ctx, s.db.Rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		purged += rows
+		ids = ids[:0]
+		return err
+	}
+
+	err := s.List(ctx, s.cfg.Unscoped(nonce.Filter{Deleted: true}), func(n nonce.Nonce) error {
+		if n.DeletedAt >= before.Unix() {
+			return nil
+		}
+		ids = append(ids, n.ID)
+		if len(ids) >= s.cfg.PurgeBatchSize() {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return purged, err
+}
+
+func (s *service) Undelete(ctx context.Context, since time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.sql.q(sqlUndelete), since.Unix(), s.cfg.Namespace())
+	var rows int64
+	if err == nil {
+		rows, err = res.RowsAffected()
+	}
+	s.cfg.Audit(nonce.AuditEvent{
+		Method:   "Undelete",
+		Detail:   fmt.Sprintf("tombstoned since %s", since.UTC().Format(time.RFC3339)),
+		Affected: int(rows),
+		Err:      err,
+	})
+	return rows, err
+}
+
+// purgeTombstones runs PurgeDeleted for the nonces tombstoned more than
+// keepDeleted ago, at the end of a sweep
+func (s *service) purgeTombstones(ctx context.Context) error {
+	if !s.opts.softDelete || s.opts.keepDeleted <= 0 {
+		return nil
+	}
+	_, err := s.PurgeDeleted(ctx, s.cfg.Clock().Now().Add(-s.opts.keepDeleted))
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestSoftDelete(t *testing.T) {
+	db := newPreparedTestDB(t)
+	defer db.Close()
+	clock := &testClock{}
+	s := NewService(db, nonce.WithClock(clock), WithSoftDelete(time.Hour)).(*service)
+	defer s.Shutdown()
+	ctx := context.Background()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	clock.Add(2 * time.Minute)
+	start := clock.Now().Add(-time.Second)
+	purged, err := s.PurgeExpired(ctx, 0)
+	if err != nil || purged != 1 {
+		t.Fatalf("Expected the expired nonce to be tombstoned. Instead got: %d, %v", purged, err)
+	}
+	stored, err := s.GetByID(n.ID)
+	if err != nil || stored.DeletedAt == 0 {
+		t.Fatalf("Expected the tombstone to be kept. Instead got: %+v, %v", stored, err)
+	}
+	if got := countListed(t, s, nonce.Filter{}); got != 0 {
+		t.Errorf("Expected List to skip tombstones. Instead got: %d", got)
+	}
+	if got := countListed(t, s, nonce.Filter{Deleted: true}); got != 1 {
+		t.Errorf("Expected List to find the tombstone. Instead got: %d", got)
+	}
+	purged, _ = s.PurgeExpired(ctx, 0)
+	if purged != 0 {
+		t.Errorf("Expected a tombstone not to be tombstoned again. Instead got: %d", purged)
+	}
+
+	// roll back the expiry
+	restored, err := s.Undelete(ctx, start)
+	if err != nil || restored != 1 {
+		t.Fatalf("Expected the tombstone to be restored. Instead got: %d, %v", restored, err)
+	}
+	_, err = s.ExtendExpiry(nonce.Filter{}, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected to revive the nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(n.Token, tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Errorf("Expected the restored nonce to check out. Instead got the error: %v", err)
+	}
+
+	// tombstones older than keep go for good
+	clock.Add(2 * time.Hour)
+	s.PurgeExpired(ctx, 0)
+	if got := countListed(t, s, nonce.Filter{Deleted: true}); got != 1 {
+		t.Fatalf("Expected a fresh tombstone. Instead got: %d", got)
+	}
+	clock.Add(2 * time.Hour)
+	s.PurgeExpired(ctx, 0)
+	_, err = s.GetByID(n.ID)
+	if err != nonce.ErrTokenNotFound {
+		t.Errorf("Expected the old tombstone to be purged. Instead got: %v", err)
+	}
+}
+
+func TestDeleteAllForUserTombstones(t *testing.T) {
+	db := newPreparedTestDB(t)
+	defer db.Close()
+	clock := &testClock{}
+	s := NewService(db, nonce.WithClock(clock), WithSoftDelete(time.Hour)).(*service)
+	defer s.Shutdown()
+	ctx := context.Background()
+	uid := uuid.NewV4()
+
+	_, err := s.New("expired", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	clock.Add(2 * time.Minute)
+	s.PurgeExpired(ctx, 0)
+	s.New(tNonce.Action, uid, time.Hour)
+
+	// the tombstone goes with the outstanding nonce
+	deleted, err := s.DeleteAllForUser(ctx, uid)
+	if err != nil || deleted != 2 {
+		t.Fatalf("Expected 2 nonces to be deleted. Instead got: %d, %v", deleted, err)
+	}
+	if got := countListed(t, s, nonce.Filter{UserID: uid, Deleted: true}); got != 0 {
+		t.Errorf("Expected no tombstones left for the user. Instead got: %d", got)
+	}
+}
+
+func countListed(t *testing.T, l nonce.Lister, f nonce.Filter) int {
+	n := 0
+	err := l.List(context.Background(), f, func(nonce.Nonce) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected to list nonces. Instead got the error: %v", err)
+	}
+	return n
+}
@@ -0,0 +1,56 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlx
+
+import (
+	"context"
+
+	"github.com/bryanjeal/go-nonce"
+)
+
+// sqlStats counts the nonces for each action in one pass over the table
+const sqlStats = `SELECT action,
+		SUM(CASE WHEN is_used = FALSE AND is_valid = TRUE AND expires_at > ? THEN 1 ELSE 0 END),
+		SUM(CASE WHEN is_used = TRUE THEN 1 ELSE 0 END),
+		SUM(CASE WHEN is_used = FALSE AND is_valid = FALSE THEN 1 ELSE 0 END),
+		SUM(CASE WHEN is_used = FALSE AND is_valid = TRUE AND expires_at <= ? THEN 1 ELSE 0 END)
+	FROM nonce WHERE namespace = ? GROUP BY action`
+
+func (s *service) Stats(ctx context.Context) (nonce.Stats, error) {
+	t := s.cfg.Clock().Now()
+	rows, err := s.db.QueryContext(ctx, s.sql.q(sqlStats), t, t, s.cfg.Namespace())
+	if err != nil {
+		return nonce.Stats{}, err
+	}
+	defer rows.Close()
+
+	st := nonce.Stats{ByAction: make(map[string]nonce.Counts)}
+	for rows.Next() {
+		var action string
+		var c nonce.Counts
+		err = rows.Scan(&action, &c.Active, &c.Used, &c.Invalid, &c.Expired)
+		if err != nil {
+			return nonce.Stats{}, err
+		}
+		st.ByAction[action] = c
+		st.Counts.Add(c)
+	}
+	if err = rows.Err(); err != nil {
+		return nonce.Stats{}, err
+	}
+	st.Entries = st.Counts.Total()
+	s.cfg.ReportSweeps(&st)
+	return st, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats describes what a Service is holding
+type Stats struct {
+	// Counts splits the stored nonces by state and ByAction splits them again by action
+	Counts
+	ByAction map[string]Counts
+
+	// Entries is how many nonces are stored, including used and expired
+	// ones that haven't been removed yet
+	Entries int
+
+	// MaxEntries is the WithMaxEntries bound, or 0 if there is none
+	MaxEntries int
+
+	// Evicted is how many nonces have been evicted to stay within MaxEntries
+	Evicted int64
+
+	// Sweeps is how many passes the goroutine removing expired nonces has
+	// made, Purged how many nonces they removed and LastPurged how many the
+	// last pass removed. See Hooks.OnSwept.
+	Sweeps, Purged, LastPurged int64
+}
+
+// Counts are how many stored nonces are in each state
+type Counts struct {
+	// Active nonces are unused, valid and unexpired
+	Active int
+	// Used nonces have been consumed, whether or not they have expired since
+	Used int
+	// Invalid nonces were replaced by a newer one or evicted before being used
+	Invalid int
+	// Expired nonces are unused and valid but past ExpiresAt, waiting to be purged
+	Expired int
+}
+
+// StatsReporter is implemented by Services that can report their Stats
+type StatsReporter interface {
+	Stats(ctx context.Context) (Stats, error)
+}
+
+// Stats reads counters kept as nonces are stored, so only the nonces due to be
+// purged are visited to tell which unused ones have expired
+func (s *nonceInMemoryService) Stats(ctx context.Context) (Stats, error) {
+	st := Stats{
+		ByAction:   s.store.counts.byAction(),
+		Entries:    int(atomic.LoadInt64(&s.store.size)),
+		MaxEntries: s.store.max,
+		Evicted:    atomic.LoadInt64(&s.store.evicted),
+	}
+
+	t := s.cfg.clock.Now()
+	seen := make(map[string]bool)
+	for _, token := range s.store.expiry.due(t) {
+		n, ok := s.store.get(token)
+		if !ok || seen[token] || n.IsUsed || !n.IsValid || n.ExpiresAt.After(t) {
+			continue
+		}
+		seen[token] = true
+		c, ok := st.ByAction[n.Action]
+		if !ok {
+			// stored after the counters were read
+			continue
+		}
+		c.Active--
+		c.Expired++
+		st.ByAction[n.Action] = c
+	}
+	for _, c := range st.ByAction {
+		st.Counts.Add(c)
+	}
+	s.sweeps.report(&st)
+	return st, nil
+}
+
+// Add adds o's counts to c
+func (c *Counts) Add(o Counts) {
+	c.Active += o.Active
+	c.Used += o.Used
+	c.Invalid += o.Invalid
+	c.Expired += o.Expired
+}
+
+// Total is how many nonces c counts in any state
+func (c Counts) Total() int {
+	return c.Active + c.Used + c.Invalid + c.Expired
+}
+
+// count adds d to the state n is in, counting unused valid nonces as Active
+func (c *Counts) count(n Nonce, d int) {
+	switch {
+	case n.IsUsed:
+		c.Used += d
+	case !n.IsValid:
+		c.Invalid += d
+	default:
+		c.Active += d
+	}
+}
+
+// inMemCounts keeps Counts for each action as nonces are stored, replaced and
+// removed. It is locked after a shard lock, never before one.
+type inMemCounts struct {
+	sync.Mutex
+	actions map[string]Counts
+}
+
+// move counts n instead of old. ok and stored say whether there are an old and
+// a new nonce.
+func (c *inMemCounts) move(old Nonce, ok bool, n Nonce, stored bool) {
+	c.Lock()
+	defer c.Unlock()
+	if c.actions == nil {
+		c.actions = make(map[string]Counts)
+	}
+	if ok {
+		ac := c.actions[old.Action]
+		ac.count(old, -1)
+		if ac.Total() == 0 {
+			delete(c.actions, old.Action)
+		} else {
+			c.actions[old.Action] = ac
+		}
+	}
+	if stored {
+		ac := c.actions[n.Action]
+		ac.count(n, 1)
+		c.actions[n.Action] = ac
+	}
+}
+
+func (c *inMemCounts) byAction() map[string]Counts {
+	c.Lock()
+	defer c.Unlock()
+	m := make(map[string]Counts, len(c.actions))
+	for k, v := range c.actions {
+		m[k] = v
+	}
+	return m
+}
+
+func (c *inMemCounts) reset() {
+	c.Lock()
+	c.actions = nil
+	c.Unlock()
+}
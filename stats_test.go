@@ -0,0 +1,79 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestStats(t *testing.T) {
+	// keep the sweep from purging the expired nonces being counted
+	interval := RemoveExpiredInterval
+	RemoveExpiredInterval = time.Hour
+	defer func() { RemoveExpiredInterval = interval }()
+
+	for name, newService := range map[string]func(clock Clock) Service{
+		"sqlx": func(clock Clock) Service {
+			return openTestStore(t, "sqlx")(WithClock(clock))
+		},
+		"inmem": func(clock Clock) Service {
+			return NewInMemoryService(WithClock(clock), WithRateLimit("", 100, time.Minute))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := &testClock{}
+			s := newService(clock)
+			defer s.Shutdown()
+
+			u1, u2, u3 := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+			mustNew := func(action string, uid uuid.UUID, d time.Duration) Nonce {
+				n, err := s.New(action, uid, d)
+				if err != nil {
+					t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+				}
+				return n
+			}
+			mustNew("login", u1, time.Minute)
+			mustNew("login", u1, time.Hour)
+			used := mustNew("reset", u2, time.Hour)
+			mustNew("reset", u3, time.Minute)
+			_, err := s.Consume(used.Token)
+			if err != nil {
+				t.Fatalf("Expected to consume nonce. Instead got the error: %v", err)
+			}
+			clock.Add(2 * time.Minute)
+
+			st, err := s.(StatsReporter).Stats(context.Background())
+			if err != nil {
+				t.Fatalf("Expected Stats to succeed. Instead got the error: %v", err)
+			}
+			want := map[string]Counts{
+				"login": {Active: 1, Invalid: 1},
+				"reset": {Used: 1, Expired: 1},
+			}
+			if !reflect.DeepEqual(st.ByAction, want) {
+				t.Fatalf("Expected per action counts %+v. Instead got: %+v", want, st.ByAction)
+			}
+			if st.Counts != (Counts{Active: 1, Used: 1, Invalid: 1, Expired: 1}) || st.Entries != 4 {
+				t.Fatalf("Expected one nonce in each state. Instead got: %+v", st)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"strconv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// SubjectID identifies who a nonce is bound to in systems that don't key
+// users by UUID, e.g. an email address or a database row ID. The empty
+// SubjectID is the anonymous user.
+type SubjectID string
+
+// UserSubject is the SubjectID for uid; nonces for it are stored under uid
+func UserSubject(uid uuid.UUID) SubjectID {
+	if uid == uuid.Nil {
+		return ""
+	}
+	return SubjectID(uid.String())
+}
+
+// Int64Subject is the SubjectID for a numeric user ID
+func Int64Subject(id int64) SubjectID {
+	return SubjectID(strconv.FormatInt(id, 10))
+}
+
+// SubjectNamespace is the namespace SubjectIDs that aren't UUIDs are hashed
+// in to give the user ID their nonces are stored under
+var SubjectNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/bryanjeal/go-nonce/subject")
+
+// UUID is the user ID nonces for s are stored under: uuid.Nil for the empty
+// SubjectID, the UUID itself if s is one, and otherwise a version 5 UUID of s
+// in SubjectNamespace, so the same subject always maps to the same user ID
+func (s SubjectID) UUID() uuid.UUID {
+	if s == "" {
+		return uuid.Nil
+	}
+	if uid, err := uuid.FromString(string(s)); err == nil {
+		return uid
+	}
+	return uuid.NewV5(SubjectNamespace, string(s))
+}
+
+// SubjectService is a Service whose nonces are bound to SubjectIDs. Nonces
+// it returns carry the UUID of their subject in UserID.
+type SubjectService interface {
+	New(action string, sub SubjectID, expiresIn time.Duration) (Nonce, error)
+	Check(token, action string, sub SubjectID) error
+	Consume(token string) (Nonce, error)
+	CheckThenConsume(token, action string, sub SubjectID) (Nonce, error)
+	ConsumeByID(id uuid.UUID, action string, sub SubjectID) (Nonce, error)
+	Get(action string, sub SubjectID) (Nonce, error)
+	Renew(token string, extendBy time.Duration) (Nonce, error)
+	Shutdown()
+
+	// Service is the Service nonces are stored in
+	Service() Service
+}
+
+// subjectService binds the nonces of a Service to SubjectIDs
+type subjectService struct {
+	s Service
+}
+
+// NewSubjectService creates a SubjectService that stores its nonces in s.
+// Nonces created through either can be used through the other, so code keyed
+// by UUID can keep calling s while the rest moves to SubjectIDs.
+func NewSubjectService(s Service) SubjectService {
+	return &subjectService{s: s}
+}
+
+func (s *subjectService) New(action string, sub SubjectID, expiresIn time.Duration) (Nonce, error) {
+	return s.s.New(action, sub.UUID(), expiresIn)
+}
+
+func (s *subjectService) Check(token, action string, sub SubjectID) error {
+	return s.s.Check(token, action, sub.UUID())
+}
+
+func (s *subjectService) Consume(token string) (Nonce, error) {
+	return s.s.Consume(token)
+}
+
+func (s *subjectService) CheckThenConsume(token, action string, sub SubjectID) (Nonce, error) {
+	return s.s.CheckThenConsume(token, action, sub.UUID())
+}
+
+func (s *subjectService) ConsumeByID(id uuid.UUID, action string, sub SubjectID) (Nonce, error) {
+	return s.s.ConsumeByID(id, action, sub.UUID())
+}
+
+func (s *subjectService) Get(action string, sub SubjectID) (Nonce, error) {
+	return s.s.Get(action, sub.UUID())
+}
+
+func (s *subjectService) Renew(token string, extendBy time.Duration) (Nonce, error) {
+	return s.s.Renew(token, extendBy)
+}
+
+func (s *subjectService) Shutdown() {
+	s.s.Shutdown()
+}
+
+func (s *subjectService) Service() Service {
+	return s.s
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestSubjectID(t *testing.T) {
+	uid := uuid.NewV4()
+	if got := UserSubject(uid).UUID(); got != uid {
+		t.Errorf("Expected a UUID subject to map to itself. Instead got %v for %v", got, uid)
+	}
+	if got := SubjectID("").UUID(); got != uuid.Nil {
+		t.Errorf("Expected the empty subject to map to uuid.Nil. Instead got %v", got)
+	}
+	email := SubjectID("user@example.com")
+	if email.UUID() != email.UUID() || email.UUID() == Int64Subject(42).UUID() {
+		t.Errorf("Expected subjects to map to stable, distinct user IDs")
+	}
+}
+
+func TestSubjectService(t *testing.T) {
+	s := NewSubjectService(NewInMemoryService())
+	defer s.Shutdown()
+
+	sub := Int64Subject(42)
+	n, err := s.New("login", sub, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	if n.UserID != sub.UUID() {
+		t.Errorf("Expected the nonce's UserID to be %v. Instead got %v", sub.UUID(), n.UserID)
+	}
+	err = s.Check(n.Token, "login", Int64Subject(43))
+	if err == nil {
+		t.Errorf("Expected Check to reject another subject")
+	}
+	err = s.Service().Check(n.Token, "login", sub.UUID())
+	if err != nil {
+		t.Errorf("Expected the underlying Service to accept the nonce. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume(n.Token, "login", sub)
+	if err != nil {
+		t.Errorf("Expected to consume nonce. Instead got: %v", err)
+	}
+}
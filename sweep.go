@@ -0,0 +1,147 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// removeExpired sweeps expired nonces away with purge straight away and then
+// every removeExpiredInterval, plus up to removeExpiredJitter, until quit is
+// closed. Closing quit cancels a sweep that is running. A sweep that fails or
+// panics is logged and the next one runs on schedule.
+func (c config) removeExpired(quit <-chan struct{}, sweeps *sweepTracker, purge func(ctx context.Context, limit int) (int64, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	t := time.NewTicker(c.removeExpiredInterval)
+	defer t.Stop()
+	for {
+		c.sweep(ctx, sweeps, purge)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if c.removeExpiredJitter > 0 {
+			jitter := time.NewTimer(time.Duration(rand.Int63n(int64(c.removeExpiredJitter))))
+			select {
+			case <-ctx.Done():
+				jitter.Stop()
+				return
+			case <-jitter.C:
+			}
+		}
+	}
+}
+
+// sweep runs one purge, recording it and calling OnSwept unless it was cancelled
+func (c config) sweep(ctx context.Context, sweeps *sweepTracker, purge func(ctx context.Context, limit int) (int64, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Printf("nonce: panic removing expired nonces: %v", r)
+		}
+	}()
+
+	start := time.Now()
+	purged, err := purge(ctx, 0)
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	r := SweepResult{At: start, Duration: time.Since(start), Purged: purged, Err: err}
+	sweeps.done(r)
+	c.swept(r)
+	if err != nil {
+		c.logger.Printf("nonce: error removing expired nonces: %v", err)
+		return
+	}
+	c.counters.swept(purged, r.Duration)
+}
+
+// SweepResult describes one pass of the goroutine removing expired nonces
+type SweepResult struct {
+	// At is when the pass started and Duration how long it took
+	At       time.Time
+	Duration time.Duration
+
+	// Purged is how many expired nonces it removed, including those removed
+	// before it failed
+	Purged int64
+
+	// Err is what it failed with, if it did
+	Err error
+}
+
+// sweepTracker records the passes of a removeExpired goroutine
+type sweepTracker struct {
+	sync.RWMutex
+	// last is when a pass last succeeded
+	last  time.Time
+	stall time.Duration
+
+	passes, purged int64
+	lastPurged     int64
+}
+
+func newSweepTracker(cfg config) *sweepTracker {
+	return &sweepTracker{
+		last:  time.Now(),
+		stall: time.Duration(cfg.sweepStallIntervals)*cfg.removeExpiredInterval + cfg.removeExpiredJitter,
+	}
+}
+
+// done records a finished pass
+func (t *sweepTracker) done(r SweepResult) {
+	t.Lock()
+	defer t.Unlock()
+	t.passes++
+	t.purged += r.Purged
+	t.lastPurged = r.Purged
+	if r.Err == nil {
+		t.last = time.Now()
+	}
+}
+
+// report adds the passes to st
+func (t *sweepTracker) report(st *Stats) {
+	t.RLock()
+	defer t.RUnlock()
+	st.Sweeps, st.Purged, st.LastPurged = t.passes, t.purged, t.lastPurged
+}
+
+func (t *sweepTracker) lastSweep() time.Time {
+	t.RLock()
+	defer t.RUnlock()
+	return t.last
+}
+
+// check returns ErrSweepStalled if the last sweep is too long ago
+func (t *sweepTracker) check() error {
+	if time.Since(t.lastSweep()) > t.stall {
+		return ErrSweepStalled
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sweepLogger collects what a sweeper logs
+type sweepLogger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *sweepLogger) Printf(format string, v ...interface{}) {
+	l.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+	l.Unlock()
+}
+
+func (l *sweepLogger) logged() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+func TestRemoveExpiredRecoversAndStops(t *testing.T) {
+	logs := &sweepLogger{}
+	cfg := newConfig([]Option{WithLogger(logs)})
+	cfg.removeExpiredInterval = time.Millisecond
+	cfg.removeExpiredJitter = time.Millisecond
+	sweeps := newSweepTracker(cfg)
+
+	calls := make(chan int, 100)
+	n := 0
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		cfg.removeExpired(quit, sweeps, func(ctx context.Context, limit int) (int64, error) {
+			n++
+			calls <- n
+			switch n {
+			case 1:
+				panic("boom")
+			case 2:
+				return 0, fmt.Errorf("database is locked")
+			case 3:
+				return 1, nil
+			}
+			// block until Shutdown cancels the sweep
+			<-ctx.Done()
+			return 0, ctx.Err()
+		})
+		close(done)
+	}()
+
+	for want := 1; want <= 4; want++ {
+		select {
+		case got := <-calls:
+			if got != want {
+				t.Fatalf("Expected sweep %d. Instead got: %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected sweep %d to run after the earlier ones failed", want)
+		}
+	}
+	close(quit)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected closing quit to cancel the running sweep")
+	}
+
+	lines := logs.logged()
+	if len(lines) != 2 || !strings.Contains(lines[0], "panic") || !strings.Contains(lines[1], "database is locked") {
+		t.Fatalf("Expected the panic and the error to be logged, not the cancellation. Instead got: %q", lines)
+	}
+	if time.Since(sweeps.lastSweep()) > time.Second {
+		t.Fatalf("Expected the successful sweep to be recorded. Instead got: %v", sweeps.lastSweep())
+	}
+	var st Stats
+	sweeps.report(&st)
+	if st.Sweeps != 2 || st.Purged != 1 || st.LastPurged != 1 {
+		t.Fatalf("Expected the failed and successful passes to be counted. Instead got: %+v", st)
+	}
+}
+
+func TestOnSwept(t *testing.T) {
+	interval := RemoveExpiredInterval
+	RemoveExpiredInterval = 10 * time.Millisecond
+	defer func() { RemoveExpiredInterval = interval }()
+
+	clock := &testClock{}
+	results := make(chan SweepResult, 100)
+	s := NewInMemoryService(WithClock(clock), WithHooks(Hooks{OnSwept: func(r SweepResult) { results <- r }}))
+	defer s.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		_, err := s.New(tNonce.Action, tNonce.UserID, time.Minute)
+		if err != nil {
+			t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+		}
+	}
+	clock.Add(time.Hour)
+
+	timeout := time.After(time.Second)
+	for purged := int64(0); purged < 2; {
+		select {
+		case r := <-results:
+			if r.Err != nil {
+				t.Fatalf("Expected the sweep to succeed. Instead got the error: %v", r.Err)
+			}
+			purged += r.Purged
+		case <-timeout:
+			t.Fatal("Expected OnSwept to report both expired nonces purged")
+		}
+	}
+
+	st, err := s.(StatsReporter).Stats(context.Background())
+	if err != nil || st.Purged != 2 || st.Sweeps < 1 {
+		t.Fatalf("Expected Stats to count the purged nonces. Instead got: %+v, %v", st, err)
+	}
+}
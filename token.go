@@ -0,0 +1,126 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"strings"
+)
+
+// TokenVersion is the version WithVersionedTokens puts in front of tokens.
+// Tokens without a version segment are version 1.
+const TokenVersion = 2
+
+// TokenInfo describes a token's format
+type TokenInfo struct {
+	// Version is the token's format version: TokenVersion for tokens starting
+	// "v2.", 1 for the unprefixed tokens issued before versions existed
+	Version int
+
+	// Pepper is the id of the WithPepper secret the token was issued under,
+	// from its "p<id>." segment, or 0 for a token without one
+	Pepper int
+
+	// Body is the token without its version and pepper segments, the
+	// TokenEncoding of Size bytes from the Hasher that made it
+	Body string
+	Size int
+}
+
+// ParseToken reads the format of token, which should already be normalized.
+// It returns ErrNoToken for an empty token and ErrInvalidToken for one with a
+// version this package doesn't know or a body that isn't base64.
+func ParseToken(token string) (TokenInfo, error) {
+	return ParseEncodedToken(token, Base64URL)
+}
+
+// ParseEncodedToken is ParseToken for tokens issued WithTokenEncoding(e)
+func ParseEncodedToken(token string, e TokenEncoding) (TokenInfo, error) {
+	if token == "" {
+		return TokenInfo{}, ErrNoToken
+	}
+	prefix, body := splitTokenVersion(token)
+	pepper, _, body := splitTokenPepper(body)
+	info := TokenInfo{Version: 1, Pepper: pepper, Body: body}
+	if prefix != "" {
+		v, err := strconv.Atoi(prefix[1 : len(prefix)-1])
+		if err != nil || v != TokenVersion {
+			return TokenInfo{}, ErrInvalidToken
+		}
+		info.Version = v
+	}
+	raw, err := e.DecodeString(body)
+	if err != nil || len(raw) == 0 {
+		return TokenInfo{}, ErrInvalidToken
+	}
+	info.Size = len(raw)
+	return info, nil
+}
+
+// WithVersionedTokens makes New issue tokens that start with "v2.", so a later
+// format can be told apart from them. Both forms are accepted either way, so
+// it can be rolled out one instance at a time. The sqlx backend needs
+// migration 0008 to widen the token column.
+func WithVersionedTokens() Option {
+	return func(cfg *config) {
+		cfg.versionTokens = true
+	}
+}
+
+// versionToken returns body as a token in the configured format
+func (c config) versionToken(body string) string {
+	if !c.versionTokens {
+		return body
+	}
+	return "v" + strconv.Itoa(TokenVersion) + "." + body
+}
+
+// splitTokenVersion splits a leading "v<n>." off token. '.' isn't in any
+// built in TokenEncoding's alphabet, so an unversioned token never has one.
+func splitTokenVersion(token string) (prefix, body string) {
+	i := strings.IndexByte(token, '.')
+	if i < 2 || token[0] != 'v' {
+		return "", token
+	}
+	for _, r := range token[1:i] {
+		if r < '0' || r > '9' {
+			return "", token
+		}
+	}
+	return token[:i+1], token[i+1:]
+}
+
+// NormalizeToken undoes the usual ways a Base64URL token is mangled on its way
+// through a URL or an email: surrounding whitespace and punctuation added or
+// kept by mail clients, line breaks from wrapping, '+' decoded to a space, '='
+// left percent encoded, the standard base64 alphabet instead of the URL one
+// and lost padding. A token that isn't mangled is returned unchanged. A
+// version segment is kept as it is and the rest normalized.
+func NormalizeToken(token string) string {
+	return normalizeToken(token, Base64URL)
+}
+
+// constantTimeEqual compares a presented token, or a hash of something
+// presented, with a stored one in time that depends only on their lengths.
+//
+// Backends find a nonce by looking its token up in an index or a map, whose
+// timing depends on how the token hashes rather than on how much of it matches
+// a stored one, then check the nonce they found. Anywhere a presented value is
+// compared with a stored one in Go it goes through here, never ==. Under
+// WithTokenHashing the lookups only ever see an HMAC of the presented token.
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
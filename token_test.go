@@ -0,0 +1,130 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeToken(t *testing.T) {
+	// "AQID++++////EA==" in the standard alphabet
+	raw := []byte{0x01, 0x02, 0x03, 0xfb, 0xef, 0xbe, 0xff, 0xff, 0xff, 0x10}
+	token := base64.URLEncoding.EncodeToString(raw)
+	std := base64.StdEncoding.EncodeToString(raw)
+
+	mangled := []string{
+		token,
+		token + ".",
+		"<" + token + ">",
+		" " + token + "\r\n",
+		strings.TrimRight(token, "="),
+		strings.Replace(token, "=", "%3D", -1),
+		token[:4] + "\r\n" + token[4:],
+		std,
+		strings.Replace(std, "+", " ", -1),
+	}
+	for _, m := range mangled {
+		if got := NormalizeToken(m); got != token {
+			t.Fatalf("Expected %q to normalize to %q. Instead got: %q", m, token, got)
+		}
+	}
+}
+
+func TestCheckMangledToken(t *testing.T) {
+	s := NewInMemoryService()
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	err = s.Check(strings.TrimRight(n.Token, "=")+".", tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Fatalf("Expected the mangled token to check out. Instead got the error: %v", err)
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	body := base64.URLEncoding.EncodeToString(make([]byte, 64))
+	for token, want := range map[string]TokenInfo{
+		body:            {Version: 1, Body: body, Size: 64},
+		"v2." + body:    {Version: 2, Body: body, Size: 64},
+		"p3." + body:    {Version: 1, Pepper: 3, Body: body, Size: 64},
+		"v2.p3." + body: {Version: 2, Pepper: 3, Body: body, Size: 64},
+	} {
+		got, err := ParseToken(token)
+		if err != nil || got != want {
+			t.Errorf("Expected %q to parse as %+v. Instead got: %+v, %v", token, want, got, err)
+		}
+	}
+	for _, token := range []string{"v3." + body, "v1." + body, "v2.", "not base64!"} {
+		_, err := ParseToken(token)
+		if err != ErrInvalidToken {
+			t.Errorf("Expected %q to return %v. Instead got: %v", token, ErrInvalidToken, err)
+		}
+	}
+	_, err := ParseToken("")
+	if err != ErrNoToken {
+		t.Errorf("Expected an empty token to return %v. Instead got: %v", ErrNoToken, err)
+	}
+}
+
+func TestVersionedTokens(t *testing.T) {
+	newService := openTestStore(t, "sqlx")
+	s := newService(WithVersionedTokens())
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil {
+		t.Fatalf("Expected to add nonce. Instead got the error: %v", err)
+	}
+	info, err := ParseToken(n.Token)
+	if err != nil || info.Version != TokenVersion || !strings.HasPrefix(n.Token, "v2.") {
+		t.Fatalf("Expected a version %d token. Instead got: %q, %+v, %v", TokenVersion, n.Token, info, err)
+	}
+	err = s.Check("<"+strings.TrimRight(n.Token, "=")+">", tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Errorf("Expected the mangled versioned token to check out. Instead got the error: %v", err)
+	}
+
+	// an instance still issuing unversioned tokens accepts it during a rolling upgrade
+	old := newService()
+	defer old.Shutdown()
+	_, err = old.Consume(n.Token)
+	if err != nil {
+		t.Errorf("Expected an unversioned Service to consume the token. Instead got the error: %v", err)
+	}
+}
+
+func FuzzNormalizeToken(f *testing.F) {
+	f.Add([]byte{0xfb, 0xff, 0xbf, 0x01})
+	f.Add([]byte("a token"))
+	f.Add([]byte("v2.a token"))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		token := base64.URLEncoding.EncodeToString(raw)
+		if got := NormalizeToken(token); got != token {
+			t.Fatalf("Expected %q to be left unchanged. Instead got: %q", token, got)
+		}
+
+		// normalizing arbitrary input is idempotent
+		s := string(raw)
+		once := NormalizeToken(s)
+		if twice := NormalizeToken(once); twice != once {
+			t.Fatalf("Expected normalizing %q to be idempotent. Instead got: %q then %q", s, once, twice)
+		}
+	})
+}
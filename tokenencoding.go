@@ -0,0 +1,127 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// TokenEncoding turns the bytes from a Hasher into the body of a token and back
+type TokenEncoding interface {
+	EncodeToString(src []byte) string
+	DecodeString(s string) ([]byte, error)
+
+	// EncodedLen is the length of the encoding of n bytes
+	EncodedLen(n int) int
+
+	// Normalize undoes the ways body may be mangled in transit, returning
+	// a body that isn't mangled unchanged
+	Normalize(body string) string
+}
+
+// Built in TokenEncodings.
+// Base64URL is the default, its tokens ending in '=' padding.
+// Base64RawURL drops the padding, so tokens can be used as a URL path segment
+// or query value without any character needing escaping.
+// Base32 uses only upper case letters and the digits 2-7, so tokens survive
+// case folding, QR alphanumeric mode and being read out.
+// Hex is twice the length of the Hasher's output; with WithVersionedTokens
+// use a Hasher of at most 62 bytes to stay within Limits.Token.
+var (
+	Base64URL    TokenEncoding = base64Encoding{base64.URLEncoding, true}
+	Base64RawURL TokenEncoding = base64Encoding{base64.RawURLEncoding, false}
+	Base32       TokenEncoding = base32Encoding{base32.StdEncoding.WithPadding(base32.NoPadding)}
+	Hex          TokenEncoding = hexEncoding{}
+)
+
+// WithTokenEncoding sets how New encodes tokens. Tokens are only accepted in
+// the configured encoding, so changing it invalidates the tokens already issued.
+func WithTokenEncoding(e TokenEncoding) Option {
+	return func(cfg *config) {
+		if e != nil {
+			cfg.encoding = e
+		}
+	}
+}
+
+// normalizeToken is NormalizeToken for tokens in e
+func normalizeToken(token string, e TokenEncoding) string {
+	token = strings.NewReplacer("\r", "", "\n", "").Replace(token)
+
+	// trim what a mail client adds in front before looking for a version
+	prefix, body := splitTokenVersion(strings.TrimLeftFunc(token, func(r rune) bool {
+		return !isAlnum(r) && !strings.ContainsRune("-_+/=", r)
+	}))
+	_, pepper, body := splitTokenPepper(body)
+	return prefix + pepper + e.Normalize(body)
+}
+
+type base64Encoding struct {
+	*base64.Encoding
+	padded bool
+}
+
+// Normalize also fixes '+' decoded to a space, '=' left percent encoded, the
+// standard base64 alphabet used instead of the URL one and lost padding
+func (e base64Encoding) Normalize(body string) string {
+	body = strings.NewReplacer("%3D", "=", "%3d", "=").Replace(body)
+	body = strings.TrimFunc(body, func(r rune) bool {
+		return !isAlnum(r) && !strings.ContainsRune("-_=+/", r)
+	})
+
+	// a space inside the token was a '+' before query decoding
+	body = strings.NewReplacer(" ", "-", "+", "-", "/", "_").Replace(body)
+
+	// re-pad to a whole number of base64 quanta
+	body = strings.TrimRight(body, "=")
+	if r := len(body) % 4; r != 0 && e.padded {
+		body += strings.Repeat("=", 4-r)
+	}
+	return body
+}
+
+type base32Encoding struct {
+	*base32.Encoding
+}
+
+// Normalize also fixes the case of letters
+func (e base32Encoding) Normalize(body string) string {
+	body = strings.ToUpper(body)
+	return strings.TrimFunc(body, func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= '2' && r <= '7')
+	})
+}
+
+type hexEncoding struct{}
+
+func (hexEncoding) EncodeToString(src []byte) string      { return hex.EncodeToString(src) }
+func (hexEncoding) DecodeString(s string) ([]byte, error) { return hex.DecodeString(s) }
+func (hexEncoding) EncodedLen(n int) int                  { return hex.EncodedLen(n) }
+
+// Normalize also fixes the case of letters
+func (hexEncoding) Normalize(body string) string {
+	body = strings.ToLower(body)
+	return strings.TrimFunc(body, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f')
+	})
+}
+
+// isAlnum reports whether r is an ASCII letter or digit
+func isAlnum(r rune) bool {
+	return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9'
+}
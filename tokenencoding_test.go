@@ -0,0 +1,78 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenEncoding(t *testing.T) {
+	tests := map[string]struct {
+		encoding TokenEncoding
+		alphabet string
+		mangle   func(string) string
+	}{
+		"base64 raw": {Base64RawURL, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_", func(s string) string { return " " + s + "==\n" }},
+		"base32":     {Base32, "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567", func(s string) string { return "<" + strings.ToLower(s) + ">" }},
+		"hex":        {Hex, "0123456789abcdef", func(s string) string { return strings.ToUpper(s) + "." }},
+	}
+	for name, tt := range tests {
+		s := openTestStore(t, "sqlx")(WithTokenEncoding(tt.encoding))
+
+		n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+		if err != nil {
+			t.Fatalf("%s: Expected to add nonce. Instead got the error: %v", name, err)
+		}
+		if len(n.Token) != tt.encoding.EncodedLen(DefaultHasher.Size()) || strings.Trim(n.Token, tt.alphabet) != "" {
+			t.Errorf("%s: Expected a token in the encoding. Instead got: %q", name, n.Token)
+		}
+		err = s.Check(tt.mangle(n.Token), tNonce.Action, tNonce.UserID)
+		if err != nil {
+			t.Errorf("%s: Expected the mangled token to check out. Instead got the error: %v", name, err)
+		}
+		info, err := ParseEncodedToken(n.Token, tt.encoding)
+		if err != nil || info.Size != DefaultHasher.Size() {
+			t.Errorf("%s: Expected to parse the token. Instead got: %+v, %v", name, info, err)
+		}
+
+		// the lengths checkToken accepts come from the encoding
+		for _, token := range []string{n.Token[1:], n.Token + n.Token[:2], strings.Repeat("A", 88)} {
+			err = s.Check(token, tNonce.Action, tNonce.UserID)
+			if err != ErrInvalidToken {
+				t.Errorf("%s: Expected %q to be invalid. Instead got: %v", name, token, err)
+			}
+		}
+		s.Shutdown()
+	}
+}
+
+func TestHexVersionedTokens(t *testing.T) {
+	s := NewInMemoryService(WithTokenEncoding(Hex), WithVersionedTokens(), WithHasher(RandomBytes(32)))
+	defer s.Shutdown()
+
+	n, err := s.New(tNonce.Action, tNonce.UserID, tNonce.ExpiresIn)
+	if err != nil || !strings.HasPrefix(n.Token, "v2.") || len(n.Token) != 67 {
+		t.Fatalf("Expected a versioned hex token. Instead got: %q, %v", n.Token, err)
+	}
+	_, err = s.CheckThenConsume(" "+strings.ToUpper(n.Token[3:])+" ", tNonce.Action, tNonce.UserID)
+	if err != ErrTokenNotFound {
+		t.Errorf("Expected the token without its version to be a different token. Instead got: %v", err)
+	}
+	_, err = s.CheckThenConsume("v2."+strings.ToUpper(n.Token[3:]), tNonce.Action, tNonce.UserID)
+	if err != nil {
+		t.Errorf("Expected the upper case token to be consumed. Instead got the error: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// hashedTokenPrefix starts every stored token hash. ':' isn't in the base64
+// URL alphabet, so a hash can't pass checkToken and be redeemed itself.
+const hashedTokenPrefix = "h1:"
+
+// WithTokenHashing makes the Service store an HMAC-SHA256 of each token under
+// key instead of the token, so a leaked copy of the store holds nothing that
+// can be redeemed. Presented tokens are hashed the same way before they are
+// looked up. An empty key gives a plain SHA-256, which still can't be reversed
+// because tokens are random; a key also keeps anyone with only the store from
+// confirming a token they guessed.
+//
+// Only the call that creates a nonce sees its token: New, NewBatch and
+// ConsumeAndChain return it, while every nonce read back from the store, by
+// Get, Consume, List, hooks and the rest, carries the hash in Token. Tokens
+// stored before hashing was enabled are no longer found, so enable it once
+// they have expired, or copy them in with PutNonce, which hashes a plain Token.
+func WithTokenHashing(key []byte) Option {
+	return func(cfg *config) {
+		cfg.hashTokens = true
+		cfg.tokenKey = append([]byte(nil), key...)
+	}
+}
+
+// storedToken returns the form token is stored under. A token issued under
+// a pepper is hashed with the pepper's secret instead of the WithTokenHashing key.
+func (c config) storedToken(token string) string {
+	if strings.HasPrefix(token, hashedTokenPrefix) {
+		return token
+	}
+	if secret, _ := c.tokenPepper(token); secret != nil {
+		return hashToken(token, secret)
+	}
+	if !c.hashTokens {
+		return token
+	}
+	return hashToken(token, c.tokenKey)
+}
+
+// hashToken returns the HMAC-SHA256 of token under key as it is stored
+func hashToken(token string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hashedTokenPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hideToken replaces n's Token with its stored form, keeping the token to
+// hand back to the caller creating n
+func (c config) hideToken(n Nonce) Nonce {
+	if stored := c.storedToken(n.Token); stored != n.Token {
+		n.plainToken, n.Token = n.Token, stored
+	}
+	return n
+}
+
+// issue returns n as the caller creating it gets it, with the token to present
+func (n Nonce) issue() Nonce {
+	if n.plainToken != "" {
+		n.Token, n.plainToken = n.plainToken, ""
+	}
+	return n
+}
+
+// issueAll is issue for every nonce of a batch
+func issueAll(nonces []Nonce) []Nonce {
+	for i := range nonces {
+		nonces[i] = nonces[i].issue()
+	}
+	return nonces
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestTokenHashing(t *testing.T) {
+	for name, newService := range map[string]func(opts ...Option) Service{
+		"sqlx":  func(opts ...Option) Service { return openTestStore(t, "sqlx")(opts...) },
+		"inmem": NewInMemoryService,
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newService(WithTokenHashing([]byte("secret")))
+			defer s.Shutdown()
+			uid := uuid.NewV4()
+
+			n, err := s.New("login", uid, time.Minute)
+			if err != nil {
+				t.Fatalf("Expected to add a nonce. Instead got the error: %v", err)
+			}
+			if strings.HasPrefix(n.Token, hashedTokenPrefix) {
+				t.Fatalf("Expected New to return the token. Instead got: %q", n.Token)
+			}
+
+			var stored []Nonce
+			s.(Lister).List(context.Background(), Filter{}, func(n Nonce) error {
+				stored = append(stored, n)
+				return nil
+			})
+			if len(stored) != 1 || stored[0].Token == n.Token || !strings.HasPrefix(stored[0].Token, hashedTokenPrefix) {
+				t.Fatalf("Expected only the token's hash to be stored. Instead got: %+v", stored)
+			}
+			err = s.Check(stored[0].Token, "login", uid)
+			if err != ErrInvalidToken {
+				t.Errorf("Expected Check of the stored hash to return %v. Instead got: %v", ErrInvalidToken, err)
+			}
+
+			err = s.Check(n.Token, "login", uid)
+			if err != nil {
+				t.Fatalf("Expected Check of the token to succeed. Instead got: %v", err)
+			}
+			got, err := s.Get("login", uid)
+			if err != nil || got.ID != n.ID || got.Token != stored[0].Token {
+				t.Errorf("Expected Get to return the nonce with its hash. Instead got: %+v, %v", got, err)
+			}
+			_, err = s.Consume(n.Token)
+			if err != nil {
+				t.Errorf("Expected Consume of the token to succeed. Instead got: %v", err)
+			}
+
+			// a fixture's plain token is hashed as it is put
+			put, err := s.(Putter).PutNonce(Nonce{Token: n.Token[:len(n.Token)-4] + "AAA=", Action: "reset", UserID: uid, IsValid: true, ExpiresAt: time.Now().Add(time.Minute)})
+			if err != nil {
+				t.Fatalf("Expected PutNonce to succeed. Instead got: %v", err)
+			}
+			_, err = s.CheckThenConsume(put.Token, "reset", uid)
+			if err != nil {
+				t.Errorf("Expected the put token to be consumable. Instead got: %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenHashingKey(t *testing.T) {
+	a := WithTokenHashing([]byte("a"))
+	b := WithTokenHashing([]byte("b"))
+	var ca, cb config
+	a(&ca)
+	b(&cb)
+	if ca.storedToken("token") == cb.storedToken("token") {
+		t.Errorf("Expected different keys to store different hashes")
+	}
+	if ca.storedToken("token") != ca.storedToken(ca.storedToken("token")) {
+		t.Errorf("Expected a stored hash not to be hashed again")
+	}
+}
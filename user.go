@@ -0,0 +1,47 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nonce
+
+import (
+	uuid "github.com/satori/go.uuid"
+)
+
+// WithUserRequired makes a Service reject nonces for uuid.Nil, the anonymous
+// user: New and NewBatch won't create them, and Check, CheckThenConsume,
+// ConsumeByID and ConsumeAndChain return ErrUserRequired before reaching the store.
+func WithUserRequired() Option {
+	return func(cfg *config) {
+		cfg.userRequired = true
+	}
+}
+
+// requireUser is the Interceptor installed by WithUserRequired
+func requireUser(c Call, next func() error) error {
+	anonymous := false
+	switch c.Method {
+	case "New", "NewBound", "NewWithPayload":
+		anonymous = c.Args[1].(uuid.UUID) == uuid.Nil
+	case "NewBatch":
+		for _, req := range c.Args[1].([]NewRequest) {
+			anonymous = anonymous || req.UserID == uuid.Nil
+		}
+	case "Check", "CheckBound", "CheckThenConsume", "CheckThenConsumeWithMeta", "ConsumeByID", "ConsumeAndChain":
+		anonymous = c.Args[2].(uuid.UUID) == uuid.Nil
+	}
+	if anonymous {
+		return ErrUserRequired
+	}
+	return next()
+}
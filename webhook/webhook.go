@@ -0,0 +1,170 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook POSTs nonce lifecycle events to HTTP endpoints as events.Event
+// JSON, e.g. to feed consumptions into a SIEM. Plug a Publisher into a Service
+// with nonce.WithHooks(p.Hooks()).
+//
+// Each request carries an HMAC-SHA256 of its timestamp and body so receivers can
+// check it came from you and isn't a replay:
+//
+//	X-Nonce-Timestamp: 1486400000
+//	X-Nonce-Signature: sha256=<hex HMAC of "1486400000." + body>
+//
+// Use Verify to check them.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/events"
+)
+
+// Config configures a Publisher
+type Config struct {
+	// URLs every event is sent to
+	URLs []string
+
+	// Secret keys the HMAC signature
+	Secret []byte
+
+	// Client sends the requests. nil uses a client with a 10 second timeout.
+	Client *http.Client
+
+	// Retries is how many more times a failed delivery is attempted,
+	// waiting Backoff, then twice as long, and so on between attempts
+	Retries int
+	Backoff time.Duration
+
+	// Logger receives deliveries that failed every attempt. nil discards them.
+	Logger nonce.Logger
+
+	// Clock stamps events and signatures. nil uses nonce.SystemClock.
+	Clock nonce.Clock
+}
+
+// Publisher sends events to the configured URLs
+type Publisher struct {
+	cfg Config
+}
+
+// New returns a Publisher for cfg
+func New(cfg Config) *Publisher {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = nonce.SystemClock
+	}
+	return &Publisher{cfg: cfg}
+}
+
+// Hooks returns nonce.Hooks that publish every lifecycle event. The Service
+// already calls each hook in its own goroutine, so retries don't hold anything up.
+func (p *Publisher) Hooks() nonce.Hooks {
+	return events.Hooks(p.cfg.Clock, func(e events.Event) { p.Publish(e) })
+}
+
+// Publish sends e to every URL, retrying each as configured, and returns the
+// last error from a URL that never accepted it
+func (p *Publisher) Publish(e events.Event) error {
+	body, err := e.Encode()
+	if err != nil {
+		return err
+	}
+
+	var last error
+	for _, url := range p.cfg.URLs {
+		err = p.deliver(url, body)
+		if err != nil {
+			if p.cfg.Logger != nil {
+				p.cfg.Logger.Printf("webhook: giving up on %s event %v for %s: %v", e.Type, e.ID, url, err)
+			}
+			last = err
+		}
+	}
+	return last
+}
+
+// deliver POSTs body to url until it is accepted or the retries run out
+func (p *Publisher) deliver(url string, body []byte) error {
+	wait := p.cfg.Backoff
+	var err error
+	for attempt := 0; attempt <= p.cfg.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		err = p.post(url, body)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (p *Publisher) post(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(p.cfg.Clock.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Nonce-Timestamp", ts)
+	req.Header.Set("X-Nonce-Signature", "sha256="+sign(p.cfg.Secret, ts, body))
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+func sign(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature, the X-Nonce-Signature header, matches
+// timestamp and body for secret and timestamp is within maxAge of now
+func Verify(secret []byte, timestamp, signature string, body []byte, maxAge time.Duration, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > maxAge || age < -maxAge {
+		return false
+	}
+	want := "sha256=" + sign(secret, timestamp, body)
+	return hmac.Equal([]byte(want), []byte(signature))
+}
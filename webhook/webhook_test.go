@@ -0,0 +1,106 @@
+// Copyright 2016 Bryan Jeal <bryan@jeal.ca>
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+// 	http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bryanjeal/go-nonce"
+	"github.com/bryanjeal/go-nonce/events"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPublishSignsAndRetries(t *testing.T) {
+	secret := []byte("shh")
+	var calls int32
+	got := make(chan events.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail the first attempt so the retry is exercised
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !Verify(secret, r.Header.Get("X-Nonce-Timestamp"), r.Header.Get("X-Nonce-Signature"), body, time.Minute, time.Now()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if strings.Contains(string(body), "token") {
+			t.Errorf("Expected events to leave the token out. Instead got: %s", body)
+		}
+		var e events.Event
+		json.Unmarshal(body, &e)
+		got <- e
+	}))
+	defer srv.Close()
+
+	p := New(Config{URLs: []string{srv.URL}, Secret: secret, Retries: 2, Backoff: time.Millisecond})
+	s := nonce.NewInMemoryService(nonce.WithHooks(p.Hooks()))
+	defer s.Shutdown()
+
+	uid := uuid.NewV4()
+	n, err := s.New("webhook", uid, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected New to succeed. Instead got: %v", err)
+	}
+
+	select {
+	case e := <-got:
+		if e.Type != events.Created || e.ID != n.ID || e.UserID != uid || e.Action != "webhook" {
+			t.Fatalf("Expected a created event for the nonce. Instead got: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the event to be delivered after a retry")
+	}
+}
+
+func TestPublishGivesUp(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	p := New(Config{URLs: []string{srv.URL}, Retries: 2, Backoff: time.Millisecond})
+	err := p.Publish(events.Event{Type: events.Consumed})
+	if err == nil || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("Expected an error after 3 attempts. Instead got: %d attempts, %v", calls, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("shh")
+	now := time.Unix(1486400000, 0)
+	body := []byte(`{"type":"nonce.consumed"}`)
+	sig := "sha256=" + sign(secret, "1486400000", body)
+
+	if !Verify(secret, "1486400000", sig, body, time.Minute, now) {
+		t.Fatalf("Expected a good signature to verify")
+	}
+	if Verify([]byte("other"), "1486400000", sig, body, time.Minute, now) {
+		t.Fatalf("Expected the wrong secret to fail")
+	}
+	if Verify(secret, "1486400000", sig, body, time.Minute, now.Add(time.Hour)) {
+		t.Fatalf("Expected an old timestamp to fail")
+	}
+}